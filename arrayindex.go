@@ -0,0 +1,138 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Array elements are addressed in field paths by a path element of the form
+// "[N]".  In addition to the plain indexes which are matched while the array
+// is being scanned, a path element may contain a negative index such as
+// "[-1]", which counts back from the end of the array, or a slice such as
+// "[1:3]", which selects a range of elements and may only be used as the
+// target of a loop.
+
+// ArraySlice describes a range of array elements to be looped over.  The
+// range includes Start and excludes End, either of which may be negative to
+// count back from the end of the array.  If HasEnd is false, the range
+// continues to the end of the array.
+type ArraySlice struct {
+	Start  int
+	End    int
+	HasEnd bool
+}
+
+func (slice ArraySlice) String() string {
+	if !slice.HasEnd {
+		return fmt.Sprintf("[%d:]", slice.Start)
+	}
+	return fmt.Sprintf("[%d:%d]", slice.Start, slice.End)
+}
+
+// needsLength reports whether the length of the array must be known before
+// the bounds of the slice can be resolved.
+func (slice ArraySlice) needsLength() bool {
+	return slice.Start < 0 || (slice.HasEnd && slice.End < 0)
+}
+
+// bounds returns the half-open range of indexes selected by the slice from
+// an array of the given length.  A length of -1 indicates that the length is
+// not known, which is only valid when needsLength is false.
+func (slice ArraySlice) bounds(length int) (int, int) {
+	clamp := func(idx int) int {
+		if idx < 0 {
+			idx += length
+			if idx < 0 {
+				idx = 0
+			}
+		}
+		if length >= 0 && idx > length {
+			idx = length
+		}
+		return idx
+	}
+
+	lo := clamp(slice.Start)
+	hi := length
+	if slice.HasEnd {
+		hi = clamp(slice.End)
+	} else if length < 0 {
+		hi = int(^uint(0) >> 1)
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// isArrayIndexElem reports whether a path element addresses array elements,
+// either by a plain or negative index or by a slice.
+func isArrayIndexElem(elem string) bool {
+	if len(elem) < 3 || elem[0] != '[' || elem[len(elem)-1] != ']' {
+		return false
+	}
+	if _, ok := parseArraySlice(elem); ok {
+		return true
+	}
+	_, err := parseArrayBound(elem[1 : len(elem)-1])
+	return err == nil
+}
+
+// parseArraySlice parses a path element of the form "[start:end]", where
+// both bounds are optional and may be negative.
+func parseArraySlice(elem string) (ArraySlice, bool) {
+	if len(elem) < 3 || elem[0] != '[' || elem[len(elem)-1] != ']' {
+		return ArraySlice{}, false
+	}
+
+	bounds := strings.Split(elem[1:len(elem)-1], ":")
+	if len(bounds) != 2 {
+		return ArraySlice{}, false
+	}
+
+	var slice ArraySlice
+	var err error
+	if bounds[0] != "" {
+		slice.Start, err = parseArrayBound(bounds[0])
+		if err != nil {
+			return ArraySlice{}, false
+		}
+	}
+	if bounds[1] != "" {
+		slice.End, err = parseArrayBound(bounds[1])
+		if err != nil {
+			return ArraySlice{}, false
+		}
+		slice.HasEnd = true
+	}
+	return slice, true
+}
+
+// parseNegativeArrayIndex parses a path element of the form "[-N]".
+func parseNegativeArrayIndex(elem string) (int, bool) {
+	if len(elem) < 4 || elem[0] != '[' || elem[1] != '-' || elem[len(elem)-1] != ']' {
+		return 0, false
+	}
+
+	idx, err := parseArrayBound(elem[1 : len(elem)-1])
+	if err != nil || idx >= 0 {
+		return 0, false
+	}
+	return idx, true
+}
+
+func parseArrayBound(bound string) (int, error) {
+	digits := strings.TrimPrefix(bound, "-")
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array bound `%s`", bound)
+	}
+	// A negative zero is neither counted back from the end of the array nor
+	// matched as the "[0]" element, so it is rejected rather than ignored.
+	if digits != bound && strings.TrimLeft(digits, "0") == "" {
+		return 0, fmt.Errorf("invalid negative zero array bound `%s`", bound)
+	}
+	return strconv.Atoi(bound)
+}
@@ -93,6 +93,9 @@ func (tree binTree) itemToString(item int) string {
 }
 
 func (tree binTree) String() string {
+	if len(tree.data) == 0 {
+		return ""
+	}
 	return tree.itemToString(0)
 }
 
@@ -251,7 +254,9 @@ func (state *binTreeState) SetStallIndex(index int) int {
 }
 
 // Resolve forces the tree to be fully resolved (including cases such as NOT)
-// by doing a depth-first resolution of all unresolved nodes with `false`.
+// by resolving all unresolved leaves with `false`.  Only leaves are marked
+// directly so that trees whose parent nodes are not laid out before their
+// children (such as those built by IncrementalDef) resolve correctly.
 func (state *binTreeState) Resolve() {
 	// Skip resolving if the full tree is already resolved
 	if state.IsResolved(0) {
@@ -261,8 +266,8 @@ func (state *binTreeState) Resolve() {
 	// Do depth-first resolution of the entire tree state
 	treeLength := len(state.data)
 	for i := treeLength - 1; i >= 0; i-- {
-		// If this bucket is not resolved, resolve it with false
-		if state.data[i] == binTreeStateUnknown {
+		// If this leaf is not resolved, resolve it with false
+		if state.data[i] == binTreeStateUnknown && state.tree.data[i].NodeType == nodeTypeLeaf {
 			state.MarkNode(i, false)
 		}
 
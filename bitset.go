@@ -0,0 +1,76 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"math/bits"
+)
+
+// Bitset is a fixed size set of bits.  It is not safe for concurrent
+// modification, except where separate goroutines only ever modify bits
+// which live in different 64-bit words.
+type Bitset struct {
+	words []uint64
+	size  int
+}
+
+func NewBitset(size int) Bitset {
+	return Bitset{
+		words: make([]uint64, (size+63)/64),
+		size:  size,
+	}
+}
+
+// Len returns the number of bits held by the set.
+func (b Bitset) Len() int {
+	return b.size
+}
+
+func (b Bitset) Set(idx int) {
+	b.words[idx/64] |= 1 << uint(idx%64)
+}
+
+func (b Bitset) Clear(idx int) {
+	b.words[idx/64] &^= 1 << uint(idx%64)
+}
+
+func (b Bitset) Test(idx int) bool {
+	return b.words[idx/64]&(1<<uint(idx%64)) != 0
+}
+
+// Reset clears every bit in the set.
+func (b Bitset) Reset() {
+	for i := range b.words {
+		b.words[i] = 0
+	}
+}
+
+// Count returns the number of bits which are set.
+func (b Bitset) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// NextSet returns the index of the first set bit at or after idx, or -1 if
+// there are no more set bits.
+func (b Bitset) NextSet(idx int) int {
+	if idx >= b.size || idx < 0 {
+		return -1
+	}
+
+	wordIdx := idx / 64
+	word := b.words[wordIdx] >> uint(idx%64)
+	if word != 0 {
+		return idx + bits.TrailingZeros64(word)
+	}
+
+	for wordIdx++; wordIdx < len(b.words); wordIdx++ {
+		if b.words[wordIdx] != 0 {
+			return wordIdx*64 + bits.TrailingZeros64(b.words[wordIdx])
+		}
+	}
+	return -1
+}
@@ -57,6 +57,9 @@ const (
 	FuncTan    string = "TAN"
 	FuncRound  string = "ROUND"
 	FuncSqrt   string = "SQRT"
+
+	// The same as REGEXP_CONTAINS
+	FuncRegexpLike string = "REGEXP_LIKE"
 )
 
 // Parser related constants
@@ -80,6 +83,18 @@ const (
 	OperatorNotMissing    string = "IS NOT MISSING"
 	OperatorNull          string = "IS NULL"
 	OperatorNotNull       string = "IS NOT NULL"
+	OperatorIn            string = "IN"
+	OperatorNotIn         string = "NOT IN"
+	OperatorBetween       string = "BETWEEN"
+	OperatorNotBetween    string = "NOT BETWEEN"
+	OperatorLike          string = "LIKE"
+	OperatorNotLike       string = "NOT LIKE"
+	OperatorEscape        string = "ESCAPE"
+	OperatorAny           string = "ANY"
+	OperatorEvery         string = "EVERY"
+	OperatorAnyEvery      string = "ANY AND EVERY"
+	OperatorSatisfies     string = "SATISFIES"
+	OperatorEnd           string = "END"
 )
 
 // Participle parser can cause stack overflow if certain inputs (i.e. a single word regex) is passed in
@@ -88,7 +103,10 @@ const (
 var GojsonsmOperators []string = []string{OperatorOr, OperatorAnd, OperatorNot, OperatorTrue,
 	OperatorFalse, OperatorMeta, OperatorEquals, OperatorEquals2, OperatorNotEquals, OperatorNotEquals2, OperatorGreaterThan,
 	OperatorGreaterThanEq, OperatorLessThan, OperatorLessThanEq, OperatorExists, OperatorMissing, OperatorNotMissing,
-	OperatorNull, OperatorNotNull /* BooleanFuncs*/, FuncRegexp}
+	OperatorNull, OperatorNotNull, OperatorIn, OperatorNotIn,
+	OperatorBetween, OperatorNotBetween, OperatorLike, OperatorNotLike, OperatorEscape,
+	OperatorAny, OperatorEvery, OperatorAnyEvery, OperatorSatisfies, OperatorEnd,
+	/* BooleanFuncs*/ FuncRegexp, FuncRegexpLike}
 
 // Error constants
 var emptyExpression Expression
@@ -123,6 +141,7 @@ const (
 	opMode      parseMode = iota
 	valueMode   parseMode = iota
 	chainMode   parseMode = iota
+	rangeMode   parseMode = iota
 )
 
 func (pm parseMode) String() string {
@@ -135,6 +154,8 @@ func (pm parseMode) String() string {
 		return "valueMode"
 	case chainMode:
 		return "chainMode"
+	case rangeMode:
+		return "rangeMode"
 	default:
 		return "Unknown"
 	}
@@ -145,6 +166,8 @@ const (
 	fieldLiteral     string = "`"
 	fieldNestedStart string = "["
 	fieldNestedEnd   string = "]"
+	fieldIndexNeg    string = "-"
+	fieldIndexSlice  string = ":"
 )
 
 // When in op mode, there can be multiple contexts
@@ -156,6 +179,8 @@ const (
 	compareOp opTokenContext = iota
 	matchOp   opTokenContext = iota
 	noFieldOp opTokenContext = iota
+	rangeOp   opTokenContext = iota
+	patternOp opTokenContext = iota
 )
 
 // Function helpers
@@ -0,0 +1,101 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+// EqualsIndex routes the value of an ExecNode to the equality ops which
+// compare it against string constants.  When the value is a string, only
+// the ops whose constant is identical to it need to be performed, and every
+// other indexed op is known to be false without comparing anything.
+type EqualsIndex struct {
+	// Values maps the JSON-escaped form of each constant to the indexes of
+	// the ops within ExecNode.Ops which compare against it.
+	Values map[string][]int
+
+	// Indexed and Unindexed hold the indexes of the ops which are and are
+	// not routed through Values.
+	Indexed   []int
+	Unindexed []int
+}
+
+// equalsIndexKey returns the key an op is indexed by, if it compares the
+// active value for equality with a string constant.
+func equalsIndexKey(op OpNode) (string, bool) {
+	if op.Op != OpTypeEquals {
+		return "", false
+	}
+
+	var constRef DataRef
+	if op.Lhs == nil {
+		constRef = op.Rhs
+	} else if op.Rhs == nil {
+		constRef = op.Lhs
+	} else {
+		return "", false
+	}
+
+	constVal, ok := constRef.(FastVal)
+	if !ok || !constVal.IsString() {
+		return "", false
+	}
+
+	return equalsIndexValueKey(constVal)
+}
+
+// equalsIndexValueKey returns the key for a string value.  Strings are
+// compared by their JSON-escaped form, so the same is used here.
+func equalsIndexValueKey(val FastVal) (string, bool) {
+	escVal, err := val.ToJsonString()
+	if err != nil {
+		return "", false
+	}
+	return string(escVal.sliceData), true
+}
+
+// newEqualsIndex builds an index over the ops of a node, returning nil if
+// fewer than minOps of them can be indexed.
+func newEqualsIndex(ops []OpNode, minOps int) *EqualsIndex {
+	index := &EqualsIndex{
+		Values: make(map[string][]int),
+	}
+
+	for opIdx, op := range ops {
+		key, ok := equalsIndexKey(op)
+		if !ok {
+			index.Unindexed = append(index.Unindexed, opIdx)
+			continue
+		}
+
+		index.Values[key] = append(index.Values[key], opIdx)
+		index.Indexed = append(index.Indexed, opIdx)
+	}
+
+	if len(index.Indexed) == 0 || len(index.Indexed) < minOps {
+		return nil
+	}
+	return index
+}
+
+// buildEqualsIndexes adds an EqualsIndex to every node beneath node which
+// has at least minOps indexable ops.
+func buildEqualsIndexes(node *ExecNode, minOps int) {
+	if node == nil {
+		return
+	}
+
+	node.Index = newEqualsIndex(node.Ops, minOps)
+
+	for _, elem := range node.Elems {
+		buildEqualsIndexes(elem, minOps)
+	}
+	for _, loop := range node.Loops {
+		buildEqualsIndexes(loop.Node, minOps)
+	}
+	if node.After != nil {
+		for _, elem := range node.After.Elems {
+			buildEqualsIndexes(elem, minOps)
+		}
+		for _, loop := range node.After.Loops {
+			buildEqualsIndexes(loop.Node, minOps)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+)
+
+func getEqualsIndexTestExprs(t *testing.T) []Expression {
+	filters := []string{
+		`eyeColor = "brown" AND age > 30`,
+		`eyeColor = "blue" AND isActive = TRUE`,
+		`eyeColor = "green" OR gender = "male"`,
+		`NOT eyeColor = "brown"`,
+		`eyeColor = "blue"`,
+		`eyeColor <> "green"`,
+		`eyeColor = "a\"b"`,
+		`eyeColor = "caf\u00e9"`,
+		`eyeColor = 5`,
+		`eyeColor = TRUE`,
+		`eyeColor IS NOT MISSING`,
+		`eyeColor >= "brown"`,
+		`"green" = eyeColor`,
+	}
+
+	var exprs []Expression
+	for _, filter := range filters {
+		exprs = append(exprs, tParseFilterExpression(t, filter))
+	}
+
+	friend := FieldExpr{1, nil}
+	friendName := FieldExpr{1, []string{"name"}}
+	exprs = append(exprs,
+		AnyInExpr{1, FieldExpr{0, []string{"tags"}}, OrExpr{
+			EqualsExpr{friend, ValueExpr{"brown"}},
+			EqualsExpr{friend, ValueExpr{"green"}},
+		}},
+		EveryInExpr{1, FieldExpr{0, []string{"friends"}}, OrExpr{
+			EqualsExpr{friendName, ValueExpr{"Kim"}},
+			NotEqualsExpr{friendName, ValueExpr{"Neil"}},
+		}},
+	)
+
+	return exprs
+}
+
+func getEqualsIndexTestDocs() [][]byte {
+	docs := [][]byte{
+		[]byte(`{"eyeColor":"brown","age":40}`),
+		[]byte(`{"eyeColor":"a\"b"}`),
+		[]byte(`{"eyeColor":"caf\u00e9"}`),
+		[]byte(`{"eyeColor":"café"}`),
+		[]byte(`{"eyeColor":"5"}`),
+		[]byte(`{"eyeColor":5}`),
+		[]byte(`{"eyeColor":"true"}`),
+		[]byte(`{"eyeColor":null}`),
+		[]byte(`{"eyeColor":{"a":"brown"}}`),
+		[]byte(`{"eyeColor":["brown"]}`),
+		[]byte(`{"eyeColor":"blue","eyeColor":"brown"}`),
+		[]byte(`{"tags":["blue","green"],"friends":[{"name":"Kim"},{"name":"Neil"}]}`),
+		[]byte(`{"tags":["blue"],"friends":[{"name":"Kim"},{"name":"Bob"}]}`),
+	}
+	return append(docs, getTestPeopleDocs()...)
+}
+
+func TestEqualsIndexBuild(t *testing.T) {
+	exprs := getEqualsIndexTestExprs(t)
+
+	trans := Transformer{EqualsIndexMinOps: 4}
+	matchDef := trans.Transform(exprs)
+
+	index := matchDef.ParseNode.Elems["eyeColor"].Index
+	if index == nil {
+		t.Fatalf("expected eyeColor to be indexed:\n%s", matchDef)
+	}
+	if len(index.Values) != 5 {
+		t.Fatalf("expected 5 indexed values, got %d:\n%s", len(index.Values), matchDef)
+	}
+	if len(index.Indexed)+len(index.Unindexed) != len(matchDef.ParseNode.Elems["eyeColor"].Ops) {
+		t.Fatalf("expected every op to be accounted for")
+	}
+
+	// The loops only have two comparisons each, which is below the limit
+	if matchDef.ParseNode.Elems["tags"].Loops[0].Node.Index != nil {
+		t.Fatalf("expected tags not to be indexed:\n%s", matchDef)
+	}
+
+	var plainTrans Transformer
+	plainDef := plainTrans.Transform(exprs)
+	if plainDef.ParseNode.Elems["eyeColor"].Index != nil {
+		t.Fatalf("expected no index by default")
+	}
+}
+
+func TestEqualsIndexMatches(t *testing.T) {
+	exprs := getEqualsIndexTestExprs(t)
+
+	var plainTrans Transformer
+	plainDef := plainTrans.Transform(exprs)
+
+	indexTrans := Transformer{EqualsIndexMinOps: 1}
+	indexDef := indexTrans.Transform(exprs)
+
+	if indexDef.ParseNode.Elems["tags"].Loops[0].Node.Index == nil {
+		t.Fatalf("expected loop nodes to be indexed:\n%s", indexDef)
+	}
+
+	plainM := NewFastMatcher(plainDef)
+	indexM := NewFastMatcher(indexDef)
+	for docIdx, doc := range getEqualsIndexTestDocs() {
+		plainM.Reset()
+		expected, err := plainM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		indexM.Reset()
+		matched, err := indexM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match with index: %s", err)
+		}
+
+		if matched != expected {
+			t.Fatalf("document %d: matched %t with index, expected %t", docIdx, matched, expected)
+		}
+		for exprIdx := range exprs {
+			if indexM.ExpressionMatched(exprIdx) != plainM.ExpressionMatched(exprIdx) {
+				t.Fatalf("document %d: expression %d (%s) matched %t with index, expected %t",
+					docIdx, exprIdx, exprs[exprIdx],
+					indexM.ExpressionMatched(exprIdx), plainM.ExpressionMatched(exprIdx))
+			}
+		}
+	}
+
+	newDef := tRoundTripMatchDef(t, indexDef)
+	if newDef.ParseNode.Elems["eyeColor"].Index == nil {
+		t.Fatalf("expected the index to survive a round trip")
+	}
+}
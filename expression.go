@@ -67,6 +67,21 @@ func (expr PcreExpr) String() string {
 	return fmt.Sprintf("/%v/", expr.Pcre)
 }
 
+// LikePatternExpr is a SQL LIKE pattern, in which % matches any sequence of
+// characters and _ matches any single character.  When Escape is set, a
+// wildcard which follows it matches only itself.
+type LikePatternExpr struct {
+	Pattern string
+	Escape  string
+}
+
+func (expr LikePatternExpr) String() string {
+	if expr.Escape == "" {
+		return fmt.Sprintf("like(%q)", expr.Pattern)
+	}
+	return fmt.Sprintf("like(%q escape %q)", expr.Pattern, expr.Escape)
+}
+
 type NotExpr struct {
 	SubExpr Expression
 }
@@ -247,6 +262,8 @@ func (expr GreaterEqualsExpr) String() string {
 	return fmt.Sprintf("%s >= %s", expr.Lhs, expr.Rhs)
 }
 
+// LikeExpr is true when Lhs is a string which matches Rhs, which is either a
+// regular expression or a LikePatternExpr.
 type LikeExpr struct {
 	Lhs Expression
 	Rhs Expression
@@ -255,3 +272,30 @@ type LikeExpr struct {
 func (expr LikeExpr) String() string {
 	return fmt.Sprintf("%s =~ %s", expr.Lhs, expr.Rhs)
 }
+
+// InExpr is true when Lhs is equal to any one of Values, each of which must
+// be a constant ValueExpr.
+type InExpr struct {
+	Lhs    Expression
+	Values []Expression
+}
+
+func (expr InExpr) String() string {
+	values := make([]string, len(expr.Values))
+	for i, value := range expr.Values {
+		values[i] = value.String()
+	}
+	return fmt.Sprintf("%s IN [%s]", expr.Lhs, strings.Join(values, ", "))
+}
+
+// BetweenExpr is true when Lhs is greater than or equal to Low and less than
+// or equal to High.
+type BetweenExpr struct {
+	Lhs  Expression
+	Low  Expression
+	High Expression
+}
+
+func (expr BetweenExpr) String() string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", expr.Lhs, expr.Low, expr.High)
+}
@@ -0,0 +1,643 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import "strconv"
+
+// Truth describes whether an expression can be true for some documents, no
+// documents or all documents.
+type Truth int
+
+const (
+	TruthUnknown Truth = iota
+	TruthNever
+	TruthAlways
+)
+
+func (value Truth) String() string {
+	switch value {
+	case TruthUnknown:
+		return "unknown"
+	case TruthNever:
+		return "never"
+	case TruthAlways:
+		return "always"
+	}
+	return "??ERROR??"
+}
+
+// AnalysisIssue describes a sub-expression which can never be true, or which
+// is always true.  Clauses holds the parts of Expr which together cause the
+// issue, or is nil if Expr itself is constant.
+type AnalysisIssue struct {
+	Expr    Expression
+	Truth   Truth
+	Clauses []Expression
+	Reason  string
+}
+
+// Report is the result of analyzing an expression.  Truth is TruthNever if the
+// expression can never match a document, and TruthAlways if it matches every
+// document.  Issues lists the innermost sub-expressions responsible for
+// that, along with any other sub-expressions which are constant.
+type Report struct {
+	Truth  Truth
+	Issues []AnalysisIssue
+}
+
+// Satisfiable returns false if the analyzed expression can never match.
+func (report Report) Satisfiable() bool {
+	return report.Truth != TruthNever
+}
+
+// Analyze looks for clauses within an expression which can never be true, or
+// which are always true.  Conjunctions are checked for conflicting numeric
+// ranges, equalities and existence checks against the same field, and for
+// clauses alongside their own negation.  Disjunctions are checked for
+// clauses alongside their own negation.  The analysis is conservative, an
+// expression reported as TruthUnknown may still be constant.
+func Analyze(expr Expression) Report {
+	var analyzer exprAnalyzer
+	truth := analyzer.analyze(expr)
+	return Report{
+		Truth:  truth,
+		Issues: analyzer.issues,
+	}
+}
+
+type exprAnalyzer struct {
+	issues []AnalysisIssue
+}
+
+func (a *exprAnalyzer) flag(expr Expression, truth Truth, reason string, clauses ...Expression) Truth {
+	a.issues = append(a.issues, AnalysisIssue{
+		Expr:    expr,
+		Truth:   truth,
+		Clauses: clauses,
+		Reason:  reason,
+	})
+	return truth
+}
+
+func (a *exprAnalyzer) analyze(expr Expression) Truth {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		return a.flag(expr, TruthAlways, "constant true")
+	case FalseExpr:
+		return a.flag(expr, TruthNever, "constant false")
+	case NotExpr:
+		switch a.analyze(expr.SubExpr) {
+		case TruthNever:
+			return TruthAlways
+		case TruthAlways:
+			return TruthNever
+		}
+		return TruthUnknown
+	case AndExpr:
+		return a.analyzeAnd(expr)
+	case OrExpr:
+		return a.analyzeOr(expr)
+	case BetweenExpr:
+		// The bounds of a single BETWEEN may already form an empty range
+		if _, conflict := findConjunctionConflict([]Expression{expr}); conflict != nil {
+			return a.flag(expr, TruthNever, conflict.reason)
+		}
+		return TruthUnknown
+	case AnyInExpr:
+		// An empty array never satisfies the loop, so only never is known
+		if a.analyze(expr.SubExpr) == TruthNever {
+			return TruthNever
+		}
+		return TruthUnknown
+	case AnyEveryInExpr:
+		if a.analyze(expr.SubExpr) == TruthNever {
+			return TruthNever
+		}
+		return TruthUnknown
+	case EveryInExpr:
+		a.analyze(expr.SubExpr)
+		return TruthUnknown
+	}
+	return TruthUnknown
+}
+
+// unwrapSingleExpr removes any AND or OR expressions which only have a single
+// sub-expression, as the filter expression parser generates.
+func unwrapSingleExpr(expr Expression) Expression {
+	for {
+		switch subExprs := expr.(type) {
+		case AndExpr:
+			if len(subExprs) != 1 {
+				return expr
+			}
+			expr = subExprs[0]
+		case OrExpr:
+			if len(subExprs) != 1 {
+				return expr
+			}
+			expr = subExprs[0]
+		default:
+			return expr
+		}
+	}
+}
+
+// flattenAnalyzeTerms collects the terms of nested AND expressions, or of
+// nested OR expressions, so that they can be checked against each other.
+func flattenAnalyzeTerms(exprs []Expression, isAnd bool, out []Expression) []Expression {
+	for _, expr := range exprs {
+		expr = unwrapSingleExpr(expr)
+		if subExprs, ok := expr.(AndExpr); ok && isAnd {
+			out = flattenAnalyzeTerms(subExprs, isAnd, out)
+		} else if subExprs, ok := expr.(OrExpr); ok && !isAnd {
+			out = flattenAnalyzeTerms(subExprs, isAnd, out)
+		} else {
+			out = append(out, expr)
+		}
+	}
+	return out
+}
+
+func (a *exprAnalyzer) analyzeAnd(expr AndExpr) Truth {
+	var clauses []Expression
+	for _, subExpr := range flattenAnalyzeTerms(expr, true, nil) {
+		switch a.analyze(subExpr) {
+		case TruthNever:
+			return TruthNever
+		case TruthUnknown:
+			clauses = append(clauses, subExpr)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return TruthAlways
+	}
+
+	if _, conflict := findConjunctionConflict(clauses); conflict != nil {
+		return a.flag(expr, TruthNever, conflict.reason, conflict.clauses...)
+	}
+
+	return TruthUnknown
+}
+
+// findConjunctionConflict looks for clauses which cannot all be true at the
+// same time, returning the constraints the clauses place on fields if none
+// are found.
+func findConjunctionConflict(clauses []Expression) (*fieldConstraints, *constraintConflict) {
+	for i := range clauses {
+		for j := i + 1; j < len(clauses); j++ {
+			if isExprNegation(clauses[i], clauses[j]) {
+				return nil, &constraintConflict{"clause and its negation cannot both be true",
+					[]Expression{clauses[i], clauses[j]}}
+			}
+		}
+	}
+
+	constraints := &fieldConstraints{}
+	for _, clause := range clauses {
+		if conflict := constraints.add(clause); conflict != nil {
+			return nil, conflict
+		}
+	}
+	if conflict := constraints.addNegatedBounds(); conflict != nil {
+		return nil, conflict
+	}
+	return constraints, nil
+}
+
+func (a *exprAnalyzer) analyzeOr(expr OrExpr) Truth {
+	var clauses []Expression
+	for _, subExpr := range flattenAnalyzeTerms(expr, false, nil) {
+		switch a.analyze(subExpr) {
+		case TruthAlways:
+			return TruthAlways
+		case TruthUnknown:
+			clauses = append(clauses, subExpr)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return TruthNever
+	}
+
+	for i := range clauses {
+		for j := i + 1; j < len(clauses); j++ {
+			if isExprNegation(clauses[i], clauses[j]) {
+				return a.flag(expr, TruthAlways, "either the clause or its negation is always true",
+					clauses[i], clauses[j])
+			}
+		}
+	}
+
+	return TruthUnknown
+}
+
+// isExprNegation returns true if one expression is exactly the negation of
+// the other.
+func isExprNegation(lhs, rhs Expression) bool {
+	if notExpr, ok := lhs.(NotExpr); ok && ExprEqual(unwrapSingleExpr(notExpr.SubExpr), rhs) {
+		return true
+	}
+	if notExpr, ok := rhs.(NotExpr); ok && ExprEqual(unwrapSingleExpr(notExpr.SubExpr), lhs) {
+		return true
+	}
+
+	switch lhs := lhs.(type) {
+	case ExistsExpr:
+		if rhs, ok := rhs.(NotExistsExpr); ok {
+			return ExprEqual(lhs.SubExpr, rhs.SubExpr)
+		}
+	case NotExistsExpr:
+		if rhs, ok := rhs.(ExistsExpr); ok {
+			return ExprEqual(lhs.SubExpr, rhs.SubExpr)
+		}
+	case EqualsExpr:
+		if rhs, ok := rhs.(NotEqualsExpr); ok {
+			return ExprEqual(lhs.Lhs, rhs.Lhs) && ExprEqual(lhs.Rhs, rhs.Rhs)
+		}
+	case NotEqualsExpr:
+		if rhs, ok := rhs.(EqualsExpr); ok {
+			return ExprEqual(lhs.Lhs, rhs.Lhs) && ExprEqual(lhs.Rhs, rhs.Rhs)
+		}
+	}
+	return false
+}
+
+type constValueClass int
+
+const (
+	constClassOther constValueClass = iota
+	constClassNull
+	constClassBool
+	constClassNumeric
+	constClassString
+)
+
+// fieldEquality is a comparison of a field for equality against a constant.
+type fieldEquality struct {
+	field FieldExpr
+	class constValueClass
+	value interface{}
+	num   FastVal
+	expr  Expression
+}
+
+type fieldClause struct {
+	field FieldExpr
+	expr  Expression
+}
+
+type fieldBound struct {
+	rangeBound
+	expr Expression
+}
+
+type constraintConflict struct {
+	reason  string
+	clauses []Expression
+}
+
+// fieldConstraints collects what a set of conjoined clauses require of the
+// fields they reference.
+type fieldConstraints struct {
+	present  []fieldClause
+	missing  []fieldClause
+	bounds   []fieldBound
+	nbounds  []fieldBound
+	equals   []fieldEquality
+	unequals []fieldEquality
+}
+
+func getConstValueClass(expr Expression) (constValueClass, interface{}, FastVal) {
+	valueExpr, ok := expr.(ValueExpr)
+	if !ok {
+		return constClassOther, nil, FastVal{}
+	}
+
+	if num, ok := getNumericValue(valueExpr); ok {
+		return constClassNumeric, valueExpr.Value, num
+	}
+
+	switch value := valueExpr.Value.(type) {
+	case nil:
+		return constClassNull, nil, FastVal{}
+	case bool:
+		return constClassBool, value, FastVal{}
+	case string:
+		return constClassString, value, FastVal{}
+	}
+	return constClassOther, nil, FastVal{}
+}
+
+func getFieldEquality(lhs, rhs Expression, expr Expression) (fieldEquality, bool) {
+	field, ok := lhs.(FieldExpr)
+	if !ok {
+		field, ok = rhs.(FieldExpr)
+		rhs = lhs
+	}
+	if !ok {
+		return fieldEquality{}, false
+	}
+
+	class, value, num := getConstValueClass(rhs)
+	if class == constClassOther {
+		return fieldEquality{}, false
+	}
+
+	return fieldEquality{field, class, value, num, expr}, true
+}
+
+func (eq fieldEquality) sameValueAs(other fieldEquality) bool {
+	if eq.class != other.class {
+		return false
+	}
+	if eq.class == constClassNumeric {
+		cmp, _ := eq.num.Compare(other.num)
+		return cmp == 0
+	}
+	return eq.value == other.value
+}
+
+// conflictsWith returns true if no value can be equal to both constants.
+func (eq fieldEquality) conflictsWith(other fieldEquality) bool {
+	if eq.class == other.class {
+		return !eq.sameValueAs(other)
+	}
+
+	if eq.class == constClassNull || other.class == constClassNull {
+		return true
+	}
+
+	// Strings are implicitly converted when compared against numbers, so
+	// only strings which do not look like a number are known to conflict
+	var str string
+	switch {
+	case eq.class == constClassNumeric && other.class == constClassString:
+		str = other.value.(string)
+	case eq.class == constClassString && other.class == constClassNumeric:
+		str = eq.value.(string)
+	default:
+		return false
+	}
+	_, err := strconv.ParseFloat(str, 64)
+	return err != nil
+}
+
+func isFieldPathPrefix(prefix, field FieldExpr) bool {
+	if prefix.Root != field.Root || len(prefix.Path) > len(field.Path) {
+		return false
+	}
+	for i := range prefix.Path {
+		if prefix.Path[i] != field.Path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fieldConstraints) add(expr Expression) *constraintConflict {
+	switch expr := expr.(type) {
+	case ExistsExpr:
+		if field, ok := expr.SubExpr.(FieldExpr); ok {
+			return c.addPresent(field, expr)
+		}
+	case NotExistsExpr:
+		if field, ok := expr.SubExpr.(FieldExpr); ok {
+			return c.addMissing(field, expr)
+		}
+	case NotEqualsExpr:
+		if eq, ok := getFieldEquality(expr.Lhs, expr.Rhs, expr); ok {
+			return c.addUnequal(eq)
+		}
+	case NotExpr:
+		switch subExpr := unwrapSingleExpr(expr.SubExpr).(type) {
+		case ExistsExpr:
+			if field, ok := subExpr.SubExpr.(FieldExpr); ok {
+				return c.addMissing(field, expr)
+			}
+		case NotExistsExpr:
+			if field, ok := subExpr.SubExpr.(FieldExpr); ok {
+				return c.addPresent(field, expr)
+			}
+		case EqualsExpr:
+			if eq, ok := getFieldEquality(subExpr.Lhs, subExpr.Rhs, expr); ok {
+				return c.addUnequal(eq)
+			}
+		case NotEqualsExpr:
+			return c.add(EqualsExpr{subExpr.Lhs, subExpr.Rhs})
+		default:
+			if bound, ok := getRangeBound(subExpr); ok {
+				c.nbounds = append(c.nbounds, fieldBound{bound, expr})
+			}
+		}
+	case EqualsExpr:
+		if conflict := c.addComparedFields(expr, expr.Lhs, expr.Rhs); conflict != nil {
+			return conflict
+		}
+		if eq, ok := getFieldEquality(expr.Lhs, expr.Rhs, expr); ok {
+			return c.addEqual(eq)
+		}
+	case LessThanExpr:
+		return c.addComparison(expr, expr.Lhs, expr.Rhs)
+	case LessEqualsExpr:
+		return c.addComparison(expr, expr.Lhs, expr.Rhs)
+	case GreaterThanExpr:
+		return c.addComparison(expr, expr.Lhs, expr.Rhs)
+	case GreaterEqualsExpr:
+		return c.addComparison(expr, expr.Lhs, expr.Rhs)
+	case LikeExpr:
+		return c.addComparedFields(expr, expr.Lhs, expr.Rhs)
+	case InExpr:
+		return c.addComparedFields(expr, expr.Lhs, nil)
+	case BetweenExpr:
+		return c.addBetween(expr)
+	}
+	return nil
+}
+
+// addComparedFields records that any fields compared by a positive
+// comparison must be present for the comparison to be true.
+func (c *fieldConstraints) addComparedFields(expr, lhs, rhs Expression) *constraintConflict {
+	for _, operand := range []Expression{lhs, rhs} {
+		if field, ok := operand.(FieldExpr); ok {
+			if conflict := c.addPresent(field, expr); conflict != nil {
+				return conflict
+			}
+		}
+	}
+	return nil
+}
+
+// addBetween adds the lower and upper bounds of a BETWEEN, which behaves the
+// same as an AND of the two comparisons.
+func (c *fieldConstraints) addBetween(expr BetweenExpr) *constraintConflict {
+	if conflict := c.addComparedFields(expr, expr.Lhs, expr.Low); conflict != nil {
+		return conflict
+	}
+	if conflict := c.addComparedFields(expr, expr.High, nil); conflict != nil {
+		return conflict
+	}
+
+	comparisons := []Expression{
+		GreaterEqualsExpr{expr.Lhs, expr.Low},
+		LessEqualsExpr{expr.Lhs, expr.High},
+	}
+	for _, comparison := range comparisons {
+		if bound, ok := getRangeBound(comparison); ok {
+			if conflict := c.addBound(fieldBound{bound, expr}); conflict != nil {
+				return conflict
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fieldConstraints) addComparison(expr, lhs, rhs Expression) *constraintConflict {
+	if conflict := c.addComparedFields(expr, lhs, rhs); conflict != nil {
+		return conflict
+	}
+	if bound, ok := getRangeBound(expr); ok {
+		return c.addBound(fieldBound{bound, expr})
+	}
+	return nil
+}
+
+// addNegatedBounds adds the opposite bound for each negated comparison
+// against a field which is known to be present.  A missing field makes every
+// comparison false, but a present field always compares in one direction or
+// the other.
+func (c *fieldConstraints) addNegatedBounds() *constraintConflict {
+	for _, nbound := range c.nbounds {
+		isPresent := false
+		for _, present := range c.present {
+			if fieldExprMatches(present.field, nbound.field) {
+				isPresent = true
+				break
+			}
+		}
+		if !isPresent {
+			continue
+		}
+
+		nbound.upper = !nbound.upper
+		nbound.inclusive = !nbound.inclusive
+		if conflict := c.addBound(nbound); conflict != nil {
+			return conflict
+		}
+	}
+	return nil
+}
+
+func (c *fieldConstraints) addPresent(field FieldExpr, expr Expression) *constraintConflict {
+	for _, missing := range c.missing {
+		if isFieldPathPrefix(missing.field, field) {
+			return &constraintConflict{"field cannot be both missing and present", []Expression{missing.expr, expr}}
+		}
+	}
+	c.present = append(c.present, fieldClause{field, expr})
+	return nil
+}
+
+func (c *fieldConstraints) addMissing(field FieldExpr, expr Expression) *constraintConflict {
+	for _, present := range c.present {
+		if isFieldPathPrefix(field, present.field) {
+			return &constraintConflict{"field cannot be both missing and present", []Expression{present.expr, expr}}
+		}
+	}
+	c.missing = append(c.missing, fieldClause{field, expr})
+	return nil
+}
+
+func (c *fieldConstraints) addEqual(eq fieldEquality) *constraintConflict {
+	for _, oeq := range c.equals {
+		if fieldExprMatches(oeq.field, eq.field) && oeq.conflictsWith(eq) {
+			return &constraintConflict{"field cannot be equal to both values", []Expression{oeq.expr, eq.expr}}
+		}
+	}
+	for _, neq := range c.unequals {
+		if fieldExprMatches(neq.field, eq.field) && neq.sameValueAs(eq) {
+			return &constraintConflict{"field cannot be both equal and not equal to a value", []Expression{neq.expr, eq.expr}}
+		}
+	}
+	c.equals = append(c.equals, eq)
+
+	if eq.class == constClassNumeric {
+		// A numeric equality bounds the field from both sides
+		lower := rangeBound{eq.field, false, true, eq.num}
+		upper := rangeBound{eq.field, true, true, eq.num}
+		if conflict := c.addBound(fieldBound{lower, eq.expr}); conflict != nil {
+			return conflict
+		}
+		return c.addBound(fieldBound{upper, eq.expr})
+	}
+	return nil
+}
+
+func (c *fieldConstraints) addUnequal(eq fieldEquality) *constraintConflict {
+	for _, oeq := range c.equals {
+		if fieldExprMatches(oeq.field, eq.field) && oeq.sameValueAs(eq) {
+			return &constraintConflict{"field cannot be both equal and not equal to a value", []Expression{oeq.expr, eq.expr}}
+		}
+	}
+	c.unequals = append(c.unequals, eq)
+
+	if eq.class == constClassNumeric {
+		return c.checkPinnedUnequal(eq.field)
+	}
+	return nil
+}
+
+// checkPinnedUnequal checks whether the bounds of a field only allow a single
+// value which the field is also required to not be equal to.
+func (c *fieldConstraints) checkPinnedUnequal(field FieldExpr) *constraintConflict {
+	var lower, upper *fieldBound
+	for i := range c.bounds {
+		bound := &c.bounds[i]
+		if !bound.inclusive || !fieldExprMatches(bound.field, field) {
+			continue
+		}
+		if bound.upper {
+			upper = bound
+		} else {
+			lower = bound
+		}
+
+		if lower == nil || upper == nil {
+			continue
+		}
+		if cmp, _ := lower.value.Compare(upper.value); cmp != 0 {
+			continue
+		}
+
+		for _, neq := range c.unequals {
+			if neq.class != constClassNumeric || !fieldExprMatches(neq.field, field) {
+				continue
+			}
+			if cmp, _ := neq.num.Compare(lower.value); cmp == 0 {
+				return &constraintConflict{"field cannot be both equal and not equal to a value",
+					[]Expression{lower.expr, upper.expr, neq.expr}}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fieldConstraints) addBound(bound fieldBound) *constraintConflict {
+	for _, obound := range c.bounds {
+		if obound.upper == bound.upper || !fieldExprMatches(obound.field, bound.field) {
+			continue
+		}
+
+		lower, upper := obound, bound
+		if obound.upper {
+			lower, upper = bound, obound
+		}
+
+		cmp, _ := lower.value.Compare(upper.value)
+		if cmp > 0 || (cmp == 0 && !(lower.inclusive && upper.inclusive)) {
+			return &constraintConflict{"field cannot be within an empty range", []Expression{obound.expr, bound.expr}}
+		}
+	}
+	c.bounds = append(c.bounds, bound)
+	return c.checkPinnedUnequal(bound.field)
+}
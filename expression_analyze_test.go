@@ -0,0 +1,164 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tParseFilterExpression(t *testing.T, filter string) Expression {
+	t.Helper()
+
+	_, fe, err := NewFilterExpressionParser(filter)
+	if err != nil {
+		t.Fatalf("failed to parse `%s`: %s", filter, err)
+	}
+	expr, err := fe.OutputExpression()
+	if err != nil {
+		t.Fatalf("failed to output `%s`: %s", filter, err)
+	}
+	return expr
+}
+
+// tCheckTruthMatches checks that a constant expression really does match
+// either every or none of the test documents.
+func tCheckTruthMatches(t *testing.T, expr Expression, truth Truth) {
+	t.Helper()
+
+	var trans Transformer
+	def, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		t.Fatalf("failed to compile %v: %s", expr, err)
+	}
+	matcher := NewFastMatcher(def)
+
+	for docIdx, doc := range getTestPeopleDocs() {
+		matcher.Reset()
+		matched, err := matcher.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match %v: %s", expr, err)
+		}
+		if matched != (truth == TruthAlways) {
+			t.Fatalf("document %d: %v is %s true but matched %t", docIdx, expr, truth, matched)
+		}
+	}
+}
+
+func TestAnalyzeNever(t *testing.T) {
+	filters := []string{
+		"age > 50 AND age < 10",
+		"age >= 30 AND age < 30",
+		"age >= 30 AND age <= 30 AND age <> 30",
+		"age = 30 AND age > 40",
+		"age = 30 AND age = 31",
+		"age > 50 AND NOT age > 30",
+		"name IS MISSING AND name = \"Neil\"",
+		"friends IS MISSING AND friends[0].name = \"Kim\"",
+		"EXISTS(name) AND name IS MISSING",
+		"name = \"Neil\" AND name = \"Brett\"",
+		"name = \"Neil\" AND name = 5",
+		"company IS NULL AND company = \"AFFLUEX\"",
+		"isActive = TRUE AND isActive = FALSE",
+		"isActive = TRUE AND NOT isActive = TRUE",
+		"REGEXP_CONTAINS(name, \"^N\") AND NOT REGEXP_CONTAINS(name, \"^N\")",
+		"gender = \"male\" AND (age > 50 AND age < 10 OR FALSE)",
+		"age > 50 AND age < 10 OR age > 60 AND age < 20",
+		"age BETWEEN 50 AND 10",
+		"age BETWEEN 10 AND 50 AND age > 60",
+	}
+
+	for _, filter := range filters {
+		t.Run(filter, func(t *testing.T) {
+			expr := tParseFilterExpression(t, filter)
+			report := Analyze(expr)
+			assert.Equal(t, TruthNever, report.Truth)
+			assert.False(t, report.Satisfiable())
+			assert.NotEmpty(t, report.Issues)
+			tCheckTruthMatches(t, expr, report.Truth)
+		})
+	}
+}
+
+func TestAnalyzeAlways(t *testing.T) {
+	filters := []string{
+		"TRUE",
+		"name IS MISSING OR name IS NOT MISSING",
+		"age = 30 OR age <> 30",
+		"gender = \"female\" OR NOT age > 50 OR age > 50",
+	}
+
+	for _, filter := range filters {
+		t.Run(filter, func(t *testing.T) {
+			expr := tParseFilterExpression(t, filter)
+			report := Analyze(expr)
+			assert.Equal(t, TruthAlways, report.Truth)
+			assert.NotEmpty(t, report.Issues)
+			tCheckTruthMatches(t, expr, report.Truth)
+		})
+	}
+
+	age := FieldExpr{0, []string{"age"}}
+	expr := OrExpr{
+		EqualsExpr{FieldExpr{0, []string{"gender"}}, ValueExpr{"female"}},
+		NotExpr{AndExpr{GreaterThanExpr{age, ValueExpr{50}}, LessThanExpr{age, ValueExpr{10}}}},
+	}
+	report := Analyze(expr)
+	assert.Equal(t, TruthAlways, report.Truth)
+	tCheckTruthMatches(t, expr, report.Truth)
+}
+
+func TestAnalyzeUnknown(t *testing.T) {
+	filters := []string{
+		"age > 10 AND age < 50",
+		"age >= 30 AND age <= 30",
+		"age = 30 AND age = 30.0",
+		"age = 30 AND age = \"30\"",
+		"name IS MISSING OR name = \"Neil\"",
+		"friends IS NOT MISSING AND friends[0].name IS MISSING",
+		"isActive = TRUE AND isActive = 1",
+		"age > 50 OR age <= 50",
+		"company IS NULL AND company <> \"AFFLUEX\"",
+		"NOT age > 50 AND NOT age < 60",
+		"age BETWEEN 30 AND 30",
+	}
+
+	for _, filter := range filters {
+		t.Run(filter, func(t *testing.T) {
+			report := Analyze(tParseFilterExpression(t, filter))
+			assert.Equal(t, TruthUnknown, report.Truth)
+			assert.True(t, report.Satisfiable())
+			assert.Empty(t, report.Issues)
+		})
+	}
+}
+
+func TestAnalyzeIssues(t *testing.T) {
+	assert := assert.New(t)
+
+	age := FieldExpr{0, []string{"age"}}
+	tooOld := GreaterThanExpr{age, ValueExpr{50}}
+	tooYoung := LessThanExpr{age, ValueExpr{10}}
+	impossible := AndExpr{tooOld, EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}}, tooYoung}
+
+	report := Analyze(OrExpr{
+		EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Neil"}},
+		impossible,
+		AnyInExpr{1, FieldExpr{0, []string{"friends"}}, FalseExpr{}},
+	})
+	assert.Equal(TruthUnknown, report.Truth)
+	assert.Equal([]AnalysisIssue{
+		{
+			Expr:    impossible,
+			Truth:   TruthNever,
+			Clauses: []Expression{tooOld, tooYoung},
+			Reason:  "field cannot be within an empty range",
+		},
+		{
+			Expr:   FalseExpr{},
+			Truth:  TruthNever,
+			Reason: "constant false",
+		},
+	}, report.Issues)
+}
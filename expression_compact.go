@@ -5,14 +5,13 @@ package gojsonsm
 func compactExpressionOr(expr OrExpr) Expression {
 	var newOrExpr OrExpr
 	for _, subExpr := range expr {
-		newSubExpr := CompactExpression(subExpr)
-		switch newSubExpr.(type) {
+		switch subExpr.(type) {
 		case TrueExpr:
 			return TrueExpr{}
 		case FalseExpr:
 			// Do Nothing
 		default:
-			newOrExpr = append(newOrExpr, newSubExpr)
+			newOrExpr = append(newOrExpr, subExpr)
 		}
 	}
 	if len(newOrExpr) == 0 {
@@ -24,14 +23,13 @@ func compactExpressionOr(expr OrExpr) Expression {
 func compactExpressionAnd(expr AndExpr) Expression {
 	var newAndExpr AndExpr
 	for _, subExpr := range expr {
-		newSubExpr := CompactExpression(subExpr)
-		switch newSubExpr.(type) {
+		switch subExpr.(type) {
 		case TrueExpr:
 			// Do nothing
 		case FalseExpr:
 			return FalseExpr{}
 		default:
-			newAndExpr = append(newAndExpr, newSubExpr)
+			newAndExpr = append(newAndExpr, subExpr)
 		}
 	}
 	if len(newAndExpr) == 0 {
@@ -60,10 +58,7 @@ func compactExpressionEveryIn(expr EveryInExpr) Expression {
 	return expr
 }
 
-// This really only compacts the initial components of the expression
-// to catch cases where a TrueExpr exists really low.
-// KNOWN NOT TO ALWAYS WORK!
-func CompactExpression(expr Expression) Expression {
+func compactExpressionOne(expr Expression) Expression {
 	switch expr := expr.(type) {
 	case OrExpr:
 		return compactExpressionOr(expr)
@@ -76,4 +71,11 @@ func CompactExpression(expr Expression) Expression {
 	default:
 		return expr
 	}
-}
\ No newline at end of file
+}
+
+// CompactExpression removes constant TrueExpr and FalseExpr values from AND
+// and OR expressions, and from the bodies of loops, anywhere within the
+// expression.
+func CompactExpression(expr Expression) Expression {
+	return Rewrite(expr, compactExpressionOne)
+}
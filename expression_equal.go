@@ -0,0 +1,221 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"reflect"
+	"sort"
+)
+
+// ExprEqual returns whether two expressions are structurally identical.
+// Constant values must be of the same Go type to be equal, so a ValueExpr
+// holding int64(1) is not equal to one holding float64(1).
+func ExprEqual(a, b Expression) bool {
+	return exprEqual(a, b, false)
+}
+
+// ExprEqualCommutative is like ExprEqual, but ignores the order of the
+// sub-expressions of AndExpr and OrExpr expressions.
+func ExprEqualCommutative(a, b Expression) bool {
+	return exprEqual(a, b, true)
+}
+
+// ExprHash returns a hash of an expression.  Expressions which are equal
+// according to ExprEqual always have the same hash.
+func ExprHash(expr Expression) uint64 {
+	return exprHash(expr, false)
+}
+
+// ExprHashCommutative returns a hash of an expression.  Expressions which are
+// equal according to ExprEqualCommutative always have the same hash.
+func ExprHashCommutative(expr Expression) uint64 {
+	return exprHash(expr, true)
+}
+
+func exprListEqual(a, b []Expression, commutative bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !exprEqual(a[i], b[i], commutative) {
+			return false
+		}
+	}
+	return true
+}
+
+// exprListEqualUnordered checks whether every expression in a can be paired
+// with an equal expression from b.
+func exprListEqualUnordered(a, b []Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	used := make([]bool, len(b))
+	for _, aExpr := range a {
+		found := false
+		for j, bExpr := range b {
+			if !used[j] && exprEqual(aExpr, bExpr, true) {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func exprEqual(a, b Expression, commutative bool) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+
+	switch a := a.(type) {
+	case ValueExpr:
+		return reflect.DeepEqual(a.Value, b.(ValueExpr).Value)
+	case TimeExpr:
+		return reflect.DeepEqual(a.Time, b.(TimeExpr).Time)
+	case RegexExpr:
+		return reflect.DeepEqual(a.Regex, b.(RegexExpr).Regex)
+	case PcreExpr:
+		return reflect.DeepEqual(a.Pcre, b.(PcreExpr).Pcre)
+	case LikePatternExpr:
+		return a == b.(LikePatternExpr)
+	case FieldExpr:
+		return fieldExprMatches(a, b.(FieldExpr))
+	case FuncExpr:
+		if a.FuncName != b.(FuncExpr).FuncName {
+			return false
+		}
+	case AnyInExpr:
+		if a.VarId != b.(AnyInExpr).VarId {
+			return false
+		}
+	case EveryInExpr:
+		if a.VarId != b.(EveryInExpr).VarId {
+			return false
+		}
+	case AnyEveryInExpr:
+		if a.VarId != b.(AnyEveryInExpr).VarId {
+			return false
+		}
+	case AndExpr:
+		if commutative {
+			return exprListEqualUnordered(a, b.(AndExpr))
+		}
+	case OrExpr:
+		if commutative {
+			return exprListEqualUnordered(a, b.(OrExpr))
+		}
+	}
+
+	aChildren, ok := exprChildren(a)
+	if !ok {
+		return reflect.DeepEqual(a, b)
+	}
+	bChildren, _ := exprChildren(b)
+	return exprListEqual(aChildren, bChildren, commutative)
+}
+
+type exprHasher struct {
+	hash.Hash64
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (h *exprHasher) writeVarint(value int64) {
+	n := binary.PutVarint(h.buf[:], value)
+	h.Write(h.buf[:n])
+}
+
+func (h *exprHasher) writeString(value string) {
+	n := binary.PutUvarint(h.buf[:], uint64(len(value)))
+	h.Write(h.buf[:n])
+	h.Write([]byte(value))
+}
+
+func (h *exprHasher) writeValue(value interface{}) {
+	// Positive and negative zero are equal, so must hash the same
+	switch typedValue := value.(type) {
+	case float64:
+		if typedValue == 0 {
+			value = float64(0)
+		}
+	case float32:
+		if typedValue == 0 {
+			value = float32(0)
+		}
+	}
+	h.writeString(fmt.Sprintf("%T:%v", value, value))
+}
+
+func exprHash(expr Expression, commutative bool) uint64 {
+	if expr == nil {
+		return 0
+	}
+
+	children, ok := exprChildren(expr)
+	childHashes := make([]uint64, len(children))
+	for i, child := range children {
+		childHashes[i] = exprHash(child, commutative)
+	}
+
+	if commutative {
+		switch expr.(type) {
+		case AndExpr, OrExpr:
+			sort.Slice(childHashes, func(i, j int) bool {
+				return childHashes[i] < childHashes[j]
+			})
+		}
+	}
+
+	h := exprHasher{Hash64: fnv.New64a()}
+	h.writeString(reflect.TypeOf(expr).String())
+
+	switch expr := expr.(type) {
+	case ValueExpr:
+		h.writeValue(expr.Value)
+	case TimeExpr:
+		h.writeValue(expr.Time)
+	case RegexExpr:
+		h.writeValue(expr.Regex)
+	case PcreExpr:
+		h.writeValue(expr.Pcre)
+	case LikePatternExpr:
+		h.writeString(expr.Pattern)
+		h.writeString(expr.Escape)
+	case FieldExpr:
+		h.writeVarint(int64(expr.Root))
+		for _, elem := range expr.Path {
+			h.writeString(elem)
+		}
+	case FuncExpr:
+		h.writeString(expr.FuncName)
+	case AnyInExpr:
+		h.writeVarint(int64(expr.VarId))
+	case EveryInExpr:
+		h.writeVarint(int64(expr.VarId))
+	case AnyEveryInExpr:
+		h.writeVarint(int64(expr.VarId))
+	default:
+		if !ok {
+			h.writeValue(expr)
+		}
+	}
+
+	for _, childHash := range childHashes {
+		binary.LittleEndian.PutUint64(h.buf[:8], childHash)
+		h.Write(h.buf[:8])
+	}
+
+	return h.Sum64()
+}
@@ -0,0 +1,109 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExprEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	exprs := append(getWalkTestExprs(), getWalkTestExpr())
+	for i, expr := range exprs {
+		copied := Rewrite(expr, func(expr Expression) Expression {
+			return expr
+		})
+		assert.True(ExprEqual(expr, copied), "%v", expr)
+		assert.True(ExprEqualCommutative(expr, copied), "%v", expr)
+		assert.Equal(ExprHash(expr), ExprHash(copied), "%v", expr)
+		assert.Equal(ExprHashCommutative(expr), ExprHashCommutative(copied), "%v", expr)
+
+		for j, other := range exprs {
+			if i != j {
+				assert.False(ExprEqual(expr, other), "%v = %v", expr, other)
+				assert.NotEqual(ExprHash(expr), ExprHash(other), "%v = %v", expr, other)
+			}
+		}
+	}
+
+	name := FieldExpr{0, []string{"name"}}
+	isNeil := EqualsExpr{name, ValueExpr{"Neil"}}
+	isOld := GreaterThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{50}}
+
+	notEqual := [][2]Expression{
+		{isNeil, EqualsExpr{ValueExpr{"Neil"}, name}},
+		{isNeil, NotEqualsExpr{name, ValueExpr{"Neil"}}},
+		{ValueExpr{int64(1)}, ValueExpr{float64(1)}},
+		{FieldExpr{0, []string{"a", "b"}}, FieldExpr{0, []string{"ab"}}},
+		{FieldExpr{0, []string{"a"}}, FieldExpr{1, []string{"a"}}},
+		{AnyInExpr{1, name, TrueExpr{}}, AnyInExpr{2, name, TrueExpr{}}},
+		{AnyInExpr{1, name, TrueExpr{}}, EveryInExpr{1, name, TrueExpr{}}},
+		{FuncExpr{MathFuncAbs, []Expression{ValueExpr{1}}}, FuncExpr{MathFuncCeil, []Expression{ValueExpr{1}}}},
+		{AndExpr{isNeil, isOld}, OrExpr{isNeil, isOld}},
+		{AndExpr{isNeil, isOld}, AndExpr{isNeil, isOld, isOld}},
+		{AndExpr{isNeil, isNeil, isOld}, AndExpr{isNeil, isOld, isOld}},
+		{NotExpr{nil}, NotExpr{TrueExpr{}}},
+	}
+	for _, pair := range notEqual {
+		assert.False(ExprEqual(pair[0], pair[1]), "%v = %v", pair[0], pair[1])
+		assert.False(ExprEqualCommutative(pair[0], pair[1]), "%v = %v", pair[0], pair[1])
+		assert.NotEqual(ExprHash(pair[0]), ExprHash(pair[1]), "%v = %v", pair[0], pair[1])
+		assert.NotEqual(ExprHashCommutative(pair[0]), ExprHashCommutative(pair[1]), "%v = %v", pair[0], pair[1])
+	}
+
+	assert.True(ExprEqual(ValueExpr{0.0}, ValueExpr{-0.0}))
+	assert.Equal(ExprHash(ValueExpr{0.0}), ExprHash(ValueExpr{-0.0}))
+	assert.True(ExprEqual(FieldExpr{1, nil}, FieldExpr{1, []string{}}))
+	assert.Equal(ExprHash(FieldExpr{1, nil}), ExprHash(FieldExpr{1, []string{}}))
+}
+
+func TestExprEqualCommutative(t *testing.T) {
+	assert := assert.New(t)
+
+	isNeil := EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Neil"}}
+	isOld := GreaterThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{50}}
+	isActive := EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}}
+
+	a := AndExpr{isNeil, OrExpr{isOld, isActive}, isNeil}
+	b := AndExpr{OrExpr{isActive, isOld}, isNeil, isNeil}
+
+	assert.False(ExprEqual(a, b))
+	assert.NotEqual(ExprHash(a), ExprHash(b))
+	assert.True(ExprEqualCommutative(a, b))
+	assert.Equal(ExprHashCommutative(a), ExprHashCommutative(b))
+
+	// Only the order of AND and OR sub-expressions is ignored
+	c := EqualsExpr{ValueExpr{"Neil"}, FieldExpr{0, []string{"name"}}}
+	assert.False(ExprEqualCommutative(isNeil, c))
+}
+
+func TestExprHashAsKey(t *testing.T) {
+	filters := []string{
+		"name = \"Neil\" AND age > 50",
+		"age > 50 AND name = \"Neil\"",
+		"name = \"Neil\" AND age > 50",
+		"name = \"Neil\" OR age > 50",
+	}
+
+	// Group identical filters by their hash, as a filter cache would
+	cache := make(map[uint64][]Expression)
+	for _, filter := range filters {
+		expr := tParseFilterExpression(t, filter)
+		hash := ExprHashCommutative(expr)
+
+		found := false
+		for _, cached := range cache[hash] {
+			if ExprEqualCommutative(cached, expr) {
+				found = true
+			}
+		}
+		if !found {
+			cache[hash] = append(cache[hash], expr)
+		}
+	}
+
+	assert.Len(t, cache, 2)
+}
@@ -0,0 +1,283 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Confidence describes how much an answer from Implies or Equivalent can
+// be relied upon.
+type Confidence int
+
+const (
+	// ConfidenceUnknown means that the answer could not be proven either
+	// way.  The answer returned alongside it is always false.
+	ConfidenceUnknown Confidence = iota
+
+	// ConfidenceCertain means that the answer has been proven.
+	ConfidenceCertain
+)
+
+func (value Confidence) String() string {
+	switch value {
+	case ConfidenceUnknown:
+		return "unknown"
+	case ConfidenceCertain:
+		return "certain"
+	}
+	return "??ERROR??"
+}
+
+// The maximum number of conjunctions that expressions are expanded into
+// before Implies gives up.
+const maxImpliesConjunctions = 256
+
+// Implies returns whether every document matched by a is also matched by b.
+// This is proven by showing that a AND NOT b can never be true, using the
+// same checks as Analyze.  Otherwise, a document is constructed from the
+// constraints which a AND NOT b place on fields and matched against both
+// expressions to prove that a does not imply b.  If neither succeeds, for
+// instance because functions or regular expressions are involved, Implies
+// returns false with ConfidenceUnknown.
+func Implies(a, b Expression) (bool, Confidence) {
+	conjunctions, ok := expressionToDnf(SimplifyExpression(AndExpr{a, NotExpr{b}}), maxImpliesConjunctions)
+	if !ok {
+		return false, ConfidenceUnknown
+	}
+
+	proven := true
+	for _, clauses := range conjunctions {
+		constraints, conflict := findConjunctionConflict(clauses)
+		if conflict != nil {
+			continue
+		}
+
+		if isImpliesCounterexample(a, b, constraints) {
+			return false, ConfidenceCertain
+		}
+		proven = false
+	}
+
+	if !proven {
+		return false, ConfidenceUnknown
+	}
+	return true, ConfidenceCertain
+}
+
+// Equivalent returns whether a and b match exactly the same documents.  See
+// Implies for the meaning of the returned Confidence.
+func Equivalent(a, b Expression) (bool, Confidence) {
+	if aImpliesB, confidence := Implies(a, b); !aImpliesB {
+		return false, confidence
+	}
+	return Implies(b, a)
+}
+
+// expressionToDnf expands an expression whose negations have already been
+// pushed inward into a list of conjunctions of clauses, any of which being
+// true makes the expression true.  It returns false if more than limit
+// conjunctions would be needed.
+func expressionToDnf(expr Expression, limit int) ([][]Expression, bool) {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		return [][]Expression{nil}, true
+	case FalseExpr:
+		return nil, true
+	case OrExpr:
+		var out [][]Expression
+		for _, subExpr := range expr {
+			subDnf, ok := expressionToDnf(subExpr, limit)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, subDnf...)
+			if len(out) > limit {
+				return nil, false
+			}
+		}
+		return out, true
+	case AndExpr:
+		out := [][]Expression{nil}
+		for _, subExpr := range expr {
+			subDnf, ok := expressionToDnf(subExpr, limit)
+			if !ok {
+				return nil, false
+			}
+			if len(out)*len(subDnf) > limit {
+				return nil, false
+			}
+
+			var product [][]Expression
+			for _, lhs := range out {
+				for _, rhs := range subDnf {
+					clauses := make([]Expression, 0, len(lhs)+len(rhs))
+					clauses = append(clauses, lhs...)
+					clauses = append(clauses, rhs...)
+					product = append(product, clauses)
+				}
+			}
+			out = product
+		}
+		return out, true
+	}
+
+	return [][]Expression{{expr}}, true
+}
+
+// isImpliesCounterexample builds a document which satisfies the constraints
+// and checks whether it is matched by a but not by b.
+func isImpliesCounterexample(a, b Expression, constraints *fieldConstraints) bool {
+	doc, ok := constraints.buildDocument()
+	if !ok {
+		return false
+	}
+
+	aMatched, err := matchExpressionOnce(a, doc)
+	if err != nil || !aMatched {
+		return false
+	}
+
+	bMatched, err := matchExpressionOnce(b, doc)
+	return err == nil && !bMatched
+}
+
+func matchExpressionOnce(expr Expression, doc []byte) (bool, error) {
+	var trans Transformer
+	def, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		return false, err
+	}
+	return NewFastMatcher(def).Match(doc)
+}
+
+// pickNumber picks a number within the bounds on a field, avoiding any
+// values the field must not be equal to.
+func (c *fieldConstraints) pickNumber(field FieldExpr) float64 {
+	var lower, upper *float64
+	for _, bound := range c.bounds {
+		if !fieldExprMatches(bound.field, field) {
+			continue
+		}
+		value, _ := bound.value.AsFloat()
+		if bound.upper && (upper == nil || value < *upper) {
+			upper = &value
+		} else if !bound.upper && (lower == nil || value > *lower) {
+			lower = &value
+		}
+	}
+
+	var value float64
+	switch {
+	case lower != nil && upper != nil:
+		value = (*lower + *upper) / 2
+	case lower != nil:
+		value = *lower + 1
+	case upper != nil:
+		value = *upper - 1
+	}
+
+	for _, neq := range c.unequals {
+		if neq.class != constClassNumeric || !fieldExprMatches(neq.field, field) {
+			continue
+		}
+		if neqValue, _ := neq.num.AsFloat(); neqValue == value {
+			if upper != nil {
+				value = (value + *upper) / 2
+			} else {
+				value += 1
+			}
+		}
+	}
+	return value
+}
+
+// buildDocument builds a JSON document which is likely to satisfy the
+// constraints.  It returns false if the constraints reference fields which
+// cannot be represented, such as loop variables or array elements.
+func (c *fieldConstraints) buildDocument() ([]byte, bool) {
+	var fields []FieldExpr
+	addField := func(field FieldExpr) {
+		for _, ofield := range fields {
+			if fieldExprMatches(ofield, field) {
+				return
+			}
+		}
+		fields = append(fields, field)
+	}
+	for _, present := range c.present {
+		addField(present.field)
+	}
+	for _, bound := range c.bounds {
+		addField(bound.field)
+	}
+	for _, eq := range c.equals {
+		addField(eq.field)
+	}
+
+	// Nested fields are placed first so that their parents become objects
+	sort.SliceStable(fields, func(i, j int) bool {
+		return len(fields[i].Path) > len(fields[j].Path)
+	})
+
+	doc := make(map[string]interface{})
+	for _, field := range fields {
+		if field.Root != 0 || len(field.Path) == 0 {
+			return nil, false
+		}
+
+		var value interface{}
+		hasValue := false
+		for _, eq := range c.equals {
+			if fieldExprMatches(eq.field, field) {
+				value = eq.value
+				hasValue = true
+				break
+			}
+		}
+		if !hasValue {
+			value = c.pickNumber(field)
+		}
+
+		if !setDocumentPath(doc, field.Path, value, hasValue) {
+			return nil, false
+		}
+	}
+
+	bytes, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false
+	}
+	return bytes, true
+}
+
+func setDocumentPath(doc map[string]interface{}, path []string, value interface{}, force bool) bool {
+	for _, elem := range path {
+		if strings.HasPrefix(elem, "[") || elem == "META()" {
+			return false
+		}
+	}
+
+	for _, elem := range path[:len(path)-1] {
+		child, ok := doc[elem]
+		if !ok {
+			child = make(map[string]interface{})
+			doc[elem] = child
+		}
+		childDoc, ok := child.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		doc = childDoc
+	}
+
+	last := path[len(path)-1]
+	if _, ok := doc[last]; ok {
+		// Presence of an object is enough unless a specific value is needed
+		return !force
+	}
+	doc[last] = value
+	return true
+}
@@ -0,0 +1,74 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImplies(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		implies    bool
+		confidence Confidence
+	}{
+		{"age > 50", "age > 30", true, ConfidenceCertain},
+		{"age > 30", "age > 50", false, ConfidenceCertain},
+		{"age = 35", "age >= 30 AND age <= 40", true, ConfidenceCertain},
+		{"age = 45", "age >= 30 AND age <= 40", false, ConfidenceCertain},
+		{"age > 20 AND age < 30", "age > 10", true, ConfidenceCertain},
+		{"name = \"Neil\" AND age > 5", "name = \"Neil\"", true, ConfidenceCertain},
+		{"name = \"Neil\"", "name = \"Neil\" AND age > 5", false, ConfidenceCertain},
+		{"name = \"Neil\"", "name IS NOT MISSING", true, ConfidenceCertain},
+		{"name IS NOT MISSING", "name = \"Neil\"", false, ConfidenceCertain},
+		{"name = \"Neil\"", "name <> \"Brett\"", true, ConfidenceCertain},
+		{"isActive = TRUE", "isActive = TRUE OR age > 5", true, ConfidenceCertain},
+		{"isActive = TRUE OR age > 5", "isActive = TRUE", false, ConfidenceCertain},
+		{"company.name = \"x\"", "company IS NOT MISSING", true, ConfidenceCertain},
+		{"age > 50 AND age < 10", "name = \"Neil\"", true, ConfidenceCertain},
+		{"REGEXP_CONTAINS(name, \"^N\") AND age > 5", "REGEXP_CONTAINS(name, \"^N\")", true, ConfidenceCertain},
+		{"REGEXP_CONTAINS(name, \"^N\")", "REGEXP_CONTAINS(name, \"^Ne\")", false, ConfidenceUnknown},
+		{"ABS(age) > 5", "age > 5", false, ConfidenceUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" => "+test.b, func(t *testing.T) {
+			implies, confidence := Implies(tParseFilterExpression(t, test.a), tParseFilterExpression(t, test.b))
+			assert.Equal(t, test.implies, implies)
+			assert.Equal(t, test.confidence, confidence)
+		})
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		equivalent bool
+		confidence Confidence
+	}{
+		{"age > 30 AND age > 50", "age > 50", true, ConfidenceCertain},
+		{"name = \"Neil\" OR age > 5", "age > 5 OR name = \"Neil\"", true, ConfidenceCertain},
+		{"age > 30", "age > 50", false, ConfidenceCertain},
+		{"age > 50", "age > 30", false, ConfidenceCertain},
+		{"age > 30", "age >= 31", false, ConfidenceCertain},
+		{"age > 30", "ABS(age) > 30 AND age > 30", false, ConfidenceUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" <=> "+test.b, func(t *testing.T) {
+			equivalent, confidence := Equivalent(tParseFilterExpression(t, test.a), tParseFilterExpression(t, test.b))
+			assert.Equal(t, test.equivalent, equivalent)
+			assert.Equal(t, test.confidence, confidence)
+		})
+	}
+
+	isActive := EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}}
+	isBlue := EqualsExpr{FieldExpr{0, []string{"eyeColor"}}, ValueExpr{"blue"}}
+	equivalent, confidence := Equivalent(
+		NotExpr{AndExpr{isActive, isBlue}},
+		OrExpr{NotExpr{isBlue}, NotExpr{isActive}})
+	assert.True(t, equivalent)
+	assert.Equal(t, ConfidenceCertain, confidence)
+}
@@ -3,8 +3,12 @@
 package gojsonsm
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
 )
 
 func parseJsonValue(data []interface{}) (Expression, error) {
@@ -157,6 +161,53 @@ func parseJsonGreaterEquals(data []interface{}) (Expression, error) {
 	return GreaterEqualsExpr{lhs, rhs}, nil
 }
 
+func parseJsonIn(data []interface{}) (Expression, error) {
+	if len(data) < 2 {
+		return nil, errors.New("invalid in expression format")
+	}
+
+	var out InExpr
+	for i := 1; i < len(data); i++ {
+		subexprData, ok := data[i].([]interface{})
+		if !ok {
+			return nil, errors.New("invalid in expression format")
+		}
+
+		subexpr, err := parseJsonSubexpr(subexprData)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 1 {
+			out.Lhs = subexpr
+		} else {
+			out.Values = append(out.Values, subexpr)
+		}
+	}
+	return out, nil
+}
+
+func parseJsonBetween(data []interface{}) (Expression, error) {
+	if len(data) != 4 {
+		return nil, errors.New("invalid between expression format")
+	}
+
+	var subexprs [3]Expression
+	for i := range subexprs {
+		subexprData, ok := data[i+1].([]interface{})
+		if !ok {
+			return nil, errors.New("invalid between expression format")
+		}
+
+		subexpr, err := parseJsonSubexpr(subexprData)
+		if err != nil {
+			return nil, err
+		}
+		subexprs[i] = subexpr
+	}
+	return BetweenExpr{subexprs[0], subexprs[1], subexprs[2]}, nil
+}
+
 func parseJsonNot(data []interface{}) (Expression, error) {
 	var out NotExpr
 
@@ -282,6 +333,33 @@ func parseJsonRegex(data []interface{}) (Expression, error) {
 	}, nil
 }
 
+func parseJsonPcre(data []interface{}) (Expression, error) {
+	return PcreExpr{
+		data[1],
+	}, nil
+}
+
+func parseJsonLikePattern(data []interface{}) (Expression, error) {
+	if len(data) != 2 && len(data) != 3 {
+		return nil, errors.New("invalid likepattern expression format")
+	}
+
+	pattern, ok := data[1].(string)
+	if !ok {
+		return nil, errors.New("invalid likepattern expression format")
+	}
+
+	var escape string
+	if len(data) == 3 {
+		escape, ok = data[2].(string)
+		if !ok {
+			return nil, errors.New("invalid likepattern expression format")
+		}
+	}
+
+	return LikePatternExpr{pattern, escape}, nil
+}
+
 func parseJsonTime(data []interface{}) (Expression, error) {
 	if dateStr, ok := data[1].(string); ok && !validTimeChecker(dateStr) {
 		return nil, ErrorInvalidTimeFormat
@@ -299,6 +377,10 @@ func parseJsonSubexpr(data []interface{}) (Expression, error) {
 	}
 
 	switch exprType {
+	case "true":
+		return TrueExpr{}, nil
+	case "false":
+		return FalseExpr{}, nil
 	case "value":
 		return parseJsonValue(data)
 	case "field":
@@ -335,8 +417,16 @@ func parseJsonSubexpr(data []interface{}) (Expression, error) {
 		return parseJsonGreaterEquals(data)
 	case "like":
 		return parseJsonLike(data)
+	case "in":
+		return parseJsonIn(data)
+	case "between":
+		return parseJsonBetween(data)
 	case "regex":
 		return parseJsonRegex(data)
+	case "pcre":
+		return parseJsonPcre(data)
+	case "likepattern":
+		return parseJsonLikePattern(data)
 	case "time":
 		return parseJsonTime(data)
 	}
@@ -344,11 +434,187 @@ func parseJsonSubexpr(data []interface{}) (Expression, error) {
 	return nil, errors.New("invalid expression type")
 }
 
+// maxExactJsonInt is the largest magnitude below which every integer can be
+// represented exactly by a float64.
+const maxExactJsonInt = 1 << 53
+
+// parseJsonNumbers replaces the numbers decoded with UseNumber by float64s,
+// as json.Unmarshal would, except for integers which a float64 cannot hold
+// exactly.  Those are kept as an int64 or uint64 so that they survive being
+// marshalled and parsed again unchanged.
+func parseJsonNumbers(data interface{}) (interface{}, error) {
+	switch data := data.(type) {
+	case json.Number:
+		if val, err := data.Int64(); err == nil {
+			if val > maxExactJsonInt || val < -maxExactJsonInt {
+				return val, nil
+			}
+		} else if val, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+			return val, nil
+		}
+		return data.Float64()
+	case []interface{}:
+		for i, elem := range data {
+			val, err := parseJsonNumbers(elem)
+			if err != nil {
+				return nil, err
+			}
+			data[i] = val
+		}
+	case map[string]interface{}:
+		for key, elem := range data {
+			val, err := parseJsonNumbers(elem)
+			if err != nil {
+				return nil, err
+			}
+			data[key] = val
+		}
+	}
+	return data, nil
+}
+
 func ParseJsonExpression(data []byte) (Expression, error) {
 	var parsedData []interface{}
-	err := json.Unmarshal(data, &parsedData)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&parsedData)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after expression")
+	}
+
+	_, err = parseJsonNumbers(parsedData)
 	if err != nil {
 		return nil, err
 	}
 	return parseJsonSubexpr(parsedData)
 }
+
+func marshalJsonComparison(exprType string, lhs, rhs Expression) ([]interface{}, error) {
+	lhsData, err := marshalJsonSubexpr(lhs)
+	if err != nil {
+		return nil, err
+	}
+
+	rhsData, err := marshalJsonSubexpr(rhs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{exprType, lhsData, rhsData}, nil
+}
+
+func marshalJsonList(out []interface{}, exprs []Expression) ([]interface{}, error) {
+	for _, subexpr := range exprs {
+		subexprData, err := marshalJsonSubexpr(subexpr)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, subexprData)
+	}
+	return out, nil
+}
+
+func marshalJsonLoop(exprType string, varId VariableID, inExpr, subExpr Expression) ([]interface{}, error) {
+	inData, err := marshalJsonSubexpr(inExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	subexprData, err := marshalJsonSubexpr(subExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{exprType, int(varId), inData, subexprData}, nil
+}
+
+func marshalJsonField(expr FieldExpr) []interface{} {
+	out := []interface{}{"field"}
+
+	// The root may only be omitted when it is the document and there is a
+	// path, otherwise the parser would have nothing to read.
+	if expr.Root != 0 || len(expr.Path) == 0 {
+		out = append(out, int(expr.Root))
+	}
+
+	for _, elem := range expr.Path {
+		out = append(out, elem)
+	}
+	return out
+}
+
+func marshalJsonSubexpr(expr Expression) ([]interface{}, error) {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		return []interface{}{"true"}, nil
+	case FalseExpr:
+		return []interface{}{"false"}, nil
+	case ValueExpr:
+		return []interface{}{"value", expr.Value}, nil
+	case TimeExpr:
+		return []interface{}{"time", expr.Time}, nil
+	case RegexExpr:
+		return []interface{}{"regex", expr.Regex}, nil
+	case PcreExpr:
+		return []interface{}{"pcre", expr.Pcre}, nil
+	case LikePatternExpr:
+		if expr.Escape == "" {
+			return []interface{}{"likepattern", expr.Pattern}, nil
+		}
+		return []interface{}{"likepattern", expr.Pattern, expr.Escape}, nil
+	case FieldExpr:
+		return marshalJsonField(expr), nil
+	case FuncExpr:
+		return marshalJsonList([]interface{}{"func", expr.FuncName}, expr.Params)
+	case NotExpr:
+		return marshalJsonList([]interface{}{"not"}, []Expression{expr.SubExpr})
+	case AndExpr:
+		return marshalJsonList([]interface{}{"and"}, expr)
+	case OrExpr:
+		return marshalJsonList([]interface{}{"or"}, expr)
+	case AnyInExpr:
+		return marshalJsonLoop("anyin", expr.VarId, expr.InExpr, expr.SubExpr)
+	case EveryInExpr:
+		return marshalJsonLoop("everyin", expr.VarId, expr.InExpr, expr.SubExpr)
+	case AnyEveryInExpr:
+		return marshalJsonLoop("anyeveryin", expr.VarId, expr.InExpr, expr.SubExpr)
+	case ExistsExpr:
+		return marshalJsonList([]interface{}{"exists"}, []Expression{expr.SubExpr})
+	case NotExistsExpr:
+		return marshalJsonList([]interface{}{"notexists"}, []Expression{expr.SubExpr})
+	case EqualsExpr:
+		return marshalJsonComparison("equals", expr.Lhs, expr.Rhs)
+	case NotEqualsExpr:
+		return marshalJsonComparison("notequals", expr.Lhs, expr.Rhs)
+	case LessThanExpr:
+		return marshalJsonComparison("lessthan", expr.Lhs, expr.Rhs)
+	case LessEqualsExpr:
+		return marshalJsonComparison("lessequals", expr.Lhs, expr.Rhs)
+	case GreaterThanExpr:
+		return marshalJsonComparison("greaterthan", expr.Lhs, expr.Rhs)
+	case GreaterEqualsExpr:
+		return marshalJsonComparison("greaterequals", expr.Lhs, expr.Rhs)
+	case LikeExpr:
+		return marshalJsonComparison("like", expr.Lhs, expr.Rhs)
+	case InExpr:
+		return marshalJsonList([]interface{}{"in"}, append([]Expression{expr.Lhs}, expr.Values...))
+	case BetweenExpr:
+		return marshalJsonList([]interface{}{"between"}, []Expression{expr.Lhs, expr.Low, expr.High})
+	}
+
+	return nil, fmt.Errorf("cannot marshal expression of type %T", expr)
+}
+
+// MarshalJsonExpression writes an expression out in the JSON array format
+// read by ParseJsonExpression.
+func MarshalJsonExpression(expr Expression) ([]byte, error) {
+	data, err := marshalJsonSubexpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
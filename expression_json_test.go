@@ -0,0 +1,173 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalJsonExpressionRoundTrip(t *testing.T) {
+	exprs := []string{
+		`["true"]`,
+		`["false"]`,
+		`["equals", ["field", "name"], ["value", "Neil"]]`,
+		`["equals", ["field", "nested", "value"], ["value", null]]`,
+		`["notequals", ["field", 0], ["value", 12.5]]`,
+		`["and", ["lessthan", ["field", "age"], ["value", 30]], ["lessequals", ["field", "age"], ["value", 40]], ["true"]]`,
+		`["or", ["greaterthan", ["field", "age"], ["value", 30]], ["greaterequals", ["field", "age"], ["value", 40]], ["false"]]`,
+		`["not", ["exists", ["field", "company"]]]`,
+		`["notexists", ["field", "company"]]`,
+		`["like", ["field", "name"], ["regex", "Ne[a|i]l"]]`,
+		`["like", ["field", "name"], ["pcre", "Ne(?=i)il"]]`,
+		`["greaterthan", ["func", "date", ["field", "registered"]], ["time", "2015-01-02T03:04:05Z"]]`,
+		`["equals", ["func", "mathRound", ["func", "mathAdd", ["field", "latitude"], ["value", 1]]], ["value", 37]]`,
+		`["lessthan", ["field", "latitude"], ["func", "mathPi"]]`,
+		`["anyin", 1, ["field", "tags"], ["equals", ["field", 1], ["value", "dolor"]]]`,
+		`["everyin", 1, ["field", "friends"], ["anyin", 2, ["field", 1, "tags"], ["equals", ["field", 2], ["field", 1, "name"]]]]`,
+		`["anyeveryin", 1, ["field", "friends"], ["equals", ["field", 1, "id"], ["field", "index"]]]`,
+		`["in", ["field", "eyeColor"], ["value", "blue"], ["value", 3], ["value", null]]`,
+		`["not", ["in", ["field", "age"], ["value", 20]]]`,
+		`["between", ["field", "age"], ["value", 20], ["func", "mathAdd", ["field", "index"], ["value", 10]]]`,
+		`["like", ["field", "name"], ["likepattern", "Ne%"]]`,
+		`["not", ["like", ["field", "discount"], ["likepattern", "10!%", "!"]]]`,
+		`["equals", ["field", "id"], ["value", 18446744073709551615]]`,
+		`["equals", ["field", "id"], ["value", -9007199254740993]]`,
+		`["in", ["field", "id"], ["value", 9007199254740993], ["value", 1e300]]`,
+	}
+
+	for _, data := range exprs {
+		expr, err := ParseJsonExpression([]byte(data))
+		if err != nil {
+			t.Fatalf("failed to parse `%s`: %s", data, err)
+		}
+
+		out, err := MarshalJsonExpression(expr)
+		if err != nil {
+			t.Fatalf("failed to marshal `%s`: %s", data, err)
+		}
+
+		newExpr, err := ParseJsonExpression(out)
+		if err != nil {
+			t.Fatalf("failed to parse marshalled `%s`: %s", out, err)
+		}
+
+		if !reflect.DeepEqual(expr, newExpr) {
+			t.Errorf("round trip of `%s` produced `%s`", data, out)
+		}
+	}
+}
+
+func TestParseJsonExpressionLargeIntegers(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected interface{}
+	}{
+		{`["value", 30]`, float64(30)},
+		{`["value", 9007199254740992]`, float64(9007199254740992)},
+		{`["value", 9007199254740993]`, int64(9007199254740993)},
+		{`["value", -9007199254740993]`, int64(-9007199254740993)},
+		{`["value", 18446744073709551615]`, uint64(18446744073709551615)},
+		{`["value", 1e20]`, float64(1e20)},
+		{`["value", 100000000000000000000000]`, float64(1e23)},
+	}
+
+	for _, test := range testCases {
+		expr, err := ParseJsonExpression([]byte(test.data))
+		if err != nil {
+			t.Fatalf("failed to parse `%s`: %s", test.data, err)
+		}
+
+		value := expr.(ValueExpr).Value
+		if value != test.expected {
+			t.Errorf("expected `%s` to parse to %T(%v), got %T(%v)", test.data, test.expected, test.expected, value, value)
+		}
+
+		out, err := MarshalJsonExpression(expr)
+		if err != nil {
+			t.Fatalf("failed to marshal `%s`: %s", test.data, err)
+		}
+
+		newExpr, err := ParseJsonExpression(out)
+		if err != nil {
+			t.Fatalf("failed to parse marshalled `%s`: %s", out, err)
+		}
+		if newExpr.(ValueExpr).Value != value {
+			t.Errorf("round trip of `%s` produced `%s`", test.data, out)
+		}
+	}
+
+	_, err := ParseJsonExpression([]byte(`["true"] ["false"]`))
+	if err == nil {
+		t.Errorf("expected trailing data to fail")
+	}
+}
+
+func TestMarshalJsonExpressionFilterExpressions(t *testing.T) {
+	filters := []string{
+		"TRUE AND (TRUE OR FALSE) AND TRUE OR FALSE",
+		"NOT NOT NOT TRUE",
+		"EXISTS(onePath) AND onePath IS NOT NULL AND onePath.field1 < onePath.field2",
+		"fieldpath.path IS NULL AND fieldpath.path2 IS MISSING",
+		"`onePath.Only` <> \"value\" OR `onePath.Only` <> \"value2\"",
+		"key < PI() AND -key < 0 AND key > -PI() AND key < ABS(-PI())",
+		"fieldpath.path <> POW(ABS(CEIL(PI())),2)",
+		"DATE(fieldpath.path) > DATE(\"2019-01-01\")",
+		"REGEXP_CONTAINS(`[$%XDCRInternalKey*%$]`, \"^xyz*\")",
+		"achievements[0] = 49 AND arrOfObjs[0].`1D` = 50",
+		"ABS(1025.0 / -achievements) = 256.25",
+		"status IN [\"a\", \"b\", 3, TRUE, NULL] AND status NOT IN [-1.5]",
+		"amount BETWEEN -1.5 AND 10 AND DATE(ts) NOT BETWEEN DATE(\"2019-01-01\") AND DATE(\"2019-02\")",
+		"name LIKE \"Ne_l%\" AND code NOT LIKE \"a!_b\" ESCAPE \"!\" AND REGEXP_LIKE(name, \"^N\")",
+	}
+
+	for _, filter := range filters {
+		_, fe, err := NewFilterExpressionParser(filter)
+		if err != nil {
+			t.Fatalf("failed to parse `%s`: %s", filter, err)
+		}
+
+		expr, err := fe.OutputExpression()
+		if err != nil {
+			t.Fatalf("failed to output `%s`: %s", filter, err)
+		}
+
+		out, err := MarshalJsonExpression(expr)
+		if err != nil {
+			t.Fatalf("failed to marshal `%s`: %s", filter, err)
+		}
+
+		newExpr, err := ParseJsonExpression(out)
+		if err != nil {
+			t.Fatalf("failed to parse marshalled `%s`: %s", out, err)
+		}
+
+		// Numeric values come back from JSON as floats, so we compare the
+		// serialized forms rather than the expressions themselves.
+		newOut, err := MarshalJsonExpression(newExpr)
+		if err != nil {
+			t.Fatalf("failed to marshal `%s`: %s", out, err)
+		}
+
+		if string(out) != string(newOut) {
+			t.Errorf("round trip of `%s` changed `%s` to `%s`", filter, out, newOut)
+		}
+	}
+}
+
+func TestMarshalJsonExpressionInvalid(t *testing.T) {
+	_, err := MarshalJsonExpression(nil)
+	if err == nil {
+		t.Errorf("expected a nil expression to fail")
+	}
+
+	_, err = MarshalJsonExpression(AndExpr{EqualsExpr{FieldExpr{0, []string{"a"}}, nil}})
+	if err == nil {
+		t.Errorf("expected a nested nil expression to fail")
+	}
+
+	_, err = MarshalJsonExpression(EqualsExpr{FieldExpr{0, []string{"a"}}, ValueExpr{make(chan int)}})
+	if err == nil {
+		t.Errorf("expected an unserializable value to fail")
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import "math"
+
+// SimplifyExpression returns an expression which matches exactly the same
+// documents as expr, but which is usually smaller.  Constant TRUE and FALSE
+// values are folded away, negations are pushed inward using De Morgan's laws,
+// nested AND and OR expressions are flattened and duplicates removed,
+// math functions with only constant parameters are evaluated and range
+// comparisons against the same field are merged.  The original expression
+// is not modified.
+func SimplifyExpression(expr Expression) Expression {
+	return Rewrite(expr, simplifyExpressionOne)
+}
+
+func simplifyExpressionOne(expr Expression) Expression {
+	switch expr := expr.(type) {
+	case NotExpr:
+		return simplifyNot(expr.SubExpr)
+	case AndExpr:
+		return simplifyAnd(expr)
+	case OrExpr:
+		return simplifyOr(expr)
+	case AnyInExpr:
+		// No item can ever satisfy the loop
+		if _, ok := expr.SubExpr.(FalseExpr); ok {
+			return FalseExpr{}
+		}
+	case AnyEveryInExpr:
+		if _, ok := expr.SubExpr.(FalseExpr); ok {
+			return FalseExpr{}
+		}
+	case FuncExpr:
+		return simplifyFunc(expr)
+	}
+	return expr
+}
+
+// simplifyNot returns the negation of an already simplified expression.
+func simplifyNot(expr Expression) Expression {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		return FalseExpr{}
+	case FalseExpr:
+		return TrueExpr{}
+	case NotExpr:
+		return expr.SubExpr
+	case ExistsExpr:
+		return NotExistsExpr{expr.SubExpr}
+	case NotExistsExpr:
+		return ExistsExpr{expr.SubExpr}
+	case NotEqualsExpr:
+		return EqualsExpr{expr.Lhs, expr.Rhs}
+	case AndExpr:
+		newExpr := make(OrExpr, len(expr))
+		for i, subExpr := range expr {
+			newExpr[i] = simplifyNot(subExpr)
+		}
+		return simplifyOr(newExpr)
+	case OrExpr:
+		newExpr := make(AndExpr, len(expr))
+		for i, subExpr := range expr {
+			newExpr[i] = simplifyNot(subExpr)
+		}
+		return simplifyAnd(newExpr)
+	}
+	return NotExpr{expr}
+}
+
+func simplifyAnd(expr AndExpr) Expression {
+	var terms []Expression
+	for _, subExpr := range expr {
+		switch subExpr := subExpr.(type) {
+		case TrueExpr:
+			// Do nothing
+		case FalseExpr:
+			return FalseExpr{}
+		case AndExpr:
+			terms = append(terms, subExpr...)
+		default:
+			terms = append(terms, subExpr)
+		}
+	}
+
+	terms = simplifyDedupe(terms)
+	terms = simplifyMergeRanges(terms, true)
+
+	switch len(terms) {
+	case 0:
+		return TrueExpr{}
+	case 1:
+		return terms[0]
+	}
+	return AndExpr(terms)
+}
+
+func simplifyOr(expr OrExpr) Expression {
+	var terms []Expression
+	for _, subExpr := range expr {
+		switch subExpr := subExpr.(type) {
+		case TrueExpr:
+			return TrueExpr{}
+		case FalseExpr:
+			// Do nothing
+		case OrExpr:
+			terms = append(terms, subExpr...)
+		default:
+			terms = append(terms, subExpr)
+		}
+	}
+
+	terms = simplifyDedupe(terms)
+	terms = simplifyMergeRanges(terms, false)
+
+	switch len(terms) {
+	case 0:
+		return FalseExpr{}
+	case 1:
+		return terms[0]
+	}
+	return OrExpr(terms)
+}
+
+func simplifyDedupe(terms []Expression) []Expression {
+	var out []Expression
+	for _, term := range terms {
+		isDupe := false
+		for _, oterm := range out {
+			if ExprEqual(term, oterm) {
+				isDupe = true
+				break
+			}
+		}
+		if !isDupe {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// rangeBound describes a comparison of a field against a numeric constant,
+// normalized so that the field is on the left hand side.
+type rangeBound struct {
+	field     FieldExpr
+	upper     bool
+	inclusive bool
+	value     FastVal
+}
+
+func getNumericValue(expr Expression) (FastVal, bool) {
+	valueExpr, ok := expr.(ValueExpr)
+	if !ok {
+		return FastVal{}, false
+	}
+
+	val := NewFastVal(valueExpr.Value)
+	if !val.IsNumeric() {
+		return FastVal{}, false
+	}
+	if val.IsFloat() && math.IsNaN(val.GetFloat()) {
+		return FastVal{}, false
+	}
+	return val, true
+}
+
+func getRangeBound(expr Expression) (rangeBound, bool) {
+	var lhs, rhs Expression
+	var bound rangeBound
+
+	switch expr := expr.(type) {
+	case LessThanExpr:
+		lhs, rhs = expr.Lhs, expr.Rhs
+		bound.upper = true
+	case LessEqualsExpr:
+		lhs, rhs = expr.Lhs, expr.Rhs
+		bound.upper = true
+		bound.inclusive = true
+	case GreaterThanExpr:
+		lhs, rhs = expr.Lhs, expr.Rhs
+	case GreaterEqualsExpr:
+		lhs, rhs = expr.Lhs, expr.Rhs
+		bound.inclusive = true
+	default:
+		return rangeBound{}, false
+	}
+
+	if field, ok := lhs.(FieldExpr); ok {
+		if val, ok := getNumericValue(rhs); ok {
+			bound.field = field
+			bound.value = val
+			return bound, true
+		}
+	} else if field, ok := rhs.(FieldExpr); ok {
+		if val, ok := getNumericValue(lhs); ok {
+			// The comparison is mirrored with the value on the left
+			bound.field = field
+			bound.value = val
+			bound.upper = !bound.upper
+			return bound, true
+		}
+	}
+
+	return rangeBound{}, false
+}
+
+// simplifyMergeRanges merges comparisons of the same field against numeric
+// constants in the same direction.  Within an AND only the most restrictive
+// of the comparisons is kept, and within an OR only the least restrictive.
+func simplifyMergeRanges(terms []Expression, isAnd bool) []Expression {
+	var out []Expression
+	var bounds []rangeBound
+	var boundIdxs []int
+
+	for _, term := range terms {
+		bound, ok := getRangeBound(term)
+		if !ok {
+			out = append(out, term)
+			continue
+		}
+
+		merged := false
+		for i, obound := range bounds {
+			if obound.upper != bound.upper || !fieldExprMatches(obound.field, bound.field) {
+				continue
+			}
+
+			cmp, _ := bound.value.Compare(obound.value)
+			if bound.upper {
+				cmp = -cmp
+			}
+
+			// cmp is now positive if the new bound is more restrictive
+			var replace bool
+			if cmp == 0 {
+				replace = isAnd == obound.inclusive && bound.inclusive != obound.inclusive
+			} else {
+				replace = isAnd == (cmp > 0)
+			}
+
+			if replace {
+				bounds[i] = bound
+				out[boundIdxs[i]] = term
+			}
+			merged = true
+			break
+		}
+
+		if !merged {
+			bounds = append(bounds, bound)
+			boundIdxs = append(boundIdxs, len(out))
+			out = append(out, term)
+		}
+	}
+
+	return out
+}
+
+func simplifyFunc(expr FuncExpr) Expression {
+	params := make([]FastVal, len(expr.Params))
+	for i, paramExpr := range expr.Params {
+		val, ok := getNumericValue(paramExpr)
+		if !ok {
+			return expr
+		}
+		params[i] = val
+	}
+
+	result, ok := evalConstMathFunc(expr.FuncName, params)
+	if !ok {
+		return expr
+	}
+
+	switch result.Type() {
+	case IntValue:
+		return ValueExpr{result.GetInt()}
+	case UintValue:
+		return ValueExpr{result.GetUint()}
+	case FloatValue:
+		floatVal := result.GetFloat()
+		if math.IsNaN(floatVal) || math.IsInf(floatVal, 0) {
+			return expr
+		}
+		return ValueExpr{floatVal}
+	}
+	return expr
+}
+
+func isZeroFastVal(val FastVal) bool {
+	floatVal, _ := val.AsFloat()
+	return floatVal == 0
+}
+
+// evalConstMathFunc evaluates a math function against constant parameters
+// in the same way as the matcher would.  It returns false if the function
+// cannot be evaluated ahead of time.
+func evalConstMathFunc(name string, params []FastVal) (FastVal, bool) {
+	switch len(params) {
+	case 0:
+		switch name {
+		case MathFuncPi:
+			return NewFloatFastVal(math.Pi), true
+		case MathFuncE:
+			return NewFloatFastVal(math.E), true
+		}
+	case 1:
+		p1 := params[0]
+		switch name {
+		case MathFuncAbs:
+			return FastValMathAbs(p1), true
+		case MathFuncAcos:
+			return FastValMathAcos(p1), true
+		case MathFuncAsin:
+			return FastValMathAsin(p1), true
+		case MathFuncAtan:
+			return FastValMathAtan(p1), true
+		case MathFuncRound:
+			return FastValMathRound(p1), true
+		case MathFuncCos:
+			return FastValMathCos(p1), true
+		case MathFuncSin:
+			return FastValMathSin(p1), true
+		case MathFuncTan:
+			return FastValMathTan(p1), true
+		case MathFuncSqrt:
+			return FastValMathSqrt(p1), true
+		case MathFuncExp:
+			return FastValMathExp(p1), true
+		case MathFuncLn:
+			return FastValMathLn(p1), true
+		case MathFuncLog:
+			return FastValMathLog(p1), true
+		case MathFuncCeil:
+			return FastValMathCeil(p1), true
+		case MathFuncFloor:
+			return FastValMathFloor(p1), true
+		case MathFuncDegrees:
+			return FastValMathDegrees(p1), true
+		case MathFuncRadians:
+			return FastValMathRadians(p1), true
+		case MathFuncNeg:
+			return FastValMathNeg(p1), true
+		}
+	case 2:
+		p1, p2 := params[0], params[1]
+		switch name {
+		case MathFuncAtan2:
+			return FastValMathAtan2(p1, p2), true
+		case MathFuncPow:
+			return FastValMathPow(p1, p2), true
+		case MathFuncAdd:
+			return FastValMathAdd(p1, p2), true
+		case MathFuncSub:
+			return FastValMathSub(p1, p2), true
+		case MathFuncMul:
+			return FastValMathMul(p1, p2), true
+		case MathFuncDiv:
+			// Integer division by zero would panic, leave it to the matcher
+			if isZeroFastVal(p2) {
+				return FastVal{}, false
+			}
+			return FastValMathDiv(p1, p2), true
+		case MathFuncMod:
+			if isZeroFastVal(p2) {
+				return FastVal{}, false
+			}
+			return FastValMathMod(p1, p2), true
+		}
+	}
+	return FastVal{}, false
+}
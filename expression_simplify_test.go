@@ -0,0 +1,215 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tCheckSimplifiedMatches(t *testing.T, expr, simplified Expression) {
+	t.Helper()
+
+	var trans Transformer
+	def, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		t.Fatalf("failed to compile %v: %s", expr, err)
+	}
+	origMatcher := NewFastMatcher(def)
+
+	var simpleTrans Transformer
+	simpleDef, err := simpleTrans.TransformE([]Expression{simplified})
+	if err != nil {
+		t.Fatalf("failed to compile %v: %s", simplified, err)
+	}
+	matcher := NewFastMatcher(simpleDef)
+
+	for docIdx, doc := range getTestPeopleDocs() {
+		origMatcher.Reset()
+		origMatched, err := origMatcher.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match %v: %s", expr, err)
+		}
+
+		matcher.Reset()
+		matched, err := matcher.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match %v: %s", simplified, err)
+		}
+
+		if matched != origMatched {
+			t.Fatalf("document %d: %v matched %t, original %v matched %t",
+				docIdx, simplified, matched, expr, origMatched)
+		}
+	}
+}
+
+func TestSimplifyExpression(t *testing.T) {
+	age := FieldExpr{0, []string{"age"}}
+	isActive := EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}}
+	isBlue := EqualsExpr{FieldExpr{0, []string{"eyeColor"}}, ValueExpr{"blue"}}
+	isMale := EqualsExpr{FieldExpr{0, []string{"gender"}}, ValueExpr{"male"}}
+
+	tests := []struct {
+		name     string
+		expr     Expression
+		expected Expression
+	}{
+		{
+			"true in and",
+			AndExpr{isActive, TrueExpr{}},
+			isActive,
+		},
+		{
+			"false in and",
+			AndExpr{isActive, FalseExpr{}},
+			FalseExpr{},
+		},
+		{
+			"true in or",
+			OrExpr{isActive, TrueExpr{}},
+			TrueExpr{},
+		},
+		{
+			"not true",
+			OrExpr{isActive, NotExpr{TrueExpr{}}},
+			isActive,
+		},
+		{
+			"double negation",
+			NotExpr{NotExpr{isActive}},
+			isActive,
+		},
+		{
+			"de morgan and",
+			NotExpr{AndExpr{isActive, NotExpr{isBlue}}},
+			OrExpr{NotExpr{isActive}, isBlue},
+		},
+		{
+			"de morgan or",
+			NotExpr{OrExpr{NotExpr{isActive}, NotExistsExpr{FieldExpr{0, []string{"friends"}}}}},
+			AndExpr{isActive, ExistsExpr{FieldExpr{0, []string{"friends"}}}},
+		},
+		{
+			"not not equals",
+			NotExpr{NotEqualsExpr{age, ValueExpr{30}}},
+			EqualsExpr{age, ValueExpr{30}},
+		},
+		{
+			"flatten",
+			AndExpr{isActive, AndExpr{isBlue, AndExpr{isMale}}},
+			AndExpr{isActive, isBlue, isMale},
+		},
+		{
+			"flatten or",
+			OrExpr{OrExpr{isActive, isBlue}, OrExpr{isMale, isActive}},
+			OrExpr{isActive, isBlue, isMale},
+		},
+		{
+			"duplicates",
+			AndExpr{isActive, isBlue, isActive},
+			AndExpr{isActive, isBlue},
+		},
+		{
+			"constant func",
+			GreaterThanExpr{age, FuncExpr{MathFuncAbs, []Expression{ValueExpr{-30}}}},
+			GreaterThanExpr{age, ValueExpr{int64(30)}},
+		},
+		{
+			"nested constant func",
+			LessThanExpr{age, FuncExpr{MathFuncMul, []Expression{
+				FuncExpr{MathFuncNeg, []Expression{ValueExpr{-3}}},
+				ValueExpr{10},
+			}}},
+			LessThanExpr{age, ValueExpr{int64(30)}},
+		},
+		{
+			"constant pi",
+			LessThanExpr{age, FuncExpr{MathFuncMul, []Expression{
+				FuncExpr{MathFuncPi, nil},
+				ValueExpr{10},
+			}}},
+			LessThanExpr{age, ValueExpr{math.Pi * 10}},
+		},
+		{
+			"non-constant func",
+			GreaterThanExpr{FuncExpr{MathFuncAbs, []Expression{age}}, ValueExpr{30}},
+			GreaterThanExpr{FuncExpr{MathFuncAbs, []Expression{age}}, ValueExpr{30}},
+		},
+		{
+			"lower bounds in and",
+			AndExpr{GreaterThanExpr{age, ValueExpr{20}}, isActive, GreaterThanExpr{age, ValueExpr{30}}},
+			AndExpr{GreaterThanExpr{age, ValueExpr{30}}, isActive},
+		},
+		{
+			"upper bounds in and",
+			AndExpr{LessEqualsExpr{age, ValueExpr{30}}, LessThanExpr{age, ValueExpr{30}}, LessThanExpr{age, ValueExpr{35.5}}},
+			LessThanExpr{age, ValueExpr{30}},
+		},
+		{
+			"mirrored bounds in and",
+			AndExpr{GreaterEqualsExpr{age, ValueExpr{25}}, LessThanExpr{ValueExpr{30}, age}},
+			LessThanExpr{ValueExpr{30}, age},
+		},
+		{
+			"bounds in or",
+			OrExpr{GreaterThanExpr{age, ValueExpr{20}}, GreaterEqualsExpr{age, ValueExpr{20}}, GreaterThanExpr{age, ValueExpr{30}}},
+			GreaterEqualsExpr{age, ValueExpr{20}},
+		},
+		{
+			"bounds in both directions",
+			AndExpr{GreaterThanExpr{age, ValueExpr{20}}, LessThanExpr{age, ValueExpr{30}}},
+			AndExpr{GreaterThanExpr{age, ValueExpr{20}}, LessThanExpr{age, ValueExpr{30}}},
+		},
+		{
+			"bounds on different fields",
+			AndExpr{GreaterThanExpr{age, ValueExpr{20}}, GreaterThanExpr{FieldExpr{0, []string{"index"}}, ValueExpr{30}}},
+			AndExpr{GreaterThanExpr{age, ValueExpr{20}}, GreaterThanExpr{FieldExpr{0, []string{"index"}}, ValueExpr{30}}},
+		},
+		{
+			"loops",
+			OrExpr{
+				AnyInExpr{1, FieldExpr{0, []string{"friends"}}, AndExpr{isActive, FalseExpr{}}},
+				AnyEveryInExpr{2, FieldExpr{0, []string{"tags"}}, NotExpr{NotExpr{
+					EqualsExpr{FieldExpr{2, nil}, ValueExpr{"et"}},
+				}}},
+			},
+			AnyEveryInExpr{2, FieldExpr{0, []string{"tags"}}, EqualsExpr{FieldExpr{2, nil}, ValueExpr{"et"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simplified := SimplifyExpression(test.expr)
+			assert.Equal(t, test.expected, simplified)
+
+			// Simplifying again must not change anything
+			assert.Equal(t, simplified, SimplifyExpression(simplified))
+
+			tCheckSimplifiedMatches(t, test.expr, simplified)
+		})
+	}
+}
+
+func TestSimplifyExpressionUnchanged(t *testing.T) {
+	expr := AndExpr{
+		NotExpr{OrExpr{
+			EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}},
+			TrueExpr{},
+		}},
+		EqualsExpr{FieldExpr{0, []string{"age"}}, FuncExpr{MathFuncDiv, []Expression{ValueExpr{1}, ValueExpr{0}}}},
+	}
+	orig := AndExpr{
+		NotExpr{OrExpr{
+			EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}},
+			TrueExpr{},
+		}},
+		EqualsExpr{FieldExpr{0, []string{"age"}}, FuncExpr{MathFuncDiv, []Expression{ValueExpr{1}, ValueExpr{0}}}},
+	}
+
+	// Division by zero is left for the matcher to handle
+	assert.Equal(t, FalseExpr{}, SimplifyExpression(expr))
+	assert.Equal(t, orig, expr)
+}
@@ -17,95 +17,124 @@ func fieldExprMatches(lhs FieldExpr, rhs FieldExpr) bool {
 	return true
 }
 
-func fetchExprFieldRefsRecurse(expr Expression, loopVars []VariableID, fields []FieldExpr) []FieldExpr {
+type fieldRefsVisitor struct {
+	loopVars []VariableID
+	fields   *[]FieldExpr
+	err      *error
+}
+
+func (v fieldRefsVisitor) Visit(expr Expression) Visitor {
+	if *v.err != nil {
+		return nil
+	}
+
 	switch expr := expr.(type) {
+	case nil:
+		return nil
 	case FieldExpr:
-		isLoopVarRef := false
-		for _, loopVar := range loopVars {
+		for _, loopVar := range v.loopVars {
 			if expr.Root == loopVar {
-				isLoopVarRef = true
-				break
+				return nil
 			}
 		}
-		if isLoopVarRef {
-			break
-		}
 
-		fieldAlreadyExists := false
-		for _, oexpr := range fields {
+		for _, oexpr := range *v.fields {
 			if fieldExprMatches(expr, oexpr) {
-				fieldAlreadyExists = true
-				break
+				return nil
 			}
 		}
-		if fieldAlreadyExists {
-			break
-		}
 
-		fields = append(fields, expr)
-	case ValueExpr:
-	case RegexExpr:
-	case PcreExpr:
-	case TimeExpr:
-	case FuncExpr:
-		for _, subexpr := range expr.Params {
-			fields = fetchExprFieldRefsRecurse(subexpr, loopVars, fields)
-		}
-	case NotExpr:
-		fields = fetchExprFieldRefsRecurse(expr.SubExpr, loopVars, fields)
-	case AndExpr:
-		for _, subexpr := range expr {
-			fields = fetchExprFieldRefsRecurse(subexpr, loopVars, fields)
-		}
-	case OrExpr:
-		for _, subexpr := range expr {
-			fields = fetchExprFieldRefsRecurse(subexpr, loopVars, fields)
-		}
+		*v.fields = append(*v.fields, expr)
+		return nil
 	case AnyInExpr:
-		fields = fetchExprFieldRefsRecurse(expr.InExpr, loopVars, fields)
-		loopVars = append(loopVars, expr.VarId)
-		fields = fetchExprFieldRefsRecurse(expr.SubExpr, loopVars, fields)
-		loopVars = loopVars[0 : len(loopVars)-1]
+		v.visitLoop(expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
 	case EveryInExpr:
-		fields = fetchExprFieldRefsRecurse(expr.InExpr, loopVars, fields)
-		loopVars = append(loopVars, expr.VarId)
-		fields = fetchExprFieldRefsRecurse(expr.SubExpr, loopVars, fields)
-		loopVars = loopVars[0 : len(loopVars)-1]
+		v.visitLoop(expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
 	case AnyEveryInExpr:
-		fields = fetchExprFieldRefsRecurse(expr.InExpr, loopVars, fields)
-		loopVars = append(loopVars, expr.VarId)
-		fields = fetchExprFieldRefsRecurse(expr.SubExpr, loopVars, fields)
-		loopVars = loopVars[0 : len(loopVars)-1]
-	case EqualsExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case NotEqualsExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case LessThanExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case LessEqualsExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case GreaterThanExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case GreaterEqualsExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	case ExistsExpr:
-		fields = fetchExprFieldRefsRecurse(expr.SubExpr, loopVars, fields)
-	case LikeExpr:
-		fields = fetchExprFieldRefsRecurse(expr.Lhs, loopVars, fields)
-		fields = fetchExprFieldRefsRecurse(expr.Rhs, loopVars, fields)
-	default:
-		panic(fmt.Sprintf("unexpected expression type %T", expr))
+		v.visitLoop(expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
 	}
 
-	return fields
+	if _, ok := exprChildren(expr); !ok {
+		*v.err = fmt.Errorf("unexpected expression type %T", expr)
+		return nil
+	}
+
+	return v
+}
+
+func (v fieldRefsVisitor) visitLoop(varID VariableID, inExpr, subExpr Expression) {
+	Walk(inExpr, v)
+
+	// The loop variable is only in scope within the loop body
+	loopVars := make([]VariableID, len(v.loopVars), len(v.loopVars)+1)
+	copy(loopVars, v.loopVars)
+	v.loopVars = append(loopVars, varID)
+	Walk(subExpr, v)
+}
+
+func fetchExprFieldRefs(expr Expression) ([]FieldExpr, error) {
+	var fields []FieldExpr
+	var err error
+	Walk(expr, fieldRefsVisitor{
+		fields: &fields,
+		err:    &err,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
 }
 
-func fetchExprFieldRefs(expr Expression) []FieldExpr {
-	return fetchExprFieldRefsRecurse(expr, nil, nil)
+type loopVarsVisitor struct {
+	maxID *VariableID
+}
+
+func (v loopVarsVisitor) Visit(expr Expression) Visitor {
+	var varID VariableID
+	switch expr := expr.(type) {
+	case nil:
+		return nil
+	case AnyInExpr:
+		varID = expr.VarId
+	case EveryInExpr:
+		varID = expr.VarId
+	case AnyEveryInExpr:
+		varID = expr.VarId
+	}
+
+	if varID > *v.maxID {
+		*v.maxID = varID
+	}
+	return v
+}
+
+// unusedLoopVariableID returns a variable which is not bound by any loop
+// within expr, so that a loop around expr can bind it without shadowing
+// any of them.
+func unusedLoopVariableID(expr Expression) VariableID {
+	var maxID VariableID
+	Walk(expr, loopVarsVisitor{&maxID})
+	return maxID + 1
+}
+
+// bindLoopVariable rewrites the document fields within expr whose path
+// starts with name to be relative to the variable varID instead.  Loops
+// within expr which bind the same name must already have been bound, so
+// that the innermost loop takes precedence.
+func bindLoopVariable(expr Expression, name string, varID VariableID) Expression {
+	return Rewrite(expr, func(expr Expression) Expression {
+		field, ok := expr.(FieldExpr)
+		if !ok || field.Root != 0 || len(field.Path) == 0 || field.Path[0] != name {
+			return expr
+		}
+
+		var path []string
+		if len(field.Path) > 1 {
+			path = field.Path[1:]
+		}
+		return FieldExpr{varID, path}
+	})
 }
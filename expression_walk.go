@@ -0,0 +1,144 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+// A Visitor's Visit method is invoked for each expression encountered by
+// Walk.  If the result visitor w is not nil, Walk visits each of the children
+// of the expression with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(expr Expression) (w Visitor)
+}
+
+// exprChildren returns the direct children of an expression in evaluation
+// order.  For loops, the expression being iterated comes before the
+// expression evaluated for each item.  The second return value is false
+// if the expression is not a type known to this package.
+func exprChildren(expr Expression) ([]Expression, bool) {
+	switch expr := expr.(type) {
+	case TrueExpr, FalseExpr, ValueExpr, TimeExpr, RegexExpr, PcreExpr, LikePatternExpr, FieldExpr:
+		return nil, true
+	case FuncExpr:
+		return expr.Params, true
+	case NotExpr:
+		return []Expression{expr.SubExpr}, true
+	case AndExpr:
+		return expr, true
+	case OrExpr:
+		return expr, true
+	case AnyInExpr:
+		return []Expression{expr.InExpr, expr.SubExpr}, true
+	case EveryInExpr:
+		return []Expression{expr.InExpr, expr.SubExpr}, true
+	case AnyEveryInExpr:
+		return []Expression{expr.InExpr, expr.SubExpr}, true
+	case ExistsExpr:
+		return []Expression{expr.SubExpr}, true
+	case NotExistsExpr:
+		return []Expression{expr.SubExpr}, true
+	case EqualsExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case NotEqualsExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case LessThanExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case LessEqualsExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case GreaterThanExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case GreaterEqualsExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case LikeExpr:
+		return []Expression{expr.Lhs, expr.Rhs}, true
+	case InExpr:
+		return append([]Expression{expr.Lhs}, expr.Values...), true
+	case BetweenExpr:
+		return []Expression{expr.Lhs, expr.Low, expr.High}, true
+	}
+
+	return nil, false
+}
+
+// exprWithChildren returns a copy of expr with its children replaced.  The
+// children must be in the order returned by exprChildren.
+func exprWithChildren(expr Expression, children []Expression) Expression {
+	switch expr := expr.(type) {
+	case FuncExpr:
+		return FuncExpr{expr.FuncName, children}
+	case NotExpr:
+		return NotExpr{children[0]}
+	case AndExpr:
+		return AndExpr(children)
+	case OrExpr:
+		return OrExpr(children)
+	case AnyInExpr:
+		return AnyInExpr{expr.VarId, children[0], children[1]}
+	case EveryInExpr:
+		return EveryInExpr{expr.VarId, children[0], children[1]}
+	case AnyEveryInExpr:
+		return AnyEveryInExpr{expr.VarId, children[0], children[1]}
+	case ExistsExpr:
+		return ExistsExpr{children[0]}
+	case NotExistsExpr:
+		return NotExistsExpr{children[0]}
+	case EqualsExpr:
+		return EqualsExpr{children[0], children[1]}
+	case NotEqualsExpr:
+		return NotEqualsExpr{children[0], children[1]}
+	case LessThanExpr:
+		return LessThanExpr{children[0], children[1]}
+	case LessEqualsExpr:
+		return LessEqualsExpr{children[0], children[1]}
+	case GreaterThanExpr:
+		return GreaterThanExpr{children[0], children[1]}
+	case GreaterEqualsExpr:
+		return GreaterEqualsExpr{children[0], children[1]}
+	case LikeExpr:
+		return LikeExpr{children[0], children[1]}
+	case InExpr:
+		return InExpr{children[0], children[1:]}
+	case BetweenExpr:
+		return BetweenExpr{children[0], children[1], children[2]}
+	}
+
+	return expr
+}
+
+// Walk traverses an expression in depth-first order.  It starts by calling
+// v.Visit(expr), if the visitor w returned by v.Visit(expr) is not nil,
+// Walk is invoked recursively with visitor w for each of the children of
+// expr, followed by a call of w.Visit(nil).  Expressions of types unknown
+// to this package are visited but have no children, and nil children are
+// skipped.
+func Walk(expr Expression, v Visitor) {
+	if v = v.Visit(expr); v == nil {
+		return
+	}
+
+	children, _ := exprChildren(expr)
+	for _, child := range children {
+		if child != nil {
+			Walk(child, v)
+		}
+	}
+
+	v.Visit(nil)
+}
+
+// Rewrite traverses an expression in depth-first order, rebuilding each
+// expression from its rewritten children and then replacing it with the
+// result of calling fn on it.  nil children are left as they are.  The
+// original expression is not modified.
+func Rewrite(expr Expression, fn func(Expression) Expression) Expression {
+	children, _ := exprChildren(expr)
+	if len(children) > 0 {
+		newChildren := make([]Expression, len(children))
+		for i, child := range children {
+			if child != nil {
+				newChildren[i] = Rewrite(child, fn)
+			}
+		}
+		expr = exprWithChildren(expr, newChildren)
+	}
+
+	return fn(expr)
+}
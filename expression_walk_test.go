@@ -0,0 +1,349 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type walkRecorder struct {
+	visited []string
+	skip    func(Expression) bool
+}
+
+func (r *walkRecorder) Visit(expr Expression) Visitor {
+	if expr == nil {
+		r.visited = append(r.visited, "end")
+		return nil
+	}
+	r.visited = append(r.visited, fmt.Sprintf("%T", expr))
+	if r.skip != nil && r.skip(expr) {
+		return nil
+	}
+	return r
+}
+
+func getWalkTestExpr() Expression {
+	return AndExpr{
+		NotExpr{EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Brett"}}},
+		OrExpr{
+			LikeExpr{FieldExpr{0, []string{"email"}}, RegexExpr{"^b"}},
+			GreaterThanExpr{
+				FuncExpr{MathFuncAbs, []Expression{FieldExpr{0, []string{"age"}}}},
+				ValueExpr{30},
+			},
+		},
+		AnyInExpr{1, FieldExpr{0, []string{"tags"}},
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"a"}}},
+		NotExistsExpr{FieldExpr{0, []string{"deleted"}}},
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &walkRecorder{}
+	Walk(NotExpr{EqualsExpr{FieldExpr{0, []string{"a"}}, ValueExpr{1}}}, r)
+	assert.Equal([]string{
+		"gojsonsm.NotExpr",
+		"gojsonsm.EqualsExpr",
+		"gojsonsm.FieldExpr",
+		"end",
+		"gojsonsm.ValueExpr",
+		"end",
+		"end",
+		"end",
+	}, r.visited)
+}
+
+func TestWalkPrune(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &walkRecorder{
+		skip: func(expr Expression) bool {
+			_, isOr := expr.(OrExpr)
+			_, isLoop := expr.(AnyInExpr)
+			return isOr || isLoop
+		},
+	}
+	Walk(getWalkTestExpr(), r)
+	assert.Equal([]string{
+		"gojsonsm.AndExpr",
+		"gojsonsm.NotExpr",
+		"gojsonsm.EqualsExpr",
+		"gojsonsm.FieldExpr",
+		"end",
+		"gojsonsm.ValueExpr",
+		"end",
+		"end",
+		"end",
+		"gojsonsm.OrExpr",
+		"gojsonsm.AnyInExpr",
+		"gojsonsm.NotExistsExpr",
+		"gojsonsm.FieldExpr",
+		"end",
+		"end",
+		"end",
+	}, r.visited)
+}
+
+// getWalkTestExprs returns an expression of every type.
+func getWalkTestExprs() []Expression {
+	return []Expression{
+		TrueExpr{},
+		FalseExpr{},
+		ValueExpr{1},
+		TimeExpr{},
+		RegexExpr{"a"},
+		PcreExpr{"a"},
+		FieldExpr{0, []string{"a"}},
+		FuncExpr{MathFuncAdd, []Expression{ValueExpr{1}, ValueExpr{2}}},
+		NotExpr{TrueExpr{}},
+		AndExpr{TrueExpr{}},
+		OrExpr{TrueExpr{}},
+		AnyInExpr{1, FieldExpr{0, []string{"a"}}, TrueExpr{}},
+		EveryInExpr{1, FieldExpr{0, []string{"a"}}, TrueExpr{}},
+		AnyEveryInExpr{1, FieldExpr{0, []string{"a"}}, TrueExpr{}},
+		ExistsExpr{FieldExpr{0, []string{"a"}}},
+		NotExistsExpr{FieldExpr{0, []string{"a"}}},
+		EqualsExpr{ValueExpr{1}, ValueExpr{2}},
+		NotEqualsExpr{ValueExpr{1}, ValueExpr{2}},
+		LessThanExpr{ValueExpr{1}, ValueExpr{2}},
+		LessEqualsExpr{ValueExpr{1}, ValueExpr{2}},
+		GreaterThanExpr{ValueExpr{1}, ValueExpr{2}},
+		GreaterEqualsExpr{ValueExpr{1}, ValueExpr{2}},
+		LikeExpr{ValueExpr{1}, ValueExpr{2}},
+	}
+}
+
+func TestWalkAllTypes(t *testing.T) {
+	for _, expr := range getWalkTestExprs() {
+		t.Run(fmt.Sprintf("%T", expr), func(t *testing.T) {
+			children, ok := exprChildren(expr)
+			assert.True(t, ok)
+
+			// Every child is visited exactly once
+			r := &walkRecorder{}
+			Walk(expr, r)
+			assert.Equal(t, 1+len(children), len(r.visited)-countEnds(r.visited))
+
+			// An identity rewrite rebuilds an identical expression
+			assert.Equal(t, expr, Rewrite(expr, func(expr Expression) Expression {
+				return expr
+			}))
+
+			var stats ExpressionStats
+			assert.Nil(t, stats.Scan(expr))
+		})
+	}
+}
+
+func countEnds(visited []string) int {
+	count := 0
+	for _, name := range visited {
+		if name == "end" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRewrite(t *testing.T) {
+	assert := assert.New(t)
+
+	expr := getWalkTestExpr()
+	orig := getWalkTestExpr()
+
+	renamed := Rewrite(expr, func(expr Expression) Expression {
+		if field, ok := expr.(FieldExpr); ok && field.Root == 0 {
+			return FieldExpr{0, append([]string{"doc"}, field.Path...)}
+		}
+		return expr
+	})
+
+	// The original expression must not be modified
+	assert.Equal(orig, expr)
+
+	fields, err := fetchExprFieldRefs(renamed)
+	assert.Nil(err)
+	assert.Equal([]FieldExpr{
+		{0, []string{"doc", "name"}},
+		{0, []string{"doc", "email"}},
+		{0, []string{"doc", "age"}},
+		{0, []string{"doc", "tags"}},
+		{0, []string{"doc", "deleted"}},
+	}, fields)
+}
+
+func TestFetchExprFieldRefs(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, err := fetchExprFieldRefs(AndExpr{
+		getWalkTestExpr(),
+		EqualsExpr{FieldExpr{0, []string{"name"}}, FieldExpr{0, []string{"alias"}}},
+		AnyInExpr{2, FieldExpr{0, []string{"groups"}},
+			AnyInExpr{3, FieldExpr{2, []string{"members"}},
+				EqualsExpr{FieldExpr{3, nil}, FieldExpr{0, []string{"owner"}}}}},
+	})
+	assert.Nil(err)
+	assert.Equal([]FieldExpr{
+		{0, []string{"name"}},
+		{0, []string{"email"}},
+		{0, []string{"age"}},
+		{0, []string{"tags"}},
+		{0, []string{"deleted"}},
+		{0, []string{"alias"}},
+		{0, []string{"groups"}},
+		{0, []string{"owner"}},
+	}, fields)
+}
+
+func TestExpressionStatsScan(t *testing.T) {
+	assert := assert.New(t)
+
+	var stats ExpressionStats
+	assert.Nil(stats.Scan(AndExpr{
+		getWalkTestExpr(),
+		AnyInExpr{2, FieldExpr{0, []string{"groups"}},
+			EveryInExpr{3, FieldExpr{2, []string{"members"}},
+				EqualsExpr{FieldExpr{3, nil}, ValueExpr{"x"}}}},
+	}))
+	assert.Equal(ExpressionStats{
+		NumLoops:        3,
+		NumNestedLoops:  1,
+		MaxLoopDepth:    2,
+		NumAnds:         2,
+		NumOrs:          1,
+		NumNots:         1,
+		NumFields:       9,
+		NumValues:       4,
+		NumComparisons:  5,
+		NumExists:       1,
+		NumFuncs:        1,
+		NumRegexes:      1,
+		RegexComplexity: 4,
+		NumBuckets:      16,
+	}, stats)
+}
+
+func tCountExecSeeks(node *ExecNode) (int, int) {
+	if node == nil {
+		return 0, 0
+	}
+
+	var afterSeeks, loopRescans int
+	if len(node.Loops) > 1 {
+		loopRescans += len(node.Loops) - 1
+	}
+	for _, elem := range node.Elems {
+		elemSeeks, elemRescans := tCountExecSeeks(elem)
+		afterSeeks += elemSeeks
+		loopRescans += elemRescans
+	}
+
+	loops := node.Loops
+	if node.After != nil {
+		afterSeeks += len(node.After.Loops) + 1
+		loops = append(append([]LoopNode(nil), loops...), node.After.Loops...)
+
+		if len(node.After.Elems) > 0 {
+			afterSeeks += len(node.After.Elems) + 1
+		}
+		for _, elem := range node.After.Elems {
+			elemSeeks, elemRescans := tCountExecSeeks(elem)
+			afterSeeks += elemSeeks
+			loopRescans += elemRescans
+		}
+	}
+	for _, loop := range loops {
+		if loop.Slice != nil && loop.Slice.needsLength() {
+			loopRescans++
+		}
+
+		loopSeeks, loopRescansInner := tCountExecSeeks(loop.Node)
+		afterSeeks += loopSeeks
+		loopRescans += loopRescansInner
+	}
+
+	return afterSeeks, loopRescans
+}
+
+func TestExpressionStatsCompiled(t *testing.T) {
+	friends := FieldExpr{0, []string{"friends"}}
+	tests := []Expression{
+		getWalkTestExpr(),
+		tParseFilterExpression(t, `name = "Neil" AND (age < 30 OR NOT isActive = TRUE)`),
+		tParseFilterExpression(t, `name.first = name.last AND name.first <> "Neil"`),
+		tParseFilterExpression(t, `name = company OR address.city = address.state`),
+		tParseFilterExpression(t, `ABS(age) > latitude AND EXISTS(email) AND NOT EXISTS(phone)`),
+		AndExpr{
+			AnyInExpr{1, friends, EqualsExpr{FieldExpr{1, []string{"name"}}, ValueExpr{"Kim"}}},
+			EveryInExpr{2, friends, GreaterThanExpr{FieldExpr{2, []string{"id"}}, ValueExpr{2}}},
+			AnyEveryInExpr{3, friends, LessThanExpr{FieldExpr{3, []string{"id"}}, ValueExpr{9}}},
+		},
+		AnyInExpr{1, friends,
+			OrExpr{
+				EqualsExpr{FieldExpr{1, []string{"name"}}, FieldExpr{0, []string{"name"}}},
+				EqualsExpr{FieldExpr{1, []string{"id"}}, FieldExpr{1, []string{"name"}}},
+			}},
+		tParseFilterExpression(t, `friends[-1].name = "Kim"`),
+		tParseFilterExpression(t, `friends[-1].id = friends[-2].id AND friends[0].id = 1`),
+		tParseFilterExpression(t, `testArray[-1] = name AND testArray[-1] <> "x"`),
+		tParseFilterExpression(t, `ANY f IN friends SATISFIES f.tags[-1] = "x" END`),
+		tParseFilterExpression(t, `ANY f IN friends[-2:] SATISFIES f.id = 1 END`),
+		tParseFilterExpression(t, `ANY f IN friends[1:] SATISFIES f.id = 1 END AND EVERY f IN friends[:-1] SATISFIES f.id > 0 END`),
+	}
+
+	for _, expr := range tests {
+		t.Run(expr.String(), func(t *testing.T) {
+			var stats ExpressionStats
+			if err := stats.Scan(expr); err != nil {
+				t.Fatalf("failed to scan: %s", err)
+			}
+
+			var trans Transformer
+			def, err := trans.TransformE([]Expression{expr})
+			if err != nil {
+				t.Fatalf("failed to transform: %s", err)
+			}
+
+			afterSeeks, loopRescans := tCountExecSeeks(def.ParseNode)
+			assert.Equal(t, def.NumBuckets, stats.NumBuckets)
+			assert.Equal(t, def.NumSlots, stats.NumSlots)
+			assert.Equal(t, afterSeeks, stats.NumAfterSeeks)
+			assert.Equal(t, loopRescans, stats.NumLoopRescans)
+		})
+	}
+}
+
+func TestExpressionStatsCost(t *testing.T) {
+	var simple, regex, seeking ExpressionStats
+	simple.Scan(tParseFilterExpression(t, `name = "Neil"`))
+	regex.Scan(LikeExpr{FieldExpr{0, []string{"name"}}, RegexExpr{"^(Neil|Brett)[a-z]*$"}})
+	seeking.Scan(tParseFilterExpression(t, `name.first = address.city`))
+
+	assert.Equal(t, 2, simple.WorstCaseByteCost())
+	assert.Equal(t, 1, regex.NumRegexes)
+	assert.True(t, regex.RegexComplexity > 10)
+	assert.True(t, regex.WorstCaseByteCost() > simple.WorstCaseByteCost())
+	assert.Equal(t, 1, seeking.NumAfterSeeks)
+	assert.Equal(t, 2, seeking.NumSlots)
+	assert.Equal(t, 4, seeking.WorstCaseByteCost())
+}
+
+func TestCompactExpression(t *testing.T) {
+	assert := assert.New(t)
+
+	field := EqualsExpr{FieldExpr{0, []string{"a"}}, ValueExpr{1}}
+
+	assert.Equal(TrueExpr{}, CompactExpression(OrExpr{field, AndExpr{TrueExpr{}}}))
+	assert.Equal(FalseExpr{}, CompactExpression(AndExpr{field, OrExpr{FalseExpr{}}}))
+	assert.Equal(AndExpr{field}, CompactExpression(AndExpr{field, TrueExpr{}}))
+
+	// Constants below other expressions are compacted as well
+	assert.Equal(NotExpr{FalseExpr{}},
+		CompactExpression(NotExpr{AndExpr{field, AnyInExpr{1, FieldExpr{0, []string{"b"}}, FalseExpr{}}}}))
+}
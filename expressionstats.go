@@ -2,16 +2,48 @@
 
 package gojsonsm
 
-import "fmt"
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
 
+// ExpressionStats describes the complexity of expressions, and estimates
+// the cost of matching them.  Scanning several expressions adds up their
+// statistics, with each expression costed as if it were compiled alone.
 type ExpressionStats struct {
 	NumLoops       int
 	NumNestedLoops int
 	MaxLoopDepth   int
 	NumAnds        int
 	NumOrs         int
+	NumNots        int
 	NumFields      int
 	NumValues      int
+	NumComparisons int
+	NumExists      int
+	NumFuncs       int
+	NumRegexes     int
+	NumTimes       int
+
+	// RegexComplexity is the total number of instructions in the programs
+	// compiled for the regular expressions.  Matching a regular expression
+	// takes up to this many steps for every byte of the value.  PCRE
+	// expressions are assumed to match without excessive backtracking.
+	RegexComplexity int
+
+	// NumBuckets and NumSlots are the number of buckets and slots which the
+	// Transformer allocates when compiling the expressions.
+	NumBuckets int
+	NumSlots   int
+
+	// NumAfterSeeks is the number of times the matcher seeks back through
+	// the document to compare fields which are at different paths or to
+	// find elements addressed from the end of an array, and NumLoopRescans
+	// the number of times it rescans an array to run a further loop over it
+	// or to count the elements of a slice relative to its end.
+	NumAfterSeeks  int
+	NumLoopRescans int
 }
 
 func (stats ExpressionStats) String() string {
@@ -21,85 +53,374 @@ func (stats ExpressionStats) String() string {
 	out += fmt.Sprintf("max loop depth: %d\n", stats.MaxLoopDepth)
 	out += fmt.Sprintf("num ands: %d\n", stats.NumAnds)
 	out += fmt.Sprintf("num ors: %d\n", stats.NumOrs)
+	out += fmt.Sprintf("num nots: %d\n", stats.NumNots)
 	out += fmt.Sprintf("num fields: %d\n", stats.NumFields)
-	out += fmt.Sprintf("num values: %d", stats.NumValues)
+	out += fmt.Sprintf("num values: %d\n", stats.NumValues)
+	out += fmt.Sprintf("num comparisons: %d\n", stats.NumComparisons)
+	out += fmt.Sprintf("num exists: %d\n", stats.NumExists)
+	out += fmt.Sprintf("num funcs: %d\n", stats.NumFuncs)
+	out += fmt.Sprintf("num regexes: %d\n", stats.NumRegexes)
+	out += fmt.Sprintf("num times: %d\n", stats.NumTimes)
+	out += fmt.Sprintf("regex complexity: %d\n", stats.RegexComplexity)
+	out += fmt.Sprintf("num buckets: %d\n", stats.NumBuckets)
+	out += fmt.Sprintf("num slots: %d\n", stats.NumSlots)
+	out += fmt.Sprintf("num after seeks: %d\n", stats.NumAfterSeeks)
+	out += fmt.Sprintf("num loop rescans: %d\n", stats.NumLoopRescans)
+	out += fmt.Sprintf("worst case byte cost: %d", stats.WorstCaseByteCost())
 	return out
 }
 
-func (stats *ExpressionStats) scanOne(expr Expression, loopDepth int) error {
-	if loopDepth > stats.MaxLoopDepth {
-		stats.MaxLoopDepth = loopDepth
+// WorstCaseByteCost estimates the worst case cost of matching each byte of
+// a document, where 1 is the cost of only tokenizing it.  Every comparison
+// may need to examine every byte of a value, and every seek or rescan may
+// cause the bytes to be read again.
+func (stats ExpressionStats) WorstCaseByteCost() int {
+	numPasses := 1 + stats.NumAfterSeeks + stats.NumLoopRescans
+	passCost := 1 + stats.NumComparisons + stats.NumExists + stats.NumFuncs + stats.RegexComplexity
+	return numPasses * passCost
+}
+
+// regexComplexity returns the number of instructions in the program which
+// a regular expression compiles to.
+func regexComplexity(pattern interface{}) int {
+	patternStr, ok := pattern.(string)
+	if !ok {
+		return 0
+	}
+
+	re, err := syntax.Parse(patternStr, syntax.Perl)
+	if err != nil {
+		// PCRE syntax which is not understood is costed by its length
+		return len(patternStr)
+	}
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return len(patternStr)
+	}
+	return len(prog.Inst)
+}
+
+// statsContext identifies the loop whose variable a field is relative to.
+type statsContext struct {
+	varID VariableID
+	id    int
+}
+
+// statsCompileState follows the nodes which the Transformer would create,
+// keyed by their context and path, to count slots, seeks and rescans.
+type statsCompileState struct {
+	numContexts int
+	slots       map[string]bool
+	afterLoops  map[string]int
+	afterElems  map[string]map[string]bool
+	loops       map[string]int
+}
+
+type statsVisitor struct {
+	stats     *ExpressionStats
+	loopDepth int
+	contexts  []statsContext
+	compile   *statsCompileState
+	err       *error
+}
+
+func (v statsVisitor) Visit(expr Expression) Visitor {
+	if expr == nil || *v.err != nil {
+		return nil
+	}
+
+	stats := v.stats
+	if v.loopDepth > stats.MaxLoopDepth {
+		stats.MaxLoopDepth = v.loopDepth
 	}
 
 	switch expr := expr.(type) {
 	case FieldExpr:
 		stats.NumFields++
+		v.visitNegativeIndexes(expr)
 	case ValueExpr:
 		stats.NumValues++
-	case FuncExpr:
-		for _, subexpr := range expr.Params {
-			stats.scanOne(subexpr, loopDepth)
+	case TimeExpr:
+		stats.NumTimes++
+	case RegexExpr:
+		stats.NumRegexes++
+		stats.RegexComplexity += regexComplexity(expr.Regex)
+	case PcreExpr:
+		stats.NumRegexes++
+		stats.RegexComplexity += regexComplexity(expr.Pcre)
+	case LikePatternExpr:
+		// Only patterns which are not a prefix, suffix or substring are
+		// matched with a regular expression
+		matcher, err := NewLikeMatcher(expr.Pattern, expr.Escape)
+		if err == nil && matcher.usesRegex() {
+			stats.NumRegexes++
+			stats.RegexComplexity += regexComplexity(matcher.regex.String())
 		}
+	case FuncExpr:
+		stats.NumFuncs++
 	case AndExpr:
 		stats.NumAnds++
-		for _, subexpr := range expr {
-			stats.scanOne(subexpr, loopDepth)
-		}
+		v.visitBranches(len(expr))
 	case OrExpr:
 		stats.NumOrs++
-		for _, subexpr := range expr {
-			stats.scanOne(subexpr, loopDepth)
-		}
-	case AnyInExpr:
-		stats.NumLoops++
-		if loopDepth == 1 {
-			stats.NumNestedLoops++
-		}
-		stats.scanOne(expr.InExpr, loopDepth)
-		stats.scanOne(expr.SubExpr, loopDepth+1)
-	case EveryInExpr:
-		stats.NumLoops++
-		if loopDepth == 1 {
-			stats.NumNestedLoops++
-		}
-		stats.scanOne(expr.InExpr, loopDepth)
-		stats.scanOne(expr.SubExpr, loopDepth+1)
-	case AnyEveryInExpr:
-		stats.NumLoops++
-		if loopDepth == 1 {
-			stats.NumNestedLoops++
-		}
-		stats.scanOne(expr.InExpr, loopDepth)
-		stats.scanOne(expr.SubExpr, loopDepth+1)
+		v.visitBranches(len(expr))
+	case NotExpr:
+		stats.NumNots++
+		stats.NumBuckets++
 	case ExistsExpr:
-		stats.scanOne(expr.SubExpr, loopDepth)
+		stats.NumExists++
+		v.visitPredicate(expr, expr.SubExpr)
 	case NotExistsExpr:
-		stats.scanOne(expr.SubExpr, loopDepth)
-	case EqualsExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		// Compiled as a NOT of an EXISTS
+		stats.NumExists++
+		stats.NumBuckets++
+		v.visitPredicate(expr, expr.SubExpr)
 	case NotEqualsExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		// Compiled as a NOT of an EQUALS
+		stats.NumComparisons++
+		stats.NumBuckets++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
+	case EqualsExpr:
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
 	case LessThanExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
 	case LessEqualsExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
 	case GreaterThanExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
 	case GreaterEqualsExpr:
-		stats.scanOne(expr.Lhs, loopDepth)
-		stats.scanOne(expr.Rhs, loopDepth)
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
+	case LikeExpr:
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs, expr.Rhs)
+	case InExpr:
+		stats.NumComparisons++
+		v.visitPredicate(expr, expr.Lhs)
+	case BetweenExpr:
+		// Compiled as a single op comparing against both bounds
+		stats.NumComparisons += 2
+		v.visitPredicate(expr, expr.Lhs, expr.Low, expr.High)
+	case AnyInExpr:
+		v.visitLoop(expr, expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
+	case EveryInExpr:
+		v.visitLoop(expr, expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
+	case AnyEveryInExpr:
+		v.visitLoop(expr, expr.VarId, expr.InExpr, expr.SubExpr)
+		return nil
 	default:
-		panic("unexpected expression type")
+		if _, ok := exprChildren(expr); !ok {
+			*v.err = fmt.Errorf("unexpected expression type %T", expr)
+			return nil
+		}
+	}
+
+	return v
+}
+
+// visitBranches counts the buckets of an AND or OR, which is compiled as a
+// chain of binary nodes with two new buckets each.
+func (v statsVisitor) visitBranches(numTerms int) {
+	if numTerms > 1 {
+		v.stats.NumBuckets += 2 * (numTerms - 1)
+	}
+}
+
+func (v statsVisitor) visitLoop(expr Expression, varID VariableID, inExpr, subExpr Expression) {
+	v.stats.NumLoops++
+	if v.loopDepth == 1 {
+		v.stats.NumNestedLoops++
+	}
+	v.stats.NumBuckets++
+
+	// A trailing slice is looped over at the array itself, but if it is
+	// relative to the end the array is counted before the loop begins.
+	expr, inExpr, slice := splitLoopSlice(expr, inExpr)
+	if slice != nil && slice.needsLength() {
+		v.stats.NumLoopRescans++
+	}
+
+	baseKey, needsAfter := v.visitPredicate(expr, inExpr)
+	if needsAfter {
+		v.compile.afterLoops[baseKey]++
+	} else {
+		v.compile.loops[baseKey]++
+	}
+
+	Walk(inExpr, v)
+
+	v.compile.numContexts++
+	contexts := make([]statsContext, len(v.contexts), len(v.contexts)+1)
+	copy(contexts, v.contexts)
+	v.contexts = append(contexts, statsContext{varID, v.compile.numContexts})
+
+	v.loopDepth++
+	Walk(subExpr, v)
+}
+
+func (v statsVisitor) currentContext() int {
+	if len(v.contexts) == 0 {
+		return 0
 	}
+	return v.contexts[len(v.contexts)-1].id
+}
+
+func (v statsVisitor) fieldContext(field FieldExpr) (int, bool) {
+	if field.Root == 0 {
+		return 0, true
+	}
+	for i := len(v.contexts) - 1; i >= 0; i-- {
+		if v.contexts[i].varID == field.Root {
+			return v.contexts[i].id, true
+		}
+	}
+	return 0, false
+}
+
+// visitNegativeIndexes records the elements of a field which are addressed
+// from the end of an array, which the Transformer places in the after node
+// of the array.
+func (v statsVisitor) visitNegativeIndexes(field FieldExpr) {
+	context, ok := v.fieldContext(field)
+	if !ok {
+		return
+	}
+
+	for i, elem := range field.Path {
+		if _, ok := parseNegativeArrayIndex(elem); !ok {
+			continue
+		}
+
+		key := statsNodeKey(context, field.Path[:i])
+		if v.compile.afterElems[key] == nil {
+			v.compile.afterElems[key] = make(map[string]bool)
+		}
+		v.compile.afterElems[key][elem] = true
+	}
+}
 
-	return nil
+func statsNodeKey(context int, path []string) string {
+	return fmt.Sprintf("%d:%s", context, strings.Join(path, "\x00"))
+}
+
+// visitPredicate picks the node which an op or loop is attached to in the
+// same way as the Transformer, and counts the slots which its operands need.
+// It returns the key of the node and whether it is attached after it.
+func (v statsVisitor) visitPredicate(expr Expression, operands ...Expression) (string, bool) {
+	fields, err := fetchExprFieldRefs(expr)
+	if err != nil {
+		return "", false
+	}
+
+	var paths [][]string
+	for _, field := range fields {
+		context, ok := v.fieldContext(field)
+		if ok && context == v.currentContext() {
+			paths = append(paths, field.Path)
+		}
+	}
+
+	var basePath []string
+	for _, path := range paths {
+		if len(path) > len(basePath) {
+			basePath = path
+		}
+	}
+
+	var commonPath []string
+PathLoop:
+	for j := 0; j < len(basePath); j++ {
+		for _, path := range paths {
+			if len(path) <= j || path[j] != basePath[j] {
+				break PathLoop
+			}
+		}
+		commonPath = append(commonPath, basePath[j])
+	}
+
+	needsAfter := len(commonPath) < len(basePath)
+	baseKey := statsNodeKey(v.currentContext(), commonPath)
+	if needsAfter {
+		if _, ok := v.compile.afterLoops[baseKey]; !ok {
+			v.compile.afterLoops[baseKey] = 0
+		}
+	}
+
+	for _, operand := range operands {
+		v.visitOperandSlots(operand, baseKey, needsAfter)
+	}
+
+	return baseKey, needsAfter
+}
+
+func (v statsVisitor) visitOperandSlots(operand Expression, baseKey string, needsAfter bool) {
+	switch operand := operand.(type) {
+	case FieldExpr:
+		context, ok := v.fieldContext(operand)
+		if !ok {
+			return
+		}
+
+		// Only fields at the node itself can be read without a slot
+		key := statsNodeKey(context, operand.Path)
+		if needsAfter || key != baseKey {
+			v.compile.slots[key] = true
+		}
+	case FuncExpr:
+		for _, param := range operand.Params {
+			v.visitOperandSlots(param, baseKey, needsAfter)
+		}
+	}
 }
 
 func (stats *ExpressionStats) Scan(expr Expression) error {
-	return stats.scanOne(expr, 0)
+	switch expr.(type) {
+	case TrueExpr, FalseExpr:
+		// Constant expressions are not compiled
+	default:
+		stats.NumBuckets++
+	}
+
+	compile := &statsCompileState{
+		slots:      make(map[string]bool),
+		afterLoops: make(map[string]int),
+		afterElems: make(map[string]map[string]bool),
+		loops:      make(map[string]int),
+	}
+
+	var err error
+	Walk(expr, statsVisitor{
+		stats:   stats,
+		compile: compile,
+		err:     &err,
+	})
+
+	stats.NumSlots += len(compile.slots)
+	for _, numLoops := range compile.afterLoops {
+		// Each after loop seeks to its array, then the position is restored
+		stats.NumAfterSeeks += numLoops + 1
+	}
+	for key, elems := range compile.afterElems {
+		// The array is counted after seeking back to it, and then each of
+		// the elements is found by seeking back to its start again.
+		stats.NumAfterSeeks += 1 + len(elems)
+		if _, ok := compile.afterLoops[key]; !ok {
+			// The position is restored once the after node is matched
+			stats.NumAfterSeeks++
+		}
+	}
+	for _, numLoops := range compile.loops {
+		if numLoops > 1 {
+			stats.NumLoopRescans += numLoops - 1
+		}
+	}
+
+	return err
 }
@@ -3,7 +3,9 @@
 package gojsonsm
 
 import (
+	"errors"
 	"fmt"
+	"math"
 )
 
 type slotData struct {
@@ -13,12 +15,63 @@ type slotData struct {
 
 var emptySlotData slotData
 
+// MatchError is returned by the FastMatcher when the document being matched
+// is not well-formed JSON.  Offset is the byte offset within the document at
+// which the problem was detected, Token is the token which was read there
+// and Expected describes what the matcher was expecting to find instead.
+type MatchError struct {
+	Offset   int
+	Token    string
+	Expected string
+	Reason   string
+}
+
+func (e *MatchError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("malformed JSON at offset %d: %s", e.Offset, e.Reason)
+	}
+	return fmt.Sprintf("malformed JSON at offset %d: expected %s, got %s", e.Offset, e.Expected, e.Token)
+}
+
+func (m *FastMatcher) newMatchError(token tokenType, expected string) error {
+	return &MatchError{
+		Offset:   m.tokens.Position(),
+		Token:    tokenToText(token),
+		Expected: expected,
+	}
+}
+
+// step reads the next token from the tokenizer, converting any tokenizer
+// failure into a MatchError.
+func (m *FastMatcher) step() (tokenType, []byte, int, error) {
+	token, tokenData, tokenDataLen, err := m.tokens.Step()
+	if err != nil {
+		return token, tokenData, tokenDataLen, &MatchError{
+			Offset: m.tokens.Position(),
+			Token:  tokenToText(token),
+			Reason: err.Error(),
+		}
+	}
+	return token, tokenData, tokenDataLen, nil
+}
+
 type FastMatcher struct {
 	def         MatchDef
 	slots       []slotData
 	buckets     *binTreeState
 	tokens      jsonTokenizer
 	collateUsed bool
+
+	// litParse is kept on the matcher so that parsing literals for op
+	// execution does not need to allocate for every document.
+	litParse fastLitParser
+
+	// When sampleStats is set, the result of every bucket is recorded for
+	// one in every sampleInterval documents.
+	sampleStats     *MatchStats
+	sampleInterval  int
+	sampleCountdown int
+	sampling        bool
 }
 
 func NewFastMatcher(def *MatchDef) *FastMatcher {
@@ -29,19 +82,27 @@ func NewFastMatcher(def *MatchDef) *FastMatcher {
 	}
 }
 
+// SetStrictMode enables or disables strict validation of the documents being
+// matched.  In strict mode the whole document is validated against the JSON
+// grammar, even when the match result is known before reaching the end of
+// it, and the first violation is returned from Match as a MatchError.
+func (m *FastMatcher) SetStrictMode(strict bool) {
+	m.tokens.SetStrict(strict)
+}
+
 func (m *FastMatcher) Reset() {
 	for i := 0; i < m.def.NumSlots; i++ {
 		m.slots[i] = emptySlotData
 	}
 	m.buckets.Reset()
+	m.collateUsed = false
 }
 
 func (m *FastMatcher) leaveValue() error {
 	depth := 0
 
-	tokens := &m.tokens
 	for {
-		token, _, _, err := tokens.Step()
+		token, _, _, err := m.step()
 		if err != nil {
 			return err
 		}
@@ -62,7 +123,7 @@ func (m *FastMatcher) leaveValue() error {
 			}
 			depth--
 		case tknEnd:
-			panic("unexpected EOF")
+			return m.newMatchError(token, "end of value")
 		}
 	}
 }
@@ -88,17 +149,23 @@ func (m *FastMatcher) skipValue(token tokenType) error {
 	case tknArrayStart:
 		return m.leaveValue()
 	}
-	panic(fmt.Sprintf("unexpected value: %v", token))
+	return m.newMatchError(token, "value")
 }
 
-func (m *FastMatcher) literalFromSlot(slot SlotID) FastVal {
+// literalFromSlot reads the value stored in a slot.  Slots are only filled
+// in once their value has been read successfully, but re-reading it is still
+// checked so that any failure is returned as a MatchError.
+func (m *FastMatcher) literalFromSlot(slot SlotID) (FastVal, error) {
 	value := NewMissingFastVal()
 
 	savePos := m.tokens.Position()
 
 	slotInfo := m.slots[slot-1]
 	m.tokens.Seek(slotInfo.start)
-	token, tokenData, _, _ := m.tokens.Step()
+	token, tokenData, _, err := m.step()
+	if err != nil {
+		return value, err
+	}
 
 	if isLiteralToken(token) {
 		var parser fastLitParser
@@ -113,108 +180,97 @@ func (m *FastMatcher) literalFromSlot(slot SlotID) FastVal {
 
 	m.tokens.Seek(savePos)
 
-	return value
+	return value, nil
 }
 
-func (m *FastMatcher) resolveFunc(fn FuncRef, activeLit *FastVal) FastVal {
+func (m *FastMatcher) resolveFunc(fn FuncRef, activeLit *FastVal) (FastVal, error) {
+	// No function takes more than two parameters, and the number which each
+	// function requires is checked when a definition is decoded.
+	var p1, p2 FastVal
+	var err error
+	if len(fn.Params) > 0 {
+		p1, err = m.resolveParam(fn.Params[0], activeLit)
+		if err != nil {
+			return FastVal{}, err
+		}
+	}
+	if len(fn.Params) > 1 {
+		p2, err = m.resolveParam(fn.Params[1], activeLit)
+		if err != nil {
+			return FastVal{}, err
+		}
+	}
+
 	switch fn.FuncName {
 	case MathFuncAbs:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathAbs(p1)
+		return FastValMathAbs(p1), nil
 	case MathFuncAcos:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathAcos(p1)
+		return FastValMathAcos(p1), nil
 	case MathFuncAsin:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathAsin(p1)
+		return FastValMathAsin(p1), nil
 	case MathFuncAtan:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathAtan(p1)
+		return FastValMathAtan(p1), nil
 	case MathFuncAtan2:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathAtan2(p1, p2)
+		return FastValMathAtan2(p1, p2), nil
 	case MathFuncRound:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathRound(p1)
+		return FastValMathRound(p1), nil
 	case MathFuncCos:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathCos(p1)
+		return FastValMathCos(p1), nil
 	case MathFuncSin:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathSin(p1)
+		return FastValMathSin(p1), nil
 	case MathFuncTan:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathTan(p1)
+		return FastValMathTan(p1), nil
 	case MathFuncSqrt:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathSqrt(p1)
+		return FastValMathSqrt(p1), nil
 	case MathFuncExp:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathExp(p1)
+		return FastValMathExp(p1), nil
 	case MathFuncLn:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathLn(p1)
+		return FastValMathLn(p1), nil
 	case MathFuncLog:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathLog(p1)
+		return FastValMathLog(p1), nil
 	case MathFuncCeil:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathCeil(p1)
+		return FastValMathCeil(p1), nil
 	case MathFuncFloor:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathFloor(p1)
+		return FastValMathFloor(p1), nil
 	case MathFuncDegrees:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathDegrees(p1)
+		return FastValMathDegrees(p1), nil
 	case MathFuncRadians:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathRadians(p1)
+		return FastValMathRadians(p1), nil
 	case MathFuncPow:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathPow(p1, p2)
+		return FastValMathPow(p1, p2), nil
 	case DateFunc:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValDateFunc(p1)
+		return FastValDateFunc(p1), nil
 	case MathFuncAdd:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathAdd(p1, p2)
+		return FastValMathAdd(p1, p2), nil
 	case MathFuncSub:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathSub(p1, p2)
+		return FastValMathSub(p1, p2), nil
 	case MathFuncMul:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathMul(p1, p2)
+		return FastValMathMul(p1, p2), nil
 	case MathFuncDiv:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathDiv(p1, p2)
+		return FastValMathDiv(p1, p2), nil
 	case MathFuncMod:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		p2 := m.resolveParam(fn.Params[1], activeLit)
-		return FastValMathMod(p1, p2)
+		return FastValMathMod(p1, p2), nil
 	case MathFuncNeg:
-		p1 := m.resolveParam(fn.Params[0], activeLit)
-		return FastValMathNeg(p1)
+		return FastValMathNeg(p1), nil
+	case MathFuncPi:
+		return NewFloatFastVal(math.Pi), nil
+	case MathFuncE:
+		return NewFloatFastVal(math.E), nil
 	default:
 		panic(fmt.Sprintf("encountered unexpected function name: %v", fn.FuncName))
 	}
 }
 
-func (m *FastMatcher) resolveParam(in interface{}, activeLit *FastVal) FastVal {
+func (m *FastMatcher) resolveParam(in interface{}, activeLit *FastVal) (FastVal, error) {
 	switch opVal := in.(type) {
 	case FastVal:
-		return opVal
+		return opVal, nil
 	case activeLitRef:
 		if activeLit == nil {
 			panic("cannot resolve active literal without having an active context")
 		}
 
-		return *activeLit
+		return *activeLit, nil
 	case SlotRef:
 		return m.literalFromSlot(opVal.Slot)
 	case FuncRef:
@@ -224,40 +280,98 @@ func (m *FastMatcher) resolveParam(in interface{}, activeLit *FastVal) FastVal {
 	}
 }
 
-func (m *FastMatcher) matchOp(op *OpNode, litVal *FastVal) error {
-	bucketIdx := int(op.BucketIdx)
+// isOpResolved returns whether every bucket which depends on an op has
+// already been resolved.
+func (m *FastMatcher) isOpResolved(op *OpNode) bool {
+	if !m.buckets.IsResolved(int(op.BucketIdx)) {
+		return false
+	}
+	for _, bucketIdx := range op.SharedBuckets {
+		if !m.buckets.IsResolved(int(bucketIdx)) {
+			return false
+		}
+	}
+	return true
+}
 
+// markOp marks the result of an op against every bucket which depends on it.
+// Marking one bucket can resolve others through their common ancestors, so
+// each is checked again before being marked.
+func (m *FastMatcher) markOp(op *OpNode, result bool) {
+	m.markBucket(int(op.BucketIdx), result)
+	for _, bucketIdx := range op.SharedBuckets {
+		m.markBucket(int(bucketIdx), result)
+	}
+}
+
+// markBucket marks the result of a bucket unless it is already resolved.
+func (m *FastMatcher) markBucket(bucketIdx int, result bool) {
 	if m.buckets.IsResolved(bucketIdx) {
-		// If the bucket for this op is already resolved  in the binary tree,
+		return
+	}
+
+	if m.sampling {
+		m.sampleStats.record(bucketIdx, result)
+	}
+	m.buckets.MarkNode(bucketIdx, result)
+}
+
+// resolveOpParam resolves one side of an op, where a nil reference is the
+// active literal.  It additionally returns whether the reference is to a
+// slot which was not found.
+func (m *FastMatcher) resolveOpParam(ref DataRef, litVal *FastVal) (FastVal, bool, error) {
+	if ref == nil {
+		if litVal == nil {
+			return NewMissingFastVal(), false, nil
+		}
+		return *litVal, false, nil
+	}
+
+	val, err := m.resolveParam(ref, litVal)
+	if err != nil {
+		return val, false, err
+	}
+	if _, ok := ref.(SlotRef); ok && val.IsMissing() {
+		return val, true, nil
+	}
+	return val, false, nil
+}
+
+func (m *FastMatcher) matchOp(op *OpNode, litVal *FastVal) error {
+	if m.isOpResolved(op) {
+		// If the buckets for this op are already resolved in the binary tree,
 		// we don't need to perform the op and can just skip it.
 		return nil
 	}
 
-	var slotNotFound bool
-	lhsVal := NewMissingFastVal()
-	if op.Lhs != nil {
-		lhsVal = m.resolveParam(op.Lhs, litVal)
-		if _, ok := op.Lhs.(SlotRef); ok && lhsVal.IsMissing() {
-			slotNotFound = true
-		}
-	} else if litVal != nil {
-		lhsVal = *litVal
+	lhsVal, slotNotFound, err := m.resolveOpParam(op.Lhs, litVal)
+	if err != nil {
+		return err
 	}
 
-	rhsVal := NewMissingFastVal()
-	if op.Rhs != nil {
-		rhsVal = m.resolveParam(op.Rhs, litVal)
-		if _, ok := op.Rhs.(SlotRef); ok && rhsVal.IsMissing() {
-			slotNotFound = true
+	var rhsVal, highVal FastVal
+	var rhsNotFound, highNotFound bool
+	switch rhs := op.Rhs.(type) {
+	case ValueSetRef:
+		// Value sets are looked up directly rather than resolved to a value
+	case RangeRef:
+		rhsVal, rhsNotFound, err = m.resolveOpParam(rhs.Low, litVal)
+		if err != nil {
+			return err
 		}
-	} else if litVal != nil {
-		rhsVal = *litVal
+		highVal, highNotFound, err = m.resolveOpParam(rhs.High, litVal)
+	default:
+		rhsVal, rhsNotFound, err = m.resolveOpParam(op.Rhs, litVal)
 	}
+	if err != nil {
+		return err
+	}
+	slotNotFound = slotNotFound || rhsNotFound || highNotFound
 
 	if slotNotFound {
 		// If references are for slots and at least one wasn't found
 		// then the matchOp should not execute
-		m.buckets.MarkNode(bucketIdx, false)
+		m.markOp(op, false)
 
 		if m.buckets.IsResolved(0) {
 			return nil
@@ -288,12 +402,27 @@ func (m *FastMatcher) matchOp(op *OpNode, litVal *FastVal) error {
 	case OpTypeExists:
 		opRes = true
 		validOp = true
+	case OpTypeIn:
+		valueSet, ok := op.Rhs.(ValueSetRef)
+		if !ok {
+			return errors.New("in op requires a value set")
+		}
+		opRes = valueSet.Contains(lhsVal)
+		validOp = true
+	case OpTypeBetween:
+		if _, ok := op.Rhs.(RangeRef); !ok {
+			return errors.New("between op requires a range")
+		}
+		lowOut, lowValid := lhsVal.Compare(rhsVal)
+		highOut, highValid := lhsVal.Compare(highVal)
+		opRes = lowOut >= 0 && highOut <= 0
+		validOp = lowValid && highValid
 	default:
 		panic("invalid op type")
 	}
 
 	// Mark the result of this operation
-	m.buckets.MarkNode(bucketIdx, opRes)
+	m.markOp(op, opRes)
 
 	if !validOp {
 		m.collateUsed = true
@@ -308,6 +437,48 @@ func (m *FastMatcher) matchOp(op *OpNode, litVal *FastVal) error {
 	return nil
 }
 
+// matchIndexedOps performs the ops of a node against a string value using
+// the node's EqualsIndex.  Only the indexed ops comparing against this exact
+// string are performed, and all of the others are immediately marked false.
+func (m *FastMatcher) matchIndexedOps(node *ExecNode, litVal *FastVal) error {
+	index := node.Index
+
+	key, ok := equalsIndexValueKey(*litVal)
+	if ok {
+		for _, opIdx := range index.Values[key] {
+			err := m.matchOp(&node.Ops[opIdx], litVal)
+			if err != nil {
+				return err
+			}
+
+			if m.buckets.IsResolved(0) {
+				return nil
+			}
+		}
+	}
+
+	for _, opIdx := range index.Indexed {
+		m.markOp(&node.Ops[opIdx], false)
+	}
+
+	if m.buckets.IsResolved(0) {
+		return nil
+	}
+
+	for _, opIdx := range index.Unindexed {
+		err := m.matchOp(&node.Ops[opIdx], litVal)
+		if err != nil {
+			return err
+		}
+
+		if m.buckets.IsResolved(0) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[string]*ExecNode) error {
 	// Note that this assumes that the tokenizer has already been placed at the target
 	// that referenced the elements themselves...
@@ -324,7 +495,7 @@ func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[st
 		// If this is not the first entry in the object, there should be a
 		// list delimiter ('c') that shows up in the input first.
 		if i != 0 {
-			token, _, _, err := m.tokens.Step()
+			token, _, _, err := m.step()
 			if err != nil {
 				return err
 			}
@@ -333,11 +504,11 @@ func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[st
 				return nil
 			}
 			if token != tknListDelim {
-				panic("expected object field element delimiter")
+				return m.newMatchError(token, tokenToText(tknListDelim))
 			}
 		}
 
-		token, tokenData, _, err := m.tokens.Step()
+		token, tokenData, _, err := m.step()
 		if err != nil {
 			return err
 		}
@@ -351,18 +522,18 @@ func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[st
 		} else if token == tknEscString {
 			keyBytes = keyLitParse.ParseEscString(tokenData)
 		} else {
-			panic("expected literal")
+			return m.newMatchError(token, tokenToText(tknString))
 		}
 
-		token, _, _, err = m.tokens.Step()
+		token, _, _, err = m.step()
 		if err != nil {
 			return err
 		}
 		if token != tknObjectKeyDelim {
-			panic("expected object key delimiter")
+			return m.newMatchError(token, tokenToText(tknObjectKeyDelim))
 		}
 
-		token, tokenData, tokenDataLen, err := m.tokens.Step()
+		token, tokenData, tokenDataLen, err := m.step()
 		if err != nil {
 			return err
 		}
@@ -370,7 +541,10 @@ func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[st
 		if keyElem, ok := elems[string(keyBytes)]; ok {
 			// Run the execution node that applies to this particular
 			// key of the object.
-			m.matchExec(token, tokenData, tokenDataLen, keyElem)
+			err = m.matchExec(token, tokenData, tokenDataLen, keyElem)
+			if err != nil {
+				return err
+			}
 
 			// Check if running this keys execution has resolved the entirety
 			// of the expression, if so we can leave immediately.
@@ -380,7 +554,10 @@ func (m *FastMatcher) matchElems(token tokenType, tokenData []byte, elems map[st
 		} else {
 			// If we don't have any parse requirements for this key in
 			// the object, we can just skip its value and continue
-			m.skipValue(token)
+			err = m.skipValue(token)
+			if err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -400,8 +577,7 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 	if m.buckets.IsResolved(loopBucketIdx) {
 		// If the bucket for this op is already resolved  in the binary tree,
 		// we don't need to perform the op and can just skip it.
-		m.skipValue(token)
-		return nil
+		return m.skipValue(token)
 	}
 
 	// We need to keep track of the overall loop result value while the bin tree
@@ -414,7 +590,23 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 	} else if loop.Mode == LoopTypeAnyEvery {
 		loopState = false
 	} else {
-		panic("invalid loop mode")
+		return errors.New("invalid loop mode")
+	}
+
+	// If the loop only covers a slice of the array, work out which elements
+	// that includes.  Slices relative to the end of the array require us to
+	// count the elements before we can begin.
+	sliceLo, sliceHi := 0, -1
+	if loop.Slice != nil {
+		length := -1
+		if loop.Slice.needsLength() {
+			var err error
+			length, err = m.arrayLength()
+			if err != nil {
+				return err
+			}
+		}
+		sliceLo, sliceHi = loop.Slice.bounds(length)
 	}
 
 	// We need to mark the stall index on our binary tree so that
@@ -424,10 +616,19 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 
 	// Scan through all the values in the loop
 	for i := 0; ; i++ {
+		// Once we pass the end of the slice, skip the rest of the array.
+		if loop.Slice != nil && i >= sliceHi {
+			err := m.leaveValue()
+			if err != nil {
+				return err
+			}
+			break
+		}
+
 		// If this is not the first entry in the array, there should be a
 		// list delimiter (',') that shows up in the input first.
 		if i != 0 {
-			token, _, _, err := m.tokens.Step()
+			token, _, _, err := m.step()
 			if err != nil {
 				return err
 			}
@@ -436,11 +637,11 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 				break
 			}
 			if token != tknListDelim {
-				panic(fmt.Sprintf("expected array element delimiter got %s", tokenToText(token)))
+				return m.newMatchError(token, tokenToText(tknListDelim))
 			}
 		}
 
-		token, tokenData, tokenDataLen, err := m.tokens.Step()
+		token, tokenData, tokenDataLen, err := m.step()
 		if err != nil {
 			return err
 		}
@@ -448,6 +649,15 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 			break
 		}
 
+		// Elements before the start of the slice are not part of the loop.
+		if i < sliceLo {
+			err = m.skipValue(token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Reset the looping node in the binary tree so that previous iterations
 		// of the loop do not impact the results of this iteration
 		m.buckets.ResetNode(loopBucketIdx)
@@ -466,7 +676,10 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 				loopState = true
 
 				// Skip the remainder of the array and leave the loop
-				m.leaveValue()
+				err = m.leaveValue()
+				if err != nil {
+					return err
+				}
 				break
 			}
 		} else if loop.Mode == LoopTypeEvery {
@@ -476,7 +689,10 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 				loopState = false
 
 				// Skip the remainder of the array and leave the loop
-				m.leaveValue()
+				err = m.leaveValue()
+				if err != nil {
+					return err
+				}
 				break
 			}
 		} else if loop.Mode == LoopTypeAnyEvery {
@@ -486,7 +702,10 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 				loopState = false
 
 				// Skip the remainder of the array and leave the loop
-				m.leaveValue()
+				err = m.leaveValue()
+				if err != nil {
+					return err
+				}
 				break
 			} else {
 				// If we encounter a truthy value, we have satisfied the 'any'
@@ -509,42 +728,145 @@ func (m *FastMatcher) matchLoop(token tokenType, tokenData []byte, loop *LoopNod
 	// multiple nested loops being processed.
 	m.buckets.SetStallIndex(previousStallIndex)
 
-	// Apply the overall loop result to the binary tree
-	if loopState {
-		m.buckets.MarkNode(loopBucketIdx, true)
-	} else {
-		m.buckets.MarkNode(loopBucketIdx, false)
+	// The result of the loop as a whole is recorded against the loop node,
+	// as the results of each iteration are recorded against its body.
+	if m.sampling {
+		m.sampleStats.record(m.def.MatchTree.data[loopBucketIdx].ParentIdx, loopState)
 	}
 
+	// Apply the overall loop result to the binary tree
+	m.buckets.MarkNode(loopBucketIdx, loopState)
+
 	return nil
 }
 
-func (m *FastMatcher) matchAfter(node *AfterNode) error {
+// arrayLength counts the elements of the array which the tokenizer has just
+// entered, leaving the tokenizer where it was.
+func (m *FastMatcher) arrayLength() (int, error) {
 	savePos := m.tokens.Position()
 
-	// Run loop matching
-	for _, loop := range node.Loops {
-		if slot, ok := loop.Target.(SlotRef); ok {
-			slotInfo := m.slots[slot.Slot-1]
+	length := 0
+	for i := 0; ; i++ {
+		token, _, _, err := m.step()
+		if err != nil {
+			return 0, err
+		}
+		if token == tknArrayEnd {
+			break
+		}
 
-			m.tokens.Seek(slotInfo.start)
-			token, tokenData, _, err := m.tokens.Step()
+		if i != 0 {
+			if token != tknListDelim {
+				return 0, m.newMatchError(token, tokenToText(tknListDelim))
+			}
 
-			// run the loop matcher
-			err = m.matchLoop(token, tokenData, &loop)
+			token, _, _, err = m.step()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err = m.skipValue(token)
+		if err != nil {
+			return 0, err
+		}
+		length++
+	}
+
+	m.tokens.Seek(savePos)
+
+	return length, nil
+}
+
+// matchAfterElems runs the elements of the array starting at valuePos which
+// are addressed by a negative index.
+func (m *FastMatcher) matchAfterElems(elems map[string]*ExecNode, valuePos int) error {
+	m.tokens.Seek(valuePos)
+	token, _, _, err := m.step()
+	if err != nil {
+		return err
+	}
+
+	// Negative indexes only apply to arrays
+	if token != tknArrayStart {
+		return nil
+	}
+
+	length, err := m.arrayLength()
+	if err != nil {
+		return err
+	}
+	arrayPos := m.tokens.Position()
+
+	for key, elem := range elems {
+		idx, ok := parseNegativeArrayIndex(key)
+		if !ok {
+			return fmt.Errorf("invalid after element `%s`", key)
+		}
+
+		// The array is too short for this element to exist
+		if length+idx < 0 {
+			continue
+		}
+
+		// Skip forward to the element itself
+		m.tokens.Seek(arrayPos)
+		for i := 0; i < length+idx; i++ {
+			token, _, _, err := m.step()
 			if err != nil {
 				return err
 			}
 
-			if m.buckets.IsResolved(0) {
-				return nil
+			err = m.skipValue(token)
+			if err != nil {
+				return err
 			}
-		} else {
-			panic("encountered after loop with non-slot target")
+
+			token, _, _, err = m.step()
+			if err != nil {
+				return err
+			}
+			if token != tknListDelim {
+				return m.newMatchError(token, tokenToText(tknListDelim))
+			}
+		}
+
+		token, tokenData, tokenDataLen, err := m.step()
+		if err != nil {
+			return err
+		}
+
+		err = m.matchExec(token, tokenData, tokenDataLen, elem)
+		if err != nil {
+			return err
+		}
+
+		if m.buckets.IsResolved(0) {
+			return nil
 		}
 	}
 
-	// Run op matching
+	return nil
+}
+
+func (m *FastMatcher) matchAfter(node *AfterNode, valuePos int) error {
+	savePos := m.tokens.Position()
+
+	// Run negative index matching first, as the loops and ops below may
+	// depend on the slots which these elements store.
+	if len(node.Elems) > 0 {
+		err := m.matchAfterElems(node.Elems, valuePos)
+		if err != nil {
+			return err
+		}
+
+		if m.buckets.IsResolved(0) {
+			return nil
+		}
+	}
+
+	// Run op matching before any loops, as ops are cheaper to perform
+	// and may resolve the expression without needing to scan the arrays.
 	for _, op := range node.Ops {
 		err := m.matchOp(&op, nil)
 		if err != nil {
@@ -556,6 +878,31 @@ func (m *FastMatcher) matchAfter(node *AfterNode) error {
 		}
 	}
 
+	// Run loop matching
+	for _, loop := range node.Loops {
+		if slot, ok := loop.Target.(SlotRef); ok {
+			slotInfo := m.slots[slot.Slot-1]
+
+			m.tokens.Seek(slotInfo.start)
+			token, tokenData, _, err := m.step()
+			if err != nil {
+				return err
+			}
+
+			// run the loop matcher
+			err = m.matchLoop(token, tokenData, &loop)
+			if err != nil {
+				return err
+			}
+
+			if m.buckets.IsResolved(0) {
+				return nil
+			}
+		} else {
+			return errors.New("encountered after loop with non-slot target")
+		}
+	}
+
 	m.tokens.Seek(savePos)
 
 	return nil
@@ -571,18 +918,16 @@ func (m *FastMatcher) matchExec(token tokenType, tokenData []byte, tokenDataLen
 	startPos -= tokenDataLen
 
 	if isLiteralToken(token) {
-		var litParse fastLitParser
-
 		// TODO(brett19): Move the litVal generation to be lazy-evaluated by the
 		// op execution below so we avoid performing any translations when the op
 		// is already resolved by something else.
 
 		// Parse the literal token from the tokenizer into a FastVal value
 		// to be used for op execution below.
-		litVal := litParse.Parse(token, tokenData)
+		litVal := m.litParse.Parse(token, tokenData)
 
-		for _, op := range node.Ops {
-			err := m.matchOp(&op, &litVal)
+		if node.Index != nil && litVal.IsString() {
+			err := m.matchIndexedOps(node, &litVal)
 			if err != nil {
 				return err
 			}
@@ -590,20 +935,41 @@ func (m *FastMatcher) matchExec(token tokenType, tokenData []byte, tokenDataLen
 			if m.buckets.IsResolved(0) {
 				return nil
 			}
+		} else {
+			for _, op := range node.Ops {
+				err := m.matchOp(&op, &litVal)
+				if err != nil {
+					return err
+				}
+
+				if m.buckets.IsResolved(0) {
+					return nil
+				}
+			}
 		}
 	} else if token == tknObjectStart {
 		objStartPos := m.tokens.Position() - 1 /* to include the objStart itself*/
 		if len(node.Elems) == 0 {
 			// If we have no element handlers, we can just skip the whole thing...
-			m.skipValue(token)
+			err := m.skipValue(token)
+			if err != nil {
+				return err
+			}
 		} else {
 			err, shouldReturn := m.matchObjectOrArray(token, tokenData, node)
-			if err == nil && node.After != nil {
-				m.matchAfter(node.After)
+			if err != nil {
+				return err
+			}
+
+			if node.After != nil {
+				err = m.matchAfter(node.After, startPos)
+				if err != nil {
+					return err
+				}
 			}
 
 			if shouldReturn {
-				return err
+				return nil
 			}
 
 			if m.buckets.IsResolved(0) {
@@ -639,8 +1005,7 @@ func (m *FastMatcher) matchExec(token tokenType, tokenData []byte, tokenDataLen
 
 			for loopIdx, loop := range node.Loops {
 				if loop.Target != nil {
-					panic("loops must always target the active state")
-
+					return errors.New("loops must always target the active state")
 				}
 				if loopIdx != 0 {
 					// If this is not the first loop, we will need to reset back to the
@@ -677,11 +1042,14 @@ func (m *FastMatcher) matchExec(token tokenType, tokenData []byte, tokenDataLen
 			}
 		}
 	} else {
-		panic(fmt.Sprintf("invalid token read - tokenType: %v data: %v", token, string(tokenData)))
+		return m.newMatchError(token, "value")
 	}
 
 	if node.After != nil {
-		m.matchAfter(node.After)
+		err := m.matchAfter(node.After, startPos)
+		if err != nil {
+			return err
+		}
 
 		if m.buckets.IsResolved(0) {
 			return nil
@@ -713,14 +1081,14 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 		endToken = tknArrayEnd
 		arrayMode = true
 	default:
-		panic("Unexpected type input for function call matchObjectOrArray")
+		return m.newMatchError(token, "object or array"), true
 	}
 
 	for i := 0; ; i++ {
 		// If this is not the first entry in the object, there should be a
 		// list delimiter ('c') that shows up in the input first.
 		if i != 0 {
-			token, _, _, err := m.tokens.Step()
+			token, _, _, err := m.step()
 			if err != nil {
 				return err, true
 			}
@@ -730,17 +1098,14 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 				return nil, false
 			case tknArrayEnd:
 				return nil, false
-			case tknEnd:
-				return nil, true
 			case tknListDelim:
 				arrayIndex++
-			// nothing
 			default:
-				panic(fmt.Sprintf("expected object field element delimiter, received: %v", token))
+				return m.newMatchError(token, tokenToText(tknListDelim)), true
 			}
 		}
 
-		token, tokenData, tokenDataLen, err := m.tokens.Step()
+		token, tokenData, tokenDataLen, err := m.step()
 		if err != nil {
 			return err, true
 		}
@@ -765,7 +1130,7 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 		default:
 			// If it's an array, it's possible that we're grabbing a literal like int or float, and we should not panic
 			if !arrayMode {
-				panic(fmt.Sprintf("expected literal, received: %v", token))
+				return m.newMatchError(token, tokenToText(tknString)), true
 			}
 		}
 
@@ -773,16 +1138,16 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 			// Fake a key element by using the array index, and use the key as the actual value, tokenData
 			keyString = fmt.Sprintf("[%d]", arrayIndex)
 		} else {
-			token, tokenData, tokenDataLen, err = m.tokens.Step()
+			token, tokenData, tokenDataLen, err = m.step()
 			if err != nil {
 				return err, true
 			}
 
 			if token != tknObjectKeyDelim {
-				panic(fmt.Sprintf("expected object key delimiter: got %v, %v", token, string(tokenData)))
+				return m.newMatchError(token, tokenToText(tknObjectKeyDelim)), true
 			}
 
-			token, tokenData, tokenDataLen, err = m.tokens.Step()
+			token, tokenData, tokenDataLen, err = m.step()
 			if err != nil {
 				return err, true
 			}
@@ -792,7 +1157,10 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 		if keyElem, ok := node.Elems[keyString]; ok {
 			// Run the execution node that applies to this particular
 			// key of the object.
-			m.matchExec(token, tokenData, tokenDataLen, keyElem)
+			err = m.matchExec(token, tokenData, tokenDataLen, keyElem)
+			if err != nil {
+				return err, true
+			}
 
 			// Check if running this keys execution has resolved the entirety
 			// of the expression, if so we can leave immediately.
@@ -802,20 +1170,68 @@ func (m *FastMatcher) matchObjectOrArray(token tokenType, tokenData []byte, node
 		} else {
 			// If we don't have any parse requirements for this key in
 			// the object, we can just skip its value and continue
-			m.skipValue(token)
+			err = m.skipValue(token)
+			if err != nil {
+				return err, true
+			}
 		}
 	}
-	return nil, false
+}
+
+// SetSampling enables the collection of statistics into stats for one in
+// every interval documents, which can then be used by ReoptimizeMatchDef.
+// The statistics are updated without any locking, so each matcher should
+// be given its own MatchStats.  Passing a nil stats disables sampling.
+func (m *FastMatcher) SetSampling(stats *MatchStats, interval int) {
+	if interval < 1 {
+		interval = 1
+	}
+	if stats != nil {
+		stats.ensureBuckets(len(m.def.MatchTree.data))
+	}
+
+	m.sampleStats = stats
+	m.sampleInterval = interval
+	m.sampleCountdown = 0
+	m.sampling = false
 }
 
 func (m *FastMatcher) Match(data []byte) (bool, error) {
 	m.tokens.Reset(data)
 
-	if len(data) == 0 {
+	m.sampling = false
+	if m.sampleStats != nil {
+		m.sampleCountdown--
+		if m.sampleCountdown <= 0 {
+			m.sampleCountdown = m.sampleInterval
+			m.sampling = true
+			m.sampleStats.NumSampled++
+		}
+	}
+
+	if len(data) == 0 && !m.tokens.strict {
 		return false, nil
 	}
 
-	token, tokenData, tokenDataLen, err := m.tokens.Step()
+	if m.def.ParseNode == nil {
+		// Definitions made up entirely of constant expressions have nothing
+		// to match, the result is whether any of those expressions are true.
+		if m.tokens.strict {
+			err := m.validateRemaining()
+			if err != nil {
+				return false, err
+			}
+		}
+
+		for _, bucketIdx := range m.def.MatchBuckets {
+			if bucketIdx == AlwaysTrueIdent {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	token, tokenData, tokenDataLen, err := m.step()
 	if err != nil {
 		return false, err
 	}
@@ -825,6 +1241,13 @@ func (m *FastMatcher) Match(data []byte) (bool, error) {
 		return false, err
 	}
 
+	if m.tokens.strict {
+		err = m.validateRemaining()
+		if err != nil {
+			return false, err
+		}
+	}
+
 	// Resolve any outstanding buckets in the tree.  This is required for
 	// operators such as NOT and NEOR to correctly be resolved.
 	m.buckets.Resolve()
@@ -832,6 +1255,21 @@ func (m *FastMatcher) Match(data []byte) (bool, error) {
 	return m.buckets.IsTrue(0), nil
 }
 
+// validateRemaining reads the rest of the document so that the tokenizer can
+// validate it.  This is only meaningful when running in strict mode.
+func (m *FastMatcher) validateRemaining() error {
+	for {
+		token, _, _, err := m.step()
+		if err != nil {
+			return err
+		}
+
+		if token == tknEnd {
+			return nil
+		}
+	}
+}
+
 func (m *FastMatcher) MatchWithStatus(data []byte) (bool, int, error) {
 	var statusFlags int
 	matched, err := m.Match(data)
@@ -843,6 +1281,12 @@ func (m *FastMatcher) MatchWithStatus(data []byte) (bool, int, error) {
 
 func (m *FastMatcher) ExpressionMatched(expressionIdx int) bool {
 	binTreeIdx := m.def.MatchBuckets[expressionIdx]
+	if binTreeIdx == AlwaysTrueIdent {
+		return true
+	} else if binTreeIdx == AlwaysFalseIdent {
+		return false
+	}
+
 	return m.buckets.IsResolved(binTreeIdx) &&
 		m.buckets.IsTrue(binTreeIdx)
 }
@@ -61,6 +61,81 @@ func (ref FuncRef) String() string {
 	return value
 }
 
+// ValueSetRef is a set of constant values, which an OpTypeIn op checks the
+// value on its other side for membership of.  Strings are compared by their
+// JSON-escaped form, so they are held in a map keyed by it, while all other
+// values are compared one at a time.
+type ValueSetRef struct {
+	Values []FastVal
+
+	strings map[string]bool
+	others  []FastVal
+}
+
+// NewValueSetRef creates a set of values.  Strings must already have been
+// converted to their JSON-escaped form.
+func NewValueSetRef(values []FastVal) ValueSetRef {
+	ref := ValueSetRef{
+		Values:  values,
+		strings: make(map[string]bool),
+	}
+
+	for _, val := range values {
+		if val.IsString() {
+			if key, ok := equalsIndexValueKey(val); ok {
+				ref.strings[key] = true
+				continue
+			}
+		}
+		ref.others = append(ref.others, val)
+	}
+
+	return ref
+}
+
+// Contains returns whether val is equal to any of the values in the set.
+func (ref ValueSetRef) Contains(val FastVal) bool {
+	switch val.Type() {
+	case StringValue, BinStringValue, JsonStringValue, IntValue, UintValue, FloatValue:
+		// These are compared against strings by their JSON-escaped form
+		var buf [32]byte
+		escVal, err := val.toJsonStringInternal(buf[:])
+		if err == nil && ref.strings[string(escVal.sliceData)] {
+			return true
+		}
+	}
+
+	for _, other := range ref.others {
+		if equals, _ := val.Equals(other); equals {
+			return true
+		}
+	}
+	return false
+}
+
+func (ref ValueSetRef) String() string {
+	value := "set:["
+	for valIdx, val := range ref.Values {
+		if valIdx != 0 {
+			value += ", "
+		}
+		value += val.String()
+	}
+	value += "]"
+	return value
+}
+
+// RangeRef is the pair of bounds which an OpTypeBetween op checks the value
+// on its other side against.  Both bounds are inclusive.
+type RangeRef struct {
+	Low  DataRef
+	High DataRef
+}
+
+func (ref RangeRef) String() string {
+	return fmt.Sprintf("range:[%s, %s]", dataRefToString(ref.Low), dataRefToString(ref.High))
+}
+
 type OpType int
 
 const (
@@ -72,6 +147,7 @@ const (
 	OpTypeExists
 	OpTypeIn
 	OpTypeMatches
+	OpTypeBetween
 )
 
 func (value OpType) String() string {
@@ -92,6 +168,8 @@ func (value OpType) String() string {
 		return "exists"
 	case OpTypeMatches:
 		return "matches"
+	case OpTypeBetween:
+		return "between"
 	}
 
 	return "??unknown??"
@@ -102,11 +180,24 @@ type OpNode struct {
 	Op        OpType
 	Lhs       DataRef
 	Rhs       DataRef
+
+	// SharedBuckets lists any further buckets which depend on exactly the
+	// same comparison.  The op is evaluated once and its result is marked
+	// against BucketIdx as well as each of these.
+	SharedBuckets []BucketID
+}
+
+func (op OpNode) bucketsString() string {
+	value := fmt.Sprintf("%d", op.BucketIdx)
+	for _, bucketIdx := range op.SharedBuckets {
+		value += fmt.Sprintf(",%d", bucketIdx)
+	}
+	return value
 }
 
 func (op OpNode) String() string {
-	return fmt.Sprintf("[%d] %s %s %s",
-		op.BucketIdx,
+	return fmt.Sprintf("[%s] %s %s %s",
+		op.bucketsString(),
 		dataRefToString(op.Lhs),
 		op.Op,
 		dataRefToString(op.Rhs))
@@ -138,11 +229,18 @@ type LoopNode struct {
 	Mode      LoopType
 	Target    DataRef
 	Node      *ExecNode
+
+	// Slice optionally restricts the loop to a range of the array elements.
+	Slice *ArraySlice
 }
 
 func (node *LoopNode) String() string {
 	out := ""
-	out += fmt.Sprintf("[%d] :%s in %s:\n", node.BucketIdx, node.Mode, dataRefToString(node.Target))
+	target := dataRefToString(node.Target)
+	if node.Slice != nil {
+		target += node.Slice.String()
+	}
+	out += fmt.Sprintf("[%d] :%s in %s:\n", node.BucketIdx, node.Mode, target)
 	out += reindentString(node.Node.String(), "  ")
 	return out
 }
@@ -150,6 +248,11 @@ func (node *LoopNode) String() string {
 type AfterNode struct {
 	Ops   []OpNode
 	Loops []LoopNode
+
+	// Elems holds the elements of an array which are addressed by a negative
+	// index such as "[-1]".  These can only be located once the length of the
+	// array is known, so are matched after the array has been scanned.
+	Elems map[string]*ExecNode
 }
 
 type ExecNode struct {
@@ -158,6 +261,10 @@ type ExecNode struct {
 	Ops     []OpNode
 	Loops   []LoopNode
 	After   *AfterNode
+
+	// Index optionally routes string values to the matching equality ops,
+	// see Transformer.EqualsIndexMinOps.
+	Index *EqualsIndex
 }
 
 type MatchDef struct {
@@ -172,8 +279,10 @@ func (def MatchDef) String() string {
 	var out string
 	out += "match tree:\n"
 	out += "  $doc:\n"
-	out += reindentString(def.ParseNode.String(), "    ")
-	out += "\n"
+	if def.ParseNode != nil {
+		out += reindentString(def.ParseNode.String(), "    ")
+		out += "\n"
+	}
 	out += "bin tree:\n"
 	out += reindentString(def.MatchTree.String(), "  ")
 	out += "\n"
@@ -192,6 +301,10 @@ func (node ExecNode) String() string {
 		out += fmt.Sprintf(":store $%d\n", node.StoreId)
 	}
 
+	if node.Index != nil {
+		out += fmt.Sprintf(":equals-index %d values, %d ops\n", len(node.Index.Values), len(node.Index.Indexed))
+	}
+
 	if len(node.Ops) > 0 {
 		out += fmt.Sprintf(":ops\n")
 		for _, op := range node.Ops {
@@ -226,6 +339,21 @@ func (node ExecNode) String() string {
 	}
 
 	if node.After != nil {
+		var aks []string
+		for k := range node.After.Elems {
+			aks = append(aks, k)
+		}
+		sort.Strings(aks)
+		if len(aks) > 0 {
+			out += fmt.Sprintf(":after-elems:\n")
+			for _, k := range aks {
+				elem := node.After.Elems[k]
+				out += fmt.Sprintf("  `%s`:\n", k)
+				out += reindentString(elem.String(), "    ")
+				out += "\n"
+			}
+		}
+
 		if len(node.After.Ops) > 0 {
 			out += fmt.Sprintf(":after-ops:\n")
 			for _, anode := range node.After.Ops {
@@ -0,0 +1,1109 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// The binary encoding of a MatchDef begins with matchDefMagic followed by
+// the format version.  Any change to the layout below must increment
+// matchDefVersion.  All integers are encoded as varints, and strings and
+// byte slices are prefixed by their length.
+//
+// Version 2 added the list of shared buckets to each op, version 3 a flag
+// marking the nodes which have an EqualsIndex, version 4 the value sets
+// used by OpTypeIn, version 5 the ranges used by OpTypeBetween, version 6
+// the LIKE pattern values and version 7 the negative index elements of
+// after nodes along with loop slices.  Definitions written with older
+// versions can still be decoded.
+const matchDefVersion = 7
+
+const minMatchDefVersion = 1
+
+var matchDefMagic = []byte("GJSM")
+
+// Limits the nesting of decoded nodes so that corrupt input cannot cause
+// unbounded recursion.
+const maxMatchDefDepth = 1024
+
+const (
+	dataRefTagNil = iota
+	dataRefTagActiveLit
+	dataRefTagSlot
+	dataRefTagFunc
+	dataRefTagValue
+	dataRefTagValueSet
+	dataRefTagRange
+)
+
+// matchDefFuncParams lists the functions which the matcher can resolve along
+// with the number of parameters each of them requires.
+var matchDefFuncParams = map[string]int{
+	MathFuncAbs:     1,
+	MathFuncAcos:    1,
+	MathFuncAsin:    1,
+	MathFuncAtan:    1,
+	MathFuncAtan2:   2,
+	MathFuncCeil:    1,
+	MathFuncCos:     1,
+	MathFuncDegrees: 1,
+	MathFuncE:       0,
+	MathFuncExp:     1,
+	MathFuncFloor:   1,
+	MathFuncLog:     1,
+	MathFuncLn:      1,
+	MathFuncPi:      0,
+	MathFuncPow:     2,
+	MathFuncRadians: 1,
+	MathFuncRound:   1,
+	MathFuncSin:     1,
+	MathFuncSqrt:    1,
+	MathFuncTan:     1,
+	MathFuncAdd:     2,
+	MathFuncSub:     2,
+	MathFuncMul:     2,
+	MathFuncDiv:     2,
+	MathFuncMod:     2,
+	MathFuncNeg:     1,
+	DateFunc:        1,
+}
+
+type pcrePatternIface interface {
+	Pattern() string
+}
+
+type matchDefWriter struct {
+	buf []byte
+}
+
+func (w *matchDefWriter) writeUvarint(value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], value)
+	w.buf = append(w.buf, scratch[:n]...)
+}
+
+func (w *matchDefWriter) writeVarint(value int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], value)
+	w.buf = append(w.buf, scratch[:n]...)
+}
+
+func (w *matchDefWriter) writeBool(value bool) {
+	if value {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *matchDefWriter) writeBytes(value []byte) {
+	w.writeUvarint(uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
+
+func (w *matchDefWriter) writeString(value string) {
+	w.writeUvarint(uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
+
+func (w *matchDefWriter) writeFastVal(val FastVal) error {
+	w.writeUvarint(uint64(val.dataType))
+	w.writeBool(val.userDefined)
+
+	switch val.dataType {
+	case InvalidValue, MissingValue, NullValue, TrueValue, FalseValue:
+	case IntValue, UintValue, FloatValue:
+		w.buf = append(w.buf, val.rawData[:]...)
+	case StringValue:
+		w.writeString(val.data.(string))
+	case BinStringValue, JsonStringValue, JsonIntValue, JsonUintValue, JsonFloatValue,
+		BinaryValue, ArrayValue, ObjectValue:
+		w.writeBytes(val.sliceData)
+	case TimeValue:
+		timeBytes, err := val.GetTime().MarshalBinary()
+		if err != nil {
+			return err
+		}
+		w.writeBytes(timeBytes)
+	case RegexValue:
+		w.writeString(val.data.(*regexp.Regexp).String())
+	case PcreValue:
+		pcre, ok := val.data.(pcrePatternIface)
+		if !ok {
+			return errors.New("pcre value does not expose its pattern")
+		}
+		w.writeString(pcre.Pattern())
+	case LikeValue:
+		matcher := val.data.(*LikeMatcher)
+		w.writeString(matcher.Pattern())
+		w.writeString(matcher.Escape())
+	default:
+		return fmt.Errorf("cannot encode value of type %d", val.dataType)
+	}
+
+	return nil
+}
+
+func (w *matchDefWriter) writeDataRef(ref DataRef) error {
+	switch ref := ref.(type) {
+	case nil:
+		w.writeUvarint(dataRefTagNil)
+	case activeLitRef:
+		w.writeUvarint(dataRefTagActiveLit)
+	case SlotRef:
+		w.writeUvarint(dataRefTagSlot)
+		w.writeVarint(int64(ref.Slot))
+	case FuncRef:
+		w.writeUvarint(dataRefTagFunc)
+		w.writeString(ref.FuncName)
+		w.writeUvarint(uint64(len(ref.Params)))
+		for _, param := range ref.Params {
+			err := w.writeDataRef(param)
+			if err != nil {
+				return err
+			}
+		}
+	case FastVal:
+		w.writeUvarint(dataRefTagValue)
+		return w.writeFastVal(ref)
+	case ValueSetRef:
+		w.writeUvarint(dataRefTagValueSet)
+		w.writeUvarint(uint64(len(ref.Values)))
+		for _, val := range ref.Values {
+			err := w.writeFastVal(val)
+			if err != nil {
+				return err
+			}
+		}
+	case RangeRef:
+		w.writeUvarint(dataRefTagRange)
+		err := w.writeDataRef(ref.Low)
+		if err != nil {
+			return err
+		}
+		return w.writeDataRef(ref.High)
+	default:
+		return fmt.Errorf("cannot encode data reference %T", ref)
+	}
+
+	return nil
+}
+
+func (w *matchDefWriter) writeOps(ops []OpNode) error {
+	w.writeUvarint(uint64(len(ops)))
+	for _, op := range ops {
+		w.writeVarint(int64(op.BucketIdx))
+		w.writeUvarint(uint64(len(op.SharedBuckets)))
+		for _, bucketIdx := range op.SharedBuckets {
+			w.writeVarint(int64(bucketIdx))
+		}
+		w.writeVarint(int64(op.Op))
+
+		err := w.writeDataRef(op.Lhs)
+		if err != nil {
+			return err
+		}
+
+		err = w.writeDataRef(op.Rhs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *matchDefWriter) writeLoops(loops []LoopNode) error {
+	w.writeUvarint(uint64(len(loops)))
+	for _, loop := range loops {
+		w.writeVarint(int64(loop.BucketIdx))
+		w.writeVarint(int64(loop.Mode))
+
+		err := w.writeDataRef(loop.Target)
+		if err != nil {
+			return err
+		}
+
+		err = w.writeExecNode(loop.Node)
+		if err != nil {
+			return err
+		}
+
+		w.writeBool(loop.Slice != nil)
+		if loop.Slice != nil {
+			w.writeVarint(int64(loop.Slice.Start))
+			w.writeVarint(int64(loop.Slice.End))
+			w.writeBool(loop.Slice.HasEnd)
+		}
+	}
+	return nil
+}
+
+func (w *matchDefWriter) writeElems(elems map[string]*ExecNode) error {
+	// Elements are written in key order so that the same definition always
+	// produces the same encoding.
+	keys := make([]string, 0, len(elems))
+	for key := range elems {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.writeUvarint(uint64(len(keys)))
+	for _, key := range keys {
+		w.writeString(key)
+
+		err := w.writeExecNode(elems[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *matchDefWriter) writeExecNode(node *ExecNode) error {
+	w.writeBool(node != nil)
+	if node == nil {
+		return nil
+	}
+
+	w.writeVarint(int64(node.StoreId))
+
+	err := w.writeElems(node.Elems)
+	if err != nil {
+		return err
+	}
+
+	err = w.writeOps(node.Ops)
+	if err != nil {
+		return err
+	}
+
+	err = w.writeLoops(node.Loops)
+	if err != nil {
+		return err
+	}
+
+	// The index itself is rebuilt from the ops when decoding
+	w.writeBool(node.Index != nil)
+
+	w.writeBool(node.After != nil)
+	if node.After != nil {
+		err = w.writeOps(node.After.Ops)
+		if err != nil {
+			return err
+		}
+
+		err = w.writeLoops(node.After.Loops)
+		if err != nil {
+			return err
+		}
+
+		err = w.writeElems(node.After.Elems)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarshalBinary encodes the definition into a versioned binary format which
+// can be decoded with UnmarshalMatchDef.  Regular expressions are encoded by
+// their source and are recompiled when the definition is decoded.
+func (def *MatchDef) MarshalBinary() ([]byte, error) {
+	w := &matchDefWriter{}
+	w.buf = append(w.buf, matchDefMagic...)
+	w.writeUvarint(matchDefVersion)
+
+	w.writeVarint(int64(def.NumBuckets))
+	w.writeVarint(int64(def.NumSlots))
+
+	w.writeUvarint(uint64(len(def.MatchBuckets)))
+	for _, bucketIdx := range def.MatchBuckets {
+		w.writeVarint(int64(bucketIdx))
+	}
+
+	w.writeUvarint(uint64(len(def.MatchTree.data)))
+	for _, node := range def.MatchTree.data {
+		w.writeVarint(int64(node.NodeType))
+		w.writeVarint(int64(node.ParentIdx))
+		w.writeVarint(int64(node.Left))
+		w.writeVarint(int64(node.Right))
+	}
+
+	err := w.writeExecNode(def.ParseNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.buf, nil
+}
+
+type matchDefReader struct {
+	data    []byte
+	pos     int
+	depth   int
+	version uint64
+	def     *MatchDef
+}
+
+func (r *matchDefReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid match definition at offset %d: %s", r.pos, fmt.Sprintf(format, args...))
+}
+
+func (r *matchDefReader) readUvarint() (uint64, error) {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.errorf("bad varint")
+	}
+	r.pos += n
+	return value, nil
+}
+
+func (r *matchDefReader) readVarint() (int64, error) {
+	value, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, r.errorf("bad varint")
+	}
+	r.pos += n
+	return value, nil
+}
+
+func (r *matchDefReader) readInt() (int, error) {
+	value, err := r.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		return 0, r.errorf("integer out of range")
+	}
+	return int(value), nil
+}
+
+// readLen reads a length, ensuring that at least minItemSize bytes per item
+// remain in the input so that corrupt lengths cannot cause huge allocations.
+func (r *matchDefReader) readLen(minItemSize int) (int, error) {
+	value, err := r.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if value > uint64(len(r.data)-r.pos)/uint64(minItemSize) {
+		return 0, r.errorf("length %d exceeds remaining data", value)
+	}
+	return int(value), nil
+}
+
+func (r *matchDefReader) readBool() (bool, error) {
+	if r.pos >= len(r.data) {
+		return false, r.errorf("unexpected end of data")
+	}
+	value := r.data[r.pos]
+	r.pos++
+	if value > 1 {
+		return false, r.errorf("bad boolean")
+	}
+	return value == 1, nil
+}
+
+func (r *matchDefReader) readBytes() ([]byte, error) {
+	length, err := r.readLen(1)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, length)
+	copy(value, r.data[r.pos:])
+	r.pos += length
+	return value, nil
+}
+
+func (r *matchDefReader) readString() (string, error) {
+	length, err := r.readLen(1)
+	if err != nil {
+		return "", err
+	}
+	value := string(r.data[r.pos : r.pos+length])
+	r.pos += length
+	return value, nil
+}
+
+func (r *matchDefReader) readFastVal() (FastVal, error) {
+	dataType, err := r.readUvarint()
+	if err != nil {
+		return FastVal{}, err
+	}
+
+	userDefined, err := r.readBool()
+	if err != nil {
+		return FastVal{}, err
+	}
+
+	var val FastVal
+	switch ValueType(dataType) {
+	case InvalidValue, MissingValue, NullValue, TrueValue, FalseValue:
+		val = FastVal{dataType: ValueType(dataType)}
+	case IntValue, UintValue, FloatValue:
+		if len(r.data)-r.pos < len(val.rawData) {
+			return FastVal{}, r.errorf("unexpected end of data")
+		}
+		val = FastVal{dataType: ValueType(dataType)}
+		copy(val.rawData[:], r.data[r.pos:])
+		r.pos += len(val.rawData)
+	case StringValue:
+		str, err := r.readString()
+		if err != nil {
+			return FastVal{}, err
+		}
+		val = NewStringFastVal(str)
+	case BinStringValue, JsonStringValue, JsonIntValue, JsonUintValue, JsonFloatValue,
+		BinaryValue, ArrayValue, ObjectValue:
+		sliceData, err := r.readBytes()
+		if err != nil {
+			return FastVal{}, err
+		}
+		val = FastVal{
+			dataType:  ValueType(dataType),
+			sliceData: sliceData,
+		}
+	case TimeValue:
+		timeBytes, err := r.readBytes()
+		if err != nil {
+			return FastVal{}, err
+		}
+		var timeVal time.Time
+		err = timeVal.UnmarshalBinary(timeBytes)
+		if err != nil {
+			return FastVal{}, r.errorf("bad time value: %s", err)
+		}
+		val = NewTimeFastVal(&timeVal)
+	case RegexValue:
+		regexStr, err := r.readString()
+		if err != nil {
+			return FastVal{}, err
+		}
+		regex, err := regexp.Compile(regexStr)
+		if err != nil {
+			return FastVal{}, errors.New("failed to compile RegexExpr: " + err.Error())
+		}
+		val = NewRegexpFastVal(regex)
+	case PcreValue:
+		pcreStr, err := r.readString()
+		if err != nil {
+			return FastVal{}, err
+		}
+		pcreWrapper, err := MakePcreWrapper(pcreStr)
+		if err != nil {
+			return FastVal{}, err
+		}
+		val = NewPcreFastVal(pcreWrapper)
+	case LikeValue:
+		pattern, err := r.readString()
+		if err != nil {
+			return FastVal{}, err
+		}
+		escape, err := r.readString()
+		if err != nil {
+			return FastVal{}, err
+		}
+		matcher, err := NewLikeMatcher(pattern, escape)
+		if err != nil {
+			return FastVal{}, r.errorf("bad like pattern: %s", err)
+		}
+		val = NewLikeFastVal(matcher)
+	default:
+		return FastVal{}, r.errorf("unknown value type %d", dataType)
+	}
+
+	val.userDefined = userDefined
+	return val, nil
+}
+
+func (r *matchDefReader) readSlot() (SlotID, error) {
+	slot, err := r.readInt()
+	if err != nil {
+		return 0, err
+	}
+	if slot < 0 || slot > r.def.NumSlots {
+		return 0, r.errorf("slot %d out of range", slot)
+	}
+	return SlotID(slot), nil
+}
+
+func (r *matchDefReader) readBucket() (BucketID, error) {
+	bucketIdx, err := r.readInt()
+	if err != nil {
+		return 0, err
+	}
+	if bucketIdx < 0 || bucketIdx >= len(r.def.MatchTree.data) {
+		return 0, r.errorf("bucket %d out of range", bucketIdx)
+	}
+	return BucketID(bucketIdx), nil
+}
+
+func (r *matchDefReader) readDataRef() (DataRef, error) {
+	tag, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case dataRefTagNil:
+		return nil, nil
+	case dataRefTagActiveLit:
+		return activeLitRef{}, nil
+	case dataRefTagSlot:
+		slot, err := r.readSlot()
+		if err != nil {
+			return nil, err
+		}
+		if slot == 0 {
+			return nil, r.errorf("invalid slot reference")
+		}
+		return SlotRef{slot}, nil
+	case dataRefTagFunc:
+		var ref FuncRef
+		ref.FuncName, err = r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		minParams, ok := matchDefFuncParams[ref.FuncName]
+		if !ok {
+			return nil, r.errorf("unknown function `%s`", ref.FuncName)
+		}
+
+		numParams, err := r.readLen(1)
+		if err != nil {
+			return nil, err
+		}
+		if numParams < minParams {
+			return nil, r.errorf("function `%s` requires %d parameters", ref.FuncName, minParams)
+		}
+
+		r.depth++
+		if r.depth > maxMatchDefDepth {
+			return nil, r.errorf("nesting too deep")
+		}
+		for i := 0; i < numParams; i++ {
+			param, err := r.readDataRef()
+			if err != nil {
+				return nil, err
+			}
+			ref.Params = append(ref.Params, param)
+		}
+		r.depth--
+
+		return ref, nil
+	case dataRefTagValue:
+		return r.readFastVal()
+	case dataRefTagValueSet:
+		numValues, err := r.readLen(2)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]FastVal, numValues)
+		for i := range values {
+			values[i], err = r.readFastVal()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewValueSetRef(values), nil
+	case dataRefTagRange:
+		var ref RangeRef
+
+		r.depth++
+		if r.depth > maxMatchDefDepth {
+			return nil, r.errorf("nesting too deep")
+		}
+		ref.Low, err = r.readDataRef()
+		if err != nil {
+			return nil, err
+		}
+		ref.High, err = r.readDataRef()
+		if err != nil {
+			return nil, err
+		}
+		r.depth--
+
+		return ref, nil
+	}
+
+	return nil, r.errorf("unknown data reference tag %d", tag)
+}
+
+// checkOperand ensures that a data reference which is resolved to a single
+// value does not contain a value set or a range, which may only be the right
+// hand side of an in op or a between op respectively.  References to the
+// active literal are only allowed where one is available.
+func (r *matchDefReader) checkOperand(ref DataRef, hasActiveLit bool) error {
+	switch ref := ref.(type) {
+	case activeLitRef:
+		if !hasActiveLit {
+			return r.errorf("active literal reference without an active literal")
+		}
+	case ValueSetRef:
+		return r.errorf("value set outside of an in op")
+	case RangeRef:
+		return r.errorf("range outside of a between op")
+	case FuncRef:
+		for _, param := range ref.Params {
+			err := r.checkOperand(param, hasActiveLit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *matchDefReader) readOps(hasActiveLit bool) ([]OpNode, error) {
+	numOps, err := r.readLen(4)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []OpNode
+	for i := 0; i < numOps; i++ {
+		var op OpNode
+
+		op.BucketIdx, err = r.readBucket()
+		if err != nil {
+			return nil, err
+		}
+
+		if r.version >= 2 {
+			numShared, err := r.readLen(1)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < numShared; j++ {
+				bucketIdx, err := r.readBucket()
+				if err != nil {
+					return nil, err
+				}
+				op.SharedBuckets = append(op.SharedBuckets, bucketIdx)
+			}
+		}
+
+		opType, err := r.readInt()
+		if err != nil {
+			return nil, err
+		}
+		if opType < int(OpTypeEquals) || opType > int(OpTypeBetween) {
+			return nil, r.errorf("unknown op type %d", opType)
+		}
+		op.Op = OpType(opType)
+
+		op.Lhs, err = r.readDataRef()
+		if err != nil {
+			return nil, err
+		}
+
+		op.Rhs, err = r.readDataRef()
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.checkOperand(op.Lhs, hasActiveLit)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case OpTypeIn:
+			if _, ok := op.Rhs.(ValueSetRef); !ok {
+				return nil, r.errorf("in op requires a value set")
+			}
+		case OpTypeBetween:
+			rng, ok := op.Rhs.(RangeRef)
+			if !ok {
+				return nil, r.errorf("between op requires a range")
+			}
+			err = r.checkOperand(rng.Low, hasActiveLit)
+			if err != nil {
+				return nil, err
+			}
+			err = r.checkOperand(rng.High, hasActiveLit)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			err = r.checkOperand(op.Rhs, hasActiveLit)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// readLoops reads the loops of a node.  Loops after a node's value has been
+// read target the slot which stored it, all other loops target the value.
+func (r *matchDefReader) readLoops(after bool) ([]LoopNode, error) {
+	numLoops, err := r.readLen(4)
+	if err != nil {
+		return nil, err
+	}
+
+	var loops []LoopNode
+	for i := 0; i < numLoops; i++ {
+		var loop LoopNode
+
+		loop.BucketIdx, err = r.readBucket()
+		if err != nil {
+			return nil, err
+		}
+
+		mode, err := r.readInt()
+		if err != nil {
+			return nil, err
+		}
+		if mode < int(LoopTypeAny) || mode > int(LoopTypeAnyEvery) {
+			return nil, r.errorf("unknown loop type %d", mode)
+		}
+		loop.Mode = LoopType(mode)
+
+		loop.Target, err = r.readDataRef()
+		if err != nil {
+			return nil, err
+		}
+
+		if after {
+			if _, ok := loop.Target.(SlotRef); !ok {
+				return nil, r.errorf("after loop without a slot target")
+			}
+		} else if loop.Target != nil {
+			return nil, r.errorf("loop with a target outside of an after node")
+		}
+
+		loop.Node, err = r.readExecNode()
+		if err != nil {
+			return nil, err
+		}
+		if loop.Node == nil {
+			return nil, r.errorf("loop without a node")
+		}
+
+		if r.version >= 7 {
+			hasSlice, err := r.readBool()
+			if err != nil {
+				return nil, err
+			}
+			if hasSlice {
+				loop.Slice = &ArraySlice{}
+				loop.Slice.Start, err = r.readInt()
+				if err != nil {
+					return nil, err
+				}
+				loop.Slice.End, err = r.readInt()
+				if err != nil {
+					return nil, err
+				}
+				loop.Slice.HasEnd, err = r.readBool()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		loops = append(loops, loop)
+	}
+
+	return loops, nil
+}
+
+func (r *matchDefReader) readExecNode() (*ExecNode, error) {
+	present, err := r.readBool()
+	if err != nil || !present {
+		return nil, err
+	}
+
+	r.depth++
+	if r.depth > maxMatchDefDepth {
+		return nil, r.errorf("nesting too deep")
+	}
+
+	node := &ExecNode{}
+
+	node.StoreId, err = r.readSlot()
+	if err != nil {
+		return nil, err
+	}
+
+	node.Elems, err = r.readElems()
+	if err != nil {
+		return nil, err
+	}
+
+	node.Ops, err = r.readOps(true)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Loops, err = r.readLoops(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.version >= 3 {
+		hasIndex, err := r.readBool()
+		if err != nil {
+			return nil, err
+		}
+		if hasIndex {
+			node.Index = newEqualsIndex(node.Ops, 1)
+			if node.Index == nil {
+				return nil, r.errorf("node has no ops which can be indexed")
+			}
+		}
+	}
+
+	hasAfter, err := r.readBool()
+	if err != nil {
+		return nil, err
+	}
+	if hasAfter {
+		node.After = &AfterNode{}
+
+		node.After.Ops, err = r.readOps(false)
+		if err != nil {
+			return nil, err
+		}
+
+		node.After.Loops, err = r.readLoops(true)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.version >= 7 {
+			node.After.Elems, err = r.readElems()
+			if err != nil {
+				return nil, err
+			}
+			for key := range node.After.Elems {
+				if _, ok := parseNegativeArrayIndex(key); !ok {
+					return nil, r.errorf("after element `%s` is not a negative array index", key)
+				}
+			}
+		}
+	}
+
+	r.depth--
+	return node, nil
+}
+
+func (r *matchDefReader) readElems() (map[string]*ExecNode, error) {
+	numElems, err := r.readLen(2)
+	if err != nil {
+		return nil, err
+	}
+
+	var elems map[string]*ExecNode
+	if numElems > 0 {
+		elems = make(map[string]*ExecNode, numElems)
+	}
+	for i := 0; i < numElems; i++ {
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		elem, err := r.readExecNode()
+		if err != nil {
+			return nil, err
+		}
+		if elem == nil {
+			return nil, r.errorf("element `%s` without a node", key)
+		}
+
+		elems[key] = elem
+	}
+
+	return elems, nil
+}
+
+func (r *matchDefReader) readMatchTree() error {
+	numNodes, err := r.readLen(4)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]binTreeNode, numNodes)
+	for i := range nodes {
+		nodeType, err := r.readInt()
+		if err != nil {
+			return err
+		}
+		if nodeType < int(nodeTypeLeaf) || nodeType > int(nodeTypeLoop) {
+			return r.errorf("unknown tree node type %d", nodeType)
+		}
+		nodes[i].NodeType = BinTreeNodeType(nodeType)
+
+		for _, ptr := range []*int{&nodes[i].ParentIdx, &nodes[i].Left, &nodes[i].Right} {
+			*ptr, err = r.readInt()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The matcher follows these pointers without any checks, so make sure
+	// they all point at nodes within the tree.
+	for i, node := range nodes {
+		if node.ParentIdx < 0 || node.ParentIdx >= numNodes {
+			return r.errorf("tree node %d has an invalid parent", i)
+		}
+		if binTreeNodeTypeHasLeft(node.NodeType) && (node.Left < 0 || node.Left >= numNodes) {
+			return r.errorf("tree node %d has an invalid left child", i)
+		}
+		if binTreeNodeTypeHasRight(node.NodeType) && (node.Right < 0 || node.Right >= numNodes) {
+			return r.errorf("tree node %d has an invalid right child", i)
+		}
+	}
+
+	// Marking nodes relies on the tree being consistent as well, so every
+	// child must point back at its parent and every node must be reached
+	// exactly once from the root.  Trees built by IncrementalDef are not laid
+	// out in preorder, which rules out using binTree.Validate here.
+	seen := make([]bool, numNodes)
+	var pending []int
+	if numNodes > 0 {
+		pending = append(pending, 0)
+	}
+	for len(pending) > 0 {
+		idx := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if seen[idx] {
+			return r.errorf("tree node %d is reached more than once", idx)
+		}
+		seen[idx] = true
+
+		node := nodes[idx]
+		var children []int
+		if binTreeNodeTypeHasLeft(node.NodeType) {
+			children = append(children, node.Left)
+		}
+		if binTreeNodeTypeHasRight(node.NodeType) {
+			children = append(children, node.Right)
+		}
+		for _, child := range children {
+			if nodes[child].ParentIdx != idx {
+				return r.errorf("tree node %d does not point back at its parent %d", child, idx)
+			}
+			pending = append(pending, child)
+		}
+	}
+	for i := range seen {
+		if !seen[i] {
+			return r.errorf("tree node %d is not reachable from the root", i)
+		}
+	}
+
+	r.def.MatchTree.data = nodes
+	return nil
+}
+
+// UnmarshalMatchDef decodes a definition which was encoded with
+// MatchDef.MarshalBinary.  Any regular expressions within the definition
+// are recompiled, which fails for PCRE expressions if this build does not
+// include PCRE support.
+func UnmarshalMatchDef(data []byte) (*MatchDef, error) {
+	if len(data) < len(matchDefMagic) || string(data[:len(matchDefMagic)]) != string(matchDefMagic) {
+		return nil, errors.New("data is not an encoded match definition")
+	}
+
+	r := &matchDefReader{
+		data: data,
+		pos:  len(matchDefMagic),
+		def:  &MatchDef{},
+	}
+
+	version, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if version < minMatchDefVersion || version > matchDefVersion {
+		return nil, fmt.Errorf("unsupported match definition version %d", version)
+	}
+	r.version = version
+
+	def := r.def
+
+	def.NumBuckets, err = r.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	def.NumSlots, err = r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if def.NumSlots < 0 {
+		return nil, r.errorf("negative slot count")
+	}
+
+	numMatchBuckets, err := r.readLen(1)
+	if err != nil {
+		return nil, err
+	}
+	def.MatchBuckets = make([]int, numMatchBuckets)
+	for i := range def.MatchBuckets {
+		def.MatchBuckets[i], err = r.readInt()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = r.readMatchTree()
+	if err != nil {
+		return nil, err
+	}
+
+	if def.NumBuckets < 0 || def.NumBuckets > len(def.MatchTree.data) {
+		return nil, r.errorf("bucket count %d does not match the tree", def.NumBuckets)
+	}
+
+	for _, bucketIdx := range def.MatchBuckets {
+		if bucketIdx == AlwaysTrueIdent || bucketIdx == AlwaysFalseIdent {
+			continue
+		}
+		if bucketIdx < 0 || bucketIdx >= len(def.MatchTree.data) {
+			return nil, r.errorf("match bucket %d out of range", bucketIdx)
+		}
+	}
+
+	def.ParseNode, err = r.readExecNode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only definitions made up entirely of constant expressions can be
+	// matched without a tree, everything else resolves it.
+	if len(def.MatchTree.data) == 0 {
+		if def.ParseNode != nil {
+			return nil, r.errorf("parse node without a match tree")
+		}
+		for _, bucketIdx := range def.MatchBuckets {
+			if bucketIdx != AlwaysTrueIdent && bucketIdx != AlwaysFalseIdent {
+				return nil, r.errorf("match bucket %d without a match tree", bucketIdx)
+			}
+		}
+	}
+
+	if r.pos != len(data) {
+		return nil, r.errorf("unexpected trailing data")
+	}
+
+	return def, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+//go:build go1.18
+// +build go1.18
+
+package gojsonsm
+
+import (
+	"testing"
+)
+
+func FuzzUnmarshalMatchDef(f *testing.F) {
+	exprs := getBinaryTestExprs()
+
+	var defs []*MatchDef
+	for _, expr := range exprs {
+		var trans Transformer
+		defs = append(defs, trans.Transform([]Expression{expr}))
+	}
+	var trans Transformer
+	defs = append(defs, trans.Transform(exprs))
+
+	incDef := NewIncrementalDef()
+	for _, expr := range exprs {
+		incDef, _, _ = incDef.Add(expr)
+	}
+	defs = append(defs, incDef.MatchDef())
+
+	for _, def := range defs {
+		data, err := def.MarshalBinary()
+		if err != nil {
+			f.Fatalf("failed to marshal definition: %s", err)
+		}
+		f.Add(data)
+	}
+
+	docs := getTestPeopleDocs()[:5]
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		def, err := UnmarshalMatchDef(data)
+		if err != nil {
+			return
+		}
+
+		for _, doc := range docs {
+			tMatchNoPanic(t, def, doc)
+		}
+	})
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func getBinaryTestExprs() []Expression {
+	exprs := getIncrementalTestExprs()
+
+	extraExprs := []string{
+		`["like", ["field", "name"], ["regex", "Ne[a|i]l"]]`,
+		`["greaterthan", ["func", "date", ["field", "registered"]], ["time", "2015-01-02T03:04:05Z"]]`,
+		`["equals", ["func", "mathAbs", ["func", "mathNegate", ["field", "age"]]], ["value", 30.5]]`,
+		`["anyeveryin", 1, ["field", "friends"], ["notequals", ["field", 1, "name"], ["field", "name"]]]`,
+		`["in", ["field", "eyeColor"], ["value", "blue"], ["value", "brown"], ["value", 7], ["value", null]]`,
+		`["between", ["field", "latitude"], ["field", "longitude"], ["func", "date", ["value", "2015-01-02"]]]`,
+		`["not", ["between", ["field", "age"], ["value", 20], ["func", "mathAbs", ["field", "longitude"]]]]`,
+		`["like", ["field", "name"], ["likepattern", "%an%"]]`,
+		`["not", ["like", ["field", "email"], ["likepattern", "_!%%", "!"]]]`,
+		`["equals", ["field", "friends", "[-1]", "id"], ["field", "index"]]`,
+		`["everyin", 1, ["field", "friends", "[1:]"], ["lessthan", ["field", 1, "id"], ["field", "index"]]]`,
+	}
+	for _, data := range extraExprs {
+		expr, err := ParseJsonExpression([]byte(data))
+		if err != nil {
+			panic(err)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	return exprs
+}
+
+func tRoundTripMatchDef(t *testing.T, def *MatchDef) *MatchDef {
+	t.Helper()
+
+	data, err := def.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal definition: %s", err)
+	}
+
+	newDef, err := UnmarshalMatchDef(data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal definition: %s", err)
+	}
+
+	if newDef.String() != def.String() {
+		t.Fatalf("definitions differ after round trip:\n%s\n\n%s", def, newDef)
+	}
+
+	newData, err := newDef.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal decoded definition: %s", err)
+	}
+	if !bytes.Equal(data, newData) {
+		t.Fatalf("encoding is not stable across round trips")
+	}
+
+	for docIdx, doc := range getTestPeopleDocs() {
+		m := NewFastMatcher(def)
+		matched, err := m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		newM := NewFastMatcher(newDef)
+		newMatched, err := newM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match decoded definition: %s", err)
+		}
+
+		if matched != newMatched {
+			t.Fatalf("document %d: original matched %t, decoded matched %t", docIdx, matched, newMatched)
+		}
+		for i := range def.MatchBuckets {
+			if m.ExpressionMatched(i) != newM.ExpressionMatched(i) {
+				t.Fatalf("document %d: expression %d mismatched after round trip", docIdx, i)
+			}
+		}
+	}
+
+	return newDef
+}
+
+func TestMatchDefBinaryRoundTrip(t *testing.T) {
+	exprs := getBinaryTestExprs()
+
+	for _, expr := range exprs {
+		var trans Transformer
+		tRoundTripMatchDef(t, trans.Transform([]Expression{expr}))
+	}
+
+	var trans Transformer
+	tRoundTripMatchDef(t, trans.Transform(exprs))
+}
+
+func TestMatchDefBinaryIncremental(t *testing.T) {
+	def := NewIncrementalDef()
+	for _, expr := range getBinaryTestExprs() {
+		def, _, _ = def.Add(expr)
+	}
+	ids := def.IDs()
+	def, _ = def.Remove(ids[2])
+
+	tRoundTripMatchDef(t, def.MatchDef())
+}
+
+func TestMatchDefBinaryInvalid(t *testing.T) {
+	var trans Transformer
+	def := trans.Transform(getBinaryTestExprs())
+
+	data, err := def.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal definition: %s", err)
+	}
+
+	// Every truncation of a valid encoding must fail cleanly
+	for i := 0; i < len(data); i++ {
+		_, err := UnmarshalMatchDef(data[:i])
+		if err == nil {
+			t.Fatalf("expected truncation at %d to fail", i)
+		}
+	}
+
+	_, err = UnmarshalMatchDef(append(data, 0))
+	if err == nil {
+		t.Errorf("expected trailing data to fail")
+	}
+
+	badVersion := append([]byte{}, data...)
+	badVersion[len(matchDefMagic)] = matchDefVersion + 1
+	_, err = UnmarshalMatchDef(badVersion)
+	if err == nil {
+		t.Errorf("expected an unknown version to fail")
+	}
+
+	// Corrupting individual bytes must never cause a panic
+	for i := len(matchDefMagic) + 1; i < len(data); i++ {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0xff
+		UnmarshalMatchDef(corrupt)
+	}
+}
+
+func tFindOp(node *ExecNode) *OpNode {
+	if node == nil {
+		return nil
+	}
+	if len(node.Ops) > 0 {
+		return &node.Ops[0]
+	}
+	for _, elem := range node.Elems {
+		if op := tFindOp(elem); op != nil {
+			return op
+		}
+	}
+	for _, loop := range node.Loops {
+		if op := tFindOp(loop.Node); op != nil {
+			return op
+		}
+	}
+	return nil
+}
+
+func tFindLoop(node *ExecNode) *LoopNode {
+	if node == nil {
+		return nil
+	}
+	if len(node.Loops) > 0 {
+		return &node.Loops[0]
+	}
+	for _, elem := range node.Elems {
+		if loop := tFindLoop(elem); loop != nil {
+			return loop
+		}
+	}
+	return nil
+}
+
+func tUnmarshalCorruptDef(t *testing.T, data string, corrupt func(def *MatchDef)) error {
+	t.Helper()
+
+	expr, err := ParseJsonExpression([]byte(data))
+	if err != nil {
+		t.Fatalf("failed to parse expression: %s", err)
+	}
+
+	var trans Transformer
+	def := trans.Transform([]Expression{expr})
+	corrupt(def)
+
+	encoded, err := def.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal definition: %s", err)
+	}
+
+	_, err = UnmarshalMatchDef(encoded)
+	return err
+}
+
+func TestMatchDefBinaryCorruptOps(t *testing.T) {
+	equalsExpr := `["equals", ["field", "age"], ["value", 30]]`
+	funcExpr := `["equals", ["func", "mathAtan2", ["field", "age"], ["value", 2]], ["value", 1]]`
+	loopExpr := `["anyin", 1, ["field", "friends"], ["equals", ["field", 1, "name"], ["value", "Neil"]]]`
+	inExpr := `["in", ["field", "eyeColor"], ["value", "blue"], ["value", "brown"]]`
+	betweenExpr := `["between", ["field", "age"], ["value", 20], ["value", 30]]`
+	andExpr := `["and", ["equals", ["field", "age"], ["value", 30]], ["equals", ["field", "name"], ["value", "Neil"]]]`
+	valueSet := NewValueSetRef([]FastVal{NewStringFastVal("blue")})
+	valueRange := RangeRef{NewIntFastVal(20), NewIntFastVal(30)}
+
+	testCases := []struct {
+		name    string
+		expr    string
+		corrupt func(def *MatchDef)
+	}{
+		{"in op without a value set", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Op = OpTypeIn
+		}},
+		{"between op without a range", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Op = OpTypeBetween
+		}},
+		{"unknown op type", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Op = OpType(99)
+		}},
+		{"negative op type", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Op = OpType(-1)
+		}},
+		{"unknown function", funcExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			fn := op.Lhs.(FuncRef)
+			fn.FuncName = "mathBogus"
+			op.Lhs = fn
+		}},
+		{"missing function parameters", funcExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			fn := op.Lhs.(FuncRef)
+			fn.Params = fn.Params[:1]
+			op.Lhs = fn
+		}},
+		{"unknown loop type", loopExpr, func(def *MatchDef) {
+			tFindLoop(def.ParseNode).Mode = LoopType(99)
+		}},
+		{"value set on the left of an in op", inExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			op.Lhs = op.Rhs
+		}},
+		{"value set outside of an in op", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Rhs = valueSet
+		}},
+		{"value set as a function parameter", funcExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			fn := op.Lhs.(FuncRef)
+			fn.Params = []DataRef{fn.Params[0], valueSet}
+			op.Lhs = fn
+		}},
+		{"range on the left of a between op", betweenExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			op.Lhs = op.Rhs
+		}},
+		{"range outside of a between op", equalsExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Rhs = valueRange
+		}},
+		{"range within a range", betweenExpr, func(def *MatchDef) {
+			tFindOp(def.ParseNode).Rhs = RangeRef{valueRange, NewIntFastVal(30)}
+		}},
+		{"range as a function parameter", funcExpr, func(def *MatchDef) {
+			op := tFindOp(def.ParseNode)
+			fn := op.Lhs.(FuncRef)
+			fn.Params = []DataRef{fn.Params[0], valueRange}
+			op.Lhs = fn
+		}},
+		{"loop with a target", loopExpr, func(def *MatchDef) {
+			tFindLoop(def.ParseNode).Target = SlotRef{1}
+		}},
+		{"tree child not pointing at its parent", andExpr, func(def *MatchDef) {
+			def.MatchTree.data[def.MatchTree.data[0].Right].ParentIdx = def.MatchTree.data[0].Left
+		}},
+		{"tree node reached twice", andExpr, func(def *MatchDef) {
+			def.MatchTree.data[0].Right = def.MatchTree.data[0].Left
+		}},
+		{"tree node not reachable", andExpr, func(def *MatchDef) {
+			def.MatchTree.data = append(def.MatchTree.data, *NewBinTreeNode(nodeTypeLeaf, 0, 0, 0))
+		}},
+		{"empty tree", equalsExpr, func(def *MatchDef) {
+			def.MatchTree.data = nil
+			def.NumBuckets = 0
+		}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := tUnmarshalCorruptDef(t, test.expr, func(def *MatchDef) {})
+			if err != nil {
+				t.Fatalf("failed to unmarshal valid definition: %s", err)
+			}
+
+			err = tUnmarshalCorruptDef(t, test.expr, test.corrupt)
+			if err == nil {
+				t.Fatalf("expected corrupt definition to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+//go:build go1.18
+// +build go1.18
+
+package gojsonsm
+
+import (
+	"testing"
+)
+
+func FuzzFastMatcherMatch(f *testing.F) {
+	for _, doc := range getTestPeopleDocs()[:10] {
+		f.Add(doc)
+	}
+	f.Add([]byte(`{"name":"Frank","tags":["jewels"],"friends":[{"id":1}]}`))
+	f.Add([]byte(`{"name":`))
+	f.Add([]byte(`[1,2,{"a":[`))
+
+	defs := getMalformedTestMatchDefs()
+
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		for _, def := range defs {
+			tMatchNoPanic(t, def, doc)
+		}
+	})
+}
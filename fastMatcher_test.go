@@ -354,3 +354,303 @@ func TestMatcherEqualsFunc(t *testing.T) {
 		"5b47eb093771f06ced629663",
 	})
 }
+
+func TestMatcherConstantFuncs(t *testing.T) {
+	runJSONExprMatchTest(t, `
+	["and",
+		["greaterthan",
+			["field", "age"],
+			["func", "mathMultiply", ["func", "mathE"], ["value", 10]]
+		],
+		["lessthan",
+			["field", "age"],
+			["func", "mathMultiply", ["func", "mathPi"], ["value", 10]]
+		]
+	]
+	`, []string{
+		"5b47eb0936ff92a567a0307e",
+		"5b47eb093771f06ced629663",
+		"5b47eb09ffac5a6ce37042e7",
+		"5b47eb095c3ad73b9925f7f8",
+	})
+}
+
+func TestMatcherInvalidOps(t *testing.T) {
+	expr, err := ParseJsonExpression([]byte(`["equals", ["field", "eyeColor"], ["value", "blue"]]`))
+	if err != nil {
+		t.Fatalf("failed to parse expression: %s", err)
+	}
+
+	// Definitions built by hand are not validated, so the matcher must
+	// reject ops which it cannot perform rather than panic.
+	var trans Transformer
+	def := trans.Transform([]Expression{expr})
+	def.ParseNode.Elems["eyeColor"].Ops[0].Op = OpTypeIn
+
+	m := NewFastMatcher(def)
+	_, err = m.Match(getTestPeopleDocs()[0])
+	if err == nil {
+		t.Fatalf("expected an in op without a value set to fail")
+	}
+
+	def.ParseNode.Elems["eyeColor"].Ops[0].Op = OpTypeBetween
+
+	m = NewFastMatcher(def)
+	_, err = m.Match(getTestPeopleDocs()[0])
+	if err == nil {
+		t.Fatalf("expected a between op without a range to fail")
+	}
+}
+
+func getMalformedTestMatchDefs() []*MatchDef {
+	exprs := []string{
+		`["equals", ["field", "name"], ["value", "Frank"]]`,
+		`["anyin", 1, ["field", "tags"], ["equals", ["field", 1], ["value", "jewels"]]]`,
+		`["everyin", 1, ["field", "friends"], ["lessthan", ["field", 1, "id"], ["value", 3]]]`,
+		`["anyin", 1, ["field", "friends"], ["equals", ["field", 1, "id"], ["field", "index"]]]`,
+		`["not", ["exists", ["field", "company"]]]`,
+	}
+
+	var defs []*MatchDef
+	for _, data := range exprs {
+		expr, err := ParseJsonExpression([]byte(data))
+		if err != nil {
+			panic(err)
+		}
+
+		var trans Transformer
+		defs = append(defs, trans.Transform([]Expression{expr}))
+	}
+	return defs
+}
+
+func tMatchNoPanic(t *testing.T, def *MatchDef, doc []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Match panicked on `%s`: %v", doc, r)
+		}
+	}()
+
+	for _, strict := range []bool{false, true} {
+		m := NewFastMatcher(def)
+		m.SetStrictMode(strict)
+		_, err := m.Match(doc)
+		if err != nil {
+			if _, ok := err.(*MatchError); !ok {
+				t.Fatalf("expected a *MatchError for `%s`, got %T: %s", doc, err, err)
+			}
+		}
+	}
+}
+
+func TestMatcherMalformedJSON(t *testing.T) {
+	tests := []struct {
+		doc      string
+		offset   int
+		token    string
+		expected string
+	}{
+		{`{"name" "Frank"}`, 15, "string", "object_key_delim"},
+		{`{"name":"Bob" "age":4}`, 19, "string", "list_delim"},
+		{`{"name":"Bob",4:4}`, 15, "integer", "string"},
+		{`{"name":"Bob","tags":[1 2]}`, 25, "integer", "list_delim"},
+		{`{"name":"Bob","tags":[1,2`, 25, "end", "list_delim"},
+		{`{"name":`, 8, "end", "value"},
+		{`{"other":{"a":[1,{`, 18, "end", "end of value"},
+	}
+
+	def := getMalformedTestMatchDefs()[1]
+	for _, test := range tests {
+		m := NewFastMatcher(def)
+		_, err := m.Match([]byte(test.doc))
+		matchErr, ok := err.(*MatchError)
+		if !ok {
+			t.Errorf("expected a *MatchError for `%s`, got %T: %v", test.doc, err, err)
+			continue
+		}
+
+		if matchErr.Offset != test.offset || matchErr.Token != test.token || matchErr.Expected != test.expected {
+			t.Errorf("unexpected error for `%s`: %+v", test.doc, matchErr)
+		}
+	}
+
+	m := NewFastMatcher(def)
+	_, err := m.Match([]byte(`{"name":"Bo\x01b"}`))
+	matchErr, ok := err.(*MatchError)
+	if !ok || matchErr.Reason == "" {
+		t.Errorf("expected a tokenizer *MatchError, got %T: %v", err, err)
+	}
+}
+
+func TestMatcherCorruptDocsNeverPanic(t *testing.T) {
+	defs := getMalformedTestMatchDefs()
+	docs := getTestPeopleDocs()
+
+	for _, def := range defs {
+		for _, doc := range docs[:3] {
+			// Every truncation of the document
+			for i := 0; i < len(doc); i++ {
+				tMatchNoPanic(t, def, doc[:i])
+			}
+
+			// Every structural character replaced with another
+			corrupt := make([]byte, len(doc))
+			for i := 0; i < len(doc); i++ {
+				for _, c := range []byte(`{}[]:,"`) {
+					if doc[i] == c {
+						continue
+					}
+					copy(corrupt, doc)
+					corrupt[i] = c
+					tMatchNoPanic(t, def, corrupt)
+				}
+			}
+		}
+	}
+}
+
+func TestMatcherStrictMode(t *testing.T) {
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name":"Frank","tags":["jewels"]}`, true},
+		{`{"tags":["jewels"],"friends":[{"id":1},{"id":2}]}`, true},
+		{`{"tags":["jewels"]} trailing`, false},
+		{`{"tags":["jewels"]}{}`, false},
+		{`{"tags":["jewels"],"tags":[]}`, false},
+		{`{"tags":["jewels"],"other":[1,]}`, false},
+		{`{"tags":["jewels"],"other":"\ud800"}`, false},
+		{"{\"tags\":[\"jewels\"],\"other\":\"\xff\"}", false},
+		{``, false},
+	}
+
+	def := getMalformedTestMatchDefs()[1]
+	for _, test := range tests {
+		m := NewFastMatcher(def)
+		m.SetStrictMode(true)
+		_, err := m.Match([]byte(test.doc))
+		if test.valid && err != nil {
+			t.Errorf("expected `%s` to be valid, got: %s", test.doc, err)
+		} else if !test.valid {
+			if _, ok := err.(*MatchError); !ok {
+				t.Errorf("expected a *MatchError for `%s`, got %T: %v", test.doc, err, err)
+			}
+		}
+	}
+
+	// Without strict mode, the first match ends the scan early
+	m := NewFastMatcher(def)
+	matched, err := m.Match([]byte(`{"tags":["jewels"],"tags":[]} trailing`))
+	if !matched || err != nil {
+		t.Errorf("expected non-strict match to succeed, got %t, %v", matched, err)
+	}
+}
+
+func TestMatcherNegativeArrayIndex(t *testing.T) {
+	runJSONExprMatchTest(t, `
+		["equals",
+			["field", "testArray", "[-1]"],
+			["value", "jewels"]
+		]
+	`, []string{
+		"5b47eb0936ff92a567a0307e",
+		"5b47eb093771f06ced629663",
+	})
+
+	runJSONExprMatchTest(t, `
+		["and",
+			["equals",
+				["field", "testArray", "[0]"],
+				["value", "joseph"]
+			],
+			["equals",
+				["field", "testArray", "[-2]"],
+				["value", "jewels"]
+			]
+		]
+	`, []string{
+		"5b47eb093771f06ced629663",
+	})
+
+	runJSONExprMatchTest(t, `
+		["equals",
+			["field", "testArray", "[-1]"],
+			["field", "testArray", "[0]"]
+		]
+	`, []string{
+		"5b47eb0936ff92a567a0307e",
+		"5b47eb096b1d911c0b9492fb",
+	})
+
+	runJSONExprMatchTest(t, `
+		["equals",
+			["field", "friends", "[-1]", "id"],
+			["field", "index"]
+		]
+	`, []string{
+		"5b47eb0950e9076fc0aecd52",
+	})
+
+	runJSONExprMatchTest(t, `
+		["exists",
+			["field", "tags", "[-8]"]
+		]
+	`, []string{})
+}
+
+func TestMatcherArraySliceLoop(t *testing.T) {
+	runJSONExprMatchTest(t, `
+		["anyin",
+			1,
+			["field", "testArray", "[1:3]"],
+			["equals",
+				["field", 1],
+				["value", "jewels"]
+			]
+		]
+	`, []string{
+		"5b47eb0936ff92a567a0307e",
+		"5b47eb0950e9076fc0aecd52",
+		"5b47eb093771f06ced629663",
+	})
+
+	runJSONExprMatchTest(t, `
+		["everyin",
+			1,
+			["field", "testArray", "[-2:]"],
+			["equals",
+				["field", 1],
+				["value", "jewels"]
+			]
+		]
+	`, []string{
+		"5b47eb0936ff92a567a0307e",
+		"5b47eb093771f06ced629663",
+		"5b47eb09ffac5a6ce37042e7",
+	})
+
+	runJSONExprMatchTest(t, `
+		["anyin",
+			1,
+			["field", "testArray", "[:-3]"],
+			["equals",
+				["field", 1],
+				["value", "joseph"]
+			]
+		]
+	`, []string{
+		"5b47eb096b1d911c0b9492fb",
+		"5b47eb093771f06ced629663",
+	})
+
+	expr, err := ParseJsonExpression([]byte(`["equals", ["field", "testArray", "[1:3]"], ["value", "jewels"]]`))
+	if err != nil {
+		t.Fatalf("failed to parse expression: %s", err)
+	}
+	var trans Transformer
+	_, err = trans.TransformE([]Expression{expr})
+	if err == nil {
+		t.Errorf("expected a slice which is not looped over to fail to compile")
+	}
+}
@@ -43,6 +43,7 @@ const (
 	BinaryValue
 	RegexValue
 	PcreValue
+	LikeValue
 )
 
 // Implicit Conversion Table
@@ -87,8 +88,6 @@ var FalseValueBytes = []byte(FalseString)
 var TrueStringRegex *regexp.Regexp = regexp.MustCompile("^[T|t][R|r][U|u][E|e]$")
 var FalseStringRegex *regexp.Regexp = regexp.MustCompile("^[F|f][A|a][L|l][S|s][E|e]$")
 
-var toJsonStringBuffer []byte
-
 type FastVal struct {
 	dataType    ValueType
 	data        interface{}
@@ -139,6 +138,8 @@ func (val FastVal) String() string {
 		return val.GetTime().String()
 	case RegexValue:
 		return "(regexp)" + val.data.(*regexp.Regexp).String()
+	case LikeValue:
+		return "(like)" + val.data.(*LikeMatcher).String()
 	}
 
 	panic(fmt.Sprintf("unexpected data type %v", val.dataType))
@@ -388,6 +389,8 @@ func (val FastVal) AsRegex() (FastValRegexIface, bool) {
 		return val.data.(*regexp.Regexp), true
 	case PcreValue:
 		return val.data.(PcreWrapperInterface), true
+	case LikeValue:
+		return val.data.(*LikeMatcher), true
 	}
 	return nil, false
 }
@@ -423,26 +426,23 @@ func (val FastVal) ToBinString() (FastVal, error) {
 	return val, errors.New("invalid type coercion")
 }
 
-// The following reuse an internal buffer so this should be hidden from outside callers
-// Internally, this must be called only once per comparison, and should be used for implicit comversion
-// (i.e. no double implicit conversion to string from the following 3 types)
-func (val FastVal) toJsonStringInternal() (FastVal, error) {
+// The following formats numeric values into the caller provided buffer so this should be hidden
+// from outside callers.  The returned value references buf, so buf must outlive its use, and should
+// be used for implicit comversion (i.e. no double implicit conversion to string from the following 3 types)
+func (val FastVal) toJsonStringInternal(buf []byte) (FastVal, error) {
 	val, err := val.ToJsonString()
 
 	if err != nil {
 		switch val.dataType {
 		case UintValue:
-			toJsonStringBuffer = toJsonStringBuffer[:0]
-			toJsonStringBuffer = strconv.AppendUint(toJsonStringBuffer, val.GetUint(), 10)
-			return NewJsonStringFastVal(toJsonStringBuffer), nil
+			buf = strconv.AppendUint(buf[:0], val.GetUint(), 10)
+			return NewJsonStringFastVal(buf), nil
 		case IntValue:
-			toJsonStringBuffer = toJsonStringBuffer[:0]
-			toJsonStringBuffer = strconv.AppendInt(toJsonStringBuffer, val.GetInt(), 10)
-			return NewJsonStringFastVal(toJsonStringBuffer), nil
+			buf = strconv.AppendInt(buf[:0], val.GetInt(), 10)
+			return NewJsonStringFastVal(buf), nil
 		case FloatValue:
-			toJsonStringBuffer = toJsonStringBuffer[:0]
-			toJsonStringBuffer = strconv.AppendFloat(toJsonStringBuffer, val.GetFloat(), 'E', -1, 64)
-			return NewJsonStringFastVal(toJsonStringBuffer), nil
+			buf = strconv.AppendFloat(buf[:0], val.GetFloat(), 'E', -1, 64)
+			return NewJsonStringFastVal(buf), nil
 		}
 	}
 
@@ -450,7 +450,6 @@ func (val FastVal) toJsonStringInternal() (FastVal, error) {
 }
 
 func (val FastVal) ToJsonString() (FastVal, error) {
-	invalidErr := errors.New("invalid type coercion")
 	switch val.dataType {
 	case StringValue:
 		// TODO: Improve AsJsonString allocations
@@ -467,9 +466,9 @@ func (val FastVal) ToJsonString() (FastVal, error) {
 	case FalseValue:
 		return NewJsonStringFastVal(FalseValueBytes), nil
 	case NullValue:
-		return NewInvalidFastVal(), invalidErr
+		return NewInvalidFastVal(), errors.New("invalid type coercion")
 	}
-	return val, invalidErr
+	return val, errors.New("invalid type coercion")
 }
 
 func (val FastVal) floatToIntOverflows() bool {
@@ -581,8 +580,9 @@ func (val FastVal) compareBoolean(other FastVal) (int, bool) {
 
 func (val FastVal) compareStrings(other FastVal) (int, bool) {
 	if other.IsString() || other.IsNumeric() {
-		escVal, err := val.toJsonStringInternal()
-		escOval, err1 := other.toJsonStringInternal()
+		var valBuf, otherBuf [32]byte
+		escVal, err := val.toJsonStringInternal(valBuf[:])
+		escOval, err1 := other.toJsonStringInternal(otherBuf[:])
 
 		result := strings.Compare(string(escVal.sliceData), string(escOval.sliceData))
 		return result, err == nil && err1 == nil
@@ -904,7 +904,10 @@ func (val FastVal) Equals(other FastVal) (bool, bool) {
 }
 
 func (val FastVal) matchStrings(other FastVal) (bool, bool) {
-	escVal, err := val.toJsonStringInternal()
+	// Only string values are matched, so no buffer is needed for formatting
+	// numbers here.  Passing the data to the regex would otherwise cause any
+	// such buffer to escape to the heap on every match.
+	escVal, err := val.ToJsonString()
 	if err != nil {
 		return false, false
 	}
@@ -964,6 +967,9 @@ func NewFastVal(val interface{}) FastVal {
 		return NewBinaryFastVal(val)
 	case *regexp.Regexp:
 		return NewRegexpFastVal(val)
+	case *LikeMatcher:
+		// Checked first as it also satisfies PcreWrapperInterface
+		return NewLikeFastVal(val)
 	case PcreWrapperInterface:
 		return NewPcreFastVal(val)
 	case *time.Time:
@@ -1096,6 +1102,14 @@ func NewPcreFastVal(value PcreWrapperInterface) FastVal {
 	return val
 }
 
+func NewLikeFastVal(value *LikeMatcher) FastVal {
+	val := FastVal{
+		dataType: LikeValue,
+		data:     value,
+	}
+	return val
+}
+
 func NewTimeFastVal(value *time.Time) FastVal {
 	val := FastVal{
 		dataType: TimeValue,
@@ -0,0 +1,222 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type likeMatchType int
+
+const (
+	likeMatchExact likeMatchType = iota
+	likeMatchPrefix
+	likeMatchSuffix
+	likeMatchContains
+	likeMatchRegex
+)
+
+// Matches a single character of an escaped JSON string, which may itself be
+// written as an escape sequence.
+const likeAnyCharPattern = `(?:\\u[0-9A-Fa-f]{4}|\\.|.)`
+
+// A run of literal text, or one of the % or _ wildcards, within a pattern.
+type likeToken struct {
+	wildcard rune
+	literal  string
+}
+
+// LikeMatcher matches strings against a SQL LIKE pattern, in which % matches
+// any sequence of characters and _ matches any single character.  It works
+// on the escaped bytes of a JSON string, so that values never need to be
+// unescaped.  Patterns whose only wildcards are a leading or trailing % are
+// matched as an exact, prefix, suffix or substring comparison of bytes, and
+// only the others are compiled to a regular expression.
+type LikeMatcher struct {
+	pattern   string
+	escape    string
+	matchType likeMatchType
+	literal   []byte
+	regex     *regexp.Regexp
+}
+
+func parseLikePattern(pattern, escape string) ([]likeToken, error) {
+	escapeRune := utf8.RuneError
+	if escape != "" {
+		var size int
+		escapeRune, size = utf8.DecodeRuneInString(escape)
+		if size != len(escape) {
+			return nil, errors.New("LIKE escape must be a single character")
+		}
+	}
+
+	var tokens []likeToken
+	var literal strings.Builder
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			tokens = append(tokens, likeToken{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	escaped := false
+	for _, r := range pattern {
+		if escaped {
+			literal.WriteRune(r)
+			escaped = false
+		} else if escape != "" && r == escapeRune {
+			escaped = true
+		} else if r == '%' || r == '_' {
+			flushLiteral()
+			if r == '%' && len(tokens) > 0 && tokens[len(tokens)-1].wildcard == '%' {
+				// Consecutive % match the same as a single one
+				continue
+			}
+			tokens = append(tokens, likeToken{wildcard: r})
+		} else {
+			literal.WriteRune(r)
+		}
+	}
+	if escaped {
+		return nil, errors.New("LIKE pattern must not end with its escape character")
+	}
+	flushLiteral()
+
+	return tokens, nil
+}
+
+// escapeLikeLiteral escapes literal text in the same way as the string
+// values which it is compared against.
+func escapeLikeLiteral(literal string) []byte {
+	escVal, _ := NewStringFastVal(literal).ToJsonString()
+	return escVal.sliceData
+}
+
+func NewLikeMatcher(pattern, escape string) (*LikeMatcher, error) {
+	tokens, err := parseLikePattern(pattern, escape)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := &LikeMatcher{
+		pattern: pattern,
+		escape:  escape,
+	}
+
+	inner := tokens
+	leadingAny := len(inner) > 0 && inner[0].wildcard == '%'
+	if leadingAny {
+		inner = inner[1:]
+	}
+	trailingAny := len(inner) > 0 && inner[len(inner)-1].wildcard == '%'
+	if trailingAny {
+		inner = inner[:len(inner)-1]
+	}
+
+	if len(inner) == 0 || len(inner) == 1 && inner[0].wildcard == 0 {
+		if len(inner) == 1 {
+			matcher.literal = escapeLikeLiteral(inner[0].literal)
+		}
+
+		if leadingAny && trailingAny || leadingAny && len(inner) == 0 {
+			matcher.matchType = likeMatchContains
+		} else if leadingAny {
+			matcher.matchType = likeMatchSuffix
+		} else if trailingAny {
+			matcher.matchType = likeMatchPrefix
+		} else {
+			matcher.matchType = likeMatchExact
+		}
+		return matcher, nil
+	}
+
+	var regexStr strings.Builder
+	regexStr.WriteString("^(?s:")
+	for _, token := range tokens {
+		switch token.wildcard {
+		case '%':
+			regexStr.WriteString(".*")
+		case '_':
+			regexStr.WriteString(likeAnyCharPattern)
+		default:
+			regexStr.WriteString(regexp.QuoteMeta(string(escapeLikeLiteral(token.literal))))
+		}
+	}
+	regexStr.WriteString(")$")
+
+	matcher.regex, err = regexp.Compile(regexStr.String())
+	if err != nil {
+		return nil, err
+	}
+	matcher.matchType = likeMatchRegex
+	return matcher, nil
+}
+
+// likeCharBoundary returns whether pos is the start of a character within
+// the escaped JSON string data, rather than part way through an escape
+// sequence.
+func likeCharBoundary(data []byte, pos int) bool {
+	i := 0
+	for i < pos {
+		if data[i] != '\\' {
+			i++
+		} else if i+1 < len(data) && data[i+1] == 'u' {
+			i += 6
+		} else {
+			i += 2
+		}
+	}
+	return i == pos
+}
+
+func (m *LikeMatcher) Match(data []byte) bool {
+	switch m.matchType {
+	case likeMatchExact:
+		return bytes.Equal(data, m.literal)
+	case likeMatchPrefix:
+		return bytes.HasPrefix(data, m.literal)
+	case likeMatchSuffix:
+		return bytes.HasSuffix(data, m.literal) && likeCharBoundary(data, len(data)-len(m.literal))
+	case likeMatchContains:
+		for offset := 0; offset <= len(data); {
+			idx := bytes.Index(data[offset:], m.literal)
+			if idx < 0 {
+				return false
+			}
+			if likeCharBoundary(data, offset+idx) {
+				return true
+			}
+			offset += idx + 1
+		}
+		return false
+	case likeMatchRegex:
+		return m.regex.Match(data)
+	}
+	return false
+}
+
+// usesRegex returns whether the pattern could only be matched by compiling
+// it to a regular expression.
+func (m *LikeMatcher) usesRegex() bool {
+	return m.matchType == likeMatchRegex
+}
+
+func (m *LikeMatcher) Pattern() string {
+	return m.pattern
+}
+
+func (m *LikeMatcher) Escape() string {
+	return m.escape
+}
+
+func (m *LikeMatcher) String() string {
+	if m.escape == "" {
+		return fmt.Sprintf("%q", m.pattern)
+	}
+	return fmt.Sprintf("%q escape %q", m.pattern, m.escape)
+}
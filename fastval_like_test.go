@@ -0,0 +1,79 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLikeMatcher(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		escape    string
+		matchType likeMatchType
+		matches   []string
+		misses    []string
+	}{
+		{"abc", "", likeMatchExact, []string{"abc"}, []string{"abcd", "xabc", "ABC", ""}},
+		{"", "", likeMatchExact, []string{""}, []string{"a"}},
+		{"abc%", "", likeMatchPrefix, []string{"abc", "abcdef"}, []string{"ab", "xabc"}},
+		{"%abc", "", likeMatchSuffix, []string{"abc", "xyzabc"}, []string{"abcx", "ab"}},
+		{"%abc%", "", likeMatchContains, []string{"abc", "xabcx", "abcabc"}, []string{"ab c", "acb"}},
+		{"%%", "", likeMatchContains, []string{"", "anything"}, nil},
+		{"a%c", "", likeMatchRegex, []string{"ac", "abbbc"}, []string{"abcd", "bac"}},
+		{"_b_", "", likeMatchRegex, []string{"abc", "\"b\"", "éb\n"}, []string{"bc", "abcd"}},
+		{"%.com", "", likeMatchSuffix, []string{"a@b.com"}, []string{"a@bxcom"}},
+		{"100!%", "!", likeMatchExact, []string{"100%"}, []string{"100", "1000"}},
+		{"!_%", "!", likeMatchPrefix, []string{"_id", "_"}, []string{"id", "x_id"}},
+		{"%n", "", likeMatchSuffix, []string{"pain"}, []string{"pai\n"}},
+		{"%u0041%", "", likeMatchContains, []string{"xu0041x"}, []string{"\u0001x"}},
+		{"%\"%", "", likeMatchContains, []string{"say \"hi\""}, []string{"say hi"}},
+	}
+
+	for _, test := range tests {
+		matcher, err := NewLikeMatcher(test.pattern, test.escape)
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", test.pattern, err)
+		}
+		assert.Equal(t, test.matchType, matcher.matchType, test.pattern)
+
+		for _, value := range test.matches {
+			escVal, _ := NewStringFastVal(value).ToJsonString()
+			assert.True(t, matcher.Match(escVal.sliceData), "`%s` should match %q", test.pattern, value)
+		}
+		for _, value := range test.misses {
+			escVal, _ := NewStringFastVal(value).ToJsonString()
+			assert.False(t, matcher.Match(escVal.sliceData), "`%s` should not match %q", test.pattern, value)
+		}
+	}
+}
+
+func TestLikeMatcherJsonEscapes(t *testing.T) {
+	assert := assert.New(t)
+
+	// Wildcards match whole escape sequences, and literals never match
+	// part way through one
+	matcher, err := NewLikeMatcher("a_c", "")
+	assert.Nil(err)
+	assert.True(matcher.Match([]byte(`aéc`)))
+	assert.True(matcher.Match([]byte(`a\"c`)))
+
+	matcher, err = NewLikeMatcher("%t", "")
+	assert.Nil(err)
+	assert.False(matcher.Match([]byte(`a\t`)))
+	assert.True(matcher.Match([]byte(`a\\t`)))
+
+	matcher, err = NewLikeMatcher("%00e9%", "")
+	assert.Nil(err)
+	assert.False(matcher.Match([]byte(`é`)))
+	assert.True(matcher.Match([]byte(`é00e9`)))
+}
+
+func TestLikeMatcherErrors(t *testing.T) {
+	_, err := NewLikeMatcher("abc!", "!")
+	assert.NotNil(t, err)
+	_, err = NewLikeMatcher("abc", "!!")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,748 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The filter grammar has no way to express these, so they are reported as
+// errors rather than being written out in a form which would not parse.
+var ErrorFilterNotExpressible error = fmt.Errorf("Error: Expression cannot be expressed as a filter expression")
+
+var filterMathOperators = map[string]string{
+	MathFuncAdd: "+",
+	MathFuncSub: "-",
+	MathFuncMul: "*",
+	MathFuncDiv: "/",
+	MathFuncMod: "%",
+}
+
+var filterFuncNames = map[string]string{
+	MathFuncAbs:     FuncAbs,
+	MathFuncAcos:    FuncAcos,
+	MathFuncAsin:    FuncAsin,
+	MathFuncAtan:    FuncAtan,
+	MathFuncAtan2:   FuncAtan2,
+	MathFuncCeil:    FuncCeil,
+	MathFuncCos:     FuncCos,
+	DateFunc:        FuncDate,
+	MathFuncDegrees: FuncDeg,
+	MathFuncExp:     FuncExp,
+	MathFuncFloor:   FuncFloor,
+	MathFuncLog:     FuncLog,
+	MathFuncLn:      FuncLn,
+	MathFuncPow:     FuncPower,
+	MathFuncRadians: FuncRad,
+	MathFuncSin:     FuncSin,
+	MathFuncTan:     FuncTan,
+	MathFuncRound:   FuncRound,
+	MathFuncSqrt:    FuncSqrt,
+	MathFuncPi:      "PI",
+	MathFuncE:       "E",
+}
+
+var filterFuncArgCounts = map[string]int{
+	MathFuncAtan2: 2,
+	MathFuncPow:   2,
+	MathFuncPi:    0,
+	MathFuncE:     0,
+}
+
+// Words which must be backticked when used as field names to avoid them
+// being read as part of the grammar.
+var filterReservedWords = map[string]bool{
+	"IS":      true,
+	"NULL":    true,
+	"MISSING": true,
+	"PI":      true,
+	"E":       true,
+}
+
+func init() {
+	for _, op := range GojsonsmOperators {
+		for _, word := range strings.Fields(op) {
+			filterReservedWords[word] = true
+		}
+	}
+	for _, name := range filterFuncNames {
+		filterReservedWords[name] = true
+	}
+}
+
+func filterFormatError(expr Expression, reason string) error {
+	return fmt.Errorf("%w: %s (%v)", ErrorFilterNotExpressible, reason, expr)
+}
+
+func isFilterIdent(name string) bool {
+	if name == "" || filterReservedWords[strings.ToUpper(name)] {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func formatFilterField(expr FieldExpr) (string, error) {
+	if expr.Root != 0 {
+		return "", filterFormatError(expr, "variable is not bound by any loop")
+	}
+	if len(expr.Path) == 0 {
+		return "", filterFormatError(expr, "field has no path")
+	}
+
+	var out strings.Builder
+	for i, elem := range expr.Path {
+		if isArrayIndexElem(elem) {
+			if i == 0 {
+				return "", filterFormatError(expr, "field cannot begin with an array index")
+			}
+			out.WriteString(elem)
+			continue
+		}
+
+		if i > 0 {
+			out.WriteByte('.')
+		}
+
+		if i == 0 && elem == OperatorMeta+"()" {
+			out.WriteString(elem)
+		} else if isFilterIdent(elem) {
+			out.WriteString(elem)
+		} else if strings.ContainsRune(elem, '`') {
+			return "", filterFormatError(expr, "field names cannot contain backticks")
+		} else {
+			out.WriteString("`" + elem + "`")
+		}
+	}
+
+	return out.String(), nil
+}
+
+func formatFilterNumber(expr ValueExpr) (string, bool, error) {
+	switch value := expr.Value.(type) {
+	case int:
+		return strconv.FormatInt(int64(value), 10), true, nil
+	case int8:
+		return strconv.FormatInt(int64(value), 10), true, nil
+	case int16:
+		return strconv.FormatInt(int64(value), 10), true, nil
+	case int32:
+		return strconv.FormatInt(int64(value), 10), true, nil
+	case int64:
+		if value == math.MinInt64 {
+			return "", true, filterFormatError(expr, "integer out of range")
+		}
+		return strconv.FormatInt(value, 10), true, nil
+	case uint:
+		return formatFilterNumber(ValueExpr{uint64(value)})
+	case uint8:
+		return strconv.FormatUint(uint64(value), 10), true, nil
+	case uint16:
+		return strconv.FormatUint(uint64(value), 10), true, nil
+	case uint32:
+		return strconv.FormatUint(uint64(value), 10), true, nil
+	case uint64:
+		if value > math.MaxInt64 {
+			return "", true, filterFormatError(expr, "integer out of range")
+		}
+		return strconv.FormatUint(value, 10), true, nil
+	case float32:
+		return formatFilterNumber(ValueExpr{float64(value)})
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return "", true, filterFormatError(expr, "number is not finite")
+		}
+		out := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(out, ".e") {
+			out += ".0"
+		}
+		return out, true, nil
+	}
+
+	return "", false, nil
+}
+
+// formatFilterOperand formats anything which may appear as a function
+// argument or on either side of a comparison.
+func formatFilterOperand(expr Expression) (string, error) {
+	switch expr := expr.(type) {
+	case FieldExpr:
+		return formatFilterField(expr)
+	case ValueExpr:
+		if str, ok := expr.Value.(string); ok {
+			return strconv.Quote(str), nil
+		}
+
+		out, isNumber, err := formatFilterNumber(expr)
+		if isNumber {
+			return out, err
+		}
+		return "", filterFormatError(expr, "unsupported value type")
+	case TimeExpr:
+		timeStr, ok := expr.Time.(string)
+		if !ok {
+			return "", filterFormatError(expr, "time must be a string")
+		}
+		return FuncDate + "(" + strconv.Quote(timeStr) + ")", nil
+	case FuncExpr:
+		return formatFilterFunc(expr)
+	}
+
+	return "", filterFormatError(expr, "unsupported operand")
+}
+
+// formatFilterMathOperand formats one side of a math operation, for which the
+// grammar only allows fields, negated fields and numbers.
+func formatFilterMathOperand(expr Expression) (string, bool, error) {
+	switch expr := expr.(type) {
+	case FieldExpr:
+		out, err := formatFilterField(expr)
+		return out, true, err
+	case FuncExpr:
+		if expr.FuncName == MathFuncNeg && len(expr.Params) == 1 {
+			if field, ok := expr.Params[0].(FieldExpr); ok {
+				out, err := formatFilterField(field)
+				return "-" + out, true, err
+			}
+		}
+	case ValueExpr:
+		out, isNumber, err := formatFilterNumber(expr)
+		if isNumber {
+			return out, false, err
+		}
+	}
+
+	return "", false, filterFormatError(expr, "math operands must be fields or numbers")
+}
+
+func formatFilterFunc(expr FuncExpr) (string, error) {
+	if mathOp, ok := filterMathOperators[expr.FuncName]; ok {
+		if len(expr.Params) != 2 {
+			return "", filterFormatError(expr, "math operators take two operands")
+		}
+
+		lhs, lhsIsField, err := formatFilterMathOperand(expr.Params[0])
+		if err != nil {
+			return "", err
+		}
+
+		rhs, rhsIsField, err := formatFilterMathOperand(expr.Params[1])
+		if err != nil {
+			return "", err
+		}
+
+		if !lhsIsField && !rhsIsField {
+			return "", filterFormatError(expr, "math requires at least one field")
+		}
+
+		return lhs + " " + mathOp + " " + rhs, nil
+	}
+
+	if expr.FuncName == MathFuncNeg {
+		if len(expr.Params) != 1 {
+			return "", filterFormatError(expr, "negation takes one operand")
+		}
+
+		switch param := expr.Params[0].(type) {
+		case FieldExpr:
+			out, err := formatFilterField(param)
+			return "-" + out, err
+		case FuncExpr:
+			if _, ok := filterFuncNames[param.FuncName]; ok {
+				out, err := formatFilterFunc(param)
+				return "-" + out, err
+			}
+		case ValueExpr:
+			out, isNumber, err := formatFilterNumber(param)
+			if isNumber && err == nil && !strings.HasPrefix(out, "-") {
+				return "-" + out, nil
+			}
+		}
+
+		return "", filterFormatError(expr, "unsupported negation")
+	}
+
+	name, ok := filterFuncNames[expr.FuncName]
+	if !ok {
+		return "", filterFormatError(expr, "unsupported function")
+	}
+
+	numArgs, ok := filterFuncArgCounts[expr.FuncName]
+	if !ok {
+		numArgs = 1
+	}
+	if len(expr.Params) != numArgs {
+		return "", filterFormatError(expr, fmt.Sprintf("%s takes %d arguments", name, numArgs))
+	}
+
+	args := make([]string, len(expr.Params))
+	for i, param := range expr.Params {
+		var err error
+		args[i], err = formatFilterOperand(param)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return name + "(" + strings.Join(args, ", ") + ")", nil
+}
+
+// formatFilterComparand formats one side of a comparison, which additionally
+// allows boolean values.
+func formatFilterComparand(expr Expression) (string, error) {
+	if value, ok := expr.(ValueExpr); ok {
+		if boolVal, ok := value.Value.(bool); ok {
+			if boolVal {
+				return OperatorTrue, nil
+			}
+			return OperatorFalse, nil
+		}
+	}
+	return formatFilterOperand(expr)
+}
+
+func isFilterNullValue(expr Expression) bool {
+	valueExpr, ok := expr.(ValueExpr)
+	return ok && valueExpr.Value == nil
+}
+
+func isFilterBoolValue(expr Expression) bool {
+	valueExpr, ok := expr.(ValueExpr)
+	if !ok {
+		return false
+	}
+	_, ok = valueExpr.Value.(bool)
+	return ok
+}
+
+// isFilterValueFirstMath checks for math which begins with a number, which
+// the grammar mistakes for a plain value on the right hand side.
+func isFilterValueFirstMath(expr Expression) bool {
+	funcExpr, ok := expr.(FuncExpr)
+	if !ok || filterMathOperators[funcExpr.FuncName] == "" || len(funcExpr.Params) != 2 {
+		return false
+	}
+	_, ok = funcExpr.Params[0].(ValueExpr)
+	return ok
+}
+
+func isFilterComparisonValid(lhs, rhs Expression) bool {
+	// The grammar reads a leading boolean as a condition of its own
+	return !isFilterBoolValue(lhs) && !isFilterValueFirstMath(rhs)
+}
+
+func formatFilterComparison(op string, lhs, rhs Expression, negate bool) (string, error) {
+	if !isFilterComparisonValid(lhs, rhs) && isFilterComparisonValid(rhs, lhs) {
+		lhs, rhs = rhs, lhs
+		switch op {
+		case OperatorLessThan:
+			op = OperatorGreaterThan
+		case OperatorLessThanEq:
+			op = OperatorGreaterThanEq
+		case OperatorGreaterThan:
+			op = OperatorLessThan
+		case OperatorGreaterThanEq:
+			op = OperatorLessThanEq
+		}
+	}
+
+	if isFilterValueFirstMath(rhs) {
+		mathExpr := rhs.(FuncExpr)
+		if mathExpr.FuncName != MathFuncAdd && mathExpr.FuncName != MathFuncMul {
+			return "", filterFormatError(rhs, "math beginning with a number cannot be on both sides")
+		}
+		rhs = FuncExpr{mathExpr.FuncName, []Expression{mathExpr.Params[1], mathExpr.Params[0]}}
+	}
+
+	if isFilterBoolValue(lhs) {
+		return "", filterFormatError(lhs, "booleans cannot be compared with each other")
+	}
+
+	if isFilterNullValue(lhs) || isFilterNullValue(rhs) {
+		return "", filterFormatError(lhs, "null may only be compared using IS NULL")
+	}
+
+	lhsStr, err := formatFilterComparand(lhs)
+	if err != nil {
+		return "", err
+	}
+
+	rhsStr, err := formatFilterComparand(rhs)
+	if err != nil {
+		return "", err
+	}
+
+	out := lhsStr + " " + op + " " + rhsStr
+	if negate {
+		out = OperatorNot + " " + out
+	}
+	return out, nil
+}
+
+func formatFilterEquality(lhs, rhs Expression, negate bool) (string, error) {
+	if isFilterNullValue(lhs) && !isFilterNullValue(rhs) {
+		lhs, rhs = rhs, lhs
+	}
+
+	if isFilterNullValue(rhs) {
+		lhsStr, err := formatFilterOperand(lhs)
+		if err != nil {
+			return "", err
+		}
+
+		if negate {
+			return lhsStr + " " + OperatorNotNull, nil
+		}
+		return lhsStr + " " + OperatorNull, nil
+	}
+
+	// NotEqualsExpr is defined as the negation of EqualsExpr, so the two can
+	// be swapped freely.
+	if negate {
+		return formatFilterComparison(OperatorNotEquals, lhs, rhs, false)
+	}
+	return formatFilterComparison(OperatorEquals, lhs, rhs, false)
+}
+
+func formatFilterExists(subExpr Expression, exists bool) (string, error) {
+	subStr, err := formatFilterOperand(subExpr)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return subStr + " " + OperatorNotMissing, nil
+	}
+	return subStr + " " + OperatorMissing, nil
+}
+
+func formatFilterRegex(expr LikeExpr, negate bool) (string, error) {
+	var pattern string
+	switch rhs := expr.Rhs.(type) {
+	case RegexExpr:
+		regexStr, ok := rhs.Regex.(string)
+		if !ok {
+			return "", filterFormatError(expr, "regex must be a string")
+		}
+		if tokenIsPcreValueType(regexStr) {
+			return "", filterFormatError(expr, "regex would be read back as pcre")
+		}
+		pattern = regexStr
+	case PcreExpr:
+		pcreStr, ok := rhs.Pcre.(string)
+		if !ok {
+			return "", filterFormatError(expr, "pcre must be a string")
+		}
+		if !tokenIsPcreValueType(pcreStr) {
+			return "", filterFormatError(expr, "pcre would be read back as regex")
+		}
+		pattern = pcreStr
+	default:
+		return "", filterFormatError(expr, "like requires a regex")
+	}
+
+	lhsStr, err := formatFilterOperand(expr.Lhs)
+	if err != nil {
+		return "", err
+	}
+
+	out := FuncRegexp + "(" + lhsStr + ", " + strconv.Quote(pattern) + ")"
+	if negate {
+		out = OperatorNot + " " + out
+	}
+	return out, nil
+}
+
+func formatFilterLike(expr LikeExpr, negate bool) (string, error) {
+	pattern := expr.Rhs.(LikePatternExpr)
+
+	lhsStr, err := formatFilterOperand(expr.Lhs)
+	if err != nil {
+		return "", err
+	}
+
+	op := OperatorLike
+	if negate {
+		op = OperatorNotLike
+	}
+	out := lhsStr + " " + op + " " + strconv.Quote(pattern.Pattern)
+	if pattern.Escape != "" {
+		out += " " + OperatorEscape + " " + strconv.Quote(pattern.Escape)
+	}
+	return out, nil
+}
+
+func formatFilterIn(expr InExpr, negate bool) (string, error) {
+	if len(expr.Values) == 0 {
+		return "", filterFormatError(expr, "in requires at least one value")
+	}
+
+	lhsStr, err := formatFilterOperand(expr.Lhs)
+	if err != nil {
+		return "", err
+	}
+
+	values := make([]string, len(expr.Values))
+	for i, value := range expr.Values {
+		if _, ok := value.(ValueExpr); !ok {
+			return "", filterFormatError(value, "in values must be constants")
+		}
+
+		if isFilterNullValue(value) {
+			values[i] = "NULL"
+			continue
+		}
+
+		values[i], err = formatFilterComparand(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	op := OperatorIn
+	if negate {
+		op = OperatorNotIn
+	}
+	return lhsStr + " " + op + " [" + strings.Join(values, ", ") + "]", nil
+}
+
+func formatFilterBetween(expr BetweenExpr, negate bool) (string, error) {
+	lhsStr, err := formatFilterOperand(expr.Lhs)
+	if err != nil {
+		return "", err
+	}
+
+	bounds := []Expression{expr.Low, expr.High}
+	boundStrs := make([]string, len(bounds))
+	for i, bound := range bounds {
+		if isFilterNullValue(bound) {
+			return "", filterFormatError(bound, "null may only be compared using IS NULL")
+		}
+		if isFilterValueFirstMath(bound) {
+			return "", filterFormatError(bound, "math beginning with a number cannot be a bound")
+		}
+
+		boundStrs[i], err = formatFilterComparand(bound)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	op := OperatorBetween
+	if negate {
+		op = OperatorNotBetween
+	}
+	return lhsStr + " " + op + " " + boundStrs[0] + " " + OperatorAnd + " " + boundStrs[1], nil
+}
+
+// filterRootFieldsVisitor collects the first path element of every document
+// field within an expression.
+type filterRootFieldsVisitor map[string]bool
+
+func (v filterRootFieldsVisitor) Visit(expr Expression) Visitor {
+	if field, ok := expr.(FieldExpr); ok && field.Root == 0 && len(field.Path) > 0 {
+		v[field.Path[0]] = true
+	}
+	return v
+}
+
+// filterLoopVarName returns the name of a loop variable, which is derived
+// from its VariableID.  Underscores are appended where the name would
+// otherwise be read back as a document field used within the loop.
+func filterLoopVarName(varID VariableID, subExpr Expression) string {
+	usedNames := make(filterRootFieldsVisitor)
+	Walk(subExpr, usedNames)
+
+	name := fmt.Sprintf("v%d", varID)
+	for usedNames[name] {
+		name += "_"
+	}
+	return name
+}
+
+// nameFilterLoopVariable rewrites the fields within expr which refer to the
+// variable varID as document fields beginning with name, which is how they
+// are written in the filter grammar.  Loops within expr which bind the same
+// variable are left alone as they shadow it.
+func nameFilterLoopVariable(expr Expression, varID VariableID, name string) Expression {
+	switch expr := expr.(type) {
+	case FieldExpr:
+		if expr.Root != varID {
+			return expr
+		}
+		return FieldExpr{0, append([]string{name}, expr.Path...)}
+	case AnyInExpr:
+		if expr.VarId == varID {
+			return AnyInExpr{expr.VarId, nameFilterLoopVariable(expr.InExpr, varID, name), expr.SubExpr}
+		}
+	case EveryInExpr:
+		if expr.VarId == varID {
+			return EveryInExpr{expr.VarId, nameFilterLoopVariable(expr.InExpr, varID, name), expr.SubExpr}
+		}
+	case AnyEveryInExpr:
+		if expr.VarId == varID {
+			return AnyEveryInExpr{expr.VarId, nameFilterLoopVariable(expr.InExpr, varID, name), expr.SubExpr}
+		}
+	}
+
+	children, _ := exprChildren(expr)
+	if len(children) == 0 {
+		return expr
+	}
+
+	newChildren := make([]Expression, len(children))
+	for i, child := range children {
+		if child != nil {
+			newChildren[i] = nameFilterLoopVariable(child, varID, name)
+		}
+	}
+	return exprWithChildren(expr, newChildren)
+}
+
+func formatFilterLoop(op string, varID VariableID, inExpr, subExpr Expression, negate bool) (string, error) {
+	inField, ok := inExpr.(FieldExpr)
+	if !ok {
+		return "", filterFormatError(inExpr, "loops must be over a field")
+	}
+
+	inStr, err := formatFilterField(inField)
+	if err != nil {
+		return "", err
+	}
+
+	name := filterLoopVarName(varID, subExpr)
+	subStr, err := formatFilterBoolean(nameFilterLoopVariable(subExpr, varID, name), false, false)
+	if err != nil {
+		return "", err
+	}
+
+	out := op + " " + name + " " + OperatorIn + " " + inStr + " " + OperatorSatisfies + " " + subStr + " " + OperatorEnd
+	if negate {
+		out = OperatorNot + " " + out
+	}
+	return out, nil
+}
+
+func formatFilterCondition(expr Expression, negate bool) (string, error) {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		if negate {
+			return OperatorFalse, nil
+		}
+		return OperatorTrue, nil
+	case FalseExpr:
+		if negate {
+			return OperatorTrue, nil
+		}
+		return OperatorFalse, nil
+	case ExistsExpr:
+		return formatFilterExists(expr.SubExpr, !negate)
+	case NotExistsExpr:
+		return formatFilterExists(expr.SubExpr, negate)
+	case EqualsExpr:
+		return formatFilterEquality(expr.Lhs, expr.Rhs, negate)
+	case NotEqualsExpr:
+		return formatFilterEquality(expr.Lhs, expr.Rhs, !negate)
+	case LessThanExpr:
+		return formatFilterComparison(OperatorLessThan, expr.Lhs, expr.Rhs, negate)
+	case LessEqualsExpr:
+		return formatFilterComparison(OperatorLessThanEq, expr.Lhs, expr.Rhs, negate)
+	case GreaterThanExpr:
+		return formatFilterComparison(OperatorGreaterThan, expr.Lhs, expr.Rhs, negate)
+	case GreaterEqualsExpr:
+		return formatFilterComparison(OperatorGreaterThanEq, expr.Lhs, expr.Rhs, negate)
+	case LikeExpr:
+		if _, ok := expr.Rhs.(LikePatternExpr); ok {
+			return formatFilterLike(expr, negate)
+		}
+		return formatFilterRegex(expr, negate)
+	case InExpr:
+		return formatFilterIn(expr, negate)
+	case BetweenExpr:
+		return formatFilterBetween(expr, negate)
+	case AnyInExpr:
+		return formatFilterLoop(OperatorAny, expr.VarId, expr.InExpr, expr.SubExpr, negate)
+	case EveryInExpr:
+		return formatFilterLoop(OperatorEvery, expr.VarId, expr.InExpr, expr.SubExpr, negate)
+	case AnyEveryInExpr:
+		return formatFilterLoop(OperatorAnyEvery, expr.VarId, expr.InExpr, expr.SubExpr, negate)
+	}
+
+	return "", filterFormatError(expr, "unsupported expression")
+}
+
+// formatFilterBoolean formats a boolean expression.  The grammar only allows
+// NOT to be applied to a single condition, so negations are pushed down to
+// the conditions using De Morgan's laws.  inAnd indicates that the output
+// will be joined with AND, requiring an OR to be parenthesized.
+func formatFilterBoolean(expr Expression, negate bool, inAnd bool) (string, error) {
+	var subExprs []Expression
+	var isOr bool
+
+	switch expr := expr.(type) {
+	case NotExpr:
+		return formatFilterBoolean(expr.SubExpr, !negate, inAnd)
+	case AndExpr:
+		subExprs = expr
+		isOr = negate
+	case OrExpr:
+		subExprs = expr
+		isOr = !negate
+	default:
+		return formatFilterCondition(expr, negate)
+	}
+
+	if len(subExprs) == 0 {
+		return "", filterFormatError(expr, "empty and/or")
+	}
+	if len(subExprs) == 1 {
+		return formatFilterBoolean(subExprs[0], negate, inAnd)
+	}
+
+	parts := make([]string, len(subExprs))
+	for i, subExpr := range subExprs {
+		var err error
+		parts[i], err = formatFilterBoolean(subExpr, negate, !isOr)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !isOr {
+		return strings.Join(parts, " "+OperatorAnd+" "), nil
+	}
+
+	out := strings.Join(parts, " "+OperatorOr+" ")
+	if inAnd {
+		out = "(" + out + ")"
+	}
+	return out, nil
+}
+
+// FormatFilterExpression writes an expression out as a canonical filter
+// expression which can be parsed by NewFilterExpressionParser.  Parentheses
+// are only emitted where they are required and field names are only
+// backticked where necessary.  Negations are pushed down to the individual
+// conditions, as the filter grammar only allows NOT to be applied to them.
+//
+// Loops are written using ANY / EVERY ... SATISFIES ... END, with each loop
+// variable named after its VariableID.  Expressions which cannot be
+// represented in the filter grammar, such as comparisons between constants
+// or fields of variables outside of their loop, return an error wrapping
+// ErrorFilterNotExpressible.
+func FormatFilterExpression(expr Expression) (string, error) {
+	if expr == nil {
+		return "", errors.New("cannot format a nil expression")
+	}
+	return formatFilterBoolean(expr, false, false)
+}
@@ -0,0 +1,250 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tFormatFilterRoundTrip(t *testing.T, expr Expression) string {
+	t.Helper()
+
+	formatted, err := FormatFilterExpression(expr)
+	if err != nil {
+		t.Fatalf("failed to format %v: %s", expr, err)
+	}
+
+	_, fe, err := NewFilterExpressionParser(formatted)
+	if err != nil {
+		t.Fatalf("failed to parse formatted `%s`: %s", formatted, err)
+	}
+
+	parsedExpr, err := fe.OutputExpression()
+	if err != nil {
+		t.Fatalf("failed to output formatted `%s`: %s", formatted, err)
+	}
+
+	reformatted, err := FormatFilterExpression(parsedExpr)
+	if err != nil {
+		t.Fatalf("failed to reformat `%s`: %s", formatted, err)
+	}
+	if reformatted != formatted {
+		t.Fatalf("formatting is not stable, `%s` became `%s`", formatted, reformatted)
+	}
+
+	matcher, err := GetFilterExpressionMatcher(formatted)
+	if err != nil {
+		t.Fatalf("failed to get matcher for `%s`: %s", formatted, err)
+	}
+
+	var trans Transformer
+	def, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		t.Fatalf("failed to compile %v: %s", expr, err)
+	}
+	origMatcher := NewFastMatcher(def)
+
+	for docIdx, doc := range getTestPeopleDocs() {
+		matcher.Reset()
+		matched, err := matcher.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match `%s`: %s", formatted, err)
+		}
+
+		origMatcher.Reset()
+		origMatched, err := origMatcher.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match %v: %s", expr, err)
+		}
+
+		if matched != origMatched {
+			t.Fatalf("document %d: `%s` matched %t, original matched %t", docIdx, formatted, matched, origMatched)
+		}
+	}
+
+	return formatted
+}
+
+func tFormatFilterStringsRoundTrip(t *testing.T, filters map[string]string) {
+	t.Helper()
+
+	for filter, expected := range filters {
+		_, fe, err := NewFilterExpressionParser(filter)
+		if err != nil {
+			// Not every test case is valid in the original grammar
+			if expected == "" {
+				continue
+			}
+			t.Fatalf("failed to parse `%s`: %s", filter, err)
+		}
+
+		expr, err := fe.OutputExpression()
+		if err != nil {
+			t.Fatalf("failed to output `%s`: %s", filter, err)
+		}
+
+		formatted := tFormatFilterRoundTrip(t, expr)
+		if expected != "" && formatted != expected {
+			t.Errorf("expected `%s` to format as `%s`, got `%s`", filter, expected, formatted)
+		}
+	}
+}
+
+func TestFormatFilterExpressionRoundTrip(t *testing.T) {
+	filters := map[string]string{
+		"TRUE":                                "TRUE",
+		"NOT NOT NOT TRUE":                    "FALSE",
+		"(TRUE OR FALSE) AND (FALSE OR TRUE)": "(TRUE OR FALSE) AND (FALSE OR TRUE)",
+		"((TRUE AND FALSE) OR (TRUE)) AND age > 20":   "(TRUE AND FALSE OR TRUE) AND age > 20",
+		"name = \"Neil\" OR (age > 30 AND age <= 40)": "name = \"Neil\" OR age > 30 AND age <= 40",
+		"`name` == 'Neil' AND NOT isActive = true":    "name = \"Neil\" AND isActive <> TRUE",
+		"NOT age < 30":                                  "NOT age < 30",
+		"NOT age = 30":                                  "age <> 30",
+		"age != 30":                                     "age <> 30",
+		"company IS NULL OR company IS NOT NULL":        "company IS NULL OR company IS NOT NULL",
+		"sometimesValue IS MISSING":                     "sometimesValue IS MISSING",
+		"EXISTS(sometimesValue)":                        "sometimesValue IS NOT MISSING",
+		"friends[0].name = \"Kim\"":                     "friends[0].name = \"Kim\"",
+		"testArray[-1] = friends[-2].name":              "testArray[-1] = friends[-2].name",
+		"`eye color`.`is.dotted` = 1":                   "`eye color`.`is.dotted` = 1",
+		"`AND` = 1 OR `e` = 2":                          "`AND` = 1 OR `e` = 2",
+		"META().id = \"x\"":                             "META().id = \"x\"",
+		"age * 2 > 60 AND 2 * age < 100":                "age * 2 > 60 AND 2 * age < 100",
+		"-age < -30 AND age % index = 1":                "-age < -30 AND age % index = 1",
+		"ABS(-latitude) > 10.5":                         "ABS(-latitude) > 10.5",
+		"ROUND(latitude) = 37 AND latitude < PI() * 20": "",
+		"POW(ABS(CEIL(PI())),2) <> age":                 "POW(ABS(CEIL(3.141592653589793)), 2) <> age",
+		"-ABS(latitude) < 0":                            "-ABS(latitude) < 0",
+		"DATE(registered) > DATE(\"2015-01-01\")":       "DATE(registered) > DATE(\"2015-01-01\")",
+		"REGEXP_CONTAINS(name, \"^Ne[a|i]l\\\\s\")":     "REGEXP_CONTAINS(name, \"^Ne[a|i]l\\\\s\")",
+		"NOT (REGEXP_CONTAINS(`name`, \"x\"))":          "",
+		"name = \"quote\\\" and\\n newline\"":           "name = \"quote\\\" and\\n newline\"",
+		"balance = 1.0 OR balance = 1e+300":             "balance = 1.0 OR balance = 1e+300",
+		"eyeColor IN ['blue', \"green\"]":               "eyeColor IN [\"blue\", \"green\"]",
+		"NOT age IN [20,30]":                            "age NOT IN [20, 30]",
+		"isActive NOT IN [TRUE, NULL] OR `IN` IN [1]":   "isActive NOT IN [TRUE, NULL] OR `IN` IN [1]",
+		"age BETWEEN 20 AND 30 AND NOT age = 25":        "age BETWEEN 20 AND 30 AND age <> 25",
+		"NOT latitude BETWEEN -age AND age * 2":         "latitude NOT BETWEEN -age AND age * 2",
+		"`BETWEEN` NOT BETWEEN 'ab' AND \"b\"":          "`BETWEEN` NOT BETWEEN \"ab\" AND \"b\"",
+		"registered BETWEEN DATE(\"2014\") AND 2015.5":  "registered BETWEEN DATE(\"2014\") AND 2015.5",
+		"name LIKE 'Ne%' AND NOT name LIKE \"%l\"":      "name LIKE \"Ne%\" AND name NOT LIKE \"%l\"",
+		"`LIKE` LIKE \"a!%\" ESCAPE '!'":                "`LIKE` LIKE \"a!%\" ESCAPE \"!\"",
+		"REGEXP_LIKE(name, \"^N\")":                     "REGEXP_CONTAINS(name, \"^N\")",
+	}
+
+	tFormatFilterStringsRoundTrip(t, filters)
+}
+
+func TestFormatFilterExpressionLoops(t *testing.T) {
+	filters := map[string]string{
+		"ANY f IN friends SATISFIES f.name = 'Kim' END":                                             "ANY v1 IN friends SATISFIES v1.name = \"Kim\" END",
+		"EVERY t IN tags SATISFIES t <> \"ut\" END":                                                 "EVERY v1 IN tags SATISFIES v1 <> \"ut\" END",
+		"ANY AND EVERY x IN testArray SATISFIES x = \"jewels\" END":                                 "ANY AND EVERY v1 IN testArray SATISFIES v1 = \"jewels\" END",
+		"NOT ANY f IN friends SATISFIES f.id > 1 END AND age > 30":                                  "NOT ANY v1 IN friends SATISFIES v1.id > 1 END AND age > 30",
+		"ANY f IN friends SATISFIES f.id = 1 OR f.name = \"x\" END":                                 "ANY v1 IN friends SATISFIES v1.id = 1 OR v1.name = \"x\" END",
+		"ANY f IN friends[1:] SATISFIES f.id = index END":                                           "ANY v1 IN friends[1:] SATISFIES v1.id = index END",
+		"ANY f IN friends SATISFIES f.id = v1 END":                                                  "ANY v1_ IN friends SATISFIES v1_.id = v1 END",
+		"ANY a IN nestedArray SATISFIES ANY b IN a SATISFIES b = \"h\" END END":                     "ANY v2 IN nestedArray SATISFIES ANY v1 IN v2 SATISFIES v1 = \"h\" END END",
+		"EVERY a IN nestedArray SATISFIES a[0] = \"a\" AND ANY b IN a SATISFIES b <> \"a\" END END": "EVERY v2 IN nestedArray SATISFIES v2[0] = \"a\" AND ANY v1 IN v2 SATISFIES v1 <> \"a\" END END",
+	}
+
+	tFormatFilterStringsRoundTrip(t, filters)
+}
+
+func TestFormatFilterExpressionFromAST(t *testing.T) {
+	assert := assert.New(t)
+
+	exprs := map[string]Expression{
+		// Negations are pushed down to the conditions
+		"(NOT age > 20 OR NOT age <= 30) AND name <> \"Neil\"": NotExpr{OrExpr{
+			AndExpr{
+				GreaterThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{20}},
+				LessEqualsExpr{FieldExpr{0, []string{"age"}}, ValueExpr{30}},
+			},
+			EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Neil"}},
+		}},
+		// Booleans and nulls are moved to the right hand side
+		"isActive = TRUE AND company IS NULL": AndExpr{
+			EqualsExpr{ValueExpr{true}, FieldExpr{0, []string{"isActive"}}},
+			EqualsExpr{ValueExpr{nil}, FieldExpr{0, []string{"company"}}},
+		},
+		"age >= TRUE": LessEqualsExpr{ValueExpr{true}, FieldExpr{0, []string{"age"}}},
+		// Nested ORs and ANDs are flattened
+		"age = 1 OR age = 2 OR age = 3": OrExpr{
+			EqualsExpr{FieldExpr{0, []string{"age"}}, ValueExpr{1}},
+			OrExpr{
+				EqualsExpr{FieldExpr{0, []string{"age"}}, ValueExpr{2}},
+				OrExpr{EqualsExpr{FieldExpr{0, []string{"age"}}, ValueExpr{int64(3)}}},
+			},
+		},
+		"company IS MISSING": NotExpr{ExistsExpr{FieldExpr{0, []string{"company"}}}},
+		"DATE(registered) < DATE(\"2016-01-01T00:00:00Z\")": LessThanExpr{
+			FuncExpr{DateFunc, []Expression{FieldExpr{0, []string{"registered"}}}},
+			TimeExpr{"2016-01-01T00:00:00Z"},
+		},
+		"2 * age = index": EqualsExpr{
+			FieldExpr{0, []string{"index"}},
+			FuncExpr{MathFuncMul, []Expression{ValueExpr{2}, FieldExpr{0, []string{"age"}}}},
+		},
+		"3 * index > age * 2": GreaterThanExpr{
+			FuncExpr{MathFuncMul, []Expression{ValueExpr{3}, FieldExpr{0, []string{"index"}}}},
+			FuncExpr{MathFuncMul, []Expression{ValueExpr{2}, FieldExpr{0, []string{"age"}}}},
+		},
+		"age = 30.0":   EqualsExpr{FieldExpr{0, []string{"age"}}, ValueExpr{float64(30)}},
+		"`1st`[2] = 1": EqualsExpr{FieldExpr{0, []string{"1st", "[2]"}}, ValueExpr{uint8(1)}},
+		// Loop variables are named after their ID
+		"NOT EVERY v1 IN tags SATISFIES v1 = \"ut\" END": NotExpr{EveryInExpr{1, FieldExpr{0, []string{"tags"}},
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"ut"}}}},
+		"ANY v2 IN nestedArray SATISFIES v2[0] = \"a\" AND ANY AND EVERY v1 IN v2 SATISFIES v1 <> \"z\" END END": AnyInExpr{
+			2, FieldExpr{0, []string{"nestedArray"}}, AndExpr{
+				EqualsExpr{FieldExpr{2, []string{"[0]"}}, ValueExpr{"a"}},
+				AnyEveryInExpr{1, FieldExpr{2, nil}, NotEqualsExpr{FieldExpr{1, nil}, ValueExpr{"z"}}},
+			},
+		},
+	}
+
+	for expected, expr := range exprs {
+		assert.Equal(expected, tFormatFilterRoundTrip(t, expr))
+	}
+}
+
+func TestFormatFilterExpressionNotExpressible(t *testing.T) {
+	exprs := []Expression{
+		EqualsExpr{FieldExpr{1, nil}, ValueExpr{"x"}},
+		AnyInExpr{1, FieldExpr{0, []string{"tags"}}, EqualsExpr{FieldExpr{2, nil}, ValueExpr{"x"}}},
+		AnyInExpr{1, ValueExpr{"tags"}, EqualsExpr{FieldExpr{1, nil}, ValueExpr{"x"}}},
+		EqualsExpr{FieldExpr{0, []string{"a`b"}}, ValueExpr{1}},
+		EqualsExpr{FieldExpr{0, []string{"[1]"}}, ValueExpr{1}},
+		LessThanExpr{FieldExpr{0, []string{"a"}}, ValueExpr{nil}},
+		EqualsExpr{FuncExpr{MathFuncAdd, []Expression{ValueExpr{1}, ValueExpr{2}}}, ValueExpr{3}},
+		EqualsExpr{FuncExpr{MathFuncAdd, []Expression{
+			FuncExpr{MathFuncMul, []Expression{FieldExpr{0, []string{"a"}}, ValueExpr{2}}},
+			ValueExpr{2},
+		}}, ValueExpr{3}},
+		EqualsExpr{FuncExpr{"unknownFunc", nil}, ValueExpr{3}},
+		EqualsExpr{FieldExpr{0, []string{"a"}}, ValueExpr{uint64(1) << 63}},
+		EqualsExpr{FieldExpr{0, []string{"a"}}, ValueExpr{[]string{"x"}}},
+		LikeExpr{FieldExpr{0, []string{"a"}}, ValueExpr{"x"}},
+		EqualsExpr{ValueExpr{true}, ValueExpr{false}},
+		EqualsExpr{
+			FuncExpr{MathFuncSub, []Expression{ValueExpr{3}, FieldExpr{0, []string{"index"}}}},
+			FuncExpr{MathFuncSub, []Expression{ValueExpr{2}, FieldExpr{0, []string{"age"}}}},
+		},
+		OrExpr{},
+	}
+
+	for _, expr := range exprs {
+		_, err := FormatFilterExpression(expr)
+		if !errors.Is(err, ErrorFilterNotExpressible) {
+			t.Errorf("expected %v to not be expressible, got %v", expr, err)
+		}
+	}
+
+	_, err := FormatFilterExpression(nil)
+	if err == nil {
+		t.Errorf("expected formatting nil to fail")
+	}
+}
@@ -16,19 +16,24 @@ import (
 // InnerAndExpression       = SubExprOrTerm { "AND" SubExprOrTerm }
 // SubExprOrTerm            = "(" InnerExpression ")" | Condition
 // Condition                = ( [ "NOT" ] Condition ) | Operand
-// Operand                  = BooleanExpr | ( LHS ( CheckOp | ( CompareOp RHS) ) )
-// BooleanExpr              = Boolean | BooleanFuncExpr
+// Operand                  = BooleanExpr | ( LHS ( CheckOp | InOp | BetweenOp | LikeOp | ( CompareOp RHS) ) )
+// BooleanExpr              = Boolean | BooleanFuncExpr | CollectionPredicate
 // LHS                      = ConstFuncExpr | Boolean | FieldWithMath | Value
 // RHS                      = ConstFuncExpr | Boolean | Value | FieldWithMath
 // CompareOp                = "=" | "==" | "<>" | "!=" | ">" | ">=" | "<" | "<="
 // CheckOp                  = ( "IS" [ "NOT" ] ( NULL | MISSING ) )
+// InOp                     = [ "NOT" ] "IN" "[" InValue { "," InValue } "]"
+// InValue                  = Boolean | "NULL" | Value
+// BetweenOp                = [ "NOT" ] "BETWEEN" RHS "AND" RHS
+// LikeOp                   = [ "NOT" ] "LIKE" ( @String | @Char ) [ "ESCAPE" ( @String | @Char ) ]
 // FieldWithMath            = FieldWMathType0 | FieldWMathType1
 // FieldWMathType0          = MathValue MathOp Field
 // FieldWMathType1          = Field { MathOp ( MathValue | Field ) }
 // Field                    = { @"-" } OnePath { "." OnePath }
 // OnePath                  = ( PathFuncExpression | StringType ){ ArrayIndex }
 // StringType               = @Ident | @RawString | @Char
-// ArrayIndex               = "[" @Int "]"
+// ArrayIndex               = "[" [ ArrayBound ] [ ":" [ ArrayBound ] ] "]"
+// ArrayBound               = [ "-" ] @Int
 // Value                    = @MathValue | @String
 // ConstFuncExpr            = { @"-" } ( ConstFuncNoArg | ConstFuncOneArg | ConstFuncTwoArgs )
 // ConstFuncNoArg           = ConstFuncNoArgName "(" ")"
@@ -46,8 +51,9 @@ import (
 // OnePathFuncNoArgName     = "META"
 // BooleanFuncExpr          = BooleanFuncTwoArgs | ExistsClause
 // BooleanFuncTwoArgs       = BooleanFuncTwoArgsName "(" ConstFuncArgument "," ConstFuncArgumentRHS ")"
-// BooleanFuncTwoArgsName   = "REGEXP_CONTAINS"
+// BooleanFuncTwoArgsName   = "REGEXP_CONTAINS" | "REGEXP_LIKE"
 // ExistsClause              = ( "EXISTS" "(" Field ")" )
+// CollectionPredicate      = ( "ANY" [ "AND" "EVERY" ] | "EVERY" ) @Ident "IN" Field "SATISFIES" InnerExpression "END"
 
 type FilterExpression struct {
 	FilterExpr *FEInnerExpression `@@`
@@ -246,6 +252,9 @@ type FEOperand struct {
 	LHS         *FELhs         `( @@ (`
 	Op          *FECompareOp   `( @@`
 	RHS         *FERhs         `@@ ) | `
+	InOp        *FEInOp        `@@ | `
+	BetweenOp   *FEBetweenOp   `@@ | `
+	LikeOp      *FELikeOp      `@@ | `
 	CheckOp     *FECheckOp     `@@ ) )`
 }
 
@@ -254,6 +263,12 @@ func (feo *FEOperand) String() string {
 		return feo.BooleanExpr.String()
 	} else if feo.LHS != nil && feo.CheckOp != nil {
 		return fmt.Sprintf("%v %v", feo.LHS.String(), feo.CheckOp.String())
+	} else if feo.LHS != nil && feo.InOp != nil {
+		return fmt.Sprintf("%v %v", feo.LHS.String(), feo.InOp.String())
+	} else if feo.LHS != nil && feo.BetweenOp != nil {
+		return fmt.Sprintf("%v %v", feo.LHS.String(), feo.BetweenOp.String())
+	} else if feo.LHS != nil && feo.LikeOp != nil {
+		return fmt.Sprintf("%v %v", feo.LHS.String(), feo.LikeOp.String())
 	} else if feo.LHS != nil && feo.Op != nil && feo.RHS != nil {
 		return fmt.Sprintf("%v %v %v", feo.LHS.String(), feo.Op.String(), feo.RHS.String())
 	} else {
@@ -273,6 +288,12 @@ func (f *FEOperand) OutputExpression() (Expression, error) {
 		if f.CheckOp != nil {
 			outExpr, err := f.CheckOp.OutputExpression(lhsExpr)
 			return outExpr, err
+		} else if f.InOp != nil {
+			return f.InOp.OutputExpression(lhsExpr)
+		} else if f.BetweenOp != nil {
+			return f.BetweenOp.OutputExpression(lhsExpr)
+		} else if f.LikeOp != nil {
+			return f.LikeOp.OutputExpression(lhsExpr)
 		} else if f.Op != nil && f.RHS != nil {
 			rhsExpr, err := f.RHS.OutputExpression()
 			if err != nil {
@@ -288,8 +309,9 @@ func (f *FEOperand) OutputExpression() (Expression, error) {
 }
 
 type FEBooleanExpr struct {
-	BooleanVal  *FEBoolean         `@@ |`
-	BooleanFunc *FEBooleanFuncExpr `@@`
+	BooleanVal  *FEBoolean             `@@ |`
+	BooleanFunc *FEBooleanFuncExpr     `@@ |`
+	Collection  *FECollectionPredicate `@@`
 }
 
 func (be *FEBooleanExpr) String() string {
@@ -297,6 +319,8 @@ func (be *FEBooleanExpr) String() string {
 		return be.BooleanVal.String()
 	} else if be.BooleanFunc != nil {
 		return be.BooleanFunc.String()
+	} else if be.Collection != nil {
+		return be.Collection.String()
 	} else {
 		return "?? (FEBooleanExpr)"
 	}
@@ -307,6 +331,8 @@ func (f *FEBooleanExpr) OutputExpression() (Expression, error) {
 		return f.BooleanVal.OutputExpression(false /*asValue*/)
 	} else if f.BooleanFunc != nil {
 		return f.BooleanFunc.OutputExpression()
+	} else if f.Collection != nil {
+		return f.Collection.OutputExpression()
 	}
 
 	return nil, fmt.Errorf("Invalid FEBooleanExpr %v", f.String())
@@ -657,6 +683,12 @@ func (feop *FEOnePath) String() string {
 func (f *FEOnePath) OutputOnePath() (string, []string, error) {
 	var arrayIdx []string
 	for _, arr := range f.ArrayIndexes {
+		if arr.Start == nil && !arr.Slice {
+			return "", arrayIdx, fmt.Errorf("Invalid empty array index: %v", f.String())
+		}
+		if !isArrayIndexElem(arr.String()) {
+			return "", arrayIdx, fmt.Errorf("Invalid array index: %v", f.String())
+		}
 		arrayIdx = append(arrayIdx, arr.String())
 	}
 
@@ -669,14 +701,38 @@ func (f *FEOnePath) OutputOnePath() (string, []string, error) {
 	}
 }
 
+// FEArrayIndex is either a single index, which counts back from the end of
+// the array when negative, or a slice such as [1:3] for use with ANY / EVERY
 type FEArrayIndex struct {
-	// For now we are not supporting negative indexes
-	// ArrayIndex string `"[" [ @"-" ] @Int "]"`
-	ArrayIndex string `"[" @Int "]"`
+	Start *FEArrayBound `"[" [ @@ ]`
+	Slice bool          `[ @":"`
+	End   *FEArrayBound `[ @@ ] ] "]"`
 }
 
 func (i *FEArrayIndex) String() string {
-	return fmt.Sprintf("[%v]", i.ArrayIndex)
+	var output string
+	if i.Start != nil {
+		output = i.Start.String()
+	}
+	if i.Slice {
+		output += ":"
+		if i.End != nil {
+			output += i.End.String()
+		}
+	}
+	return fmt.Sprintf("[%v]", output)
+}
+
+type FEArrayBound struct {
+	Neg   *bool  `[ @"-" ]`
+	Value string `@Int`
+}
+
+func (b *FEArrayBound) String() string {
+	if b.Neg != nil && *b.Neg {
+		return "-" + b.Value
+	}
+	return b.Value
 }
 
 type FEOnePathFuncExpr struct {
@@ -1032,6 +1088,183 @@ func (f *FECheckOp) OutputExpression(subExpr Expression) (Expression, error) {
 	return nil, fmt.Errorf("Invalid FECheckOp %v", f.String())
 }
 
+type FEInOp struct {
+	Not    *bool        `( [ @"NOT" ] "IN"`
+	Values []*FEInValue `"[" @@ { "," @@ } "]" )`
+}
+
+func (f *FEInOp) isNot() bool {
+	return f.Not != nil && *f.Not == true
+}
+
+func (f *FEInOp) String() string {
+	var values []string
+	for _, value := range f.Values {
+		values = append(values, value.String())
+	}
+
+	op := OperatorIn
+	if f.isNot() {
+		op = OperatorNotIn
+	}
+	return fmt.Sprintf("%v [ %v ]", op, strings.Join(values, " , "))
+}
+
+func (f *FEInOp) OutputExpression(lhs Expression) (Expression, error) {
+	if len(f.Values) == 0 {
+		return nil, fmt.Errorf("Invalid FEInOp %v", f.String())
+	}
+
+	outExpr := InExpr{
+		Lhs: lhs,
+	}
+	for _, value := range f.Values {
+		valueExpr, err := value.OutputExpression()
+		if err != nil {
+			return nil, err
+		}
+		outExpr.Values = append(outExpr.Values, valueExpr)
+	}
+
+	if f.isNot() {
+		return NotExpr{outExpr}, nil
+	}
+	return outExpr, nil
+}
+
+type FEBetweenOp struct {
+	Not  *bool  `( [ @"NOT" ] "BETWEEN"`
+	Low  *FERhs `@@ "AND"`
+	High *FERhs `@@ )`
+}
+
+func (f *FEBetweenOp) isNot() bool {
+	return f.Not != nil && *f.Not == true
+}
+
+func (f *FEBetweenOp) String() string {
+	if f.Low == nil || f.High == nil {
+		return "?? (FEBetweenOp)"
+	}
+
+	op := OperatorBetween
+	if f.isNot() {
+		op = OperatorNotBetween
+	}
+	return fmt.Sprintf("%v %v %v %v", op, f.Low.String(), OperatorAnd, f.High.String())
+}
+
+func (f *FEBetweenOp) OutputExpression(lhs Expression) (Expression, error) {
+	if f.Low == nil || f.High == nil {
+		return nil, fmt.Errorf("Invalid FEBetweenOp %v", f.String())
+	}
+
+	lowExpr, err := f.Low.OutputExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	highExpr, err := f.High.OutputExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	outExpr := BetweenExpr{
+		Lhs:  lhs,
+		Low:  lowExpr,
+		High: highExpr,
+	}
+
+	if f.isNot() {
+		return NotExpr{outExpr}, nil
+	}
+	return outExpr, nil
+}
+
+type FELikeOp struct {
+	Not     *bool   `( [ @"NOT" ] "LIKE"`
+	Pattern *string `( @String | @Char )`
+	Escape  *string `[ "ESCAPE" ( @String | @Char ) ] )`
+}
+
+func (f *FELikeOp) isNot() bool {
+	return f.Not != nil && *f.Not == true
+}
+
+func (f *FELikeOp) String() string {
+	if f.Pattern == nil {
+		return "?? (FELikeOp)"
+	}
+
+	op := OperatorLike
+	if f.isNot() {
+		op = OperatorNotLike
+	}
+	if f.Escape != nil {
+		return fmt.Sprintf("%v %v %v %v", op, *f.Pattern, OperatorEscape, *f.Escape)
+	}
+	return fmt.Sprintf("%v %v", op, *f.Pattern)
+}
+
+func (f *FELikeOp) OutputExpression(lhs Expression) (Expression, error) {
+	if f.Pattern == nil {
+		return nil, fmt.Errorf("Invalid FELikeOp %v", f.String())
+	}
+
+	pattern := LikePatternExpr{
+		Pattern: *f.Pattern,
+	}
+	if f.Escape != nil {
+		pattern.Escape = *f.Escape
+	}
+
+	// Catch bad patterns here rather than when the expression is compiled
+	_, err := NewLikeMatcher(pattern.Pattern, pattern.Escape)
+	if err != nil {
+		return nil, err
+	}
+
+	outExpr := LikeExpr{
+		Lhs: lhs,
+		Rhs: pattern,
+	}
+
+	if f.isNot() {
+		return NotExpr{outExpr}, nil
+	}
+	return outExpr, nil
+}
+
+type FEInValue struct {
+	Bool  *FEBoolean `@@ |`
+	Null  *bool      `@"NULL" |`
+	Value *FEValue   `@@`
+}
+
+func (f *FEInValue) String() string {
+	if f.Bool != nil {
+		return f.Bool.String()
+	} else if f.Null != nil {
+		return "NULL"
+	} else if f.Value != nil {
+		return f.Value.String()
+	} else {
+		return "?? (FEInValue)"
+	}
+}
+
+func (f *FEInValue) OutputExpression() (Expression, error) {
+	if f.Bool != nil {
+		return f.Bool.OutputExpression(true /*asValue*/)
+	} else if f.Null != nil {
+		return ValueExpr{nil}, nil
+	} else if f.Value != nil {
+		return f.Value.OutputExpression()
+	} else {
+		return nil, fmt.Errorf("Invalid FEInValue %v", f.String())
+	}
+}
+
 // Technically we could have an slice of arguments, but having OneArg vs NoArg vs TwoArg could
 // allow us to do more strict function check (i.e. certain funcs should only allow one argument, etc, at this level)
 type FEConstFuncExpression struct {
@@ -1428,8 +1661,7 @@ func (a *FEBooleanFuncTwoArgs) String() string {
 }
 
 func (f *FEBooleanFuncTwoArgs) OutputExpression() (Expression, error) {
-	if f.BooleanFuncTwoArgsName != nil && f.BooleanFuncTwoArgsName.RegexContains != nil && *f.BooleanFuncTwoArgsName.RegexContains &&
-		f.Argument0 != nil && f.Argument1 != nil {
+	if f.BooleanFuncTwoArgsName != nil && f.BooleanFuncTwoArgsName.isRegex() && f.Argument0 != nil && f.Argument1 != nil {
 		outputExpr, err := f.BooleanFuncTwoArgsName.OutputExpression()
 		if err != nil {
 			return nil, err
@@ -1454,20 +1686,29 @@ func (f *FEBooleanFuncTwoArgs) OutputExpression() (Expression, error) {
 	}
 }
 
+// REGEXP_LIKE is the same as REGEXP_CONTAINS, and is kept for those used to
+// LIKE meaning a regular expression
 type FEBooleanFuncTwoArgsName struct {
-	RegexContains *bool `@"REGEXP_CONTAINS"`
+	RegexContains *bool `( @"REGEXP_CONTAINS" |`
+	RegexLike     *bool `@"REGEXP_LIKE" )`
+}
+
+func (n *FEBooleanFuncTwoArgsName) isRegex() bool {
+	return n.RegexContains != nil && *n.RegexContains == true || n.RegexLike != nil && *n.RegexLike == true
 }
 
 func (n *FEBooleanFuncTwoArgsName) String() string {
 	if n.RegexContains != nil && *n.RegexContains == true {
 		return FuncRegexp
+	} else if n.RegexLike != nil && *n.RegexLike == true {
+		return FuncRegexpLike
 	} else {
 		return "?? (FEBooleanFuncTwoArgsName)"
 	}
 }
 
 func (n *FEBooleanFuncTwoArgsName) OutputExpression() (Expression, error) {
-	if n.RegexContains != nil && *n.RegexContains == true {
+	if n.isRegex() {
 		return LikeExpr{}, nil
 	} else {
 		return nil, ErrorNotFound
@@ -1500,6 +1741,77 @@ func (f *FEExistsClause) OutputExpression() (Expression, error) {
 	return nil, fmt.Errorf("Invalid FEExistsClause %v", f.String())
 }
 
+// FECollectionPredicate tests the items of an array, each of which is bound
+// to Var while the SATISFIES expression is evaluated
+type FECollectionPredicate struct {
+	Any      *bool              `( ( @"ANY"`
+	AndEvery *bool              `[ "AND" @"EVERY" ] ) |`
+	Every    *bool              `@"EVERY" )`
+	Var      *string            `@Ident "IN"`
+	InExpr   *FEField           `@@ "SATISFIES"`
+	SubExpr  *FEInnerExpression `@@ "END"`
+}
+
+func (f *FECollectionPredicate) isAnyAndEvery() bool {
+	return f.Any != nil && *f.Any == true && f.AndEvery != nil && *f.AndEvery == true
+}
+
+func (f *FECollectionPredicate) isAny() bool {
+	return f.Any != nil && *f.Any == true && !f.isAnyAndEvery()
+}
+
+func (f *FECollectionPredicate) isEvery() bool {
+	return f.Every != nil && *f.Every == true
+}
+
+func (f *FECollectionPredicate) String() string {
+	if f.Var == nil || f.InExpr == nil || f.SubExpr == nil {
+		return "?? (FECollectionPredicate)"
+	}
+
+	var op string
+	if f.isAnyAndEvery() {
+		op = OperatorAnyEvery
+	} else if f.isAny() {
+		op = OperatorAny
+	} else {
+		op = OperatorEvery
+	}
+	return fmt.Sprintf("%v %v %v %v %v %v %v", op, *f.Var, OperatorIn, f.InExpr.String(),
+		OperatorSatisfies, f.SubExpr.String(), OperatorEnd)
+}
+
+func (f *FECollectionPredicate) OutputExpression() (Expression, error) {
+	if f.Var == nil || f.InExpr == nil || f.SubExpr == nil {
+		return nil, fmt.Errorf("Invalid FECollectionPredicate %v", f.String())
+	}
+
+	inExpr, err := f.InExpr.OutputExpression()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inExpr.(FieldExpr); !ok {
+		return nil, fmt.Errorf("Invalid FECollectionPredicate - %v is not a field", f.InExpr.String())
+	}
+
+	subExpr, err := f.SubExpr.OutputExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	varId := unusedLoopVariableID(subExpr)
+	subExpr = bindLoopVariable(subExpr, *f.Var, varId)
+
+	if f.isAnyAndEvery() {
+		return AnyEveryInExpr{varId, inExpr, subExpr}, nil
+	} else if f.isAny() {
+		return AnyInExpr{varId, inExpr, subExpr}, nil
+	} else if f.isEvery() {
+		return EveryInExpr{varId, inExpr, subExpr}, nil
+	}
+	return nil, fmt.Errorf("Invalid FECollectionPredicate %v", f.String())
+}
+
 func parserWrapper(parser *participle.Parser, expression string, fe *FilterExpression, err *error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -1539,7 +1851,10 @@ func GetFilterExpressionMatcher(expression string) (Matcher, error) {
 	}
 
 	var trans Transformer
-	matchDef := trans.Transform([]Expression{expr})
+	matchDef, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		return nil, err
+	}
 
 	matcher := NewFastMatcher(matchDef)
 	return matcher, nil
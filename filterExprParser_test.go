@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -566,10 +567,11 @@ OR
 	assert.True(match)
 
 	// path name with leading number must be escaped - TODO this should be documented
-	// We're not supporting neg index as of now
+	// Negative indexes count back from the end of the array
 	fe = &FilterExpression{}
 	err = parser.ParseString("`2DarrayPath`[1][-2] = fieldpath2.path2", fe)
-	assert.NotNil(err)
+	assert.Nil(err)
+	assert.Equal("2DarrayPath [1] [-2]", fe.FilterExpr.Expr[0].Expr[0].Expr.Operand.LHS.FieldWMath.Type1.Field.Path[0].String())
 
 	fe = &FilterExpression{}
 	err = parser.ParseString("`1DarrayPath`[1] = \"arrayVal1\"", fe)
@@ -586,10 +588,10 @@ OR
 	match, err = m.Match(udMarsh)
 	assert.True(match)
 
-	// No negative indexes for now
 	fe = &FilterExpression{}
 	err = parser.ParseString("arrayPath[1].path2.arrayPath3[-10].`multiword array`[20] = fieldpath2.path2", fe)
-	assert.NotNil(err)
+	assert.Nil(err)
+	assert.Equal("arrayPath3 [-10]", fe.FilterExpr.Expr[0].Expr[0].Expr.Operand.LHS.FieldWMath.Type1.Field.Path[2].String())
 
 	fe = &FilterExpression{}
 	err = parser.ParseString("arrayPath[1].path2.arrayPath3[10].`multiword array`[20] = fieldpath2.path2", fe)
@@ -1278,3 +1280,231 @@ func TestEdgyJson(t *testing.T) {
 		m.Reset()
 	}
 }
+
+func TestFilterExpressionIn(t *testing.T) {
+	tests := map[string]string{
+		`eyeColor IN ["blue", "green"]`:     `eyeColor = "blue" OR eyeColor = "green"`,
+		`eyeColor NOT IN ["blue", "green"]`: `eyeColor <> "blue" AND eyeColor <> "green"`,
+		`age IN [20, 30.0, "40"]`:           `age = 20 OR age = 30.0 OR age = "40"`,
+		`isActive IN [TRUE, NULL]`:          `isActive = TRUE OR isActive = NULL`,
+	}
+
+	var trans Transformer
+	for filter, equivalent := range tests {
+		expr := tParseFilterExpression(t, filter)
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", filter, err)
+		}
+
+		assert.Equal(t, 1, strings.Count(def.String(), " in set:"), filter)
+
+		equivExpr := tParseFilterExpression(t, equivalent)
+		equivDef, err := trans.TransformE([]Expression{equivExpr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", equivalent, err)
+		}
+		tCheckSameMatches(t, []Expression{expr}, equivDef, def)
+	}
+}
+
+func TestFilterExpressionBetween(t *testing.T) {
+	tests := map[string]string{
+		`age BETWEEN 25 AND 35`:                      `age >= 25 AND age <= 35`,
+		`age NOT BETWEEN 25 AND 35`:                  `NOT age >= 25 OR NOT age <= 35`,
+		`eyeColor BETWEEN "blue" AND "green"`:        `eyeColor >= "blue" AND eyeColor <= "green"`,
+		`latitude BETWEEN -longitude AND age * 2`:    `latitude >= -longitude AND latitude <= age * 2`,
+		`age BETWEEN 30 AND 20 OR isActive = FALSE`:  `age >= 30 AND age <= 20 OR isActive = FALSE`,
+		`name BETWEEN DATE("2014") AND DATE("2015")`: `name >= DATE("2014") AND name <= DATE("2015")`,
+	}
+
+	var trans Transformer
+	for filter, equivalent := range tests {
+		expr := tParseFilterExpression(t, filter)
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", filter, err)
+		}
+		assert.Equal(t, 1, strings.Count(def.String(), " between range:"), filter)
+
+		equivExpr := tParseFilterExpression(t, equivalent)
+		equivDef, err := trans.TransformE([]Expression{equivExpr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", equivalent, err)
+		}
+		tCheckSameMatches(t, []Expression{expr}, equivDef, def)
+	}
+}
+
+func TestFilterExpressionLike(t *testing.T) {
+	tests := map[string]string{
+		`name LIKE "T%"`:              `REGEXP_CONTAINS(name, "^T")`,
+		`name LIKE "%n"`:              `REGEXP_CONTAINS(name, "n$")`,
+		`name LIKE "%an%"`:            `REGEXP_CONTAINS(name, "an")`,
+		`name LIKE "_a%e%"`:           `REGEXP_CONTAINS(name, "^.a.*e")`,
+		`eyeColor LIKE "blue"`:        `eyeColor = "blue"`,
+		`eyeColor NOT LIKE "%e%"`:     `NOT REGEXP_CONTAINS(eyeColor, "e")`,
+		`name LIKE "%!_%" ESCAPE "!"`: `REGEXP_CONTAINS(name, "_")`,
+		`name LIKE "%"`:               `REGEXP_LIKE(name, "")`,
+	}
+
+	var trans Transformer
+	for filter, equivalent := range tests {
+		expr := tParseFilterExpression(t, filter)
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", filter, err)
+		}
+
+		assert.Equal(t, 1, strings.Count(def.String(), "(like)"), filter)
+
+		equivExpr := tParseFilterExpression(t, equivalent)
+		equivDef, err := trans.TransformE([]Expression{equivExpr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", equivalent, err)
+		}
+		tCheckSameMatches(t, []Expression{expr}, equivDef, def)
+	}
+
+	for _, filter := range []string{
+		`name LIKE "abc!" ESCAPE "!"`,
+		`name LIKE "abc" ESCAPE "!!"`,
+		`name LIKE age`,
+	} {
+		_, err := GetFilterExpressionMatcher(filter)
+		assert.NotNil(t, err, filter)
+	}
+}
+
+func TestFilterExpressionCollectionPredicate(t *testing.T) {
+	friends := FieldExpr{0, []string{"friends"}}
+	friendName := FieldExpr{1, []string{"name"}}
+	tests := map[string]Expression{
+		`ANY f IN friends SATISFIES f.name = "Melva Berry" END`: AnyInExpr{1, friends,
+			EqualsExpr{friendName, ValueExpr{"Melva Berry"}}},
+		`EVERY f IN friends SATISFIES f.id < 3 END AND age > 30`: AndExpr{
+			EveryInExpr{1, friends, LessThanExpr{FieldExpr{1, []string{"id"}}, ValueExpr{float64(3)}}},
+			GreaterThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{float64(30)}},
+		},
+		`NOT ANY AND EVERY t IN tags SATISFIES t = "ut" END`: NotExpr{AnyEveryInExpr{1, FieldExpr{0, []string{"tags"}},
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"ut"}}}},
+		`ANY f IN friends SATISFIES f.name = name OR f.name LIKE "M%" END`: AnyInExpr{1, friends, OrExpr{
+			EqualsExpr{friendName, FieldExpr{0, []string{"name"}}},
+			LikeExpr{friendName, LikePatternExpr{Pattern: "M%"}},
+		}},
+	}
+
+	// The matcher cannot run nested loops over arrays of the document itself,
+	// so these are only checked for being bound correctly
+	nestedTests := map[string]Expression{
+		`ANY t IN tags SATISFIES ANY f IN friends SATISFIES f.name LIKE "%e%" AND t = "ut" END END`: AnyInExpr{2,
+			FieldExpr{0, []string{"tags"}}, AnyInExpr{1, friends, AndExpr{
+				LikeExpr{friendName, LikePatternExpr{Pattern: "%e%"}},
+				EqualsExpr{FieldExpr{2, nil}, ValueExpr{"ut"}},
+			}}},
+		// The innermost loop variable shadows any outer one of the same name
+		`ANY f IN friends SATISFIES ( ANY f IN f.tags SATISFIES f = "x" END ) END`: AnyInExpr{2, friends,
+			AnyInExpr{1, FieldExpr{2, []string{"tags"}}, EqualsExpr{FieldExpr{1, nil}, ValueExpr{"x"}}}},
+	}
+
+	for filter, expected := range nestedTests {
+		assert.Equal(t, expected.String(), tParseFilterExpression(t, filter).String(), filter)
+	}
+
+	var trans Transformer
+	for filter, expected := range tests {
+		expr := tParseFilterExpression(t, filter)
+		assert.Equal(t, expected.String(), expr.String(), filter)
+
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", filter, err)
+		}
+		expectedDef, err := trans.TransformE([]Expression{expected})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", expected, err)
+		}
+		tCheckSameMatches(t, []Expression{expr}, expectedDef, def)
+	}
+
+	for _, filter := range []string{
+		`ANY f IN friends SATISFIES f.id = 1`,
+		`ANY f IN -friends SATISFIES f.id = 1 END`,
+		`EVERY IN friends SATISFIES id = 1 END`,
+	} {
+		_, err := GetFilterExpressionMatcher(filter)
+		assert.NotNil(t, err, filter)
+	}
+}
+
+func TestFilterExpressionArrayIndexes(t *testing.T) {
+	testArray := func(elem string) FieldExpr {
+		return FieldExpr{0, []string{"testArray", elem}}
+	}
+	tests := map[string]Expression{
+		`testArray[-1] = "jewels"`: EqualsExpr{testArray("[-1]"), ValueExpr{"jewels"}},
+		`friends[-1].id = index`: EqualsExpr{FieldExpr{0, []string{"friends", "[-1]", "id"}},
+			FieldExpr{0, []string{"index"}}},
+		`testArray[0] = testArray[-1]`: EqualsExpr{testArray("[0]"), testArray("[-1]")},
+		`ANY t IN testArray[1:3] SATISFIES t = "jewels" END`: AnyInExpr{1, testArray("[1:3]"),
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"jewels"}}},
+		`EVERY t IN testArray[-2:] SATISFIES t = "joseph" END`: EveryInExpr{1, testArray("[-2:]"),
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"joseph"}}},
+		`ANY t IN testArray[:-3] SATISFIES t = "joseph" END`: AnyInExpr{1, testArray("[:-3]"),
+			EqualsExpr{FieldExpr{1, nil}, ValueExpr{"joseph"}}},
+	}
+
+	var trans Transformer
+	for filter, expected := range tests {
+		expr := tParseFilterExpression(t, filter)
+		assert.Equal(t, expected.String(), expr.String(), filter)
+
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", filter, err)
+		}
+		expectedDef, err := trans.TransformE([]Expression{expected})
+		if err != nil {
+			t.Fatalf("failed to compile `%s`: %s", expected, err)
+		}
+		tCheckSameMatches(t, []Expression{expr}, expectedDef, def)
+	}
+
+	for _, filter := range []string{
+		`testArray[] = "jewels"`,
+		`testArray[1:3] = "jewels"`,
+		`testArray[-] = "jewels"`,
+		`testArray[-0] = "jewels"`,
+		`ANY t IN testArray[-0:] SATISFIES t = "jewels" END`,
+	} {
+		_, err := GetFilterExpressionMatcher(filter)
+		assert.NotNil(t, err, filter)
+	}
+}
+
+func TestFilterExpressionBetweenDate(t *testing.T) {
+	assert := assert.New(t)
+
+	expr := tParseFilterExpression(t, `DATE(txDate) BETWEEN DATE("2018-01-01") AND DATE("2018-12-31T23:59:59Z")`)
+
+	var trans Transformer
+	def, err := trans.TransformE([]Expression{expr})
+	assert.Nil(err)
+
+	// The constant dates are parsed when compiling rather than when matching
+	assert.Contains(def.String(), "range:[2018-01-01 00:00:00 +0000 UTC, 2018-12-31 23:59:59 +0000 UTC]")
+
+	m := NewFastMatcher(def)
+	for date, expected := range map[string]bool{
+		"2017-12-31T23:59:59Z": false,
+		"2018-01-01":           true,
+		"2018-12-31T23:59:59Z": true,
+		"2019-01-01":           false,
+		"not a date":           false,
+	} {
+		m.Reset()
+		match, err := m.Match([]byte(`{"txDate":"` + date + `"}`))
+		assert.Nil(err)
+		assert.Equal(expected, match, date)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NamedExpression associates a label with an expression in a FilterSet.
+type NamedExpression struct {
+	Label      string
+	Expression Expression
+}
+
+// FilterSet compiles many labelled expressions into a single MatchDef so that
+// a document can be checked against all of them in a single pass.  Filters
+// keep the order they were provided in, which is also their priority when
+// first-match mode is enabled.  Once created, a FilterSet is only read by its
+// matchers, so matchers may be used on different goroutines.
+type FilterSet struct {
+	labels     []string
+	def        *MatchDef
+	firstMatch bool
+}
+
+// NewFilterSet compiles the named expressions into a FilterSet.  Labels must
+// be unique.
+func NewFilterSet(filters []NamedExpression) (*FilterSet, error) {
+	labels := make([]string, len(filters))
+	exprs := make([]Expression, len(filters))
+	seenLabels := make(map[string]bool, len(filters))
+
+	for i, filter := range filters {
+		if seenLabels[filter.Label] {
+			return nil, fmt.Errorf("duplicate filter label `%s`", filter.Label)
+		}
+		seenLabels[filter.Label] = true
+
+		labels[i] = filter.Label
+		exprs[i] = filter.Expression
+	}
+
+	var def *MatchDef
+	if len(exprs) > 0 {
+		var trans Transformer
+		var err error
+		def, err = trans.TransformE(exprs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FilterSet{
+		labels: labels,
+		def:    def,
+	}, nil
+}
+
+// NewFilterSetFromStrings parses each filter expression string and compiles
+// them into a FilterSet.  As maps are unordered, the filters are ordered by
+// their labels.
+func NewFilterSetFromStrings(filters map[string]string) (*FilterSet, error) {
+	labels := make([]string, 0, len(filters))
+	for label := range filters {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	namedExprs := make([]NamedExpression, len(labels))
+	for i, label := range labels {
+		_, fe, err := NewFilterExpressionParser(filters[label])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse filter `%s`: %s", label, err)
+		}
+
+		expr, err := fe.OutputExpression()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse filter `%s`: %s", label, err)
+		}
+
+		namedExprs[i] = NamedExpression{
+			Label:      label,
+			Expression: expr,
+		}
+	}
+
+	return NewFilterSet(namedExprs)
+}
+
+// SetFirstMatch sets whether matchers subsequently created by NewMatcher
+// start in first-match mode, see FilterSetMatcher.SetFirstMatch.  Matchers
+// which already exist are not affected, and this must not be called while
+// other goroutines may be creating matchers.
+func (fs *FilterSet) SetFirstMatch(firstMatch bool) {
+	fs.firstMatch = firstMatch
+}
+
+// Len returns the number of filters in the set.
+func (fs *FilterSet) Len() int {
+	return len(fs.labels)
+}
+
+// Label returns the label of the filter at the specified index.
+func (fs *FilterSet) Label(idx int) string {
+	return fs.labels[idx]
+}
+
+// NewMatcher creates a matcher for this set.  Matchers hold mutable state and
+// must not be shared between goroutines.
+func (fs *FilterSet) NewMatcher() *FilterSetMatcher {
+	var matcher *FastMatcher
+	if fs.def != nil {
+		matcher = NewFastMatcher(fs.def)
+	}
+
+	return &FilterSetMatcher{
+		set:        fs,
+		matcher:    matcher,
+		matched:    NewBitset(len(fs.labels)),
+		firstMatch: fs.firstMatch,
+	}
+}
+
+// FilterSetMatcher matches documents against all of the filters of a
+// FilterSet at once.
+type FilterSetMatcher struct {
+	set        *FilterSet
+	matcher    *FastMatcher
+	matched    Bitset
+	firstMatch bool
+}
+
+// SetFirstMatch enables or disables first-match mode.  In first-match mode,
+// only the highest priority (earliest) matching filter is reported, which
+// is useful for routing tables.  This only filters the reported result, every
+// filter is still evaluated against the document.
+func (m *FilterSetMatcher) SetFirstMatch(firstMatch bool) {
+	m.firstMatch = firstMatch
+}
+
+// Match matches a document against every filter in the set.  The returned
+// bitset has the bit set for the index of each matching filter.  It is
+// owned by the matcher and is overwritten by the next call to Match.
+func (m *FilterSetMatcher) Match(data []byte) (Bitset, error) {
+	m.matched.Reset()
+
+	if m.matcher == nil {
+		return m.matched, nil
+	}
+
+	m.matcher.Reset()
+	_, err := m.matcher.Match(data)
+	if err != nil {
+		return m.matched, err
+	}
+
+	for i := range m.set.labels {
+		if m.matcher.ExpressionMatched(i) {
+			m.matched.Set(i)
+
+			if m.firstMatch {
+				break
+			}
+		}
+	}
+
+	return m.matched, nil
+}
+
+// MatchedLabels appends the labels of the filters matched by the last call
+// to Match to labels and returns the extended slice.
+func (m *FilterSetMatcher) MatchedLabels(labels []string) []string {
+	for i := m.matched.NextSet(0); i != -1; i = m.matched.NextSet(i + 1) {
+		labels = append(labels, m.set.labels[i])
+	}
+	return labels
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterSet(t *testing.T) {
+	assert := assert.New(t)
+
+	fs, err := NewFilterSet([]NamedExpression{
+		{"blue", EqualsExpr{FieldExpr{0, []string{"eyeColor"}}, ValueExpr{"blue"}}},
+		{"everything", TrueExpr{}},
+		{"young", LessThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{30}}},
+		{"nothing", FalseExpr{}},
+	})
+	assert.Nil(err)
+	assert.Equal(4, fs.Len())
+	assert.Equal("young", fs.Label(2))
+
+	m := fs.NewMatcher()
+
+	matched, err := m.Match([]byte(`{"eyeColor":"blue","age":25}`))
+	assert.Nil(err)
+	assert.Equal(3, matched.Count())
+	assert.Equal([]string{"blue", "everything", "young"}, m.MatchedLabels(nil))
+
+	// The same matcher must be reusable for the next document
+	matched, err = m.Match([]byte(`{"eyeColor":"green","age":45}`))
+	assert.Nil(err)
+	assert.Equal(1, matched.Count())
+	assert.Equal([]string{"everything"}, m.MatchedLabels(nil))
+
+	m.SetFirstMatch(true)
+	matched, err = m.Match([]byte(`{"eyeColor":"blue","age":25}`))
+	assert.Nil(err)
+	assert.Equal(1, matched.Count())
+	assert.True(matched.Test(0))
+
+	matched, err = m.Match([]byte(`{"eyeColor":"green","age":25}`))
+	assert.Nil(err)
+	assert.Equal([]string{"everything"}, m.MatchedLabels(nil))
+
+	_, err = m.Match([]byte(`{"eyeColor" "green"}`))
+	assert.NotNil(err)
+}
+
+func TestFilterSetFromStrings(t *testing.T) {
+	assert := assert.New(t)
+
+	fs, err := NewFilterSetFromStrings(map[string]string{
+		"c-old":   "age >= 40",
+		"a-blue":  "eyeColor = \"blue\"",
+		"b-brown": "eyeColor = \"brown\" AND age < 40",
+	})
+	assert.Nil(err)
+	assert.Equal("a-blue", fs.Label(0))
+	assert.Equal("b-brown", fs.Label(1))
+	assert.Equal("c-old", fs.Label(2))
+
+	m := fs.NewMatcher()
+	_, err = m.Match([]byte(`{"eyeColor":"blue","age":45}`))
+	assert.Nil(err)
+	assert.Equal([]string{"a-blue", "c-old"}, m.MatchedLabels(nil))
+
+	// The default only applies to matchers created afterwards
+	fs.SetFirstMatch(true)
+	_, err = m.Match([]byte(`{"eyeColor":"blue","age":45}`))
+	assert.Nil(err)
+	assert.Equal([]string{"a-blue", "c-old"}, m.MatchedLabels(nil))
+
+	m = fs.NewMatcher()
+	_, err = m.Match([]byte(`{"eyeColor":"blue","age":45}`))
+	assert.Nil(err)
+	assert.Equal([]string{"a-blue"}, m.MatchedLabels(nil))
+
+	_, err = NewFilterSetFromStrings(map[string]string{
+		"bad": "age >=",
+	})
+	assert.NotNil(err)
+}
+
+func TestFilterSetErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewFilterSet([]NamedExpression{
+		{"a", TrueExpr{}},
+		{"a", FalseExpr{}},
+	})
+	assert.NotNil(err)
+
+	fs, err := NewFilterSet(nil)
+	assert.Nil(err)
+	matched, err := fs.NewMatcher().Match([]byte(`{}`))
+	assert.Nil(err)
+	assert.Equal(0, matched.Count())
+}
+
+func TestFilterSetConstantsOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	fs, err := NewFilterSet([]NamedExpression{
+		{"never", FalseExpr{}},
+		{"always", TrueExpr{}},
+	})
+	assert.Nil(err)
+
+	m := fs.NewMatcher()
+	_, err = m.Match([]byte(`{"a":1}`))
+	assert.Nil(err)
+	assert.Equal([]string{"always"}, m.MatchedLabels(nil))
+}
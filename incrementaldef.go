@@ -0,0 +1,631 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ExpressionID identifies an expression that was added to an IncrementalDef.
+// IDs remain stable across versions of the definition.
+type ExpressionID int
+
+type incrementalEntry struct {
+	id   ExpressionID
+	expr Expression
+
+	// constant is AlwaysTrueIdent or AlwaysFalseIdent for expressions which
+	// compiled to a constant, and 0 otherwise.
+	constant int
+
+	// buckets are the buckets of the global tree which are owned by this
+	// expression, with tree holding the tree node for each of them.  The
+	// first bucket is the root of the expression.
+	buckets []BucketID
+	tree    []binTreeNode
+
+	// slots lists every slot reference made by this expression.
+	slots []SlotID
+}
+
+// IncrementalDef is an immutable set of expressions compiled into a single
+// MatchDef.  Adding or removing an expression produces a new IncrementalDef
+// without recompiling the remaining expressions.  The new definition shares
+// every ExecNode which the change did not touch with the old one, and the
+// bucket and slot assignments of unchanged expressions never move, so both
+// versions may be used for matching at the same time.
+type IncrementalDef struct {
+	version uint64
+	nextID  ExpressionID
+	entries []*incrementalEntry
+
+	root        *ExecNode
+	areaEnd     int
+	freeBuckets []BucketID
+	numSlots    int
+	freeSlots   []SlotID
+	slotRefs    map[SlotID]int
+
+	def *MatchDef
+}
+
+// NewIncrementalDef returns an empty definition to which expressions can be
+// added.
+func NewIncrementalDef() *IncrementalDef {
+	d := &IncrementalDef{
+		areaEnd:  1,
+		slotRefs: make(map[SlotID]int),
+	}
+	d.buildMatchDef()
+	return d
+}
+
+// Version returns a counter which is incremented by every change.
+func (d *IncrementalDef) Version() uint64 {
+	return d.version
+}
+
+// MatchDef returns the compiled definition for this version.  The index of
+// each expression within the definition (as used by ExpressionMatched) is
+// its position in IDs.
+func (d *IncrementalDef) MatchDef() *MatchDef {
+	return d.def
+}
+
+// IDs returns the IDs of the expressions in this version, in the same order
+// as the expressions of the compiled MatchDef.
+func (d *IncrementalDef) IDs() []ExpressionID {
+	ids := make([]ExpressionID, len(d.entries))
+	for i, entry := range d.entries {
+		ids[i] = entry.id
+	}
+	return ids
+}
+
+// Index returns the position of an expression within the compiled MatchDef.
+func (d *IncrementalDef) Index(id ExpressionID) (int, bool) {
+	for i, entry := range d.entries {
+		if entry.id == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Expression returns the expression which was added with the specified ID.
+func (d *IncrementalDef) Expression(id ExpressionID) (Expression, bool) {
+	idx, ok := d.Index(id)
+	if !ok {
+		return nil, false
+	}
+	return d.entries[idx].expr, true
+}
+
+func (d *IncrementalDef) clone() *IncrementalDef {
+	nd := &IncrementalDef{
+		version:     d.version + 1,
+		nextID:      d.nextID,
+		entries:     append([]*incrementalEntry(nil), d.entries...),
+		root:        d.root,
+		areaEnd:     d.areaEnd,
+		freeBuckets: append([]BucketID(nil), d.freeBuckets...),
+		numSlots:    d.numSlots,
+		freeSlots:   append([]SlotID(nil), d.freeSlots...),
+		slotRefs:    make(map[SlotID]int, len(d.slotRefs)),
+	}
+	for slot, refs := range d.slotRefs {
+		nd.slotRefs[slot] = refs
+	}
+	return nd
+}
+
+func (d *IncrementalDef) allocBucket() BucketID {
+	if len(d.freeBuckets) > 0 {
+		bucket := d.freeBuckets[0]
+		d.freeBuckets = d.freeBuckets[1:]
+		return bucket
+	}
+
+	bucket := BucketID(d.areaEnd)
+	d.areaEnd++
+	return bucket
+}
+
+func (d *IncrementalDef) allocSlot() SlotID {
+	if len(d.freeSlots) > 0 {
+		slot := d.freeSlots[0]
+		d.freeSlots = d.freeSlots[1:]
+		return slot
+	}
+
+	d.numSlots++
+	return SlotID(d.numSlots)
+}
+
+// Add compiles expr and returns a new version of the definition which
+// includes it, along with the ID assigned to the expression.
+func (d *IncrementalDef) Add(expr Expression) (*IncrementalDef, ExpressionID, error) {
+	var trans Transformer
+	fragDef, err := trans.TransformE([]Expression{expr})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nd := d.clone()
+	entry := &incrementalEntry{
+		id:   nd.nextID,
+		expr: expr,
+	}
+	nd.nextID++
+
+	if fragDef.MatchBuckets[0] < 0 {
+		entry.constant = fragDef.MatchBuckets[0]
+	} else {
+		merger := incrementalMerger{
+			def:       nd,
+			entry:     entry,
+			bucketMap: make([]BucketID, fragDef.NumBuckets),
+			slotMap:   make(map[SlotID]SlotID),
+		}
+
+		// Assign global buckets to the fragment, the fragment root is always
+		// bucket 0 so it becomes the first of the entries buckets.
+		for i := range merger.bucketMap {
+			merger.bucketMap[i] = nd.allocBucket()
+		}
+
+		entry.buckets = merger.bucketMap
+		entry.tree = make([]binTreeNode, len(fragDef.MatchTree.data))
+		for i, node := range fragDef.MatchTree.data {
+			node.ParentIdx = int(merger.bucketMap[node.ParentIdx])
+			if node.Left != 0 {
+				node.Left = int(merger.bucketMap[node.Left])
+			}
+			if node.Right != 0 {
+				node.Right = int(merger.bucketMap[node.Right])
+			}
+			entry.tree[i] = node
+		}
+
+		merger.mapSlots(fragDef.ParseNode, nd.root)
+		nd.root = merger.merge(nd.root, fragDef.ParseNode)
+	}
+
+	nd.entries = append(nd.entries, entry)
+	nd.buildMatchDef()
+	return nd, entry.id, nil
+}
+
+// Remove returns a new version of the definition without the expression
+// with the specified ID.
+func (d *IncrementalDef) Remove(id ExpressionID) (*IncrementalDef, error) {
+	idx, ok := d.Index(id)
+	if !ok {
+		return nil, fmt.Errorf("expression %d is not part of the definition", id)
+	}
+
+	nd := d.clone()
+	entry := nd.entries[idx]
+	nd.entries = append(nd.entries[:idx:idx], nd.entries[idx+1:]...)
+
+	if entry.constant == 0 {
+		for _, slot := range entry.slots {
+			nd.slotRefs[slot]--
+			if nd.slotRefs[slot] == 0 {
+				delete(nd.slotRefs, slot)
+			}
+		}
+
+		owned := make(map[BucketID]bool, len(entry.buckets))
+		for _, bucket := range entry.buckets {
+			owned[bucket] = true
+		}
+
+		root, _ := nd.prune(nd.root, owned)
+		if root == nil {
+			root = &ExecNode{}
+		}
+		nd.root = root
+
+		nd.releaseBuckets(entry.buckets)
+	}
+
+	nd.buildMatchDef()
+	return nd, nil
+}
+
+func (d *IncrementalDef) releaseBuckets(buckets []BucketID) {
+	d.freeBuckets = append(d.freeBuckets, buckets...)
+	sort.Slice(d.freeBuckets, func(i, j int) bool {
+		return d.freeBuckets[i] < d.freeBuckets[j]
+	})
+
+	// Shrink the expression area if its tail is no longer in use
+	for len(d.freeBuckets) > 0 && int(d.freeBuckets[len(d.freeBuckets)-1]) == d.areaEnd-1 {
+		d.freeBuckets = d.freeBuckets[:len(d.freeBuckets)-1]
+		d.areaEnd--
+	}
+}
+
+// filterOwnedOps removes the ops belonging to the owned buckets.  Ops are only
+// shared between buckets of the same fragment, so checking the first bucket
+// of each op is enough.
+func filterOwnedOps(ops []OpNode, owned map[BucketID]bool) ([]OpNode, bool) {
+	var out []OpNode
+	changed := false
+	for _, op := range ops {
+		if owned[op.BucketIdx] {
+			changed = true
+			continue
+		}
+		out = append(out, op)
+	}
+	if !changed {
+		return ops, false
+	}
+	return out, true
+}
+
+// releaseSlots frees the slots stored by a node which is being discarded.
+func (d *IncrementalDef) releaseSlots(node *ExecNode) {
+	if node.StoreId > 0 && d.slotRefs[node.StoreId] == 0 {
+		d.freeSlots = append(d.freeSlots, node.StoreId)
+	}
+
+	for _, elem := range node.Elems {
+		d.releaseSlots(elem)
+	}
+	for _, loop := range node.Loops {
+		d.releaseSlots(loop.Node)
+	}
+	if node.After != nil {
+		for _, elem := range node.After.Elems {
+			d.releaseSlots(elem)
+		}
+		for _, loop := range node.After.Loops {
+			d.releaseSlots(loop.Node)
+		}
+	}
+}
+
+func (d *IncrementalDef) filterOwnedLoops(loops []LoopNode, owned map[BucketID]bool) ([]LoopNode, bool) {
+	var out []LoopNode
+	changed := false
+	for _, loop := range loops {
+		if owned[loop.BucketIdx] {
+			d.releaseSlots(loop.Node)
+			changed = true
+			continue
+		}
+		out = append(out, loop)
+	}
+	if !changed {
+		return loops, false
+	}
+	return out, true
+}
+
+// pruneElems prunes each of the elements in elems, returning a new map if
+// any of them were changed.
+func (d *IncrementalDef) pruneElems(elems map[string]*ExecNode, owned map[BucketID]bool) (map[string]*ExecNode, bool) {
+	newElems := elems
+	changed := false
+	for key, elem := range elems {
+		newElem, elemChanged := d.prune(elem, owned)
+		if !elemChanged {
+			continue
+		}
+
+		if !changed {
+			newElems = make(map[string]*ExecNode, len(elems))
+			for okey, oelem := range elems {
+				newElems[okey] = oelem
+			}
+			changed = true
+		}
+
+		if newElem == nil {
+			delete(newElems, key)
+		} else {
+			newElems[key] = newElem
+		}
+	}
+	if changed && len(newElems) == 0 {
+		newElems = nil
+	}
+	return newElems, changed
+}
+
+// prune returns a copy of node with every op and loop belonging to the owned
+// buckets removed.  Subtrees which are not affected are returned unchanged.
+// Loops always belong entirely to a single expression, so they never need to
+// be descended into.
+func (d *IncrementalDef) prune(node *ExecNode, owned map[BucketID]bool) (*ExecNode, bool) {
+	if node == nil {
+		return nil, false
+	}
+
+	ops, opsChanged := filterOwnedOps(node.Ops, owned)
+	loops, loopsChanged := d.filterOwnedLoops(node.Loops, owned)
+	changed := opsChanged || loopsChanged
+
+	after := node.After
+	if after != nil {
+		afterOps, afterOpsChanged := filterOwnedOps(after.Ops, owned)
+		afterLoops, afterLoopsChanged := d.filterOwnedLoops(after.Loops, owned)
+		afterElems, afterElemsChanged := d.pruneElems(after.Elems, owned)
+		if afterOpsChanged || afterLoopsChanged || afterElemsChanged {
+			changed = true
+			if len(afterOps) == 0 && len(afterLoops) == 0 && len(afterElems) == 0 {
+				after = nil
+			} else {
+				after = &AfterNode{
+					Ops:   afterOps,
+					Loops: afterLoops,
+					Elems: afterElems,
+				}
+			}
+		}
+	}
+
+	elems, elemsChanged := d.pruneElems(node.Elems, owned)
+	if elemsChanged {
+		changed = true
+	}
+
+	storeID := node.StoreId
+	if storeID > 0 && d.slotRefs[storeID] == 0 {
+		d.freeSlots = append(d.freeSlots, storeID)
+		storeID = 0
+		changed = true
+	}
+
+	if !changed {
+		return node, false
+	}
+
+	if storeID == 0 && len(ops) == 0 && len(loops) == 0 && after == nil && len(elems) == 0 {
+		return nil, true
+	}
+
+	return &ExecNode{
+		StoreId: storeID,
+		Elems:   elems,
+		Ops:     ops,
+		Loops:   loops,
+		After:   after,
+	}, true
+}
+
+// buildMatchDef assembles the MatchDef for the current set of entries.  The
+// roots of all expressions (along with any unused buckets) are joined by a
+// chain of neor nodes.  Bucket 0 is the head of the chain and the remainder
+// of the chain lives after the expression area, it is rebuilt every time.
+// As a result the tree is not in preorder and will not pass Validate, which
+// the matcher does not require.
+func (d *IncrementalDef) buildMatchDef() {
+	def := &MatchDef{
+		MatchBuckets: make([]int, len(d.entries)),
+		NumSlots:     d.numSlots,
+	}
+
+	var items []int
+	for i, entry := range d.entries {
+		if entry.constant != 0 {
+			def.MatchBuckets[i] = entry.constant
+			continue
+		}
+
+		def.MatchBuckets[i] = int(entry.buckets[0])
+		items = append(items, int(entry.buckets[0]))
+	}
+
+	if len(items) == 0 {
+		d.def = def
+		return
+	}
+
+	data := make([]binTreeNode, d.areaEnd)
+	for _, entry := range d.entries {
+		for i, bucket := range entry.buckets {
+			data[bucket] = entry.tree[i]
+		}
+	}
+
+	// Unused buckets are attached to the chain as leaves, they are never
+	// marked by any op and simply resolve to false.
+	for _, bucket := range d.freeBuckets {
+		data[bucket] = binTreeNode{NodeType: nodeTypeLeaf}
+		items = append(items, int(bucket))
+	}
+
+	if len(items) == 1 {
+		data = append(data, binTreeNode{NodeType: nodeTypeLeaf})
+		items = append(items, len(data)-1)
+	}
+
+	chainIdx := 0
+	for i := 0; i < len(items)-1; i++ {
+		nextIdx := items[i+1]
+		if i < len(items)-2 {
+			data = append(data, binTreeNode{})
+			nextIdx = len(data) - 1
+		}
+
+		data[chainIdx].NodeType = nodeTypeNeor
+		data[chainIdx].Left = items[i]
+		data[chainIdx].Right = nextIdx
+		data[items[i]].ParentIdx = chainIdx
+		data[nextIdx].ParentIdx = chainIdx
+		chainIdx = nextIdx
+	}
+
+	def.ParseNode = d.root
+	def.MatchTree = binTree{data}
+	def.NumBuckets = len(data)
+	d.def = def
+}
+
+type incrementalMerger struct {
+	def       *IncrementalDef
+	entry     *incrementalEntry
+	bucketMap []BucketID
+	slotMap   map[SlotID]SlotID
+}
+
+// mapSlots assigns a global slot to every slot of the fragment.  Fragment
+// nodes which line up with an existing global node that is already stored
+// reuse that slot, all others are allocated a new one.
+func (m *incrementalMerger) mapSlots(fragNode, globalNode *ExecNode) {
+	if fragNode.StoreId > 0 {
+		if globalNode != nil && globalNode.StoreId > 0 {
+			m.slotMap[fragNode.StoreId] = globalNode.StoreId
+		} else {
+			m.slotMap[fragNode.StoreId] = m.def.allocSlot()
+		}
+	}
+
+	for key, fragElem := range fragNode.Elems {
+		var globalElem *ExecNode
+		if globalNode != nil {
+			globalElem = globalNode.Elems[key]
+		}
+		m.mapSlots(fragElem, globalElem)
+	}
+
+	for _, loop := range fragNode.Loops {
+		m.mapSlots(loop.Node, nil)
+	}
+	if fragNode.After != nil {
+		for key, fragElem := range fragNode.After.Elems {
+			var globalElem *ExecNode
+			if globalNode != nil && globalNode.After != nil {
+				globalElem = globalNode.After.Elems[key]
+			}
+			m.mapSlots(fragElem, globalElem)
+		}
+		for _, loop := range fragNode.After.Loops {
+			m.mapSlots(loop.Node, nil)
+		}
+	}
+}
+
+func (m *incrementalMerger) remapRef(ref DataRef) DataRef {
+	switch ref := ref.(type) {
+	case SlotRef:
+		slot := m.slotMap[ref.Slot]
+		m.entry.slots = append(m.entry.slots, slot)
+		m.def.slotRefs[slot]++
+		return SlotRef{slot}
+	case FuncRef:
+		params := make([]DataRef, len(ref.Params))
+		for i, param := range ref.Params {
+			params[i] = m.remapRef(param)
+		}
+		return FuncRef{
+			FuncName: ref.FuncName,
+			Params:   params,
+		}
+	case RangeRef:
+		return RangeRef{
+			Low:  m.remapRef(ref.Low),
+			High: m.remapRef(ref.High),
+		}
+	}
+	return ref
+}
+
+func (m *incrementalMerger) remapOps(ops []OpNode) []OpNode {
+	var out []OpNode
+	for _, op := range ops {
+		var sharedBuckets []BucketID
+		for _, bucketIdx := range op.SharedBuckets {
+			sharedBuckets = append(sharedBuckets, m.bucketMap[bucketIdx])
+		}
+
+		out = append(out, OpNode{
+			BucketIdx:     m.bucketMap[op.BucketIdx],
+			Op:            op.Op,
+			Lhs:           m.remapRef(op.Lhs),
+			Rhs:           m.remapRef(op.Rhs),
+			SharedBuckets: sharedBuckets,
+		})
+	}
+	return out
+}
+
+func (m *incrementalMerger) remapLoops(loops []LoopNode) []LoopNode {
+	var out []LoopNode
+	for _, loop := range loops {
+		out = append(out, LoopNode{
+			BucketIdx: m.bucketMap[loop.BucketIdx],
+			Mode:      loop.Mode,
+			Target:    m.remapRef(loop.Target),
+			Node:      m.merge(nil, loop.Node),
+			Slice:     loop.Slice,
+		})
+	}
+	return out
+}
+
+// merge returns a new node containing everything from globalNode as well as
+// the remapped contents of fragNode.  Elements of globalNode which are not
+// referenced by the fragment are shared with the new node.
+func (m *incrementalMerger) merge(globalNode, fragNode *ExecNode) *ExecNode {
+	newNode := &ExecNode{}
+	if globalNode != nil {
+		newNode.StoreId = globalNode.StoreId
+		newNode.Ops = append([]OpNode(nil), globalNode.Ops...)
+		newNode.Loops = append([]LoopNode(nil), globalNode.Loops...)
+		if globalNode.After != nil {
+			newNode.After = &AfterNode{
+				Ops:   append([]OpNode(nil), globalNode.After.Ops...),
+				Loops: append([]LoopNode(nil), globalNode.After.Loops...),
+			}
+			if globalNode.After.Elems != nil {
+				newNode.After.Elems = make(map[string]*ExecNode, len(globalNode.After.Elems))
+				for key, elem := range globalNode.After.Elems {
+					newNode.After.Elems[key] = elem
+				}
+			}
+		}
+		if globalNode.Elems != nil {
+			newNode.Elems = make(map[string]*ExecNode, len(globalNode.Elems))
+			for key, elem := range globalNode.Elems {
+				newNode.Elems[key] = elem
+			}
+		}
+	}
+
+	if fragNode.StoreId > 0 && newNode.StoreId == 0 {
+		newNode.StoreId = m.slotMap[fragNode.StoreId]
+	}
+
+	newNode.Ops = append(newNode.Ops, m.remapOps(fragNode.Ops)...)
+	newNode.Loops = append(newNode.Loops, m.remapLoops(fragNode.Loops)...)
+
+	if fragNode.After != nil {
+		if newNode.After == nil {
+			newNode.After = &AfterNode{}
+		}
+		newNode.After.Ops = append(newNode.After.Ops, m.remapOps(fragNode.After.Ops)...)
+		newNode.After.Loops = append(newNode.After.Loops, m.remapLoops(fragNode.After.Loops)...)
+
+		for key, fragElem := range fragNode.After.Elems {
+			if newNode.After.Elems == nil {
+				newNode.After.Elems = make(map[string]*ExecNode)
+			}
+			newNode.After.Elems[key] = m.merge(newNode.After.Elems[key], fragElem)
+		}
+	}
+
+	for key, fragElem := range fragNode.Elems {
+		if newNode.Elems == nil {
+			newNode.Elems = make(map[string]*ExecNode)
+		}
+		newNode.Elems[key] = m.merge(newNode.Elems[key], fragElem)
+	}
+
+	return newNode
+}
@@ -0,0 +1,231 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func getIncrementalTestExprs() []Expression {
+	exprs := []string{
+		`["equals", ["field", "eyeColor"], ["value", "blue"]]`,
+		`["lessthan", ["field", "age"], ["value", 30]]`,
+		`["and", ["equals", ["field", "eyeColor"], ["value", "brown"]], ["greaterequals", ["field", "age"], ["value", 25]]]`,
+		`["anyin", 1, ["field", "tags"], ["equals", ["field", 1], ["value", "dolor"]]]`,
+		`["anyin", 1, ["field", "friends"], ["equals", ["field", 1, "id"], ["field", "index"]]]`,
+		`["not", ["exists", ["field", "sometimesValue"]]]`,
+		`["equals", ["func", "mathRound", ["field", "latitude"]], ["value", 37]]`,
+		`["everyin", 1, ["field", "friends"], ["lessthan", ["field", 1, "id"], ["value", 3]]]`,
+		`["equals", ["field", "sometimesValue"], ["field", "isActive"]]`,
+		`["equals", ["field", "tags", "[-1]"], ["field", "tags", "[0]"]]`,
+		`["anyin", 1, ["field", "testArray", "[-2:]"], ["equals", ["field", 1], ["value", "joseph"]]]`,
+	}
+
+	var out []Expression
+	for _, data := range exprs {
+		expr, err := ParseJsonExpression([]byte(data))
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, expr)
+	}
+	return append(out, TrueExpr{}, FalseExpr{})
+}
+
+// tCheckIncrementalDef compares the results of an incremental definition
+// against a definition freshly compiled from the same expressions.
+func tCheckIncrementalDef(t *testing.T, def *IncrementalDef) {
+	t.Helper()
+
+	var exprs []Expression
+	for _, id := range def.IDs() {
+		expr, _ := def.Expression(id)
+		exprs = append(exprs, expr)
+	}
+
+	var trans Transformer
+	freshDef, err := trans.TransformE(exprs)
+	if err != nil {
+		t.Fatalf("failed to compile expressions: %s", err)
+	}
+
+	for docIdx, doc := range getTestPeopleDocs() {
+		im := NewFastMatcher(def.MatchDef())
+		imatched, err := im.Match(doc)
+		if err != nil {
+			t.Fatalf("incremental match failed: %s", err)
+		}
+
+		fm := NewFastMatcher(freshDef)
+		fmatched, err := fm.Match(doc)
+		if err != nil {
+			t.Fatalf("fresh match failed: %s", err)
+		}
+
+		if imatched != fmatched {
+			t.Fatalf("document %d: incremental matched %t, fresh matched %t\n%s", docIdx, imatched, fmatched, def.MatchDef())
+		}
+
+		for i := range exprs {
+			if im.ExpressionMatched(i) != fm.ExpressionMatched(i) {
+				t.Fatalf("document %d: expression %s mismatched\n%s", docIdx, exprs[i], def.MatchDef())
+			}
+		}
+	}
+}
+
+func TestIncrementalDefAddRemove(t *testing.T) {
+	exprs := getIncrementalTestExprs()
+
+	def := NewIncrementalDef()
+	var ids []ExpressionID
+	for _, expr := range exprs {
+		var id ExpressionID
+		var err error
+		def, id, err = def.Add(expr)
+		if err != nil {
+			t.Fatalf("failed to add expression: %s", err)
+		}
+		ids = append(ids, id)
+		tCheckIncrementalDef(t, def)
+	}
+
+	for i := len(ids) - 1; i >= 0; i -= 2 {
+		var err error
+		def, err = def.Remove(ids[i])
+		if err != nil {
+			t.Fatalf("failed to remove expression: %s", err)
+		}
+		tCheckIncrementalDef(t, def)
+	}
+
+	_, err := def.Remove(ids[len(ids)-1])
+	if err == nil {
+		t.Errorf("expected removing an unknown expression to fail")
+	}
+}
+
+func TestIncrementalDefRandomized(t *testing.T) {
+	exprs := getIncrementalTestExprs()
+	rng := rand.New(rand.NewSource(17))
+
+	def := NewIncrementalDef()
+	for i := 0; i < 200; i++ {
+		ids := def.IDs()
+		if len(ids) > 0 && rng.Intn(3) == 0 {
+			var err error
+			def, err = def.Remove(ids[rng.Intn(len(ids))])
+			if err != nil {
+				t.Fatalf("failed to remove expression: %s", err)
+			}
+		} else {
+			var err error
+			def, _, err = def.Add(exprs[rng.Intn(len(exprs))])
+			if err != nil {
+				t.Fatalf("failed to add expression: %s", err)
+			}
+		}
+
+		if i%10 == 0 {
+			tCheckIncrementalDef(t, def)
+		}
+	}
+	tCheckIncrementalDef(t, def)
+
+	// Removing everything should leave us with an empty definition
+	for _, id := range def.IDs() {
+		def, _ = def.Remove(id)
+	}
+	if def.MatchDef().NumBuckets != 0 || len(def.MatchDef().MatchBuckets) != 0 {
+		t.Errorf("expected an empty definition after removing everything:\n%s", def.MatchDef())
+	}
+}
+
+func TestIncrementalDefSharesNodes(t *testing.T) {
+	exprs := getIncrementalTestExprs()
+
+	v1, _, _ := NewIncrementalDef().Add(exprs[0])
+	v2, ageID, _ := v1.Add(exprs[1])
+	v3, _, _ := v2.Add(exprs[3])
+
+	root1 := v1.MatchDef().ParseNode
+	root2 := v2.MatchDef().ParseNode
+	root3 := v3.MatchDef().ParseNode
+
+	if root1.Elems["eyeColor"] != root2.Elems["eyeColor"] {
+		t.Errorf("expected the eyeColor node to be shared after adding an age expression")
+	}
+	if root2.Elems["age"] != root3.Elems["age"] {
+		t.Errorf("expected the age node to be shared after adding a tags expression")
+	}
+	if root1 == root2 {
+		t.Errorf("expected the root node to be copied")
+	}
+	if len(root1.Elems) != 1 {
+		t.Errorf("adding an expression modified the previous version")
+	}
+
+	v4, _ := v3.Remove(ageID)
+	root4 := v4.MatchDef().ParseNode
+	if _, ok := root4.Elems["age"]; ok {
+		t.Errorf("expected the age node to be removed")
+	}
+	if root4.Elems["tags"] != root3.Elems["tags"] {
+		t.Errorf("expected the tags node to be shared after removing the age expression")
+	}
+	if _, ok := root3.Elems["age"]; !ok {
+		t.Errorf("removing an expression modified the previous version")
+	}
+
+	// Older versions must continue to function
+	tCheckIncrementalDef(t, v1)
+	tCheckIncrementalDef(t, v2)
+	tCheckIncrementalDef(t, v3)
+	tCheckIncrementalDef(t, v4)
+}
+
+func TestSwappableMatcher(t *testing.T) {
+	exprs := getIncrementalTestExprs()
+	docs := getTestPeopleDocs()
+
+	def := NewIncrementalDef()
+	def, _, _ = def.Add(exprs[0])
+	swapper := NewSwappableMatcher(def.MatchDef())
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, doc := range docs {
+					_, err := swapper.Match(doc)
+					if err != nil {
+						t.Errorf("match failed: %s", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for _, expr := range exprs[1:] {
+		def, _, _ = def.Add(expr)
+		swapper.Swap(def.MatchDef())
+	}
+	close(stop)
+	wg.Wait()
+
+	if swapper.Current().Def() != def.MatchDef() {
+		t.Errorf("expected the latest definition to be current")
+	}
+}
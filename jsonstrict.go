@@ -0,0 +1,309 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+type strictState int
+
+const (
+	strictBeginValue strictState = iota
+	strictBeginValueOrArrayEnd
+	strictBeginKey
+	strictBeginKeyOrObjectEnd
+	strictKeyDelim
+	strictEndValue
+)
+
+type strictContainer struct {
+	isObject    bool
+	keysStart   int
+	keyBufStart int
+}
+
+// strictKey locates the unescaped bytes of an object key.  Keys without
+// escapes refer directly to the input, the others to the validators keyBuf.
+type strictKey struct {
+	start     int
+	end       int
+	unescaped bool
+}
+
+// strictKeySorter orders the keys of a single object by their unescaped
+// bytes, so that duplicates end up next to each other.
+type strictKeySorter struct {
+	data   []byte
+	keyBuf []byte
+	keys   []strictKey
+}
+
+func (s *strictKeySorter) keyBytes(key strictKey) []byte {
+	if key.unescaped {
+		return s.keyBuf[key.start:key.end]
+	}
+	return s.data[key.start:key.end]
+}
+
+func (s *strictKeySorter) Len() int {
+	return len(s.keys)
+}
+
+func (s *strictKeySorter) Less(i, j int) bool {
+	return bytes.Compare(s.keyBytes(s.keys[i]), s.keyBytes(s.keys[j])) < 0
+}
+
+func (s *strictKeySorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// jsonStrictValidator checks the stream of tokens produced by a jsonTokenizer
+// against the JSON grammar.  It tracks the nesting of objects and arrays and
+// the keys of every open object so that duplicate keys can be detected.  The
+// keys of an object are sorted once the object ends, which keeps duplicate
+// detection at O(n log n) for objects with many keys.  The buffers it uses
+// are retained across resets so that, once warmed up, the validator performs
+// no allocations.
+type jsonStrictValidator struct {
+	state    strictState
+	stack    []strictContainer
+	keys     []strictKey
+	keyBuf   []byte
+	sorter   strictKeySorter
+	validPos int
+}
+
+func (v *jsonStrictValidator) Reset() {
+	v.state = strictBeginValue
+	v.stack = v.stack[:0]
+	v.keys = v.keys[:0]
+	v.keyBuf = v.keyBuf[:0]
+	v.validPos = 0
+}
+
+func (v *jsonStrictValidator) unexpectedToken(token tokenType, expected string) error {
+	if token == tknEnd {
+		return errors.New("unexpected end of input")
+	}
+	return fmt.Errorf("expected %s but found %s", expected, tokenToText(token))
+}
+
+func (v *jsonStrictValidator) pushContainer(isObject bool) {
+	v.stack = append(v.stack, strictContainer{
+		isObject:    isObject,
+		keysStart:   len(v.keys),
+		keyBufStart: len(v.keyBuf),
+	})
+
+	if isObject {
+		v.state = strictBeginKeyOrObjectEnd
+	} else {
+		v.state = strictBeginValueOrArrayEnd
+	}
+}
+
+func (v *jsonStrictValidator) popContainer(data []byte, isObject bool) error {
+	if len(v.stack) == 0 || v.stack[len(v.stack)-1].isObject != isObject {
+		if isObject {
+			return errors.New("unexpected object end")
+		}
+		return errors.New("unexpected array end")
+	}
+
+	container := v.stack[len(v.stack)-1]
+	if isObject {
+		err := v.checkDuplicateKeys(data, v.keys[container.keysStart:])
+		if err != nil {
+			return err
+		}
+	}
+
+	v.keys = v.keys[:container.keysStart]
+	v.keyBuf = v.keyBuf[:container.keyBufStart]
+	v.stack = v.stack[:len(v.stack)-1]
+	v.state = strictEndValue
+	return nil
+}
+
+func (v *jsonStrictValidator) checkString(token tokenType, tokenData []byte) error {
+	strData := tokenData[1 : len(tokenData)-1]
+
+	if !utf8.Valid(strData) {
+		return errors.New("invalid UTF-8 in string literal")
+	}
+
+	if token == tknEscString {
+		// The tokenizer only validates the shape of escapes, we additionally
+		// need to make sure that any surrogate pairs are correctly formed.
+		var scratch [utf8.UTFMax]byte
+		for i := 0; i < len(strData); i++ {
+			if strData[i] != '\\' {
+				continue
+			}
+
+			inLen, _ := unescapeToUTF8(strData[i:], scratch[:])
+			if inLen == -1 {
+				return errors.New("in string escape code")
+			}
+			i += inLen - 1
+		}
+	}
+
+	return nil
+}
+
+// addKey records an object key, unescaping it into keyBuf if needed so that
+// keys can later be compared byte for byte.
+func (v *jsonStrictValidator) addKey(data []byte, token tokenType, start, end int) {
+	strData := data[start+1 : end-1]
+	if token != tknEscString {
+		v.keys = append(v.keys, strictKey{
+			start: start + 1,
+			end:   end - 1,
+		})
+		return
+	}
+
+	keyStart := len(v.keyBuf)
+	var scratch [utf8.UTFMax]byte
+	for i := 0; i < len(strData); i++ {
+		if strData[i] != '\\' {
+			v.keyBuf = append(v.keyBuf, strData[i])
+			continue
+		}
+
+		// The escape has already been validated by checkString
+		inLen, outLen := unescapeToUTF8(strData[i:], scratch[:])
+		v.keyBuf = append(v.keyBuf, scratch[:outLen]...)
+		i += inLen - 1
+	}
+
+	v.keys = append(v.keys, strictKey{
+		start:     keyStart,
+		end:       len(v.keyBuf),
+		unescaped: true,
+	})
+}
+
+// checkDuplicateKeys sorts the keys of an object which has just ended and
+// reports the first key which appears more than once.
+func (v *jsonStrictValidator) checkDuplicateKeys(data []byte, keys []strictKey) error {
+	if len(keys) < 2 {
+		return nil
+	}
+
+	v.sorter = strictKeySorter{
+		data:   data,
+		keyBuf: v.keyBuf,
+		keys:   keys,
+	}
+	sort.Sort(&v.sorter)
+
+	var err error
+	for i := 1; i < len(keys); i++ {
+		key := v.sorter.keyBytes(keys[i])
+		if bytes.Equal(v.sorter.keyBytes(keys[i-1]), key) {
+			err = fmt.Errorf("duplicate object key \"%s\"", key)
+			break
+		}
+	}
+
+	// Avoid holding on to the input once we are done with it
+	v.sorter = strictKeySorter{}
+	return err
+}
+
+// Check validates the next token of the input.  start and end are the
+// positions of the token data within data.
+func (v *jsonStrictValidator) Check(data []byte, token tokenType, start, end int) error {
+	if token == tknString || token == tknEscString {
+		err := v.checkString(token, data[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	switch v.state {
+	case strictBeginValueOrArrayEnd:
+		if token == tknArrayEnd {
+			return v.popContainer(data, false)
+		}
+		fallthrough
+
+	case strictBeginValue:
+		switch token {
+		case tknObjectStart:
+			v.pushContainer(true)
+			return nil
+		case tknArrayStart:
+			v.pushContainer(false)
+			return nil
+		}
+
+		if !isLiteralToken(token) {
+			return v.unexpectedToken(token, "value")
+		}
+
+		v.state = strictEndValue
+		return nil
+
+	case strictBeginKeyOrObjectEnd:
+		if token == tknObjectEnd {
+			return v.popContainer(data, true)
+		}
+		fallthrough
+
+	case strictBeginKey:
+		if token != tknString && token != tknEscString {
+			return v.unexpectedToken(token, "object key string")
+		}
+
+		v.addKey(data, token, start, end)
+
+		v.state = strictKeyDelim
+		return nil
+
+	case strictKeyDelim:
+		if token != tknObjectKeyDelim {
+			return v.unexpectedToken(token, tokenToText(tknObjectKeyDelim))
+		}
+
+		v.state = strictBeginValue
+		return nil
+
+	case strictEndValue:
+		if len(v.stack) == 0 {
+			if token != tknEnd {
+				return fmt.Errorf("invalid %s after top-level value", tokenToText(token))
+			}
+			return nil
+		}
+
+		isObject := v.stack[len(v.stack)-1].isObject
+		switch token {
+		case tknListDelim:
+			if isObject {
+				v.state = strictBeginKey
+			} else {
+				v.state = strictBeginValue
+			}
+			return nil
+		case tknObjectEnd:
+			return v.popContainer(data, true)
+		case tknArrayEnd:
+			return v.popContainer(data, false)
+		}
+
+		if isObject {
+			return v.unexpectedToken(token, "list_delim or object_end")
+		}
+		return v.unexpectedToken(token, "list_delim or array_end")
+	}
+
+	return errors.New("invalid strict validator state")
+}
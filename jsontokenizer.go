@@ -106,12 +106,25 @@ type jsonTokenizer struct {
 	data    []byte
 	dataLen int
 	pos     int
+
+	strict    bool
+	validator jsonStrictValidator
 }
 
 func (tkn *jsonTokenizer) Reset(data []byte) {
 	tkn.data = data
 	tkn.dataLen = len(data)
 	tkn.pos = 0
+	tkn.validator.Reset()
+}
+
+// SetStrict enables or disables strict validation of the input.  In strict
+// mode every token is checked against the JSON grammar as it is read, which
+// additionally catches invalid UTF-8, malformed surrogate escapes, duplicate
+// object keys and trailing data after the top-level value.  Seeking backwards
+// and re-reading tokens which were already validated is permitted.
+func (tkn *jsonTokenizer) SetStrict(strict bool) {
+	tkn.strict = strict
 }
 
 func (tkn *jsonTokenizer) Position() int {
@@ -123,6 +136,33 @@ func (tkn *jsonTokenizer) Seek(pos int) {
 }
 
 func (tkn *jsonTokenizer) Step() (tokenType, []byte, int, error) {
+	if !tkn.strict {
+		return tkn.step()
+	}
+
+	startPos := tkn.pos
+	token, tokenData, tokenDataLen, err := tkn.step()
+	if err != nil {
+		return token, tokenData, tokenDataLen, err
+	}
+
+	// Tokens before the validated position have already been checked, this
+	// happens when the caller seeks backwards to re-read part of the input.
+	if startPos < tkn.validator.validPos {
+		return token, tokenData, tokenDataLen, nil
+	}
+
+	err = tkn.validator.Check(tkn.data, token, tkn.pos-tokenDataLen, tkn.pos)
+	if err != nil {
+		tkn.pos = startPos
+		return tknUnknown, nil, 0, err
+	}
+	tkn.validator.validPos = tkn.pos
+
+	return token, tokenData, tokenDataLen, nil
+}
+
+func (tkn *jsonTokenizer) step() (tokenType, []byte, int, error) {
 	// Bring everying local for optimization purposes
 	dataSlice := tkn.data
 	dataLen := tkn.dataLen
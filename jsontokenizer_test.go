@@ -212,3 +212,198 @@ func BenchmarkTokenize(b *testing.B) {
 		}
 	}
 }
+
+func testTokenizeStrict(t *testing.T, data string) error {
+	t.Helper()
+
+	var tok jsonTokenizer
+	tok.SetStrict(true)
+	tok.Reset([]byte(data))
+	for {
+		token, _, _, err := tok.Step()
+		if err != nil {
+			return err
+		}
+
+		if token == tknEnd {
+			return nil
+		}
+	}
+}
+
+func TestTokenizeStrictValid(t *testing.T) {
+	validDocs := []string{
+		`{}`,
+		`[]`,
+		`  "hello"  `,
+		`14`,
+		`{"a":[1,2,{"b":null}],"c":{"a":true},"d":"😀"}`,
+		`[{"a":1},{"a":2}]`,
+		`{"café":1,"cafe":2}`,
+		"{\"\xe6\x97\xa5\xe6\x9c\xac\":\"\xe8\xaa\x9e\"}",
+	}
+
+	for _, doc := range validDocs {
+		err := testTokenizeStrict(t, doc)
+		if err != nil {
+			t.Errorf("expected `%s` to be valid, got: %s", doc, err)
+		}
+	}
+
+	dataBytes, err := ioutil.ReadFile("testdata/people.json")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read test data file: %s", err))
+	}
+	err = testTokenizeStrict(t, string(dataBytes))
+	if err != nil {
+		t.Errorf("expected test data to be valid, got: %s", err)
+	}
+}
+
+func TestTokenizeStrictInvalid(t *testing.T) {
+	invalidDocs := []string{
+		``,
+		`   `,
+		`{"a":1}}`,
+		`{"a":1} {"b":2}`,
+		`[1,2]x`,
+		`[1,]`,
+		`[,1]`,
+		`{"a":1,}`,
+		`{"a" 1}`,
+		`{"a":}`,
+		`{1:2}`,
+		`{"a":1]`,
+		`[1}`,
+		`[1 2]`,
+		`{"a":1`,
+		`{"a":1,"a":2}`,
+		`{"a":1,"\u0061":2}`,
+		`{"a":{"b":1,"c":2,"b":3}}`,
+		`"bad \x escape"`,
+		"\"ctrl \x01 char\"",
+		"\"bad utf8 \xff\"",
+		"{\"\xc3\x28\":1}",
+		`"lone \ud800 surrogate"`,
+	}
+
+	for _, doc := range invalidDocs {
+		err := testTokenizeStrict(t, doc)
+		if err == nil {
+			t.Errorf("expected `%s` to be invalid", doc)
+		}
+	}
+}
+
+func TestTokenizeStrictLargeObject(t *testing.T) {
+	// Duplicate detection must not be quadratic in the number of keys
+	const numKeys = 50000
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"nested":{"a":1,"\u0062":2},`)
+	for i := 0; i < numKeys; i++ {
+		fmt.Fprintf(&buf, `"key%d":%d,`, i, i)
+	}
+	doc := buf.Bytes()
+
+	err := testTokenizeStrict(t, string(doc[:len(doc)-1])+"}")
+	if err != nil {
+		t.Errorf("expected large object to be valid, got: %s", err)
+	}
+
+	// The duplicate is escaped differently to the original key
+	err = testTokenizeStrict(t, string(doc)+`"key\u0031234":0}`)
+	if err == nil {
+		t.Errorf("expected duplicate key in large object to be invalid")
+	}
+
+	err = testTokenizeStrict(t, string(doc)+`"other":{"b":1,"b":2}}`)
+	if err == nil {
+		t.Errorf("expected duplicate key in nested object to be invalid")
+	}
+}
+
+func TestTokenizeStrictAfterSeek(t *testing.T) {
+	dataBytes := []byte(`{"a":[1,2],"b":3}`)
+
+	var tok jsonTokenizer
+	tok.SetStrict(true)
+	tok.Reset(dataBytes)
+
+	testTokenizedStep(t, &tok, tknObjectStart, "{")
+	testTokenizedStep(t, &tok, tknString, `"a"`)
+	testTokenizedStep(t, &tok, tknObjectKeyDelim, ":")
+
+	savedPos := tok.Position()
+	testTokenizedStep(t, &tok, tknArrayStart, "[")
+	testTokenizedStep(t, &tok, tknInteger, "1")
+
+	// Re-reading already validated tokens must not disturb the validator
+	tok.Seek(savedPos)
+	testTokenizedStep(t, &tok, tknArrayStart, "[")
+	testTokenizedStep(t, &tok, tknInteger, "1")
+	testTokenizedStep(t, &tok, tknListDelim, ",")
+	testTokenizedStep(t, &tok, tknInteger, "2")
+	testTokenizedStep(t, &tok, tknArrayEnd, "]")
+	testTokenizedStep(t, &tok, tknListDelim, ",")
+	testTokenizedStep(t, &tok, tknString, `"b"`)
+	testTokenizedStep(t, &tok, tknObjectKeyDelim, ":")
+	testTokenizedStep(t, &tok, tknInteger, "3")
+	testTokenizedStep(t, &tok, tknObjectEnd, "}")
+	testTokenizedStep(t, &tok, tknEnd, "")
+}
+
+func TestTokenizeStrictNoAllocs(t *testing.T) {
+	dataBytes, err := ioutil.ReadFile("testdata/people.json")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read test data file: %s", err))
+	}
+
+	var tok jsonTokenizer
+	tok.SetStrict(true)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		tok.Reset(dataBytes)
+		for {
+			token, _, _, err := tok.Step()
+			if err != nil {
+				panic(fmt.Sprintf("encountered stepping error: %s", err))
+			}
+
+			if token == tknEnd {
+				break
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("strict tokenizing allocated %f times per run", allocs)
+	}
+}
+
+func BenchmarkTokenizeStrict(b *testing.B) {
+	var tok jsonTokenizer
+	tok.SetStrict(true)
+
+	dataBytes, err := ioutil.ReadFile("testdata/people.json")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read test data file: %s", err))
+	}
+
+	b.SetBytes(int64(len(dataBytes)))
+	b.ResetTimer()
+
+	for j := 0; j < b.N; j++ {
+		tok.Reset(dataBytes)
+
+		for {
+			token, _, _, err := tok.Step()
+			if err != nil {
+				panic(fmt.Sprintf("encountered stepping error: %s", err))
+			}
+
+			if token == tknEnd {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"runtime"
+	"sync"
+)
+
+// BatchResult holds the outcome of matching a batch of documents.
+type BatchResult struct {
+	// Matched has the bit for a document set if the document matched the
+	// definition as a whole.
+	Matched Bitset
+
+	// Expressions holds one set per expression that the definition was
+	// compiled from, with the bit for a document set if the document
+	// matched that particular expression.
+	Expressions []Bitset
+}
+
+// MatchBatch matches every document in docs against def, splitting the
+// documents across the specified number of workers.  If workers is less
+// than 1, GOMAXPROCS workers are used.  Each worker owns a single matcher
+// which is reused for all of its documents, so no allocations are made
+// per document beyond those made by the matcher itself.
+//
+// If any document fails to match, the returned error slice holds the error
+// for each failing document at its index and nil for the rest.  Otherwise
+// the error slice is nil.
+func MatchBatch(def *MatchDef, docs [][]byte, workers int) (*BatchResult, []error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	result := &BatchResult{
+		Matched:     NewBitset(len(docs)),
+		Expressions: make([]Bitset, len(def.MatchBuckets)),
+	}
+	for i := range result.Expressions {
+		result.Expressions[i] = NewBitset(len(docs))
+	}
+
+	errs := make([]error, len(docs))
+
+	// Each worker receives a contiguous range of documents which is aligned
+	// to the word size of the bitsets, this lets the workers write results
+	// without needing to synchronize with each other.
+	chunkSize := (len(docs) + workers - 1) / workers
+	chunkSize = (chunkSize + 63) &^ 63
+
+	var failed bool
+	var failedLock sync.Mutex
+	var wg sync.WaitGroup
+	for start := 0; start < len(docs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+
+			m := NewFastMatcher(def)
+			workerFailed := false
+			for docIdx := start; docIdx < end; docIdx++ {
+				m.Reset()
+
+				matched, err := m.Match(docs[docIdx])
+				if err != nil {
+					errs[docIdx] = err
+					workerFailed = true
+					continue
+				}
+
+				if matched {
+					result.Matched.Set(docIdx)
+				}
+
+				for exprIdx := range result.Expressions {
+					if m.ExpressionMatched(exprIdx) {
+						result.Expressions[exprIdx].Set(docIdx)
+					}
+				}
+			}
+
+			if workerFailed {
+				failedLock.Lock()
+				failed = true
+				failedLock.Unlock()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if !failed {
+		return result, nil
+	}
+	return result, errs
+}
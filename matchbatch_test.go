@@ -0,0 +1,151 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+)
+
+func TestBitset(t *testing.T) {
+	set := NewBitset(130)
+	if set.Len() != 130 {
+		t.Fatalf("expected a length of 130, got %d", set.Len())
+	}
+
+	set.Set(0)
+	set.Set(63)
+	set.Set(64)
+	set.Set(129)
+	if set.Count() != 4 {
+		t.Errorf("expected 4 bits to be set, got %d", set.Count())
+	}
+	if !set.Test(63) || !set.Test(64) || set.Test(65) {
+		t.Errorf("bits were not set correctly")
+	}
+
+	var found []int
+	for i := set.NextSet(0); i != -1; i = set.NextSet(i + 1) {
+		found = append(found, i)
+	}
+	if len(found) != 4 || found[0] != 0 || found[1] != 63 || found[2] != 64 || found[3] != 129 {
+		t.Errorf("unexpected iteration results: %v", found)
+	}
+
+	set.Clear(63)
+	if set.Test(63) {
+		t.Errorf("bit 63 should have been cleared")
+	}
+
+	set.Reset()
+	if set.Count() != 0 {
+		t.Errorf("expected an empty set after reset")
+	}
+}
+
+func TestMatchBatch(t *testing.T) {
+	exprs := []Expression{
+		EqualsExpr{FieldExpr{0, []string{"eyeColor"}}, ValueExpr{"blue"}},
+		TrueExpr{},
+		LessThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{30}},
+		FalseExpr{},
+	}
+
+	var trans Transformer
+	def, err := trans.TransformE(exprs)
+	if err != nil {
+		t.Fatalf("failed to compile expressions: %s", err)
+	}
+
+	var docs [][]byte
+	for i := 0; i < 4; i++ {
+		docs = append(docs, getTestPeopleDocs()...)
+	}
+
+	// Build the expected results one document at a time
+	expected := make([][]bool, len(exprs))
+	expectedMatched := make([]bool, len(docs))
+	for i := range exprs {
+		expected[i] = make([]bool, len(docs))
+	}
+	for docIdx, doc := range docs {
+		m := NewFastMatcher(def)
+		expectedMatched[docIdx], err = m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match document: %s", err)
+		}
+		for i := range exprs {
+			expected[i][docIdx] = m.ExpressionMatched(i)
+		}
+	}
+
+	for _, workers := range []int{0, 1, 3, 16} {
+		result, errs := MatchBatch(def, docs, workers)
+		if errs != nil {
+			t.Fatalf("unexpected errors from batch: %v", errs)
+		}
+
+		for docIdx := range docs {
+			if result.Matched.Test(docIdx) != expectedMatched[docIdx] {
+				t.Errorf("workers %d: document %d overall result mismatch", workers, docIdx)
+			}
+			for i := range exprs {
+				if result.Expressions[i].Test(docIdx) != expected[i][docIdx] {
+					t.Errorf("workers %d: document %d expression %d mismatch", workers, docIdx, i)
+				}
+			}
+		}
+	}
+
+	result, _ := MatchBatch(def, docs, 2)
+	if result.Expressions[1].Count() != len(docs) {
+		t.Errorf("expected the true expression to match every document")
+	}
+	if result.Expressions[3].Count() != 0 {
+		t.Errorf("expected the false expression to match no documents")
+	}
+}
+
+func TestMatchBatchErrors(t *testing.T) {
+	var trans Transformer
+	def := trans.Transform([]Expression{
+		EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Frank"}},
+	})
+
+	docs := [][]byte{
+		[]byte(`{"name":"Frank"}`),
+		[]byte(`{"name" "Frank"}`),
+		[]byte(`{"name":"Bob"}`),
+	}
+
+	result, errs := MatchBatch(def, docs, 2)
+	if len(errs) != len(docs) || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("unexpected batch errors: %v", errs)
+	}
+	if !result.Matched.Test(0) || result.Matched.Test(1) || result.Matched.Test(2) {
+		t.Errorf("unexpected batch results")
+	}
+}
+
+func TestMatchBatchNoPerDocumentAllocs(t *testing.T) {
+	var trans Transformer
+	def := trans.Transform([]Expression{
+		EqualsExpr{FieldExpr{0, []string{"isActive"}}, ValueExpr{true}},
+	})
+
+	docs := getTestPeopleDocs()
+	var moreDocs [][]byte
+	for i := 0; i < 10; i++ {
+		moreDocs = append(moreDocs, docs...)
+	}
+
+	fewAllocs := testing.AllocsPerRun(5, func() {
+		MatchBatch(def, docs, 1)
+	})
+	manyAllocs := testing.AllocsPerRun(5, func() {
+		MatchBatch(def, moreDocs, 1)
+	})
+
+	if manyAllocs != fewAllocs {
+		t.Errorf("allocations grew with the number of documents: %f vs %f", fewAllocs, manyAllocs)
+	}
+}
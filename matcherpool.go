@@ -0,0 +1,115 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MatcherPool hands out FastMatchers for a single compiled MatchDef so that
+// the definition can be shared by many goroutines.  A FastMatcher holds
+// mutable matching state and must only be used by one goroutine at a time,
+// the pool takes care of giving each caller its own matcher.
+type MatcherPool struct {
+	def    *MatchDef
+	strict bool
+	pool   sync.Pool
+}
+
+// NewMatcherPool creates a pool of matchers for def.  The definition must not
+// be modified while the pool is in use.
+func NewMatcherPool(def *MatchDef) *MatcherPool {
+	pool := &MatcherPool{
+		def: def,
+	}
+	pool.pool.New = func() interface{} {
+		m := NewFastMatcher(pool.def)
+		m.SetStrictMode(pool.strict)
+		return m
+	}
+	return pool
+}
+
+// SetStrictMode sets the strict mode of every matcher subsequently handed
+// out by the pool.  It must not be called concurrently with Get or Match.
+func (p *MatcherPool) SetStrictMode(strict bool) {
+	p.strict = strict
+}
+
+// Get returns a matcher for the pool's definition that is ready for use.
+// The matcher should be returned with Put once the caller is done with it.
+func (p *MatcherPool) Get() *FastMatcher {
+	m := p.pool.Get().(*FastMatcher)
+	m.SetStrictMode(p.strict)
+	return m
+}
+
+// Put resets a matcher obtained from Get and returns it to the pool.  The
+// matcher must not be used again after it has been returned.
+func (p *MatcherPool) Put(m *FastMatcher) {
+	m.Reset()
+	p.pool.Put(m)
+}
+
+// Match matches data against the pool's definition using a pooled matcher.
+// It is safe to call from multiple goroutines at once.
+func (p *MatcherPool) Match(data []byte) (bool, error) {
+	m := p.Get()
+	matched, err := m.Match(data)
+	p.Put(m)
+	return matched, err
+}
+
+// MatchWithStatus is the pooled equivalent of FastMatcher.MatchWithStatus.
+func (p *MatcherPool) MatchWithStatus(data []byte) (bool, int, error) {
+	m := p.Get()
+	matched, status, err := m.MatchWithStatus(data)
+	p.Put(m)
+	return matched, status, err
+}
+
+// Def returns the definition that the pool's matchers match against.
+func (p *MatcherPool) Def() *MatchDef {
+	return p.def
+}
+
+// SwappableMatcher matches documents against a definition which can be
+// atomically replaced at any time.  Matches which are in progress when the
+// definition is swapped finish against the definition they started with,
+// and all subsequent matches use the new definition.
+type SwappableMatcher struct {
+	current atomic.Value
+}
+
+// NewSwappableMatcher creates a SwappableMatcher for def.
+func NewSwappableMatcher(def *MatchDef) *SwappableMatcher {
+	s := &SwappableMatcher{}
+	s.Swap(def)
+	return s
+}
+
+// Swap replaces the current definition with def.
+func (s *SwappableMatcher) Swap(def *MatchDef) {
+	s.current.Store(NewMatcherPool(def))
+}
+
+// Current returns the pool for the current definition.  Callers which need
+// to match several documents against one consistent definition, or inspect
+// ExpressionMatched results, should take matchers from this pool.
+func (s *SwappableMatcher) Current() *MatcherPool {
+	return s.current.Load().(*MatcherPool)
+}
+
+func (s *SwappableMatcher) Match(data []byte) (bool, error) {
+	return s.Current().Match(data)
+}
+
+func (s *SwappableMatcher) MatchWithStatus(data []byte) (bool, int, error) {
+	return s.Current().MatchWithStatus(data)
+}
+
+// Reset is a no-op, matchers are reset automatically as they are returned
+// to their pool.  It is provided to satisfy the Matcher interface.
+func (s *SwappableMatcher) Reset() {
+}
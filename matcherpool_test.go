@@ -0,0 +1,134 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestMatcherPoolConcurrent(t *testing.T) {
+	exprs := []string{
+		`["anyin", 1, ["field", "tags"], ["equals", ["field", 1], ["value", "jewels"]]]`,
+		`["anyin", 1, ["field", "friends"], ["equals", ["field", 1, "id"], ["field", "index"]]]`,
+		`["lessthan", ["field", "balance"], ["value", 2000]]`,
+		`["greaterthan", ["field", "name"], ["value", 1.5]]`,
+	}
+	docs := getTestPeopleDocs()
+
+	for _, data := range exprs {
+		expr, err := ParseJsonExpression([]byte(data))
+		if err != nil {
+			t.Fatalf("failed to parse expression: %s", err)
+		}
+
+		var trans Transformer
+		def, err := trans.TransformE([]Expression{expr})
+		if err != nil {
+			t.Fatalf("failed to compile expression: %s", err)
+		}
+
+		expected := make([]bool, len(docs))
+		for i, doc := range docs {
+			m := NewFastMatcher(def)
+			expected[i], err = m.Match(doc)
+			if err != nil {
+				t.Fatalf("failed to match document: %s", err)
+			}
+		}
+
+		pool := NewMatcherPool(def)
+
+		var wg sync.WaitGroup
+		errCh := make(chan string, 8)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for iter := 0; iter < 10; iter++ {
+					for i, doc := range docs {
+						matched, err := pool.Match(doc)
+						if err != nil || matched != expected[i] {
+							errCh <- data
+							return
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errCh)
+
+		for failed := range errCh {
+			t.Errorf("concurrent pooled matching disagreed with serial matching for %s", failed)
+		}
+	}
+}
+
+func TestMatcherPoolResetsMatchers(t *testing.T) {
+	expr, err := ParseJsonExpression([]byte(`["equals", ["field", "name"], ["value", "Frank"]]`))
+	if err != nil {
+		t.Fatalf("failed to parse expression: %s", err)
+	}
+
+	var trans Transformer
+	pool := NewMatcherPool(trans.Transform([]Expression{expr}))
+
+	m := pool.Get()
+	matched, _ := m.Match([]byte(`{"name":"Frank"}`))
+	if !matched {
+		t.Fatalf("expected the first document to match")
+	}
+	pool.Put(m)
+
+	matched, err = pool.Match([]byte(`{"name":"Bob"}`))
+	if matched || err != nil {
+		t.Errorf("expected the second document not to match, got %t, %v", matched, err)
+	}
+
+	pool.SetStrictMode(true)
+	_, err = pool.Match([]byte(`{"name":"Frank"} junk`))
+	if err == nil {
+		t.Errorf("expected strict pooled matcher to reject trailing data")
+	}
+}
+
+func TestFastValConcurrentNumericStringCompare(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				num := int64(g*1000 + i)
+				str := NewStringFastVal(fmt.Sprintf("%d", num))
+				result, valid := str.compareStrings(NewIntFastVal(num))
+				if !valid || result != 0 {
+					t.Errorf("expected %s to compare equal to %d", str, num)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestFastValMatchNoAllocs(t *testing.T) {
+	val := NewJsonStringFastVal([]byte("Neil Armstrong"))
+	regex := NewFastVal(regexp.MustCompile("^Ne[a|i]l"))
+
+	// Warm up any state cached by the regex
+	val.Matches(regex)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		matched, valid := val.Matches(regex)
+		if !matched || !valid {
+			panic("expected the regex to match")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("regex matching allocated %f times per run", allocs)
+	}
+}
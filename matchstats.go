@@ -0,0 +1,204 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MatchStats holds the number of times each bucket of a MatchDef has been
+// found to be true or false, as collected by FastMatcher.SetSampling.  The
+// result of a bucket within a loop is recorded once for every element, and
+// the result of the loop as a whole against the loop node of the tree.
+type MatchStats struct {
+	NumSampled int
+	Hits       []uint64
+	Misses     []uint64
+}
+
+// NewMatchStats creates an empty set of statistics for a definition.
+func NewMatchStats(def *MatchDef) *MatchStats {
+	stats := &MatchStats{}
+	stats.ensureBuckets(len(def.MatchTree.data))
+	return stats
+}
+
+func (stats *MatchStats) ensureBuckets(numBuckets int) {
+	for len(stats.Hits) < numBuckets {
+		stats.Hits = append(stats.Hits, 0)
+		stats.Misses = append(stats.Misses, 0)
+	}
+}
+
+func (stats *MatchStats) record(bucketIdx int, result bool) {
+	if result {
+		stats.Hits[bucketIdx]++
+	} else {
+		stats.Misses[bucketIdx]++
+	}
+}
+
+// Reset discards all of the collected statistics.
+func (stats *MatchStats) Reset() {
+	stats.NumSampled = 0
+	for i := range stats.Hits {
+		stats.Hits[i] = 0
+		stats.Misses[i] = 0
+	}
+}
+
+// Merge adds the statistics collected in other, which must have been
+// collected against the same definition.
+func (stats *MatchStats) Merge(other *MatchStats) {
+	stats.ensureBuckets(len(other.Hits))
+	stats.NumSampled += other.NumSampled
+	for i := range other.Hits {
+		stats.Hits[i] += other.Hits[i]
+		stats.Misses[i] += other.Misses[i]
+	}
+}
+
+// Selectivity returns the fraction of the recorded results of a bucket
+// which were true.  It returns false if no results have been recorded.
+func (stats *MatchStats) Selectivity(bucketIdx BucketID) (float64, bool) {
+	if int(bucketIdx) >= len(stats.Hits) {
+		return 0, false
+	}
+
+	hits := stats.Hits[bucketIdx]
+	total := hits + stats.Misses[bucketIdx]
+	if total == 0 {
+		return 0, false
+	}
+	return float64(hits) / float64(total), true
+}
+
+func (stats *MatchStats) String() string {
+	var out string
+	out += fmt.Sprintf("num sampled: %d\n", stats.NumSampled)
+	for i := range stats.Hits {
+		if stats.Hits[i] == 0 && stats.Misses[i] == 0 {
+			continue
+		}
+		out += fmt.Sprintf("  %d: %d hits, %d misses\n", i, stats.Hits[i], stats.Misses[i])
+	}
+	return out
+}
+
+// shortCircuitChance returns the observed probability that the result of a
+// bucket decides the result of its parent, so that the other children of
+// the parent no longer need to be matched.  Buckets without any recorded
+// results are assumed to decide their parent half of the time.
+func (stats *MatchStats) shortCircuitChance(tree *binTree, bucketIdx BucketID) float64 {
+	selectivity, ok := stats.Selectivity(bucketIdx)
+	if !ok {
+		return 0.5
+	}
+	return bucketShortCircuitChance(tree, bucketIdx, selectivity)
+}
+
+func (stats *MatchStats) opRank(tree *binTree, op *OpNode) float64 {
+	chance := stats.shortCircuitChance(tree, op.BucketIdx)
+	for _, bucketIdx := range op.SharedBuckets {
+		sharedChance := stats.shortCircuitChance(tree, bucketIdx)
+		if sharedChance > chance {
+			chance = sharedChance
+		}
+	}
+
+	if chance < minShortCircuitChance {
+		chance = minShortCircuitChance
+	}
+	return staticOpCost(op) / chance
+}
+
+func (stats *MatchStats) loopRank(tree *binTree, loop *LoopNode) float64 {
+	// The bucket of a LoopNode is the body of the loop, the result of the
+	// loop as a whole belongs to its parent.
+	loopIdx := BucketID(tree.data[loop.BucketIdx].ParentIdx)
+	chance := stats.shortCircuitChance(tree, loopIdx)
+	if chance < minShortCircuitChance {
+		chance = minShortCircuitChance
+	}
+	return staticLoopCost / chance
+}
+
+// ReoptimizeMatchDef returns a copy of def with the ops and loops of every
+// node reordered so that those observed to most often decide the result of
+// their parent expression are matched first.  Buckets are left unchanged,
+// so the same statistics can continue to be collected against the new
+// definition.  The original definition is not modified.
+func ReoptimizeMatchDef(def *MatchDef, stats *MatchStats) *MatchDef {
+	newDef := *def
+	newDef.ParseNode = stats.reoptimizeNode(&def.MatchTree, def.ParseNode)
+	return &newDef
+}
+
+func (stats *MatchStats) reoptimizeLoops(tree *binTree, loops []LoopNode) []LoopNode {
+	if loops == nil {
+		return nil
+	}
+
+	newLoops := make([]LoopNode, len(loops))
+	ranks := make([]float64, len(loops))
+	for i, loop := range loops {
+		loop.Node = stats.reoptimizeNode(tree, loop.Node)
+		newLoops[i] = loop
+		ranks[i] = stats.loopRank(tree, &loop)
+	}
+
+	sort.Stable(loopSorter{newLoops, ranks})
+	return newLoops
+}
+
+func (stats *MatchStats) reoptimizeOps(tree *binTree, ops []OpNode) []OpNode {
+	if ops == nil {
+		return nil
+	}
+
+	newOps := append([]OpNode(nil), ops...)
+	sortOps(newOps, func(op *OpNode) float64 {
+		return stats.opRank(tree, op)
+	})
+	return newOps
+}
+
+func (stats *MatchStats) reoptimizeNode(tree *binTree, node *ExecNode) *ExecNode {
+	if node == nil {
+		return nil
+	}
+
+	newNode := &ExecNode{
+		StoreId: node.StoreId,
+		Ops:     stats.reoptimizeOps(tree, node.Ops),
+		Loops:   stats.reoptimizeLoops(tree, node.Loops),
+	}
+
+	if node.Elems != nil {
+		newNode.Elems = make(map[string]*ExecNode, len(node.Elems))
+		for key, elem := range node.Elems {
+			newNode.Elems[key] = stats.reoptimizeNode(tree, elem)
+		}
+	}
+
+	if node.After != nil {
+		newNode.After = &AfterNode{
+			Ops:   stats.reoptimizeOps(tree, node.After.Ops),
+			Loops: stats.reoptimizeLoops(tree, node.After.Loops),
+		}
+		if node.After.Elems != nil {
+			newNode.After.Elems = make(map[string]*ExecNode, len(node.After.Elems))
+			for key, elem := range node.After.Elems {
+				newNode.After.Elems[key] = stats.reoptimizeNode(tree, elem)
+			}
+		}
+	}
+
+	// The index refers to ops by their position, so must be rebuilt
+	if node.Index != nil {
+		newNode.Index = newEqualsIndex(newNode.Ops, 1)
+	}
+
+	return newNode
+}
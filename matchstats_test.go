@@ -0,0 +1,143 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tSampleMatchDef(t *testing.T, def *MatchDef, interval int) *MatchStats {
+	t.Helper()
+
+	stats := NewMatchStats(def)
+	m := NewFastMatcher(def)
+	m.SetSampling(stats, interval)
+	for _, doc := range getTestPeopleDocs() {
+		m.Reset()
+		_, err := m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+	}
+	return stats
+}
+
+func tCheckSameMatches(t *testing.T, exprs []Expression, def, newDef *MatchDef) {
+	t.Helper()
+
+	m := NewFastMatcher(def)
+	newM := NewFastMatcher(newDef)
+	for docIdx, doc := range getTestPeopleDocs() {
+		m.Reset()
+		expected, err := m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		newM.Reset()
+		matched, err := newM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		if matched != expected {
+			t.Fatalf("document %d: matched %t, expected %t", docIdx, matched, expected)
+		}
+		for exprIdx := range exprs {
+			if newM.ExpressionMatched(exprIdx) != m.ExpressionMatched(exprIdx) {
+				t.Fatalf("document %d: expression %d mismatched", docIdx, exprIdx)
+			}
+		}
+	}
+}
+
+func TestMatchStatsSampling(t *testing.T) {
+	expr := tParseFilterExpression(t, `eyeColor = "brown" AND gender = "female"`)
+
+	var trans Transformer
+	def := trans.Transform([]Expression{expr})
+	brownIdx := def.ParseNode.Elems["eyeColor"].Ops[0].BucketIdx
+
+	var numBrown uint64
+	var brownTrans Transformer
+	brownM := NewFastMatcher(brownTrans.Transform([]Expression{
+		tParseFilterExpression(t, `eyeColor = "brown"`),
+	}))
+	for _, doc := range getTestPeopleDocs() {
+		brownM.Reset()
+		matched, err := brownM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+		if matched {
+			numBrown++
+		}
+	}
+
+	numDocs := len(getTestPeopleDocs())
+	stats := tSampleMatchDef(t, def, 1)
+	assert.Equal(t, numDocs, stats.NumSampled)
+	assert.Equal(t, numBrown, stats.Hits[brownIdx])
+	assert.Equal(t, uint64(numDocs)-numBrown, stats.Misses[brownIdx])
+
+	selectivity, ok := stats.Selectivity(brownIdx)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(numBrown)/float64(numDocs), selectivity, 0.0001)
+
+	stats = tSampleMatchDef(t, def, 3)
+	assert.Equal(t, (numDocs+2)/3, stats.NumSampled)
+
+	merged := NewMatchStats(def)
+	merged.Merge(stats)
+	merged.Merge(stats)
+	assert.Equal(t, 2*stats.NumSampled, merged.NumSampled)
+	assert.Equal(t, 2*stats.Misses[brownIdx], merged.Misses[brownIdx])
+
+	merged.Reset()
+	assert.Equal(t, 0, merged.NumSampled)
+	_, ok = merged.Selectivity(brownIdx)
+	assert.False(t, ok)
+}
+
+func TestReoptimizeMatchDef(t *testing.T) {
+	exprs := []Expression{
+		tParseFilterExpression(t, `age > 0 AND age < 0`),
+		AndExpr{
+			AnyInExpr{1, FieldExpr{0, []string{"friends"}},
+				GreaterEqualsExpr{FieldExpr{1, []string{"id"}}, ValueExpr{0}}},
+			AnyInExpr{2, FieldExpr{0, []string{"friends"}},
+				LessThanExpr{FieldExpr{2, []string{"id"}}, ValueExpr{0}}},
+		},
+		tParseFilterExpression(t, `eyeColor = "brown" OR eyeColor = "blue" OR eyeColor = "green"`),
+	}
+
+	trans := Transformer{EqualsIndexMinOps: 2}
+	def := trans.Transform(exprs)
+	ageOps := def.ParseNode.Elems["age"].Ops
+	friendLoops := def.ParseNode.Elems["friends"].Loops
+
+	stats := tSampleMatchDef(t, def, 1)
+	newDef := ReoptimizeMatchDef(def, stats)
+
+	// The comparisons which are never true decide the AND, so come first
+	newAgeOps := newDef.ParseNode.Elems["age"].Ops
+	assert.Equal(t, ageOps[1].BucketIdx, newAgeOps[0].BucketIdx)
+	assert.Equal(t, ageOps[0].BucketIdx, newAgeOps[1].BucketIdx)
+
+	newFriendLoops := newDef.ParseNode.Elems["friends"].Loops
+	assert.Equal(t, friendLoops[1].BucketIdx, newFriendLoops[0].BucketIdx)
+	assert.Equal(t, friendLoops[0].BucketIdx, newFriendLoops[1].BucketIdx)
+
+	// The original definition must be left alone
+	assert.Equal(t, ageOps[0].BucketIdx, def.ParseNode.Elems["age"].Ops[0].BucketIdx)
+
+	assert.NotNil(t, newDef.ParseNode.Elems["eyeColor"].Index)
+	tCheckSameMatches(t, exprs, def, newDef)
+
+	// Comparisons which no longer need to be performed are skipped
+	newStats := tSampleMatchDef(t, newDef, 1)
+	assert.NotZero(t, stats.Hits[ageOps[0].BucketIdx])
+	assert.Zero(t, newStats.Hits[ageOps[0].BucketIdx]+newStats.Misses[ageOps[0].BucketIdx])
+}
@@ -0,0 +1,356 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import "sort"
+
+// Estimator provides the estimates used to order predicates so that those
+// most likely to short-circuit the rest of an expression are matched first.
+type Estimator interface {
+	// Cost returns the relative cost of matching an expression.
+	Cost(expr Expression) float64
+
+	// Selectivity returns the estimated fraction of documents for which an
+	// expression is true, between 0 and 1.
+	Selectivity(expr Expression) float64
+}
+
+// StaticEstimator estimates the cost and selectivity of an expression from
+// its structure alone.  Comparisons against constants are cheap, regular
+// expressions and functions are more expensive and loops are the most
+// expensive of all.
+type StaticEstimator struct{}
+
+const (
+	staticCompareCost = 1.0
+	staticRegexCost   = 10.0
+	staticFuncCost    = 2.0
+	staticLoopCost    = 20.0
+
+	// Loops are assumed to run their body against this many elements.
+	staticLoopItems = 10.0
+)
+
+func (est StaticEstimator) Cost(expr Expression) float64 {
+	switch expr := expr.(type) {
+	case RegexExpr, PcreExpr:
+		return staticRegexCost
+	case LikePatternExpr:
+		if matcher, err := NewLikeMatcher(expr.Pattern, expr.Escape); err == nil && matcher.usesRegex() {
+			return staticRegexCost
+		}
+		return 0
+	case FuncExpr:
+		cost := staticFuncCost
+		for _, param := range expr.Params {
+			cost += est.Cost(param)
+		}
+		return cost
+	case ExistsExpr, NotExistsExpr:
+		return staticCompareCost
+	case AnyInExpr:
+		return staticLoopCost + est.Cost(expr.InExpr) + staticLoopItems*est.Cost(expr.SubExpr)
+	case EveryInExpr:
+		return staticLoopCost + est.Cost(expr.InExpr) + staticLoopItems*est.Cost(expr.SubExpr)
+	case AnyEveryInExpr:
+		return staticLoopCost + est.Cost(expr.InExpr) + staticLoopItems*est.Cost(expr.SubExpr)
+	}
+
+	children, _ := exprChildren(expr)
+	var cost float64
+	for _, child := range children {
+		cost += est.Cost(child)
+	}
+
+	switch expr.(type) {
+	case EqualsExpr, NotEqualsExpr, LessThanExpr, LessEqualsExpr,
+		GreaterThanExpr, GreaterEqualsExpr, LikeExpr, InExpr:
+		cost += staticCompareCost
+	case BetweenExpr:
+		cost += 2 * staticCompareCost
+	}
+	return cost
+}
+
+func (est StaticEstimator) Selectivity(expr Expression) float64 {
+	switch expr := expr.(type) {
+	case TrueExpr:
+		return 1
+	case FalseExpr:
+		return 0
+	case NotExpr:
+		return 1 - est.Selectivity(expr.SubExpr)
+	case AndExpr:
+		sel := 1.0
+		for _, subExpr := range expr {
+			sel *= est.Selectivity(subExpr)
+		}
+		return sel
+	case OrExpr:
+		notSel := 1.0
+		for _, subExpr := range expr {
+			notSel *= 1 - est.Selectivity(subExpr)
+		}
+		return 1 - notSel
+	case EqualsExpr:
+		return 0.1
+	case NotEqualsExpr:
+		return 0.9
+	case LessThanExpr, LessEqualsExpr, GreaterThanExpr, GreaterEqualsExpr:
+		return 0.3
+	case BetweenExpr:
+		// The same as an AND of its two comparisons
+		return 0.3 * 0.3
+	case LikeExpr:
+		return 0.25
+	case InExpr:
+		sel := 0.1 * float64(len(expr.Values))
+		if sel > 1 {
+			return 1
+		}
+		return sel
+	case ExistsExpr:
+		return 0.9
+	case NotExistsExpr:
+		return 0.1
+	case AnyEveryInExpr:
+		return 0.25
+	}
+	return 0.5
+}
+
+// The smallest probability used when ranking, so that predicates which are
+// never expected to short-circuit still have a finite rank.
+const minShortCircuitChance = 0.001
+
+// shortCircuitRank returns the expected cost of matching a sub-expression
+// for every time it decides the result of its parent.  A sub-expression of
+// an AND decides the result when it is false, and of an OR when it is true.
+func shortCircuitRank(est Estimator, expr Expression, isAnd bool) float64 {
+	chance := est.Selectivity(expr)
+	if isAnd {
+		chance = 1 - chance
+	}
+	if chance < minShortCircuitChance {
+		chance = minShortCircuitChance
+	}
+	return est.Cost(expr) / chance
+}
+
+// exprHasLoop reports whether an expression contains a loop anywhere within
+// it.
+func exprHasLoop(expr Expression) bool {
+	switch expr.(type) {
+	case AnyInExpr, EveryInExpr, AnyEveryInExpr:
+		return true
+	}
+
+	children, _ := exprChildren(expr)
+	for _, child := range children {
+		if exprHasLoop(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func reorderTerms(est Estimator, terms []Expression, isAnd bool) []Expression {
+	ranks := make([]float64, len(terms))
+	hasLoop := make([]bool, len(terms))
+	for i, term := range terms {
+		ranks[i] = shortCircuitRank(est, term, isAnd)
+		hasLoop[i] = exprHasLoop(term)
+	}
+
+	// Terms containing loops always come after those which do not,
+	// regardless of their estimates, as they may scan whole arrays.
+	idxs := make([]int, len(terms))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		if hasLoop[idxs[i]] != hasLoop[idxs[j]] {
+			return !hasLoop[idxs[i]]
+		}
+		return ranks[idxs[i]] < ranks[idxs[j]]
+	})
+
+	out := make([]Expression, len(terms))
+	for i, idx := range idxs {
+		out[i] = terms[idx]
+	}
+	return out
+}
+
+// ReorderExpression returns an expression which matches the same documents
+// as expr, but with the sub-expressions of every AND and OR reordered so
+// that the cheapest and most likely to decide the result come first, with
+// any containing loops last.  Where estimates are equal, the original order
+// is kept.
+func ReorderExpression(expr Expression, est Estimator) Expression {
+	return Rewrite(expr, func(expr Expression) Expression {
+		switch expr := expr.(type) {
+		case AndExpr:
+			return AndExpr(reorderTerms(est, expr, true))
+		case OrExpr:
+			return OrExpr(reorderTerms(est, expr, false))
+		}
+		return expr
+	})
+}
+
+func staticDataRefCost(ref DataRef) float64 {
+	switch ref := ref.(type) {
+	case FuncRef:
+		cost := staticFuncCost
+		for _, param := range ref.Params {
+			cost += staticDataRefCost(param)
+		}
+		return cost
+	case RangeRef:
+		return staticDataRefCost(ref.Low) + staticDataRefCost(ref.High)
+	case FastVal:
+		if ref.Type() == RegexValue || ref.Type() == PcreValue {
+			return staticRegexCost
+		}
+		if ref.Type() == LikeValue && ref.data.(*LikeMatcher).usesRegex() {
+			return staticRegexCost
+		}
+	}
+	return 0
+}
+
+// staticOpCost estimates the cost of performing an op.
+func staticOpCost(op *OpNode) float64 {
+	cost := staticCompareCost + staticDataRefCost(op.Lhs) + staticDataRefCost(op.Rhs)
+	if op.Op == OpTypeBetween {
+		// Compares against each of its two bounds
+		cost += staticCompareCost
+	}
+	return cost
+}
+
+// bucketShortCircuitChance returns the probability that the result of a
+// bucket decides the result of its parent, so that the other children of
+// the parent no longer need to be matched, given the probability that the
+// bucket is true.
+func bucketShortCircuitChance(tree *binTree, bucketIdx BucketID, selectivity float64) float64 {
+	// Find the parent which the bucket can resolve, and whether it is a
+	// true or false result from the bucket which does so.
+	decidedByTrue := true
+	idx := int(bucketIdx)
+	for {
+		if idx == 0 {
+			// The whole expression is resolved either way
+			return 1
+		}
+
+		parentIdx := tree.data[idx].ParentIdx
+		switch tree.data[parentIdx].NodeType {
+		case nodeTypeAnd:
+			if decidedByTrue {
+				return 1 - selectivity
+			}
+			return selectivity
+		case nodeTypeOr:
+			if decidedByTrue {
+				return selectivity
+			}
+			return 1 - selectivity
+		case nodeTypeNot:
+			decidedByTrue = !decidedByTrue
+			idx = parentIdx
+		case nodeTypeLoop:
+			// Each iteration of the loop is resolved either way
+			return 1
+		default:
+			return 0
+		}
+	}
+}
+
+// estimatedOpRank returns the estimated cost of performing an op for every
+// time it decides the result of its parent, using the Estimator to rank the
+// comparison which the op was compiled from.  An op shared by several
+// buckets is ranked by the bucket it is most likely to decide.
+func (t *Transformer) estimatedOpRank(op *OpNode) float64 {
+	expr, ok := t.opExprs[op.BucketIdx]
+	if !ok {
+		return staticOpCost(op)
+	}
+
+	chance := bucketShortCircuitChance(&t.RootTree, op.BucketIdx, t.Estimator.Selectivity(expr))
+	for _, bucketIdx := range op.SharedBuckets {
+		sharedExpr, ok := t.opExprs[bucketIdx]
+		if !ok {
+			continue
+		}
+		sharedChance := bucketShortCircuitChance(&t.RootTree, bucketIdx, t.Estimator.Selectivity(sharedExpr))
+		if sharedChance > chance {
+			chance = sharedChance
+		}
+	}
+
+	if chance < minShortCircuitChance {
+		chance = minShortCircuitChance
+	}
+	return t.Estimator.Cost(expr) / chance
+}
+
+func sortOps(ops []OpNode, rank func(op *OpNode) float64) {
+	ranks := make([]float64, len(ops))
+	for i := range ops {
+		ranks[i] = rank(&ops[i])
+	}
+	sort.Stable(opSorter{ops, ranks})
+}
+
+type opSorter struct {
+	ops   []OpNode
+	ranks []float64
+}
+
+func (s opSorter) Len() int           { return len(s.ops) }
+func (s opSorter) Less(i, j int) bool { return s.ranks[i] < s.ranks[j] }
+func (s opSorter) Swap(i, j int) {
+	s.ops[i], s.ops[j] = s.ops[j], s.ops[i]
+	s.ranks[i], s.ranks[j] = s.ranks[j], s.ranks[i]
+}
+
+// reorderExecOps sorts the ops of every node beneath node by rank, lowest
+// first.  The order of ops with the same rank is kept.
+func reorderExecOps(node *ExecNode, rank func(op *OpNode) float64) {
+	if node == nil {
+		return
+	}
+
+	sortOps(node.Ops, rank)
+
+	for _, elem := range node.Elems {
+		reorderExecOps(elem, rank)
+	}
+	for _, loop := range node.Loops {
+		reorderExecOps(loop.Node, rank)
+	}
+	if node.After != nil {
+		sortOps(node.After.Ops, rank)
+		for _, elem := range node.After.Elems {
+			reorderExecOps(elem, rank)
+		}
+		for _, loop := range node.After.Loops {
+			reorderExecOps(loop.Node, rank)
+		}
+	}
+}
+
+type loopSorter struct {
+	loops []LoopNode
+	ranks []float64
+}
+
+func (s loopSorter) Len() int           { return len(s.loops) }
+func (s loopSorter) Less(i, j int) bool { return s.ranks[i] < s.ranks[j] }
+func (s loopSorter) Swap(i, j int) {
+	s.loops[i], s.loops[j] = s.loops[j], s.loops[i]
+	s.ranks[i], s.ranks[j] = s.ranks[j], s.ranks[i]
+}
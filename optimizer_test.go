@@ -0,0 +1,170 @@
+// Copyright 2018-2019 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderExpression(t *testing.T) {
+	name := FieldExpr{0, []string{"name"}}
+	isMale := EqualsExpr{FieldExpr{0, []string{"gender"}}, ValueExpr{"male"}}
+	notBlue := NotEqualsExpr{FieldExpr{0, []string{"eyeColor"}}, ValueExpr{"blue"}}
+	isNeil := LikeExpr{name, RegexExpr{"^Neil"}}
+	hasKim := AnyInExpr{1, FieldExpr{0, []string{"friends"}},
+		EqualsExpr{FieldExpr{1, []string{"name"}}, ValueExpr{"Kim"}}}
+	isOld := GreaterThanExpr{FieldExpr{0, []string{"age"}}, ValueExpr{50}}
+
+	tests := []struct {
+		expr     Expression
+		expected Expression
+	}{
+		{AndExpr{hasKim, isNeil, isMale}, AndExpr{isMale, isNeil, hasKim}},
+		{OrExpr{isMale, notBlue}, OrExpr{notBlue, isMale}},
+		{AndExpr{isMale, notBlue}, AndExpr{isMale, notBlue}},
+		{AndExpr{isOld, isMale}, AndExpr{isMale, isOld}},
+		{OrExpr{hasKim, notBlue}, OrExpr{notBlue, hasKim}},
+		{NotExpr{AndExpr{isNeil, isMale}}, NotExpr{AndExpr{isMale, isNeil}}},
+		{AnyInExpr{1, FieldExpr{0, []string{"friends"}}, AndExpr{isNeil, isMale}},
+			AnyInExpr{1, FieldExpr{0, []string{"friends"}}, AndExpr{isMale, isNeil}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr.String(), func(t *testing.T) {
+			reordered := ReorderExpression(test.expr, StaticEstimator{})
+			if !ExprEqual(reordered, test.expected) {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.expected, reordered)
+			}
+			assert.True(t, ExprEqualCommutative(reordered, test.expr))
+		})
+	}
+}
+
+// tRegexFirstEstimator inverts the static costs of regular expressions and
+// equality comparisons, and treats loops as free.
+type tRegexFirstEstimator struct {
+	StaticEstimator
+}
+
+func (est tRegexFirstEstimator) Cost(expr Expression) float64 {
+	switch expr := expr.(type) {
+	case LikeExpr:
+		return 0.1
+	case EqualsExpr:
+		return staticRegexCost
+	case AnyInExpr, EveryInExpr, AnyEveryInExpr:
+		return 0
+	case AndExpr, OrExpr, NotExpr:
+		var cost float64
+		children, _ := exprChildren(expr)
+		for _, child := range children {
+			cost += est.Cost(child)
+		}
+		return cost
+	}
+	return est.StaticEstimator.Cost(expr)
+}
+
+func TestReorderExpressionLoopsLast(t *testing.T) {
+	isMale := EqualsExpr{FieldExpr{0, []string{"gender"}}, ValueExpr{"male"}}
+	isNeil := LikeExpr{FieldExpr{0, []string{"name"}}, RegexExpr{"^Neil"}}
+	hasKim := AnyInExpr{1, FieldExpr{0, []string{"friends"}},
+		EqualsExpr{FieldExpr{1, []string{"name"}}, ValueExpr{"Kim"}}}
+	notKim := NotExpr{hasKim}
+
+	tests := []struct {
+		expr     Expression
+		expected Expression
+	}{
+		{AndExpr{hasKim, isMale, isNeil}, AndExpr{isNeil, isMale, hasKim}},
+		{OrExpr{notKim, isMale}, OrExpr{isMale, notKim}},
+		{AndExpr{isMale, OrExpr{hasKim, isNeil}}, AndExpr{isMale, OrExpr{isNeil, hasKim}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr.String(), func(t *testing.T) {
+			reordered := ReorderExpression(test.expr, tRegexFirstEstimator{})
+			if !ExprEqual(reordered, test.expected) {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.expected, reordered)
+			}
+		})
+	}
+}
+
+func TestStaticEstimator(t *testing.T) {
+	est := StaticEstimator{}
+	name := FieldExpr{0, []string{"name"}}
+	isNeil := EqualsExpr{name, ValueExpr{"Neil"}}
+	likeNeil := LikeExpr{name, RegexExpr{"^Neil"}}
+	hasKim := AnyInExpr{1, FieldExpr{0, []string{"friends"}}, isNeil}
+
+	assert.True(t, est.Cost(isNeil) < est.Cost(likeNeil))
+	assert.True(t, est.Cost(likeNeil) < est.Cost(hasKim))
+	assert.Equal(t, 1.0, est.Selectivity(TrueExpr{}))
+	assert.Equal(t, 0.0, est.Selectivity(FalseExpr{}))
+	assert.InDelta(t, 0.9, est.Selectivity(NotExpr{isNeil}), 0.0001)
+	assert.InDelta(t, 0.01, est.Selectivity(AndExpr{isNeil, isNeil}), 0.0001)
+	assert.InDelta(t, 0.19, est.Selectivity(OrExpr{isNeil, isNeil}), 0.0001)
+}
+
+func TestTransformEstimator(t *testing.T) {
+	name := FieldExpr{0, []string{"name"}}
+	exprs := []Expression{
+		LikeExpr{name, RegexExpr{"^D"}},
+		EqualsExpr{name, ValueExpr{"Daphne Sutton"}},
+		AndExpr{
+			AnyInExpr{1, FieldExpr{0, []string{"friends"}},
+				EqualsExpr{FieldExpr{1, []string{"name"}}, ValueExpr{"Kim"}}},
+			EqualsExpr{FieldExpr{0, []string{"gender"}}, ValueExpr{"female"}},
+		},
+		tParseFilterExpression(t, `age > 30 OR eyeColor <> "blue"`),
+		tParseFilterExpression(t, `REGEXP_CONTAINS(company, "^A") AND isActive = TRUE AND age < 40`),
+	}
+
+	var plainTrans Transformer
+	plainDef := plainTrans.Transform(exprs)
+
+	optTrans := Transformer{Estimator: StaticEstimator{}}
+	optDef := optTrans.Transform(exprs)
+
+	nameOps := optDef.ParseNode.Elems["name"].Ops
+	if len(nameOps) != 2 || nameOps[0].Op != OpTypeEquals || nameOps[1].Op != OpTypeMatches {
+		t.Fatalf("expected the regex to be matched last:\n%s", optDef)
+	}
+
+	// The ops of a node are ranked using the supplied estimator
+	customTrans := Transformer{Estimator: tRegexFirstEstimator{}}
+	customDef := customTrans.Transform(exprs)
+
+	nameOps = customDef.ParseNode.Elems["name"].Ops
+	if len(nameOps) != 2 || nameOps[0].Op != OpTypeMatches || nameOps[1].Op != OpTypeEquals {
+		t.Fatalf("expected the regex to be matched first:\n%s", customDef)
+	}
+
+	plainM := NewFastMatcher(plainDef)
+	optM := NewFastMatcher(optDef)
+	for docIdx, doc := range getTestPeopleDocs() {
+		plainM.Reset()
+		expected, err := plainM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		optM.Reset()
+		matched, err := optM.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		if matched != expected {
+			t.Fatalf("document %d: matched %t when reordered, expected %t", docIdx, matched, expected)
+		}
+		for exprIdx := range exprs {
+			if optM.ExpressionMatched(exprIdx) != plainM.ExpressionMatched(exprIdx) {
+				t.Fatalf("document %d: expression %d mismatched when reordered", docIdx, exprIdx)
+			}
+		}
+	}
+}
@@ -11,10 +11,13 @@ import (
 
 type PcreWrapper struct {
 	pcreRegex *pcre.Regexp
+	pattern   string
 }
 
 func MakePcreWrapper(expression string) (PcreWrapperInterface, error) {
-	pcreWrapper := &PcreWrapper{}
+	pcreWrapper := &PcreWrapper{
+		pattern: expression,
+	}
 
 	pcreRegex, err := pcre.Compile(expression, 0)
 	if err != nil {
@@ -31,6 +34,11 @@ func (wrapper *PcreWrapper) Match(b []byte) bool {
 	return matcher.Matches()
 }
 
+// Pattern returns the source of the compiled expression.
+func (wrapper *PcreWrapper) Pattern() string {
+	return wrapper.pattern
+}
+
 func MakePcreExpression(expression string) (Expression, error) {
 	return PcreExpr{expression}, nil
 }
@@ -15,6 +15,10 @@ func (wrapper *PcreWrapper) Match(b []byte) bool {
 	return false
 }
 
+func (wrapper *PcreWrapper) Pattern() string {
+	return ""
+}
+
 func MakePcreExpression(expression string) (Expression, error) {
 	return nil, ErrorPcreNotSupported
 }
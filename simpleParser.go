@@ -23,16 +23,40 @@ import (
  *
  * Field variables can be escaped by backticks ` to become literals.
  * Example:
- * 		`version0.1_serialNumber` LIKE "SN[0-9]+"
+ * 		`version0.1_serialNumber` =~ "SN[0-9]+"
  *
  * Arrays are accessed with brackets, and integer indexes do not have to be enclosed by backticks.
  * Example:
  * 		user[10]
  * 		US.`users.ids`[10]
  *
+ * Negative indexes count back from the end of the array, and a slice of the array can be looped over
+ * with ANY / EVERY.
+ * Example:
+ * 		user[-1]
+ * 		ANY id IN user[1:3] SATISFIES id > 100 END
+ *
  * Parenthesis are allowed, but must be surrounded by at least 1 white space
  * Currently, only the following operations are supported:
- * 		==/=, !=, ||/OR, &&/AND, >=, >, <=, <, LIKE/=~, NOT LIKE, EXISTS, IS MISSING, IS NULL, IS NOT NULL
+ * 		==/=, !=, ||/OR, &&/AND, >=, >, <=, <, =~/REGEXP_LIKE, NOT REGEXP_LIKE, LIKE, NOT LIKE, EXISTS,
+ * 		IS MISSING, IS NULL, IS NOT NULL, BETWEEN, NOT BETWEEN, ANY / EVERY ... SATISFIES ... END
+ *
+ * =~ matches a regular expression, whereas LIKE matches a SQL pattern in which % matches any sequence
+ * of characters and _ matches any single character. The pattern may be followed by ESCAPE and a
+ * character which makes the wildcard after it match itself.
+ * Example:
+ * 		name LIKE "Ne%"
+ * 		discount LIKE "10!%" ESCAPE "!"
+ *
+ * The bounds of a BETWEEN are separated by AND, and both are inclusive.
+ * Example:
+ * 		age BETWEEN 18 AND 65
+ *
+ * Arrays can be tested with ANY, EVERY or ANY AND EVERY, which bind each item to a variable while the
+ * expression between SATISFIES and END is evaluated. Loops may be nested.
+ * Example:
+ * 		ANY i IN items SATISFIES i.qty > 5 END
+ * 		EVERY o IN orders SATISFIES ANY i IN o.items SATISFIES i.qty > 5 END END
  *
  * Usage example:
  * exprStr := "name.`first.name` == "Neil" && (age < 50 || isActive == true)"
@@ -57,6 +81,9 @@ var fieldTokenInt *regexp.Regexp = regexp.MustCompile(`[0-9]`)
 // But they cannot have leading zeros
 var fieldIndexNoLeadingZero *regexp.Regexp = regexp.MustCompile(`[^0][0-9]+`)
 
+// Loop variables are plain identifiers
+var loopVarRegex *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // Functions patterns
 var funcTranslateTable map[string]string = map[string]string{
 	FuncAbs:   MathFuncAbs,
@@ -164,6 +191,12 @@ type parserSubContext struct {
 	// Means that we should return as soon as the one layer of field -> op -> value is done
 	oneLayerMode bool
 
+	// Set once the low bound of a BETWEEN has been read, so that the next value is its high bound
+	rangeHasLow bool
+
+	// The ESCAPE character read along with the last LIKE pattern
+	likeEscape string
+
 	// Last seeker found
 	lastSeeker *opSeeker
 }
@@ -293,6 +326,18 @@ type expressionParserContext struct {
 	funcOutputContext map[int]*funcOutputHelper
 	builtInFuncRegex  map[string]*regexp.Regexp
 
+	// The low bound of a BETWEEN is the right of its op node, and the high bound is stored here.
+	// Key is the index of the op node
+	rangeHighNodes map[int]int
+
+	// The ESCAPE characters of LIKE patterns. Key is the index of the pattern's value node
+	likeEscapes map[int]string
+
+	// ANY / EVERY loops, whose array is the left of a SATISFIES op node. Key is the index of the op node.
+	// A loop is pending from when its tokens are rewritten until its op node is inserted
+	collectionLoops map[int]*collectionLoop
+	pendingLoop     *collectionLoop
+
 	// Outputting context
 	currentOuputNode int
 
@@ -300,6 +345,13 @@ type expressionParserContext struct {
 	pcreWrapper PcreWrapperInterface
 }
 
+// An ANY / EVERY loop whose body has already been parsed, with its variable bound
+type collectionLoop struct {
+	loopType string
+	varId    VariableID
+	subExpr  Expression
+}
+
 type checkFieldMode int
 
 const (
@@ -317,6 +369,9 @@ func NewExpressionParserCtx(strExpression string) (*expressionParserContext, err
 		fieldTokenPaths:   make(map[int][]string),
 		builtInFuncRegex:  make(map[string]*regexp.Regexp),
 		funcOutputContext: make(map[int]*funcOutputHelper),
+		rangeHighNodes:    make(map[int]int),
+		likeEscapes:       make(map[int]string),
+		collectionLoops:   make(map[int]*collectionLoop),
 	}
 	for k, _ := range funcTranslateTable {
 		regex := regexp.MustCompile(getCheckFuncPattern(k))
@@ -342,6 +397,7 @@ const (
 	TokenTypeValue    ParseTokenType = iota
 	TokenTypeRegex    ParseTokenType = iota
 	TokenTypePcre     ParseTokenType = iota
+	TokenTypeLike     ParseTokenType = iota
 	TokenTypeParen    ParseTokenType = iota
 	TokenTypeEndParen ParseTokenType = iota
 	TokenTypeTrue     ParseTokenType = iota
@@ -361,6 +417,8 @@ func (ptt ParseTokenType) String() string {
 		return "TokenTypeRegex"
 	case TokenTypePcre:
 		return "TokenTypePcre"
+	case TokenTypeLike:
+		return "TokenTypeLike"
 	case TokenTypeParen:
 		return "TokenTypeParen"
 	case TokenTypeEndParen:
@@ -389,7 +447,8 @@ func (ptt ParseTokenType) isOpType() bool {
 
 // Regex is a type of special "value", and functions can act as values too
 func (ptt ParseTokenType) isValueType() bool {
-	return ptt == TokenTypeValue || ptt == TokenTypeRegex || ptt == TokenTypeFunc || ptt == TokenTypePcre
+	return ptt == TokenTypeValue || ptt == TokenTypeRegex || ptt == TokenTypeFunc || ptt == TokenTypePcre ||
+		ptt == TokenTypeLike
 }
 
 // Operator types
@@ -404,6 +463,18 @@ const (
 	TokenOperatorGreaterThanEq = ">="
 	TokenOperatorLike          = "=~"
 	TokenOperatorExists        = "EXISTS"
+	TokenOperatorBetween       = "BETWEEN"
+	TokenOperatorLikePattern   = "LIKE"
+	TokenOperatorEscape        = "ESCAPE"
+	TokenOperatorSatisfies     = "SATISFIES"
+)
+
+// Keywords of ANY / EVERY loops, which are rewritten as a field followed by the SATISFIES op
+const (
+	TokenKeywordAny   = "ANY"
+	TokenKeywordEvery = "EVERY"
+	TokenKeywordIn    = "IN"
+	TokenKeywordEnd   = "END"
 )
 
 // Other allowable operator tokens
@@ -411,14 +482,16 @@ const (
 	TokenOperatorEqual2 = "="
 	TokenOperatorOr2    = "OR"
 	TokenOperatorAnd2   = "AND"
-	TokenOperatorLike2  = "LIKE"
+	TokenOperatorLike2  = "REGEXP_LIKE"
 )
 
 // Multi-word operator tokens
 var TokenOperatorNotLike []string = []string{"NOT", "LIKE"}
+var TokenOperatorNotRegexpLike []string = []string{"NOT", "REGEXP_LIKE"}
 var TokenOperatorIsNull []string = []string{"IS", "NULL"}
 var TokenOperatorIsNotNull []string = []string{"IS", "NOT", "NULL"}
 var TokenOperatorIsMissing []string = []string{"IS", "MISSING"}
+var TokenOperatorNotBetween []string = []string{"NOT", "BETWEEN"}
 
 // In keeping with internals, flatten it and use it as comparison for actual op when outputting
 func flattenToken(token []string) string {
@@ -442,12 +515,12 @@ func replaceOpTokenIfNecessary(token string) string {
 func tokenIsOpType(token string) bool {
 	// Equal is both numeric and logical
 	return tokenIsChainOpType(token) || tokenIsEquivalentType(token) || tokenIsCompareOpType(token) || tokenIsLikeType(token) ||
-		tokenIsOpOnlyType(token)
+		tokenIsOpOnlyType(token) || tokenIsRangeType(token) || tokenIsLikePatternType(token)
 }
 
 // This ops do not have value follow-ups
 func tokenIsOpOnlyType(token string) bool {
-	return tokenIsExistenceType(token) || tokenIsNullType(token)
+	return tokenIsExistenceType(token) || tokenIsNullType(token) || token == TokenOperatorSatisfies
 }
 
 func tokenIsExistenceType(token string) bool {
@@ -459,7 +532,17 @@ func tokenIsNullType(token string) bool {
 }
 
 func tokenIsLikeType(token string) bool {
-	return token == TokenOperatorLike || token == TokenOperatorLike2 || token == flattenToken(TokenOperatorNotLike)
+	return token == TokenOperatorLike || token == TokenOperatorLike2 || token == flattenToken(TokenOperatorNotRegexpLike)
+}
+
+// SQL LIKE operators are followed by a pattern rather than a regular expression
+func tokenIsLikePatternType(token string) bool {
+	return token == TokenOperatorLikePattern || token == flattenToken(TokenOperatorNotLike)
+}
+
+// Range operators are followed by two values separated by AND
+func tokenIsRangeType(token string) bool {
+	return token == TokenOperatorBetween || token == flattenToken(TokenOperatorNotBetween)
 }
 
 func tokenIsEquivalentType(token string) bool {
@@ -497,6 +580,14 @@ func (opCtx opTokenContext) isLikeOp() bool {
 	return opCtx == matchOp
 }
 
+func (opCtx opTokenContext) isRangeOp() bool {
+	return opCtx == rangeOp
+}
+
+func (opCtx opTokenContext) isLikePatternOp() bool {
+	return opCtx == patternOp
+}
+
 func (opCtx *opTokenContext) clear() {
 	if *opCtx != noOp {
 		*opCtx = noOp
@@ -543,6 +634,14 @@ func (ctx *expressionParserContext) advanceToken() error {
 			ctx.subCtx.currentMode = valueMode
 		}
 	case valueMode:
+		if ctx.subCtx.opTokenContext.isRangeOp() && !ctx.subCtx.rangeHasLow {
+			// The low bound of a range must be followed by AND and then the high bound
+			ctx.subCtx.rangeHasLow = true
+			ctx.subCtx.currentMode = rangeMode
+			return nil
+		}
+		ctx.subCtx.rangeHasLow = false
+
 		if ctx.subCtx.oneLayerMode {
 			// One layer mode means that we should return as soon as this value is done so we can merge
 			ctx.currentTokenIndex = ctx.currentTokenIndex - 1
@@ -557,6 +656,8 @@ func (ctx *expressionParserContext) advanceToken() error {
 	case chainMode:
 		ctx.subCtx.currentMode = fieldMode
 		ctx.subCtx.fieldIsTrueOrFalse = false
+	case rangeMode:
+		ctx.subCtx.currentMode = valueMode
 	default:
 		return fmt.Errorf("Not implemented yet for mode transition %v", ctx.subCtx.currentMode)
 	}
@@ -605,6 +706,11 @@ func (ctx *expressionParserContext) handleOpenParenBookKeeping() error {
 
 // Given a specific op token, set the opTokenContext to the type of op it is
 func (ctx *expressionParserContext) checkAndMarkDetailedOpToken(token string) {
+	if ctx.subCtx.currentMode == rangeMode {
+		// The AND between the bounds of a range does not change the op
+		return
+	}
+
 	if tokenIsChainOpType(token) && ctx.subCtx.lastSubFieldNode != -1 {
 		// Only set chain op if there is something previously to chain
 		ctx.subCtx.opTokenContext = chainOp
@@ -614,6 +720,10 @@ func (ctx *expressionParserContext) checkAndMarkDetailedOpToken(token string) {
 		ctx.subCtx.opTokenContext = matchOp
 	} else if tokenIsOpOnlyType(token) {
 		ctx.subCtx.opTokenContext = noFieldOp
+	} else if tokenIsRangeType(token) {
+		ctx.subCtx.opTokenContext = rangeOp
+	} else if tokenIsLikePatternType(token) {
+		ctx.subCtx.opTokenContext = patternOp
 	}
 }
 
@@ -624,6 +734,9 @@ func (ctx *expressionParserContext) checkIfTokenIsPotentiallyOpType(token string
 			ctx.multiwordHelperMap[flattenToken(TokenOperatorNotLike)] = &multiwordHelperPair{
 				actualMultiWords: TokenOperatorNotLike,
 			}
+			ctx.multiwordHelperMap[flattenToken(TokenOperatorNotRegexpLike)] = &multiwordHelperPair{
+				actualMultiWords: TokenOperatorNotRegexpLike,
+			}
 			ctx.multiwordHelperMap[flattenToken(TokenOperatorIsNull)] = &multiwordHelperPair{
 				actualMultiWords: TokenOperatorIsNull,
 			}
@@ -633,6 +746,9 @@ func (ctx *expressionParserContext) checkIfTokenIsPotentiallyOpType(token string
 			ctx.multiwordHelperMap[flattenToken(TokenOperatorIsMissing)] = &multiwordHelperPair{
 				actualMultiWords: TokenOperatorIsMissing,
 			}
+			ctx.multiwordHelperMap[flattenToken(TokenOperatorNotBetween)] = &multiwordHelperPair{
+				actualMultiWords: TokenOperatorNotBetween,
+			}
 		})
 		for _, v := range ctx.multiwordHelperMap {
 			v.valid = true
@@ -704,6 +820,8 @@ func (ctx *expressionParserContext) getCurrentTokenParenHelper(token string) (st
 func (ctx *expressionParserContext) getTokenValueSubtype() ParseTokenType {
 	if ctx.subCtx.opTokenContext.isLikeOp() {
 		return TokenTypeRegex
+	} else if ctx.subCtx.opTokenContext.isLikePatternOp() {
+		return TokenTypeLike
 	} else {
 		return TokenTypeValue
 	}
@@ -716,7 +834,9 @@ func (ctx *expressionParserContext) getValueTokenHelper(delim string) (string, P
 	token = strings.TrimPrefix(token, delim)
 	token = strings.TrimSuffix(token, delim)
 
-	if ctx.getTokenValueSubtype() != TokenTypeValue {
+	if ctx.getTokenValueSubtype() == TokenTypeLike {
+		return token, TokenTypeLike, ctx.getLikeEscapeHelper(token)
+	} else if ctx.getTokenValueSubtype() != TokenTypeValue {
 		_, err := regexp.Compile(token)
 		if err != nil {
 			if tokenIsPcreValueType(token) {
@@ -754,6 +874,8 @@ func (ctx *expressionParserContext) getCurrentToken() (string, ParseTokenType, e
 		token = replaceOpTokenIfNecessary(token)
 		ctx.checkAndMarkDetailedOpToken(token)
 		return token, TokenTypeOperator, nil
+	} else if (token == TokenKeywordAny || token == TokenKeywordEvery) && ctx.subCtx.currentMode == fieldMode {
+		return ctx.getCollectionLoopHelper()
 	} else if delim, ok := valueCheck(token).(string); ok && ctx.subCtx.currentMode == valueMode {
 		return ctx.getValueTokenHelper(delim)
 	} else if isNum, ok := valueCheck(token).(bool); ok && isNum {
@@ -808,9 +930,101 @@ func (ctx *expressionParserContext) getUnfinishedValueHelper(delim string) (stri
 		return "", TokenTypeInvalid, ErrorMissingQuote
 	}
 
+	if ctx.getTokenValueSubtype() == TokenTypeLike {
+		return outputToken, TokenTypeLike, ctx.getLikeEscapeHelper(outputToken)
+	}
 	return outputToken, ctx.getTokenValueSubtype(), nil
 }
 
+// A LIKE pattern may be followed by ESCAPE and the escape character, which are read along with the pattern
+func (ctx *expressionParserContext) getLikeEscapeHelper(pattern string) error {
+	var escape string
+	if ctx.currentTokenIndex+1 < len(ctx.tokens) && ctx.tokens[ctx.currentTokenIndex+1] == TokenOperatorEscape {
+		ctx.currentTokenIndex += 2
+		if ctx.currentTokenIndex >= len(ctx.tokens) {
+			return fmt.Errorf("Error: Missing the character after %v", TokenOperatorEscape)
+		}
+		if ctx.parenDepth > 0 && strings.HasSuffix(ctx.tokens[ctx.currentTokenIndex], ")") {
+			ctx.handleParenSuffix(")")
+		}
+
+		token := ctx.tokens[ctx.currentTokenIndex]
+		delim, ok := valueCheck(token).(string)
+		if !ok {
+			return fmt.Errorf("Error: The character after %v must be quoted - received %v", TokenOperatorEscape, token)
+		}
+		escape = strings.TrimSuffix(strings.TrimPrefix(token, delim), delim)
+	}
+
+	_, err := NewLikeMatcher(pattern, escape)
+	if err != nil {
+		return err
+	}
+	ctx.subCtx.likeEscape = escape
+	return nil
+}
+
+// An ANY / EVERY loop is parsed as a whole up to its matching END. Its body is parsed on its own, and its tokens
+// are then rewritten to the array field followed by the SATISFIES op, so that it is handled like an EXISTS
+func (ctx *expressionParserContext) getCollectionLoopHelper() (string, ParseTokenType, error) {
+	start := ctx.currentTokenIndex
+	token := ctx.tokens[start]
+	i := start + 1
+
+	loop := &collectionLoop{loopType: token}
+	if token == TokenKeywordAny && i+1 < len(ctx.tokens) && ctx.tokens[i] == TokenOperatorAnd2 &&
+		ctx.tokens[i+1] == TokenKeywordEvery {
+		loop.loopType = OperatorAnyEvery
+		i += 2
+	}
+
+	if i+3 >= len(ctx.tokens) || ctx.tokens[i+1] != TokenKeywordIn || ctx.tokens[i+3] != TokenOperatorSatisfies {
+		return token, TokenTypeInvalid, fmt.Errorf("Error: Expecting %v <variable> %v <field> %v", loop.loopType,
+			TokenKeywordIn, TokenOperatorSatisfies)
+	}
+	varName := ctx.tokens[i]
+	if !loopVarRegex.MatchString(varName) {
+		return token, TokenTypeInvalid, fmt.Errorf("Error: Invalid loop variable: %v", varName)
+	}
+	field := ctx.tokens[i+2]
+
+	// Nested loops each have their own SATISFIES and END
+	bodyStart := i + 4
+	end := -1
+	depth := 1
+	for j := bodyStart; j < len(ctx.tokens) && end == -1; j++ {
+		if ctx.tokens[j] == TokenOperatorSatisfies {
+			depth++
+		} else if strings.TrimRight(ctx.tokens[j], ")") == TokenKeywordEnd {
+			depth--
+			if depth == 0 {
+				end = j
+			}
+		}
+	}
+	if end == -1 {
+		return token, TokenTypeInvalid, fmt.Errorf("Error: Could not find the %v of %v", TokenKeywordEnd, loop.loopType)
+	}
+
+	subExpr, err := ParseSimpleExpression(strings.Join(ctx.tokens[bodyStart:end], " "))
+	if err != nil {
+		return token, TokenTypeInvalid, err
+	}
+	loop.varId = unusedLoopVariableID(subExpr)
+	loop.subExpr = bindLoopVariable(subExpr, varName, loop.varId)
+
+	// Any parens closed right after the END are kept as tokens of their own
+	rewritten := []string{field, TokenOperatorSatisfies}
+	for k := len(TokenKeywordEnd); k < len(ctx.tokens[end]); k++ {
+		rewritten = append(rewritten, ")")
+	}
+	rewritten = append(rewritten, ctx.tokens[end+1:]...)
+	ctx.tokens = append(ctx.tokens[:start], rewritten...)
+	ctx.pendingLoop = loop
+
+	return ctx.getCurrentToken()
+}
+
 func (ctx *expressionParserContext) NewFuncHelper() *funcOutputHelper {
 	helper := &funcOutputHelper{
 		args: make([][]interface{}, 1),
@@ -872,6 +1086,7 @@ func checkAndParseField(tokens []string, i *int, subTokens *[]string) (string, e
 	for !done {
 		var pos int
 		var beginPos int
+		var numBeginPos int
 		for ; pos < len(token); pos++ {
 			switch mode {
 			case cfmNone:
@@ -896,6 +1111,7 @@ func checkAndParseField(tokens []string, i *int, subTokens *[]string) (string, e
 						skipAppend = false
 					}
 					beginPos = pos
+					numBeginPos = pos + 1
 					mode = cfmNestedNumeric
 				}
 			case cfmBacktick:
@@ -923,7 +1139,17 @@ func checkAndParseField(tokens []string, i *int, subTokens *[]string) (string, e
 				if pos == beginPos {
 					continue
 				}
-				if pos == beginPos+1 && string(token[pos]) == "0" {
+				// A number may be negative to count from the end of the array,
+				// and two numbers separated by a colon form a slice
+				if pos == numBeginPos && string(token[pos]) == fieldIndexNeg {
+					numBeginPos = pos + 1
+					continue
+				} else if string(token[pos]) == fieldIndexSlice {
+					numBeginPos = pos + 1
+					continue
+				}
+				if pos == numBeginPos && string(token[pos]) == "0" && pos+1 < len(token) &&
+					string(token[pos+1]) != fieldNestedEnd && string(token[pos+1]) != fieldIndexSlice {
 					return outputToken, ErrorLeadingZeroes
 				} else if !fieldTokenInt.MatchString(string(token[pos])) && string(token[pos]) != fieldNestedEnd {
 					return outputToken, ErrorAllInts
@@ -934,6 +1160,9 @@ func checkAndParseField(tokens []string, i *int, subTokens *[]string) (string, e
 					if pos == beginPos+1 {
 						return outputToken, ErrorEmptyNest
 					}
+					if !isArrayIndexElem(token[beginPos : pos+1]) {
+						return outputToken, ErrorAllInts
+					}
 
 					// Advance mode to the next, and skip appending if this is the last or is followed by another nest
 					mode = cfmNone
@@ -943,7 +1172,7 @@ func checkAndParseField(tokens []string, i *int, subTokens *[]string) (string, e
 					} else {
 						skipAppend = false
 					}
-					if pos == len(token)-1 || (pos < len(token)-1 && string(token[pos+1]) == fieldNestedStart) {
+					if pos == len(token)-1 || (pos < len(token)-1 && (string(token[pos+1]) == fieldNestedStart || string(token[pos+1]) == fieldSeparator)) {
 						skipAppend = true
 					}
 				case fieldSeparator:
@@ -997,6 +1226,10 @@ func (ctx *expressionParserContext) getErrorNeedToStartOneNewCtx() error {
 
 func (ctx *expressionParserContext) checkTokenTypeWithinContext(tokenType ParseTokenType, token string) error {
 	switch ctx.subCtx.currentMode {
+	case rangeMode:
+		if !tokenType.isOpType() || token != TokenOperatorAnd {
+			return fmt.Errorf("Error: For range mode, expecting AND between the bounds - received %v(%v)", token, tokenType.String())
+		}
 	case opMode:
 		// opMode is pretty much a less restrictive chainMode
 		fallthrough
@@ -1023,7 +1256,8 @@ func (ctx *expressionParserContext) checkTokenTypeWithinContext(tokenType ParseT
 		if tokenType.isFieldType() && ctx.subCtx.opTokenContext.isChainOp() {
 			return ctx.getErrorNeedToStartOneNewCtx()
 		} else if tokenType.isBoolType() {
-			if ctx.subCtx.opTokenContext.isCompareOp() || ctx.subCtx.opTokenContext.isLikeOp() {
+			if ctx.subCtx.opTokenContext.isCompareOp() || ctx.subCtx.opTokenContext.isLikeOp() || ctx.subCtx.opTokenContext.isRangeOp() ||
+				ctx.subCtx.opTokenContext.isLikePatternOp() {
 				return fmt.Errorf("Error: Unable to do comparison operator on true or false values")
 			}
 		} else if !tokenType.isValueType() && !tokenType.isFieldType() {
@@ -1037,6 +1271,11 @@ func (ctx *expressionParserContext) checkTokenTypeWithinContext(tokenType ParseT
 }
 
 func (ctx *expressionParserContext) insertNode(newNode ParserTreeNode) error {
+	if ctx.subCtx.currentMode == rangeMode {
+		// The AND between the bounds of a range is not a node of its own
+		return nil
+	}
+
 	ctx.parserDataNodes = append(ctx.parserDataNodes, newNode)
 	ctx.subCtx.lastParserDataNode = len(ctx.parserDataNodes) - 1
 
@@ -1076,12 +1315,29 @@ func (ctx *expressionParserContext) insertNode(newNode ParserTreeNode) error {
 			ctx.treeHeadIndex = ctx.subCtx.lastOpIndex
 		}
 		ctx.subCtx.lastSubFieldNode = ctx.subCtx.lastOpIndex
+
+		if newNode.data == TokenOperatorSatisfies {
+			if ctx.pendingLoop == nil {
+				return fmt.Errorf("Error: %v must be part of an %v or %v loop", TokenOperatorSatisfies, TokenKeywordAny, TokenKeywordEvery)
+			}
+			ctx.collectionLoops[ctx.subCtx.lastOpIndex] = ctx.pendingLoop
+			ctx.pendingLoop = nil
+		}
 	case valueMode:
 		ctx.subCtx.lastValueIndex = ctx.subCtx.lastBinTreeDataNode
 		// Value mode means that the op's right is the value
 		thisValueNode := &ctx.parserTree.data[ctx.subCtx.lastValueIndex]
 		thisValueNode.ParentIdx = ctx.subCtx.lastOpIndex
 
+		if ctx.subCtx.rangeHasLow {
+			// The high bound of a range is kept aside, as the op's right is already the low bound
+			ctx.rangeHighNodes[ctx.subCtx.lastOpIndex] = ctx.subCtx.lastValueIndex
+			if ctx.subCtx.funcHelperCtx != nil {
+				ctx.funcOutputContext[ctx.subCtx.lastValueIndex] = ctx.subCtx.funcHelperCtx
+			}
+			break
+		}
+
 		lastOpNode := &ctx.parserTree.data[ctx.subCtx.lastOpIndex]
 		lastOpNode.Right = ctx.subCtx.lastValueIndex
 
@@ -1091,6 +1347,10 @@ func (ctx *expressionParserContext) insertNode(newNode ParserTreeNode) error {
 		if ctx.subCtx.funcHelperCtx != nil {
 			ctx.funcOutputContext[ctx.subCtx.lastValueIndex] = ctx.subCtx.funcHelperCtx
 		}
+		if ctx.subCtx.likeEscape != "" {
+			ctx.likeEscapes[ctx.subCtx.lastValueIndex] = ctx.subCtx.likeEscape
+			ctx.subCtx.likeEscape = ""
+		}
 	default:
 		// OK to leak the node created above because we should be erroring out anyway
 		return fmt.Errorf("Unsure how to insert into tree: %v", newNode)
@@ -1311,6 +1571,8 @@ func (ctx *expressionParserContext) outputNode(node ParserTreeNode, pos int) (Ex
 		return ctx.outputFunc(pos)
 	case TokenTypePcre:
 		return ctx.outputPcre(node)
+	case TokenTypeLike:
+		return ctx.outputLikePattern(node, pos)
 	default:
 		return emptyExpression, fmt.Errorf("Error: Invalid Node token type: %v", node.tokenType.String())
 	}
@@ -1350,6 +1612,14 @@ func (ctx *expressionParserContext) outputPcre(node ParserTreeNode) (Expression,
 	return PcreExpr{node.data}, nil
 }
 
+func (ctx *expressionParserContext) outputLikePattern(node ParserTreeNode, pos int) (Expression, error) {
+	pattern, ok := node.data.(string)
+	if !ok {
+		return emptyExpression, fmt.Errorf("Error: Invalid LIKE pattern: %v", node.data)
+	}
+	return LikePatternExpr{pattern, ctx.likeEscapes[pos]}, nil
+}
+
 func (ctx *expressionParserContext) outputField(pos int) (Expression, error) {
 	var out FieldExpr
 	path, ok := ctx.fieldTokenPaths[pos]
@@ -1436,9 +1706,9 @@ func (ctx *expressionParserContext) outputOp(node ParserTreeNode, pos int) (Expr
 		return ctx.outputGreaterThan(node, pos)
 	case TokenOperatorGreaterThanEq:
 		return ctx.outputGreaterThanEq(node, pos)
-	case TokenOperatorLike:
+	case TokenOperatorLike, TokenOperatorLikePattern:
 		return ctx.outputLike(node, pos)
-	case flattenToken(TokenOperatorNotLike):
+	case flattenToken(TokenOperatorNotLike), flattenToken(TokenOperatorNotRegexpLike):
 		return ctx.outputNotLike(node, pos)
 	case TokenOperatorExists:
 		return ctx.outputExists(node, pos)
@@ -1448,6 +1718,12 @@ func (ctx *expressionParserContext) outputOp(node ParserTreeNode, pos int) (Expr
 		return ctx.outputIsNull(node, pos)
 	case flattenToken(TokenOperatorIsNotNull):
 		return ctx.outputIsNotNull(node, pos)
+	case TokenOperatorBetween:
+		return ctx.outputBetween(node, pos)
+	case flattenToken(TokenOperatorNotBetween):
+		return ctx.outputNotBetween(node, pos)
+	case TokenOperatorSatisfies:
+		return ctx.outputCollectionLoop(node, pos)
 	default:
 		return emptyExpression, fmt.Errorf("Error: Invalid op type: %s", nodeData)
 	}
@@ -1580,6 +1856,40 @@ func (ctx *expressionParserContext) outputNotLike(node ParserTreeNode, pos int)
 	}, nil
 }
 
+func (ctx *expressionParserContext) outputBetween(node ParserTreeNode, pos int) (Expression, error) {
+	leftSubExpr, lowSubExpr, err := ctx.getComparisonSubExprsNodes(node, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	highPos, ok := ctx.rangeHighNodes[pos]
+	if !ok {
+		return nil, fmt.Errorf("Error: Missing the high bound of %v", node.data)
+	}
+
+	highSubExpr, err := ctx.outputNode(ctx.getThisOutputNode(highPos), highPos)
+	if err != nil {
+		return nil, err
+	}
+
+	return BetweenExpr{
+		leftSubExpr,
+		lowSubExpr,
+		highSubExpr,
+	}, nil
+}
+
+func (ctx *expressionParserContext) outputNotBetween(node ParserTreeNode, pos int) (Expression, error) {
+	betweenExpr, err := ctx.outputBetween(node, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	return NotExpr{
+		betweenExpr,
+	}, nil
+}
+
 func (ctx *expressionParserContext) outputExists(node ParserTreeNode, pos int) (Expression, error) {
 	subExpr, err := ctx.getSingleLeftSubExprsNodes(node, pos)
 	if err != nil {
@@ -1591,6 +1901,29 @@ func (ctx *expressionParserContext) outputExists(node ParserTreeNode, pos int) (
 	}, nil
 }
 
+func (ctx *expressionParserContext) outputCollectionLoop(node ParserTreeNode, pos int) (Expression, error) {
+	inExpr, err := ctx.getSingleLeftSubExprsNodes(node, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	loop, ok := ctx.collectionLoops[pos]
+	if !ok {
+		return nil, fmt.Errorf("Error: Unable to find the loop of %v", TokenOperatorSatisfies)
+	}
+
+	switch loop.loopType {
+	case TokenKeywordAny:
+		return AnyInExpr{loop.varId, inExpr, loop.subExpr}, nil
+	case TokenKeywordEvery:
+		return EveryInExpr{loop.varId, inExpr, loop.subExpr}, nil
+	case OperatorAnyEvery:
+		return AnyEveryInExpr{loop.varId, inExpr, loop.subExpr}, nil
+	default:
+		return nil, fmt.Errorf("Error: Invalid loop type: %v", loop.loopType)
+	}
+}
+
 func (ctx *expressionParserContext) outputIsMissing(node ParserTreeNode, pos int) (Expression, error) {
 	subExpr, err := ctx.getSingleLeftSubExprsNodes(node, pos)
 	if err != nil {
@@ -46,7 +46,7 @@ func TestPcre(t *testing.T) {
 
 func TestContextParserPcreToken(t *testing.T) {
 	assert := assert.New(t)
-	testString := "name.first == \"Neil\" || (age < 50) || (true) && `someStr` LIKE \"a(?<!foo)\""
+	testString := "name.first == \"Neil\" || (age < 50) || (true) && `someStr` REGEXP_LIKE \"a(?<!foo)\""
 	ctx, err := NewExpressionParserCtx(testString)
 
 	// name.first
@@ -155,7 +155,7 @@ func TestContextParserPcreToken(t *testing.T) {
 	assert.Nil(ctx.insertNode(NewParserTreeNode(tokenType, token)))
 	ctx.advanceToken()
 
-	// LIKE
+	// REGEXP_LIKE
 	token, tokenType, err = ctx.getCurrentToken()
 	assert.Equal(tokenType, (ParseTokenType)(TokenTypeOperator))
 	assert.Nil(err)
@@ -173,7 +173,7 @@ func TestContextParserPcreToken(t *testing.T) {
 func TestParserExpressionPcre(t *testing.T) {
 	assert := assert.New(t)
 
-	strExpr := "pcreKey LIKE \"q(?!uit)\""
+	strExpr := "pcreKey =~ \"q(?!uit)\""
 
 	ctx, err := NewExpressionParserCtx(strExpr)
 	assert.Nil(err)
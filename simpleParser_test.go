@@ -13,7 +13,7 @@ import (
 
 func TestContextParserToken(t *testing.T) {
 	assert := assert.New(t)
-	testString := "name.first == \"Neil\" || (age < 50) || (true) && `someStr` LIKE \"a(?<!foo)\""
+	testString := "name.first == \"Neil\" || (age < 50) || (true) && `someStr` REGEXP_LIKE \"a(?<!foo)\""
 	ctx, err := NewExpressionParserCtx(testString)
 
 	// name.first
@@ -110,7 +110,7 @@ func TestContextParserToken(t *testing.T) {
 	assert.Nil(err)
 	ctx.advanceToken()
 
-	// LIKE
+	// REGEXP_LIKE
 	_, tokenType, err = ctx.getCurrentToken()
 	assert.Equal(tokenType, (ParseTokenType)(TokenTypeOperator))
 	assert.Nil(err)
@@ -498,7 +498,7 @@ func TestContextShortCircuit2(t *testing.T) {
 
 func TestContextParserMultiwordToken(t *testing.T) {
 	assert := assert.New(t)
-	testString := "`name`.`first` NOT LIKE \"abc\""
+	testString := "`name`.`first` NOT REGEXP_LIKE \"abc\""
 	ctx, err := NewExpressionParserCtx(testString)
 
 	// `name`.`first`
@@ -507,10 +507,10 @@ func TestContextParserMultiwordToken(t *testing.T) {
 	assert.Nil(err)
 	ctx.advanceToken()
 
-	// NOT LIKE
+	// NOT REGEXP_LIKE
 	token, tokenType, err := ctx.getCurrentToken()
 	assert.Equal(tokenType, (ParseTokenType)(TokenTypeOperator))
-	assert.Equal("NOT_LIKE", token)
+	assert.Equal("NOT_REGEXP_LIKE", token)
 	assert.Nil(err)
 	ctx.advanceToken()
 
@@ -626,7 +626,7 @@ func TestContextParserMultiwordToken3(t *testing.T) {
 
 func TestContextParserMatch(t *testing.T) {
 	assert := assert.New(t)
-	testString := "name.first LIKE \"Ne[a|i]l\""
+	testString := "name.first REGEXP_LIKE \"Ne[a|i]l\""
 	ctx, err := NewExpressionParserCtx(testString)
 
 	// `name`.`first`
@@ -635,7 +635,7 @@ func TestContextParserMatch(t *testing.T) {
 	assert.Nil(err)
 	ctx.advanceToken()
 
-	// LIKE
+	// REGEXP_LIKE
 	token, tokenType, err := ctx.getCurrentToken()
 	assert.Equal(tokenType, (ParseTokenType)(TokenTypeOperator))
 	assert.Equal("=~", token)
@@ -1396,7 +1396,7 @@ func TestParserExpressionOutputMatchNotNeg(t *testing.T) {
 
 	jsonExpr, err := ParseJsonExpression(matchJson)
 	assert.Nil(err)
-	strExpr := "`name`.`first` NOT LIKE \"Ne[a|i]l\""
+	strExpr := "`name`.`first` NOT REGEXP_LIKE \"Ne[a|i]l\""
 
 	ctx, err := NewExpressionParserCtx(strExpr)
 	assert.Nil(err)
@@ -1461,7 +1461,7 @@ func TestParserExpressionOutputNotMatch(t *testing.T) {
 
 	jsonExpr, err := ParseJsonExpression(matchJson)
 	assert.Nil(err)
-	strExpr := "`name`.`first` NOT LIKE \"Ne[a|i]l\""
+	strExpr := "`name`.`first` NOT REGEXP_LIKE \"Ne[a|i]l\""
 
 	ctx, err := NewExpressionParserCtx(strExpr)
 	assert.Nil(err)
@@ -2597,3 +2597,332 @@ func TestParserDateFuncNeg(t *testing.T) {
 	err = ctx.parse()
 	assert.Equal(ErrorInvalidTimeFormat, err)
 }
+
+func TestParserExpressionOutputBetween(t *testing.T) {
+	assert := assert.New(t)
+
+	matchJson := []byte(`
+	["and",
+		["between",
+			["field", "age"],
+			["value", 20],
+			["value", 30]
+		],
+		["not",
+			["between",
+				["field", "name", "first"],
+				["value", "A"],
+				["value", "M"]
+			]
+		]
+	]`)
+
+	jsonExpr, err := ParseJsonExpression(matchJson)
+	assert.Nil(err)
+
+	strExpr := "age BETWEEN 20 AND 30 && name.first NOT BETWEEN \"A\" AND \"M\""
+	ctx, err := NewExpressionParserCtx(strExpr)
+	assert.Nil(err)
+
+	err = ctx.parse()
+	assert.Nil(err)
+
+	simpleExpr, err := ctx.outputExpression()
+	assert.Nil(err)
+
+	assert.Equal(jsonExpr.String(), simpleExpr.String())
+
+	var trans Transformer
+	matchDef := trans.Transform([]Expression{simpleExpr})
+	assert.NotNil(matchDef)
+
+	m := NewFastMatcher(matchDef)
+	userData := map[string]interface{}{
+		"age": 30,
+		"name": map[string]interface{}{
+			"first": "Neil",
+		},
+	}
+	udMarsh, _ := json.Marshal(userData)
+	match, err := m.Match(udMarsh)
+	assert.Nil(err)
+	assert.True(match)
+
+	m.Reset()
+	userData["age"] = 31
+	udMarsh, _ = json.Marshal(userData)
+	match, err = m.Match(udMarsh)
+	assert.Nil(err)
+	assert.False(match)
+
+	// The AND of a BETWEEN must be followed by its high bound
+	_, err = ParseSimpleExpression("age BETWEEN 20 || 30")
+	assert.NotNil(err)
+	_, err = ParseSimpleExpression("age BETWEEN 20")
+	assert.NotNil(err)
+}
+
+func TestParserDateFuncBetween(t *testing.T) {
+	assert := assert.New(t)
+
+	strExpr := "DATE(transactionDate) BETWEEN DATE(\"2018-01-01\") AND DATE(\"2018-12-31\") || isActive == true"
+	simpleExpr, err := ParseSimpleExpression(strExpr)
+	assert.Nil(err)
+
+	var trans Transformer
+	matchDef := trans.Transform([]Expression{simpleExpr})
+	assert.NotNil(matchDef)
+
+	m := NewFastMatcher(matchDef)
+	for date, expected := range map[string]bool{
+		"2017-12-31": false,
+		"2018-01-01": true,
+		"2018-06-01": true,
+		"2018-12-31": true,
+		"2019-01-01": false,
+	} {
+		userData := map[string]interface{}{
+			"transactionDate": date,
+			"isActive":        false,
+		}
+		udMarsh, _ := json.Marshal(userData)
+
+		m.Reset()
+		match, err := m.Match(udMarsh)
+		assert.Nil(err)
+		assert.Equal(expected, match, date)
+	}
+}
+
+func TestParserExpressionOutputLikePattern(t *testing.T) {
+	assert := assert.New(t)
+
+	matchJson := []byte(`
+	["and",
+		["and",
+			["like",
+				["field", "name", "first"],
+				["likepattern", "Ne%"]
+			],
+			["like",
+				["field", "name", "last"],
+				["regex", "^Hu"]
+			]
+		],
+		["not",
+			["like",
+				["field", "discount"],
+				["likepattern", "10!%", "!"]
+			]
+		]
+	]`)
+
+	jsonExpr, err := ParseJsonExpression(matchJson)
+	assert.Nil(err)
+
+	strExpr := "name.first LIKE \"Ne%\" && name.last =~ \"^Hu\" && ( discount NOT LIKE \"10!%\" ESCAPE \"!\")"
+	ctx, err := NewExpressionParserCtx(strExpr)
+	assert.Nil(err)
+
+	err = ctx.parse()
+	assert.Nil(err)
+
+	simpleExpr, err := ctx.outputExpression()
+	assert.Nil(err)
+
+	assert.Equal(jsonExpr.String(), simpleExpr.String())
+
+	var trans Transformer
+	matchDef := trans.Transform([]Expression{simpleExpr})
+	assert.NotNil(matchDef)
+
+	m := NewFastMatcher(matchDef)
+	userData := map[string]interface{}{
+		"discount": "10 off",
+		"name": map[string]interface{}{
+			"first": "Neil",
+			"last":  "Huang",
+		},
+	}
+	udMarsh, _ := json.Marshal(userData)
+	match, err := m.Match(udMarsh)
+	assert.Nil(err)
+	assert.True(match)
+
+	m.Reset()
+	userData["discount"] = "10%"
+	udMarsh, _ = json.Marshal(userData)
+	match, err = m.Match(udMarsh)
+	assert.Nil(err)
+	assert.False(match)
+
+	// ESCAPE must be followed by a single quoted character which does not end the pattern
+	_, err = ParseSimpleExpression("discount LIKE \"10!%\" ESCAPE")
+	assert.NotNil(err)
+	_, err = ParseSimpleExpression("discount LIKE \"10!%\" ESCAPE \"!!\"")
+	assert.NotNil(err)
+	_, err = ParseSimpleExpression("discount LIKE \"10!\" ESCAPE \"!\"")
+	assert.NotNil(err)
+}
+
+func TestParserExpressionOutputCollectionLoops(t *testing.T) {
+	assert := assert.New(t)
+
+	matchJson := []byte(`
+	["and",
+		["anyin",
+			2,
+			["field", "orders"],
+			["anyin",
+				1,
+				["field", 2, "items"],
+				["and",
+					["greaterthan",
+						["field", 1, "qty"],
+						["value", 5]
+					],
+					["equals",
+						["field", 2, "status"],
+						["value", "open"]
+					]
+				]
+			]
+		],
+		["everyin",
+			1,
+			["field", "tags"],
+			["notequals",
+				["field", 1],
+				["value", "blocked"]
+			]
+		]
+	]`)
+
+	jsonExpr, err := ParseJsonExpression(matchJson)
+	assert.Nil(err)
+
+	strExpr := "ANY o IN orders SATISFIES ANY i IN o.items SATISFIES i.qty > 5 && o.status == \"open\" END END && ( EVERY t IN tags SATISFIES t != \"blocked\" END)"
+	simpleExpr, err := ParseSimpleExpression(strExpr)
+	assert.Nil(err)
+
+	assert.Equal(jsonExpr.String(), simpleExpr.String())
+
+	var trans Transformer
+	matchDef := trans.Transform([]Expression{simpleExpr})
+	assert.NotNil(matchDef)
+
+	m := NewFastMatcher(matchDef)
+	userData := map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{
+				"status": "closed",
+				"items":  []interface{}{map[string]interface{}{"qty": 10}},
+			},
+			map[string]interface{}{
+				"status": "open",
+				"items":  []interface{}{map[string]interface{}{"qty": 1}, map[string]interface{}{"qty": 6}},
+			},
+		},
+		"tags": []interface{}{"new", "priority"},
+	}
+	udMarsh, _ := json.Marshal(userData)
+	match, err := m.Match(udMarsh)
+	assert.Nil(err)
+	assert.True(match)
+
+	m.Reset()
+	userData["tags"] = []interface{}{"new", "blocked"}
+	udMarsh, _ = json.Marshal(userData)
+	match, err = m.Match(udMarsh)
+	assert.Nil(err)
+	assert.False(match)
+
+	expr, err := ParseSimpleExpression("ANY AND EVERY t IN tags SATISFIES t == \"new\" END")
+	assert.Nil(err)
+	assert.Equal(AnyEveryInExpr{1, FieldExpr{0, []string{"tags"}}, EqualsExpr{FieldExpr{1, nil}, ValueExpr{"new"}}}.String(),
+		expr.String())
+
+	// Loops must be complete, and SATISFIES cannot be used on its own
+	_, err = ParseSimpleExpression("ANY t IN tags SATISFIES t == \"new\"")
+	assert.NotNil(err)
+	_, err = ParseSimpleExpression("ANY t tags SATISFIES t == \"new\" END")
+	assert.NotNil(err)
+	_, err = ParseSimpleExpression("tags SATISFIES")
+	assert.NotNil(err)
+}
+
+func TestParserExpressionOutputArrayIndexes(t *testing.T) {
+	assert := assert.New(t)
+
+	matchJson := []byte(`
+	["and",
+		["equals",
+			["field", "items", "[-1]", "sku"],
+			["value", "abc"]
+		],
+		["and",
+			["equals",
+				["field", "items", "[0]", "qty"],
+				["value", 1]
+			],
+			["anyin",
+				1,
+				["field", "tags", "[1:3]"],
+				["equals",
+					["field", 1],
+					["value", "sale"]
+				]
+			]
+		]
+	]`)
+
+	jsonExpr, err := ParseJsonExpression(matchJson)
+	assert.Nil(err)
+
+	strExpr := "items[-1].sku == \"abc\" && items[0].qty == 1 && ANY t IN tags[1:3] SATISFIES t == \"sale\" END"
+	simpleExpr, err := ParseSimpleExpression(strExpr)
+	assert.Nil(err)
+
+	assert.Equal(jsonExpr.String(), simpleExpr.String())
+
+	var trans Transformer
+	matchDef := trans.Transform([]Expression{simpleExpr})
+	assert.NotNil(matchDef)
+
+	m := NewFastMatcher(matchDef)
+	userData := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"sku": "xyz", "qty": 1},
+			map[string]interface{}{"sku": "abc", "qty": 2},
+		},
+		"tags": []interface{}{"new", "priority", "sale", "clearance"},
+	}
+	udMarsh, _ := json.Marshal(userData)
+	match, err := m.Match(udMarsh)
+	assert.Nil(err)
+	assert.True(match)
+
+	// The last tag is outside of the slice
+	m.Reset()
+	userData["tags"] = []interface{}{"new", "priority", "clearance", "sale"}
+	udMarsh, _ = json.Marshal(userData)
+	match, err = m.Match(udMarsh)
+	assert.Nil(err)
+	assert.False(match)
+
+	expr, err := ParseSimpleExpression("EVERY t IN `tags`[-2:] SATISFIES t != \"sale\" END")
+	assert.Nil(err)
+	assert.Equal(EveryInExpr{1, FieldExpr{0, []string{"tags", "[-2:]"}},
+		NotEqualsExpr{FieldExpr{1, nil}, ValueExpr{"sale"}}}.String(), expr.String())
+
+	_, err = ParseSimpleExpression("items[-01] == 1")
+	assert.Equal(ErrorLeadingZeroes, err)
+	_, err = ParseSimpleExpression("items[1-] == 1")
+	assert.Equal(ErrorAllInts, err)
+	_, err = ParseSimpleExpression("items[-0] == 1")
+	assert.Equal(ErrorAllInts, err)
+	_, err = ParseSimpleExpression("ANY t IN tags[:-0] SATISFIES t == \"sale\" END")
+	assert.Equal(ErrorAllInts, err)
+	_, err = ParseSimpleExpression("items[1:2:3] == 1")
+	assert.Equal(ErrorAllInts, err)
+}
@@ -229,6 +229,35 @@ func (m *SlowMatcher) matchGreaterEqualsExpr(expr GreaterEqualsExpr) (bool, erro
 	return val >= 0, nil
 }
 
+func (m *SlowMatcher) matchInExpr(expr InExpr) (bool, error) {
+	for _, value := range expr.Values {
+		val, err := m.compareExprs(expr.Lhs, value)
+		if err != nil {
+			return false, err
+		}
+
+		if val == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *SlowMatcher) matchBetweenExpr(expr BetweenExpr) (bool, error) {
+	lowVal, err := m.compareExprs(expr.Lhs, expr.Low)
+	if err != nil {
+		return false, err
+	}
+
+	highVal, err := m.compareExprs(expr.Lhs, expr.High)
+	if err != nil {
+		return false, err
+	}
+
+	return lowVal >= 0 && highVal <= 0, nil
+}
+
 func (m *SlowMatcher) matchOne(expr Expression) (bool, error) {
 	switch expr := expr.(type) {
 	case OrExpr:
@@ -249,6 +278,10 @@ func (m *SlowMatcher) matchOne(expr Expression) (bool, error) {
 		return m.matchGreaterThanExpr(expr)
 	case GreaterEqualsExpr:
 		return m.matchGreaterEqualsExpr(expr)
+	case InExpr:
+		return m.matchInExpr(expr)
+	case BetweenExpr:
+		return m.matchBetweenExpr(expr)
 	}
 
 	panic("unexpected expression")
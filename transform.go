@@ -47,6 +47,36 @@ func (expr mergeExpr) String() string {
 	}
 }
 
+// CompileError is returned when an expression cannot be compiled into a
+// MatchDef.  Expr holds the sub-expression which caused the failure.
+type CompileError struct {
+	Expr Expression
+	Err  error
+}
+
+func (e *CompileError) Error() string {
+	if e.Expr == nil {
+		return "failed to compile expression: " + e.Err.Error()
+	}
+	return fmt.Sprintf("failed to compile expression `%s`: %s", e.Expr, e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// newCompileError wraps err with the sub-expression that caused it.  If err
+// is already a CompileError, the original (innermost) expression is kept.
+func newCompileError(expr Expression, err error) error {
+	if compileErr, ok := err.(*CompileError); ok {
+		return compileErr
+	}
+	return &CompileError{
+		Expr: expr,
+		Err:  err,
+	}
+}
+
 type compileContext struct {
 	Depth int
 	Var   VariableID
@@ -65,6 +95,32 @@ type Transformer struct {
 
 	ContextStack    []*compileContext
 	ActiveBucketIdx BucketID
+
+	// EqualsIndexMinOps enables routing of string values through an
+	// EqualsIndex.  Any node with at least this many equality comparisons
+	// against string constants is indexed, so that documents only perform
+	// the comparisons which can be true.  Zero disables indexing.
+	EqualsIndexMinOps int
+
+	// Estimator enables reordering of predicates.  When set, the operands
+	// of every AND and OR are ordered using ReorderExpression, and the ops
+	// of every node are ordered by the Estimator's ranking of the comparison
+	// which each of them performs.
+	Estimator Estimator
+
+	// Maps each op already added to a node to its index in that node's
+	// list of ops, so that identical comparisons can be shared.
+	sharedOps map[sharedOpKey]int
+
+	// Maps the bucket of each op to the comparison it was compiled from, so
+	// that ops can be ranked by the Estimator.  Only kept when an Estimator
+	// is set.
+	opExprs map[BucketID]Expression
+}
+
+type sharedOpKey struct {
+	ops  *[]OpNode
+	desc string
 }
 
 func (t *Transformer) getExecNode(field resolvedFieldRef) *ExecNode {
@@ -74,15 +130,22 @@ func (t *Transformer) getExecNode(field resolvedFieldRef) *ExecNode {
 	}
 
 	for _, entry := range field.Path {
-		if node.Elems == nil {
-			node.Elems = make(map[string]*ExecNode)
-		} else if newNode, ok := node.Elems[entry]; ok {
+		// Elements addressed from the end of an array can only be found once
+		// the whole array has been scanned, so they live in the after node.
+		elems := &node.Elems
+		if _, ok := parseNegativeArrayIndex(entry); ok {
+			elems = &t.getAfterNode(node).Elems
+		}
+
+		if *elems == nil {
+			*elems = make(map[string]*ExecNode)
+		} else if newNode, ok := (*elems)[entry]; ok {
 			node = newNode
 			continue
 		}
 
 		newNode := &ExecNode{}
-		node.Elems[entry] = newNode
+		(*elems)[entry] = newNode
 		node = newNode
 	}
 	return node
@@ -131,44 +194,68 @@ func (t *Transformer) pushContext(varID VariableID, execNode *ExecNode) {
 	})
 }
 
-func (t *Transformer) popContext(execNode *ExecNode) {
+func (t *Transformer) popContext(execNode *ExecNode) error {
+	if len(t.ContextStack) == 0 {
+		return errors.New("context stack is empty")
+	}
+
 	topContext := t.ContextStack[len(t.ContextStack)-1]
 	if topContext.Node != execNode {
-		panic("unexpected context in the stack")
+		return errors.New("unexpected context in the stack")
 	}
 
 	t.ContextStack = t.ContextStack[0 : len(t.ContextStack)-1]
+	return nil
 }
 
-func (t *Transformer) gatherResolvedFieldRefs(expr Expression) []resolvedFieldRef {
-	fieldRefs := fetchExprFieldRefs(expr)
+func (t *Transformer) gatherResolvedFieldRefs(expr Expression) ([]resolvedFieldRef, error) {
+	fieldRefs, err := fetchExprFieldRefs(expr)
+	if err != nil {
+		return nil, err
+	}
 
 	var resolvedFieldRefs []resolvedFieldRef
 	for _, fieldRef := range fieldRefs {
-		resolvedFieldRefs = append(resolvedFieldRefs, t.resolveRef(fieldRef))
+		resolvedRef, err := t.resolveRef(fieldRef)
+		if err != nil {
+			return nil, err
+		}
+		resolvedFieldRefs = append(resolvedFieldRefs, resolvedRef)
 	}
-	return resolvedFieldRefs
+	return resolvedFieldRefs, nil
 }
 
-func (t *Transformer) getContext(varID VariableID) *compileContext {
+func (t *Transformer) getContext(varID VariableID) (*compileContext, error) {
 	if varID == 0 {
-		return nil
+		return nil, nil
 	}
 
-	for i := len(t.ContextStack) - 1; i >= 0; i++ {
+	for i := len(t.ContextStack) - 1; i >= 0; i-- {
 		if t.ContextStack[i].Var == varID {
-			return t.ContextStack[i]
+			return t.ContextStack[i], nil
 		}
 	}
 
-	panic("reference to out-of-context variable was encountered")
+	return nil, fmt.Errorf("reference to out-of-context variable %s was encountered", varID)
 }
 
-func (t *Transformer) resolveRef(fieldExpr FieldExpr) resolvedFieldRef {
+func (t *Transformer) resolveRef(fieldExpr FieldExpr) (resolvedFieldRef, error) {
+	context, err := t.getContext(fieldExpr.Root)
+	if err != nil {
+		return resolvedFieldRef{}, newCompileError(fieldExpr, err)
+	}
+
+	for _, entry := range fieldExpr.Path {
+		if _, ok := parseArraySlice(entry); ok {
+			return resolvedFieldRef{}, newCompileError(fieldExpr,
+				errors.New("array slices can only be the target of a loop"))
+		}
+	}
+
 	return resolvedFieldRef{
-		Context: t.getContext(fieldExpr.Root),
+		Context: context,
 		Path:    fieldExpr.Path,
-	}
+	}, nil
 }
 
 func (t *Transformer) findFieldRefsBestRoot(fieldRefs []resolvedFieldRef) (resolvedFieldRef, bool) {
@@ -225,17 +312,74 @@ type nodeRef struct {
 	after *AfterNode
 }
 
-func (ref *nodeRef) AddOp(op OpNode) {
+func (ref *nodeRef) AddOp(op OpNode) error {
 	if ref.node != nil {
 		ref.node.Ops = append(ref.node.Ops, op)
 	} else if ref.after != nil {
 		ref.after.Ops = append(ref.after.Ops, op)
 	} else {
-		panic("cannot add an op to a null node reference")
+		return errors.New("cannot add an op to a null node reference")
+	}
+	return nil
+}
+
+func (ref *nodeRef) opList() *[]OpNode {
+	if ref.node != nil {
+		return &ref.node.Ops
+	} else if ref.after != nil {
+		return &ref.after.Ops
+	}
+	return nil
+}
+
+// describeOp returns a string which is identical for any two ops which
+// perform the same comparison, regardless of which bucket they are for.
+func describeOp(op OpNode) (string, bool) {
+	var w matchDefWriter
+	w.writeVarint(int64(op.Op))
+	if w.writeDataRef(op.Lhs) != nil || w.writeDataRef(op.Rhs) != nil {
+		return "", false
+	}
+	return string(w.buf), true
+}
+
+// addSharedOp adds an op to a node, unless the node already has an op which
+// performs an identical comparison.  In that case, the bucket is instead
+// added to the existing op so that the comparison is only performed once.
+// The expression is the comparison which the op was compiled from.
+func (t *Transformer) addSharedOp(ref nodeRef, expr Expression, op OpNode) error {
+	if t.Estimator != nil {
+		if t.opExprs == nil {
+			t.opExprs = make(map[BucketID]Expression)
+		}
+		t.opExprs[op.BucketIdx] = expr
+	}
+
+	ops := ref.opList()
+	desc, canShare := describeOp(op)
+	if ops != nil && canShare {
+		if opIdx, ok := t.sharedOps[sharedOpKey{ops, desc}]; ok {
+			sharedOp := &(*ops)[opIdx]
+			sharedOp.SharedBuckets = append(sharedOp.SharedBuckets, op.BucketIdx)
+			return nil
+		}
+	}
+
+	err := ref.AddOp(op)
+	if err != nil {
+		return err
+	}
+
+	if canShare {
+		if t.sharedOps == nil {
+			t.sharedOps = make(map[sharedOpKey]int)
+		}
+		t.sharedOps[sharedOpKey{ops, desc}] = len(*ops) - 1
 	}
+	return nil
 }
 
-func (ref *nodeRef) AddLoop(loop LoopNode) {
+func (ref *nodeRef) AddLoop(loop LoopNode) error {
 	// TODO(brett19): This function currently validates that there
 	// is only 1 valid possible loop target used depending on which
 	// loop type its going into.  Someday we may implement function
@@ -245,23 +389,28 @@ func (ref *nodeRef) AddLoop(loop LoopNode) {
 
 	if ref.node != nil {
 		if loop.Target != nil {
-			panic("loops must always target the active state")
+			return errors.New("loops must always target the active state")
 		}
 
 		ref.node.Loops = append(ref.node.Loops, loop)
 	} else if ref.after != nil {
 		if _, ok := loop.Target.(SlotRef); !ok {
-			panic("after-loops must always target a slot")
+			return errors.New("after-loops must always target a slot")
 		}
 
 		ref.after.Loops = append(ref.after.Loops, loop)
 	} else {
-		panic("cannot add a loop to a null node reference")
+		return errors.New("cannot add a loop to a null node reference")
 	}
+	return nil
 }
 
-func (t *Transformer) pickBaseNode(expr Expression) nodeRef {
-	fieldRefs := t.gatherResolvedFieldRefs(expr)
+func (t *Transformer) pickBaseNode(expr Expression) (nodeRef, error) {
+	fieldRefs, err := t.gatherResolvedFieldRefs(expr)
+	if err != nil {
+		return nodeRef{}, err
+	}
+
 	bestBase, needsAfter := t.findFieldRefsBestRoot(fieldRefs)
 	baseNode := t.getExecNode(bestBase)
 
@@ -269,20 +418,23 @@ func (t *Transformer) pickBaseNode(expr Expression) nodeRef {
 		return nodeRef{
 			node:  baseNode,
 			after: nil,
-		}
+		}, nil
 	}
 
 	afterNode := t.getAfterNode(baseNode)
 	return nodeRef{
 		node:  nil,
 		after: afterNode,
-	}
+	}, nil
 }
 
 func (t *Transformer) makeDataRefRecurse(expr Expression, context nodeRef, isRoot bool) (DataRef, error) {
 	switch expr := expr.(type) {
 	case FieldExpr:
-		resField := t.resolveRef(expr)
+		resField, err := t.resolveRef(expr)
+		if err != nil {
+			return nil, err
+		}
 		fieldNode := t.getExecNode(resField)
 		if context.node == fieldNode {
 			if isRoot {
@@ -302,18 +454,37 @@ func (t *Transformer) makeDataRefRecurse(expr Expression, context nodeRef, isRoo
 		val.userDefined = true
 		return val, nil
 	case RegexExpr:
-		regex, err := regexp.Compile(expr.Regex.(string))
+		regexStr, ok := expr.Regex.(string)
+		if !ok {
+			return nil, newCompileError(expr, errors.New("regex must be a string"))
+		}
+		regex, err := regexp.Compile(regexStr)
 		if err != nil {
-			return nil, errors.New("failed to compile RegexExpr: " + err.Error())
+			return nil, newCompileError(expr, errors.New("failed to compile RegexExpr: "+err.Error()))
 		}
 		val := NewFastVal(regex)
 		val.userDefined = true
 		return val, nil
 	case PcreExpr:
-		pcreWrapper, err := MakePcreWrapper(expr.Pcre.(string))
+		pcreStr, ok := expr.Pcre.(string)
+		if !ok {
+			return nil, newCompileError(expr, errors.New("pcre must be a string"))
+		}
+		pcreWrapper, err := MakePcreWrapper(pcreStr)
+		if err != nil {
+			return nil, newCompileError(expr, err)
+		}
 		val := NewFastVal(pcreWrapper)
 		val.userDefined = true
-		return val, err
+		return val, nil
+	case LikePatternExpr:
+		matcher, err := NewLikeMatcher(expr.Pattern, expr.Escape)
+		if err != nil {
+			return nil, newCompileError(expr, err)
+		}
+		val := NewFastVal(matcher)
+		val.userDefined = true
+		return val, nil
 	case FuncExpr:
 		var params []DataRef
 
@@ -325,24 +496,41 @@ func (t *Transformer) makeDataRefRecurse(expr Expression, context nodeRef, isRoo
 			params = append(params, param)
 		}
 
+		if expr.FuncName == DateFunc && len(params) == 1 {
+			// A date of a constant is parsed once here, rather than again
+			// for every document which is matched.
+			if param, ok := params[0].(FastVal); ok {
+				if val := FastValDateFunc(param); val.Type() == TimeValue {
+					return val, nil
+				}
+			}
+		}
+
 		return FuncRef{
 			FuncName: expr.FuncName,
 			Params:   params,
 		}, nil
 	case TimeExpr:
-		val, err := GetNewTimeFastVal(expr.Time.(string))
+		timeStr, ok := expr.Time.(string)
+		if !ok {
+			return nil, newCompileError(expr, errors.New("time must be a string"))
+		}
+		val, err := GetNewTimeFastVal(timeStr)
+		if err != nil {
+			return nil, newCompileError(expr, err)
+		}
 		val.userDefined = true
-		return val, err
+		return val, nil
 	}
 
-	return nil, errors.New("unsupported expression in parameter")
+	return nil, newCompileError(expr, errors.New("unsupported expression in parameter"))
 }
 
 func (t *Transformer) makeDataRef(expr Expression, context nodeRef) (DataRef, error) {
 	return t.makeDataRefRecurse(expr, context, true)
 }
 
-func (t *Transformer) transformMergePiece(expr mergeExpr, i int) *ExecNode {
+func (t *Transformer) transformMergePiece(expr mergeExpr, i int) error {
 	if i == len(expr.exprs)-1 {
 		expr.bucketIDs[i] = t.ActiveBucketIdx
 		return t.transformOne(expr.exprs[i])
@@ -354,32 +542,35 @@ func (t *Transformer) transformMergePiece(expr mergeExpr, i int) *ExecNode {
 	t.newBucket()
 	expr.bucketIDs[i] = t.ActiveBucketIdx
 	t.RootTree.data[baseBucketIdx].Left = int(t.ActiveBucketIdx)
-	t.transformOne(expr.exprs[i])
+	err := t.transformOne(expr.exprs[i])
+	if err != nil {
+		return err
+	}
 
 	t.ActiveBucketIdx = baseBucketIdx
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Right = int(t.ActiveBucketIdx)
-	t.transformMergePiece(expr, i+1)
-
-	return nil
+	return t.transformMergePiece(expr, i+1)
 }
 
-func (t *Transformer) transformMerge(expr mergeExpr) *ExecNode {
+func (t *Transformer) transformMerge(expr mergeExpr) error {
 	return t.transformMergePiece(expr, 0)
 }
 
-func (t *Transformer) transformNot(expr NotExpr) *ExecNode {
+func (t *Transformer) transformNot(expr NotExpr) error {
 	baseBucketIdx := t.ActiveBucketIdx
 	t.RootTree.data[baseBucketIdx].NodeType = nodeTypeNot
 
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Left = int(t.ActiveBucketIdx)
-	t.transformOne(expr.SubExpr)
-
-	return nil
+	return t.transformOne(expr.SubExpr)
 }
 
-func (t *Transformer) transformOr(expr OrExpr) *ExecNode {
+func (t *Transformer) transformOr(expr OrExpr) error {
+	if len(expr) == 0 {
+		return newCompileError(expr, errors.New("empty or expression"))
+	}
+
 	if len(expr) == 1 {
 		return t.transformOne(expr[0])
 	}
@@ -389,17 +580,22 @@ func (t *Transformer) transformOr(expr OrExpr) *ExecNode {
 
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Left = int(t.ActiveBucketIdx)
-	t.transformOne(expr[0])
+	err := t.transformOne(expr[0])
+	if err != nil {
+		return err
+	}
 
 	t.ActiveBucketIdx = baseBucketIdx
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Right = int(t.ActiveBucketIdx)
-	t.transformOr(expr[1:])
-
-	return nil
+	return t.transformOr(expr[1:])
 }
 
-func (t *Transformer) transformAnd(expr AndExpr) *ExecNode {
+func (t *Transformer) transformAnd(expr AndExpr) error {
+	if len(expr) == 0 {
+		return newCompileError(expr, errors.New("empty and expression"))
+	}
+
 	if len(expr) == 1 {
 		return t.transformOne(expr[0])
 	}
@@ -409,24 +605,53 @@ func (t *Transformer) transformAnd(expr AndExpr) *ExecNode {
 
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Left = int(t.ActiveBucketIdx)
-	t.transformOne(expr[0])
+	err := t.transformOne(expr[0])
+	if err != nil {
+		return err
+	}
 
 	t.ActiveBucketIdx = baseBucketIdx
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Right = int(t.ActiveBucketIdx)
-	t.transformAnd(expr[1:])
+	return t.transformAnd(expr[1:])
+}
 
-	return nil
+// splitLoopSlice separates a trailing array slice from the target of a loop,
+// returning the loop expression and target with the slice removed.
+func splitLoopSlice(expr, inExpr Expression) (Expression, Expression, *ArraySlice) {
+	field, ok := inExpr.(FieldExpr)
+	if !ok || len(field.Path) == 0 {
+		return expr, inExpr, nil
+	}
+
+	slice, ok := parseArraySlice(field.Path[len(field.Path)-1])
+	if !ok {
+		return expr, inExpr, nil
+	}
+
+	inExpr = FieldExpr{
+		Root: field.Root,
+		Path: field.Path[:len(field.Path)-1],
+	}
+	children, _ := exprChildren(expr)
+	children = append([]Expression(nil), children...)
+	children[0] = inExpr
+	return exprWithChildren(expr, children), inExpr, &slice
 }
 
-func (t *Transformer) transformLoop(expr Expression, loopType LoopType, varID VariableID, inExpr, subExpr Expression) *ExecNode {
-	baseNode := t.pickBaseNode(expr)
+func (t *Transformer) transformLoop(expr Expression, loopType LoopType, varID VariableID, inExpr, subExpr Expression) error {
+	expr, inExpr, slice := splitLoopSlice(expr, inExpr)
+
+	baseNode, err := t.pickBaseNode(expr)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	newNode := &ExecNode{}
 
 	loopTarget, err := t.makeDataRef(inExpr, baseNode)
 	if err != nil {
-		panic(err)
+		return newCompileError(inExpr, err)
 	}
 
 	baseBucketIdx := t.ActiveBucketIdx
@@ -434,56 +659,71 @@ func (t *Transformer) transformLoop(expr Expression, loopType LoopType, varID Va
 	t.newBucket()
 	t.RootTree.data[baseBucketIdx].Left = int(t.ActiveBucketIdx)
 
-	baseNode.AddLoop(LoopNode{
-		t.ActiveBucketIdx,
-		loopType,
-		loopTarget,
-		newNode,
+	err = baseNode.AddLoop(LoopNode{
+		BucketIdx: t.ActiveBucketIdx,
+		Mode:      loopType,
+		Target:    loopTarget,
+		Node:      newNode,
+		Slice:     slice,
 	})
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	// Push this context to the stack
 	t.pushContext(varID, newNode)
 
 	// Transform the loops expression body
-	t.transformOne(subExpr)
+	err = t.transformOne(subExpr)
+	if err != nil {
+		return err
+	}
 
 	// Pop from the context stack
-	t.popContext(newNode)
+	err = t.popContext(newNode)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	return nil
 }
 
-func (t *Transformer) transformAnyIn(expr AnyInExpr) *ExecNode {
+func (t *Transformer) transformAnyIn(expr AnyInExpr) error {
 	return t.transformLoop(expr, LoopTypeAny, expr.VarId, expr.InExpr, expr.SubExpr)
 }
 
-func (t *Transformer) transformEveryIn(expr EveryInExpr) *ExecNode {
+func (t *Transformer) transformEveryIn(expr EveryInExpr) error {
 	return t.transformLoop(expr, LoopTypeEvery, expr.VarId, expr.InExpr, expr.SubExpr)
 }
 
-func (t *Transformer) transformAnyEveryIn(expr AnyEveryInExpr) *ExecNode {
+func (t *Transformer) transformAnyEveryIn(expr AnyEveryInExpr) error {
 	return t.transformLoop(expr, LoopTypeAnyEvery, expr.VarId, expr.InExpr, expr.SubExpr)
 }
 
-func (t *Transformer) transformExists(expr ExistsExpr) *ExecNode {
-	baseNode := t.pickBaseNode(expr)
+func (t *Transformer) transformExists(expr ExistsExpr) error {
+	baseNode, err := t.pickBaseNode(expr)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	lhsDataRef, err := t.makeDataRef(expr.SubExpr, baseNode)
 	if err != nil {
-		panic(err)
+		return newCompileError(expr.SubExpr, err)
 	}
 
-	baseNode.AddOp(OpNode{
-		t.ActiveBucketIdx,
-		OpTypeExists,
-		lhsDataRef,
-		nil,
+	err = t.addSharedOp(baseNode, expr, OpNode{
+		BucketIdx: t.ActiveBucketIdx,
+		Op:        OpTypeExists,
+		Lhs:       lhsDataRef,
 	})
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	return nil
 }
 
-func (t *Transformer) transformNotExists(expr NotExistsExpr) *ExecNode {
+func (t *Transformer) transformNotExists(expr NotExistsExpr) error {
 	return t.transformOne(NotExpr{
 		ExistsExpr{
 			expr.SubExpr,
@@ -491,59 +731,154 @@ func (t *Transformer) transformNotExists(expr NotExistsExpr) *ExecNode {
 	})
 }
 
-func (t *Transformer) transformComparison(expr Expression, op OpType, lhs, rhs Expression) *ExecNode {
-	baseNode := t.pickBaseNode(expr)
+func (t *Transformer) transformComparison(expr Expression, op OpType, lhs, rhs Expression) error {
+	baseNode, err := t.pickBaseNode(expr)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	lhsRef, err := t.makeDataRef(lhs, baseNode)
 	if err != nil {
-		panic(err)
+		return newCompileError(lhs, err)
 	}
 
 	rhsRef, err := t.makeDataRef(rhs, baseNode)
 	if err != nil {
-		panic(err)
+		return newCompileError(rhs, err)
 	}
 
-	baseNode.AddOp(OpNode{
-		t.ActiveBucketIdx,
-		op,
-		lhsRef,
-		rhsRef,
+	err = t.addSharedOp(baseNode, expr, OpNode{
+		BucketIdx: t.ActiveBucketIdx,
+		Op:        op,
+		Lhs:       lhsRef,
+		Rhs:       rhsRef,
 	})
+	if err != nil {
+		return newCompileError(expr, err)
+	}
 
 	return nil
 }
 
-func (t *Transformer) transformEquals(expr EqualsExpr) *ExecNode {
+func (t *Transformer) transformEquals(expr EqualsExpr) error {
 	return t.transformComparison(expr, OpTypeEquals, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformNotEquals(expr NotEqualsExpr) *ExecNode {
+func (t *Transformer) transformNotEquals(expr NotEqualsExpr) error {
 	return t.transformOne(NotExpr{EqualsExpr{expr.Lhs, expr.Rhs}})
 }
 
-func (t *Transformer) transformLessThan(expr LessThanExpr) *ExecNode {
+func (t *Transformer) transformLessThan(expr LessThanExpr) error {
 	return t.transformComparison(expr, OpTypeLessThan, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformLessEquals(expr LessEqualsExpr) *ExecNode {
+func (t *Transformer) transformLessEquals(expr LessEqualsExpr) error {
 	return t.transformComparison(expr, OpTypeLessEquals, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformGreaterThan(expr GreaterThanExpr) *ExecNode {
+func (t *Transformer) transformGreaterThan(expr GreaterThanExpr) error {
 	return t.transformComparison(expr, OpTypeGreaterThan, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformGreaterEquals(expr GreaterEqualsExpr) *ExecNode {
+func (t *Transformer) transformGreaterEquals(expr GreaterEqualsExpr) error {
 	return t.transformComparison(expr, OpTypeGreaterEquals, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformLike(expr LikeExpr) *ExecNode {
+func (t *Transformer) transformLike(expr LikeExpr) error {
 	return t.transformComparison(expr, OpTypeMatches, expr.Lhs, expr.Rhs)
 }
 
-func (t *Transformer) transformOne(expr Expression) *ExecNode {
+func (t *Transformer) transformIn(expr InExpr) error {
+	baseNode, err := t.pickBaseNode(expr)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
+
+	lhsRef, err := t.makeDataRef(expr.Lhs, baseNode)
+	if err != nil {
+		return newCompileError(expr.Lhs, err)
+	}
+
+	values := make([]FastVal, len(expr.Values))
+	for i, valueExpr := range expr.Values {
+		if _, ok := valueExpr.(ValueExpr); !ok {
+			return newCompileError(valueExpr, errors.New("in values must be constants"))
+		}
+
+		valueRef, err := t.makeDataRef(valueExpr, baseNode)
+		if err != nil {
+			return newCompileError(valueExpr, err)
+		}
+		values[i] = valueRef.(FastVal)
+	}
+
+	err = t.addSharedOp(baseNode, expr, OpNode{
+		BucketIdx: t.ActiveBucketIdx,
+		Op:        OpTypeIn,
+		Lhs:       lhsRef,
+		Rhs:       NewValueSetRef(values),
+	})
+	if err != nil {
+		return newCompileError(expr, err)
+	}
+
+	return nil
+}
+
+func (t *Transformer) transformBetween(expr BetweenExpr) error {
+	baseNode, err := t.pickBaseNode(expr)
+	if err != nil {
+		return newCompileError(expr, err)
+	}
+
+	lhsRef, err := t.makeDataRef(expr.Lhs, baseNode)
+	if err != nil {
+		return newCompileError(expr.Lhs, err)
+	}
+
+	// The bounds are not the root of the op, so a field at the base node
+	// is referenced as the active literal rather than as nil.
+	lowRef, err := t.makeDataRefRecurse(expr.Low, baseNode, false)
+	if err != nil {
+		return newCompileError(expr.Low, err)
+	}
+
+	highRef, err := t.makeDataRefRecurse(expr.High, baseNode, false)
+	if err != nil {
+		return newCompileError(expr.High, err)
+	}
+
+	err = t.addSharedOp(baseNode, expr, OpNode{
+		BucketIdx: t.ActiveBucketIdx,
+		Op:        OpTypeBetween,
+		Lhs:       lhsRef,
+		Rhs:       RangeRef{lowRef, highRef},
+	})
+	if err != nil {
+		return newCompileError(expr, err)
+	}
+
+	return nil
+}
+
+func (t *Transformer) transformTrue(expr TrueExpr) error {
+	// A constant true is implemented as a literal comparison against the
+	// root node so that the bucket is resolved once the document is read.
+	return t.transformComparison(expr, OpTypeEquals, ValueExpr{true}, ValueExpr{true})
+}
+
+func (t *Transformer) transformFalse(expr FalseExpr) error {
+	// Unresolved leaf buckets are resolved to false at the end of matching,
+	// so a constant false requires no ops at all.
+	return nil
+}
+
+func (t *Transformer) transformOne(expr Expression) error {
 	switch expr := expr.(type) {
+	case TrueExpr:
+		return t.transformTrue(expr)
+	case FalseExpr:
+		return t.transformFalse(expr)
 	case mergeExpr:
 		return t.transformMerge(expr)
 	case AnyInExpr:
@@ -576,16 +911,34 @@ func (t *Transformer) transformOne(expr Expression) *ExecNode {
 		return t.transformGreaterEquals(expr)
 	case LikeExpr:
 		return t.transformLike(expr)
+	case InExpr:
+		return t.transformIn(expr)
+	case BetweenExpr:
+		return t.transformBetween(expr)
 	}
-	return nil
+	return newCompileError(expr, fmt.Errorf("unsupported expression type %T", expr))
 }
 
 var AlwaysTrueIdent = -1
 var AlwaysFalseIdent = -2
 
+// Transform compiles the expressions into a MatchDef, panicking if any of
+// them cannot be compiled.  New code should prefer TransformE.
 func (t *Transformer) Transform(exprs []Expression) *MatchDef {
+	matchDef, err := t.TransformE(exprs)
+	if err != nil {
+		panic(err)
+	}
+	return matchDef
+}
+
+// TransformE compiles the expressions into a MatchDef.  Any failure is
+// reported as a *CompileError identifying the offending sub-expression.
+func (t *Transformer) TransformE(exprs []Expression) (*MatchDef, error) {
 	t.RootExec = &ExecNode{}
 	t.ContextStack = nil
+	t.sharedOps = nil
+	t.opExprs = nil
 	t.BucketIdx = 1
 	t.ActiveBucketIdx = 0
 	t.RootTree = binTree{[]binTreeNode{
@@ -610,6 +963,10 @@ func (t *Transformer) Transform(exprs []Expression) *MatchDef {
 			continue
 		}
 
+		if t.Estimator != nil {
+			expr = ReorderExpression(expr, t.Estimator)
+		}
+
 		genExprs = append(genExprs, expr)
 		exprBucketIDs[i] = len(genExprs) - 1
 	}
@@ -619,7 +976,10 @@ func (t *Transformer) Transform(exprs []Expression) *MatchDef {
 			exprs:     genExprs,
 			bucketIDs: make([]BucketID, len(exprs)),
 		}
-		t.transformOne(mergeExpr)
+		err := t.transformOne(mergeExpr)
+		if err != nil {
+			return nil, newCompileError(nil, err)
+		}
 
 		for i, index := range exprBucketIDs {
 			if index >= 0 {
@@ -636,11 +996,19 @@ func (t *Transformer) Transform(exprs []Expression) *MatchDef {
 	if t.RootExec != nil {
 		err := t.RootTree.Validate()
 		if err != nil {
-			panic(err)
+			return nil, newCompileError(nil, err)
 		}
 
 		if t.RootTree.NumNodes() != int(t.BucketIdx) {
-			panic("bucket count did not match tree size")
+			return nil, newCompileError(nil, errors.New("bucket count did not match tree size"))
+		}
+
+		if t.Estimator != nil {
+			reorderExecOps(t.RootExec, t.estimatedOpRank)
+		}
+
+		if t.EqualsIndexMinOps > 0 {
+			buildEqualsIndexes(t.RootExec, t.EqualsIndexMinOps)
 		}
 	}
 
@@ -650,5 +1018,5 @@ func (t *Transformer) Transform(exprs []Expression) *MatchDef {
 		MatchBuckets: exprBucketIDs,
 		NumBuckets:   int(t.BucketIdx),
 		NumSlots:     int(t.SlotIdx),
-	}
+	}, nil
 }
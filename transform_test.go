@@ -0,0 +1,191 @@
+// Copyright 2018 Couchbase, Inc. All rights reserved.
+
+package gojsonsm
+
+import (
+	"testing"
+)
+
+func tExpectCompileError(t *testing.T, expr Expression, failedExpr Expression) {
+	var trans Transformer
+	matchDef, err := trans.TransformE([]Expression{expr})
+	if err == nil {
+		t.Fatalf("expected a compile error, got definition:\n%s", matchDef)
+	}
+
+	compileErr, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("expected a *CompileError, got %T: %s", err, err)
+	}
+
+	if failedExpr != nil && compileErr.Expr.String() != failedExpr.String() {
+		t.Errorf("compile error referenced the wrong expression:")
+		t.Errorf("  Expected: %s", failedExpr)
+		t.Errorf("  Received: %s", compileErr.Expr)
+	}
+}
+
+func TestTransformInvalidRegex(t *testing.T) {
+	regexExpr := RegexExpr{"a(b"}
+	tExpectCompileError(t, LikeExpr{
+		FieldExpr{0, []string{"name"}},
+		regexExpr,
+	}, regexExpr)
+}
+
+func TestTransformNonStringRegex(t *testing.T) {
+	regexExpr := RegexExpr{14}
+	tExpectCompileError(t, LikeExpr{
+		FieldExpr{0, []string{"name"}},
+		regexExpr,
+	}, regexExpr)
+}
+
+func TestTransformInvalidTime(t *testing.T) {
+	timeExpr := TimeExpr{"not-a-date"}
+	tExpectCompileError(t, LessThanExpr{
+		FieldExpr{0, []string{"birthday"}},
+		timeExpr,
+	}, timeExpr)
+}
+
+func TestTransformOutOfContextVariable(t *testing.T) {
+	fieldExpr := FieldExpr{3, []string{"name"}}
+	tExpectCompileError(t, EqualsExpr{
+		fieldExpr,
+		ValueExpr{"Frank"},
+	}, nil)
+}
+
+func TestTransformUnsupportedExpression(t *testing.T) {
+	valueExpr := ValueExpr{"lonely"}
+	tExpectCompileError(t, AndExpr{
+		EqualsExpr{
+			FieldExpr{0, []string{"name"}},
+			ValueExpr{"Frank"},
+		},
+		valueExpr,
+	}, valueExpr)
+}
+
+func TestTransformEmptyOr(t *testing.T) {
+	tExpectCompileError(t, OrExpr{}, OrExpr{})
+}
+
+func TestTransformNoPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("TransformE panicked: %v", r)
+		}
+	}()
+
+	var trans Transformer
+	trans.TransformE([]Expression{
+		AnyInExpr{
+			1,
+			FieldExpr{0, []string{"tags"}},
+			EqualsExpr{
+				FieldExpr{2, nil},
+				ValueExpr{"x"},
+			},
+		},
+	})
+}
+
+func TestFilterExpressionMatcherCompileError(t *testing.T) {
+	_, err := GetFilterExpressionMatcher("name =~ \"a(b\"")
+	if err == nil {
+		t.Fatalf("expected an error for an invalid regex")
+	}
+}
+
+func TestTransformNestedConstants(t *testing.T) {
+	doc := []byte(`{"name":"Frank"}`)
+	tests := []struct {
+		expr     Expression
+		expected bool
+	}{
+		{AndExpr{TrueExpr{}, EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Frank"}}}, true},
+		{AndExpr{FalseExpr{}, EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Frank"}}}, false},
+		{OrExpr{TrueExpr{}, EqualsExpr{FieldExpr{0, []string{"name"}}, ValueExpr{"Bob"}}}, true},
+		{NotExpr{FalseExpr{}}, true},
+		{NotExpr{TrueExpr{}}, false},
+	}
+
+	for _, test := range tests {
+		var trans Transformer
+		matchDef, err := trans.TransformE([]Expression{test.expr})
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.expr, err)
+		}
+
+		m := NewFastMatcher(matchDef)
+		matched, err := m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match %s: %s", test.expr, err)
+		}
+		if matched != test.expected {
+			t.Errorf("expression %s matched %t, expected %t", test.expr, matched, test.expected)
+		}
+	}
+}
+
+func TestTransformSharedOps(t *testing.T) {
+	filters := []string{
+		`gender = "male" AND age > 30`,
+		`gender = "male" AND isActive = TRUE`,
+		`gender = "male" OR age < 25`,
+		`NOT gender = "male"`,
+		`gender = "male"`,
+		`age > 30 OR gender = "female" AND age > 30`,
+	}
+
+	var exprs []Expression
+	for _, filter := range filters {
+		exprs = append(exprs, tParseFilterExpression(t, filter))
+	}
+
+	var trans Transformer
+	matchDef, err := trans.TransformE(exprs)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	genderOps := matchDef.ParseNode.Elems["gender"].Ops
+	if len(genderOps) != 2 {
+		t.Fatalf("expected 2 gender ops, got:\n%s", matchDef)
+	}
+	if len(genderOps[0].SharedBuckets) != 4 || len(genderOps[1].SharedBuckets) != 0 {
+		t.Fatalf("expected the male comparison to be shared, got:\n%s", matchDef)
+	}
+
+	ageOps := matchDef.ParseNode.Elems["age"].Ops
+	if len(ageOps) != 2 {
+		t.Fatalf("expected 2 age ops, got:\n%s", matchDef)
+	}
+
+	m := NewFastMatcher(matchDef)
+	for docIdx, doc := range getTestPeopleDocs() {
+		m.Reset()
+		_, err := m.Match(doc)
+		if err != nil {
+			t.Fatalf("failed to match: %s", err)
+		}
+
+		for exprIdx, expr := range exprs {
+			var exprTrans Transformer
+			exprM := NewFastMatcher(exprTrans.Transform([]Expression{expr}))
+			expected, err := exprM.Match(doc)
+			if err != nil {
+				t.Fatalf("failed to match: %s", err)
+			}
+
+			if m.ExpressionMatched(exprIdx) != expected {
+				t.Fatalf("document %d: `%s` matched %t when shared, expected %t",
+					docIdx, filters[exprIdx], m.ExpressionMatched(exprIdx), expected)
+			}
+		}
+	}
+
+	tRoundTripMatchDef(t, matchDef)
+}